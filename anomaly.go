@@ -0,0 +1,75 @@
+package welog
+
+import (
+	"github.com/sirupsen/logrus"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	anomalyAlpha            = 0.05 // Weight of the newest request in the moving averages
+	anomalyWarmup           = 30   // Requests a route needs before it can be flagged
+	defaultAnomalyThreshold = 3.0  // Latency z-score above which a request is anomalous
+	rareErrorRate           = 0.01 // Error rate below which a failing request is anomalous
+)
+
+// routeBaseline holds the exponentially weighted moving averages of a route.
+type routeBaseline struct {
+	count     int
+	mean      float64 // Mean latency in milliseconds
+	variance  float64 // Variance of the latency
+	errorRate float64 // Share of requests logged at warning level or above
+}
+
+var (
+	baselines      = map[string]*routeBaseline{} // Baselines by method and route
+	baselinesMutex sync.Mutex                    // Protects access to baselines
+)
+
+// detectAnomaly compares a request with the baseline of its route, tags the document with
+// the anomaly and zscore fields, and then folds the request into the baseline. Requests are
+// anomalous when their latency deviates more than AnomalyThreshold standard deviations from
+// the mean, or when they fail on a route that rarely fails.
+func detectAnomaly(method, route string, latency time.Duration, level logrus.Level, fields logrus.Fields) {
+	config := currentConfig()
+	if !config.AnomalyDetection {
+		return
+	}
+
+	threshold := config.AnomalyThreshold
+	if threshold <= 0 {
+		threshold = defaultAnomalyThreshold
+	}
+
+	failed := 0.0
+	if level <= logrus.WarnLevel {
+		failed = 1
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+
+	baselinesMutex.Lock()
+	defer baselinesMutex.Unlock()
+
+	key := method + " " + route
+	b, ok := baselines[key]
+	if !ok {
+		b = &routeBaseline{mean: ms}
+		baselines[key] = b
+	}
+
+	if b.count >= anomalyWarmup {
+		zscore := 0.0
+		if stddev := math.Sqrt(b.variance); stddev > 0 {
+			zscore = (ms - b.mean) / stddev
+		}
+		fields["zscore"] = math.Round(zscore*100) / 100
+		fields["anomaly"] = math.Abs(zscore) > threshold || (failed == 1 && b.errorRate < rareErrorRate)
+	}
+
+	diff := ms - b.mean
+	b.mean += anomalyAlpha * diff
+	b.variance = (1 - anomalyAlpha) * (b.variance + anomalyAlpha*diff*diff)
+	b.errorRate += anomalyAlpha * (failed - b.errorRate)
+	b.count++
+}
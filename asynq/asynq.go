@@ -0,0 +1,53 @@
+// Package asynq logs the tasks processed by Asynq servers with welog. Every task run gets a
+// document of its own, with the task ID as request ID and the calls logged with
+// welog.AddTarget in its target field:
+//
+//	mux := asynq.NewServeMux()
+//	mux.Use(welogAsynq.Middleware)
+package asynq
+
+import (
+	"context"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	hibiken "github.com/hibiken/asynq"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// Middleware logs every run of the tasks handled by next with the task type, ID, queue,
+// attempt, maximum number of retries, latency, and error. The handler gets a context
+// prepared by welog.NewJobContext, so its logger and target entries belong to the run.
+func Middleware(next hibiken.Handler) hibiken.Handler {
+	return hibiken.HandlerFunc(func(ctx context.Context, task *hibiken.Task) error {
+		taskID, _ := hibiken.GetTaskID(ctx)
+		ctx = welog.NewJobContext(ctx, taskID)
+
+		start := logger.Now()
+		err := next.ProcessTask(ctx, task)
+
+		welog.LogJob(ctx, taskFields(ctx, task, taskID, start), err)
+		return err
+	})
+}
+
+// taskFields describes a task run started at start.
+func taskFields(ctx context.Context, task *hibiken.Task, taskID string, start time.Time) logrus.Fields {
+	queue, _ := hibiken.GetQueueName(ctx)
+	retried, _ := hibiken.GetRetryCount(ctx)
+	maxRetry, _ := hibiken.GetMaxRetry(ctx)
+
+	latency := logger.Since(start)
+
+	return logrus.Fields{
+		"taskAttempt":   retried + 1,
+		"taskId":        taskID,
+		"taskLatency":   latency.String(),
+		"taskLatencyMs": util.Milliseconds(latency),
+		"taskMaxRetry":  maxRetry,
+		"taskQueue":     queue,
+		"taskTimestamp": start.Format(time.RFC3339Nano),
+		"taskType":      task.Type(),
+	}
+}
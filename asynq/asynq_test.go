@@ -0,0 +1,40 @@
+package asynq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	hibiken "github.com/hibiken/asynq"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestMiddleware tests that task runs are logged with their targets and errors.
+func TestMiddleware(t *testing.T) {
+	welog.SetConfig(welog.Config{StdoutOnly: true})
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+
+	handler := Middleware(hibiken.HandlerFunc(func(ctx context.Context, task *hibiken.Task) error {
+		welog.AddTarget(ctx, logrus.Fields{"targetRequestURL": "https://example.com"})
+		return errors.New("mail server unavailable")
+	}))
+
+	err := handler.ProcessTask(context.Background(), hibiken.NewTask("email:welcome", nil))
+	assert.EqualError(t, err, "mail server unavailable")
+
+	// Assert that the run is logged at error level with the task and target fields.
+	output := strings.TrimSpace(buf.String())
+	assert.NotContains(t, output, "\n")
+	assert.Contains(t, output, `"log.level":"error"`)
+	assert.Contains(t, output, `"jobError":"mail server unavailable"`)
+	assert.Contains(t, output, `"taskType":"email:welcome"`)
+	assert.Contains(t, output, `"taskAttempt":1`)
+	assert.Contains(t, output, `"target":[{"targetRequestURL":"https://example.com"}]`)
+}
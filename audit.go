@@ -0,0 +1,50 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Audit logs a compliance event, such as a login, a permission change, or a data export, to
+// the audit index. action names the event, resource what it was performed on, and outcome
+// how it ended, e.g. "success" or "failure" like the ECS event.outcome field. The entry is
+// tagged with event.kind "audit" and carries the request ID and the user set by SetUser if
+// ctx belongs to a request. Audit entries are neither sampled nor subject to the budgets, and
+// the caller waits for room in the queue of the ElasticSearch hook and in the memory budget
+// rather than dropping them, for up to 5 seconds before writing them to the fallback file.
+func Audit(ctx context.Context, action, resource, outcome string, details logrus.Fields) {
+	config := currentConfig()
+
+	fields := logrus.Fields{
+		"auditResource": resource,
+		"event.action":  action,
+		"event.kind":    "audit",
+		"event.outcome": outcome,
+	}
+	if len(details) > 0 {
+		fields["auditDetails"] = details
+	}
+
+	requester, _ := ctx.Value(generalkey.UserKey).(*requestUser)
+	for key, value := range requester.fields() {
+		fields[key] = value
+	}
+
+	applyFieldNaming(config, fields)
+
+	LoggerFromContext(ctx).
+		WithContext(logger.WithCategory(ctx, logger.CategoryAudit)).
+		WithFields(fields).
+		Info(action)
+}
+
+// applyAuditIndex routes the audit entries to their own index.
+func applyAuditIndex(config Config) {
+	index := config.AuditIndex
+	if index == "" && config.ElasticIndex != "" {
+		index = config.ElasticIndex + "-audit"
+	}
+	logger.SetCategoryIndex(logger.CategoryAudit, index)
+}
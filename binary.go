@@ -0,0 +1,101 @@
+package welog
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// BinaryMode selects how a binary body is represented in the body string fields.
+type BinaryMode int
+
+const (
+	// BinarySize logs only the content type and size of a binary body. It is the default.
+	BinarySize BinaryMode = iota
+
+	// BinaryHash logs the SHA-256 hash of a binary body, prefixed with "sha256:".
+	BinaryHash
+
+	// BinaryBase64 logs the first binarySnippetSize bytes of a binary body encoded in base64,
+	// prefixed with "base64:".
+	BinaryBase64
+)
+
+// binarySnippetSize is the number of bytes of a binary body logged by BinaryBase64.
+const binarySnippetSize = 256
+
+// binaryMediaTypes lists the media types that are binary whatever their content. Other
+// bodies are treated as binary when they aren't valid UTF-8.
+var binaryMediaTypes = []string{
+	"application/gzip",
+	"application/octet-stream",
+	"application/pdf",
+	"application/protobuf",
+	"application/x-protobuf",
+	"application/zip",
+	"audio/*",
+	"font/*",
+	"image/*",
+	"video/*",
+}
+
+// bodyString returns the body as logged in the body string fields. Text bodies are logged
+// as they are, binary bodies as configured by Config.BinaryBodies.
+func bodyString(contentType string, body []byte) string {
+	return bodyStringFor(currentConfig(), contentType, body)
+}
+
+// bodyStringFor is like bodyString, with the BinaryBodies of config.
+func bodyStringFor(config Config, contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !matchMediaType(binaryMediaTypes, mediaType) && utf8.Valid(body) {
+		return string(body)
+	}
+
+	switch binaryMode(config.BinaryBodies, mediaType) {
+	case BinaryHash:
+		sum := sha256.Sum256(body)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	case BinaryBase64:
+		return "base64:" + base64.StdEncoding.EncodeToString(body[:min(len(body), binarySnippetSize)])
+	default:
+		if mediaType == "" {
+			mediaType = "unknown"
+		}
+		return fmt.Sprintf("[binary %s, %d bytes]", mediaType, len(body))
+	}
+}
+
+// binaryMode returns the mode configured in modes for a media type, trying the exact type
+// before a "type/*" wildcard.
+func binaryMode(modes map[string]BinaryMode, mediaType string) BinaryMode {
+	if mode, ok := modes[mediaType]; ok {
+		return mode
+	}
+	if major, _, ok := strings.Cut(mediaType, "/"); ok {
+		return modes[major+"/*"]
+	}
+	return BinarySize
+}
+
+// matchMediaType reports whether mediaType matches one of patterns, which are media types
+// or "type/*" wildcards.
+func matchMediaType(patterns []string, mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if major, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, major+"/") {
+				return true
+			}
+		} else if pattern == mediaType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,377 @@
+package welog
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// truncatedKey is the key under which the fields beyond Config.BodyMaxKeys are logged as a
+// JSON string.
+const truncatedKey = "_truncated"
+
+// maxXMLDepth is the nesting depth beyond which an XML document isn't decoded, so a crafted
+// body can't make parseXML recurse without bound.
+const maxXMLDepth = 64
+
+// ParseBody decodes a body into structured fields according to its content type, as logged in
+// the body fields of the documents, for integrations logging bodies of their own, such as the
+// gRPC messages of github.com/christiandoxa/welog/grpc. JSON objects, URL-encoded forms,
+// multipart forms, and, with Config.ParseXML, XML documents are supported. Other bodies, such as
+// HTML, plain text, or empty bodies, yield nil without reporting an error; their raw
+// string is still logged by the callers. The fields are limited to Config.BodyMaxDepth
+// and Config.BodyMaxKeys.
+func ParseBody(contentType string, body []byte) logrus.Fields {
+	return parseBodyFor(currentConfig(), contentType, body)
+}
+
+// parseBodyFor is like ParseBody, under the body settings of config.
+func parseBodyFor(config Config, contentType string, body []byte) logrus.Fields {
+	fields := decodeBody(config, contentType, body)
+	if fields == nil || (config.BodyMaxDepth <= 0 && config.BodyMaxKeys <= 0) {
+		return fields
+	}
+
+	limiter := bodyLimiter{maxDepth: config.BodyMaxDepth, maxKeys: config.BodyMaxKeys}
+	return limiter.object(fields, 1)
+}
+
+// decodeBody decodes a body according to its content type, see ParseBody.
+func decodeBody(config Config, contentType string, body []byte) logrus.Fields {
+	if contentType == "" {
+		// Without a declared content type, the body is sniffed for a JSON object.
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+			return parseJSON(body)
+		}
+		return nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return parseJSON(body)
+	case mediaType == "application/x-www-form-urlencoded":
+		return parseForm(body)
+	case mediaType == "multipart/form-data":
+		return parseMultipart(body, params["boundary"])
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		if config.ParseXML {
+			return parseXML(body)
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// bodyLimiter limits the nesting depth and the number of keys of decoded bodies, so deeply
+// nested or huge bodies don't explode the ElasticSearch mapping and the document size.
+type bodyLimiter struct {
+	maxDepth int // Maximum nesting depth of objects, zero for no limit
+	maxKeys  int // Maximum number of keys over all objects, zero for no limit
+	keys     int // Keys kept so far
+}
+
+// object limits the object m at the given depth. Its keys are visited in sorted order, so
+// the same keys are kept for every request; the keys beyond the limit are logged together
+// as a JSON string under truncatedKey.
+func (l *bodyLimiter) object(m map[string]any, depth int) logrus.Fields {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	limited := make(logrus.Fields, len(m))
+	var rest map[string]any
+	for _, key := range keys {
+		if l.maxKeys > 0 && l.keys >= l.maxKeys {
+			if rest == nil {
+				rest = map[string]any{}
+			}
+			rest[key] = m[key]
+			continue
+		}
+		l.keys++
+		limited[key] = l.value(m[key], depth+1)
+	}
+	if rest != nil {
+		limited[truncatedKey] = jsonString(rest)
+	}
+	return limited
+}
+
+// value limits a value found at the given depth. Objects deeper than the maximum depth are
+// logged as JSON strings; arrays don't add a level.
+func (l *bodyLimiter) value(v any, depth int) any {
+	switch t := v.(type) {
+	case map[string]any:
+		if l.maxDepth > 0 && depth > l.maxDepth {
+			return jsonString(t)
+		}
+		return l.object(t, depth)
+	case logrus.Fields:
+		return l.value(map[string]any(t), depth)
+	case []any:
+		limited := make([]any, len(t))
+		for i, element := range t {
+			limited[i] = l.value(element, depth)
+		}
+		return limited
+	default:
+		return v
+	}
+}
+
+// jsonString encodes v as a JSON string, or an empty string if it can't be encoded.
+func jsonString(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// parseJSON decodes a JSON object, returning nil if the body isn't one.
+func parseJSON(body []byte) logrus.Fields {
+	var fields logrus.Fields
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// parseForm decodes a URL-encoded form. Keys with a single value map to a string, repeated
+// keys to a slice of strings.
+func parseForm(body []byte) logrus.Fields {
+	values, err := url.ParseQuery(string(body))
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+
+	fields := logrus.Fields{}
+	for key, value := range values {
+		fields[key] = formValue(value)
+	}
+	return fields
+}
+
+// parseMultipart decodes a multipart form. Values are recorded like in parseForm, files as
+// their file name, content type, and size; file contents are never recorded.
+func parseMultipart(body []byte, boundary string) logrus.Fields {
+	if boundary == "" {
+		return nil
+	}
+
+	values := map[string][]string{}
+	files := map[string][]logrus.Fields{}
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+
+		name := part.FormName()
+		if part.FileName() == "" {
+			value, err := io.ReadAll(part)
+			if err != nil {
+				return nil
+			}
+			values[name] = append(values[name], string(value))
+			continue
+		}
+
+		size, err := io.Copy(io.Discard, part)
+		if err != nil {
+			return nil
+		}
+		files[name] = append(files[name], logrus.Fields{
+			"contentType": part.Header.Get("Content-Type"),
+			"fileName":    part.FileName(),
+			"size":        size,
+		})
+	}
+
+	fields := logrus.Fields{}
+	for name, value := range values {
+		fields[name] = formValue(value)
+	}
+	for name, file := range files {
+		if len(file) == 1 {
+			fields[name] = file[0]
+		} else {
+			fields[name] = file
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return fields
+}
+
+// formValue collapses the values of a form key to a string when there is only one.
+func formValue(values []string) any {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// parseXML decodes an XML document into a map keyed by the local name of its root element.
+// Elements without attributes or children map to their text. Other elements map to a map of
+// their children, with repeated children as slices, attributes prefixed with "@", and
+// non-blank text under "#text". Documents nested deeper than maxXMLDepth yield nil.
+func parseXML(body []byte) logrus.Fields {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := xmlElement(decoder, start, 1)
+			if err != nil {
+				return nil
+			}
+			return logrus.Fields{start.Name.Local: value}
+		}
+	}
+}
+
+// xmlElement decodes the element opened by start at the given depth, see parseXML.
+func xmlElement(decoder *xml.Decoder, start xml.StartElement, depth int) (any, error) {
+	if depth > maxXMLDepth {
+		return nil, errors.New("xml is nested too deeply")
+	}
+
+	element := logrus.Fields{}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		element["@"+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := xmlElement(decoder, t, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := element[name].(type) {
+			case nil:
+				element[name] = child
+			case []any:
+				element[name] = append(existing, child)
+			default:
+				element[name] = []any{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			content := strings.TrimSpace(text.String())
+			if len(element) == 0 {
+				return content, nil
+			}
+			if content != "" {
+				element["#text"] = content
+			}
+			return element, nil
+		}
+	}
+}
+
+// soapAction returns the action of a SOAP request, taken from the SOAPAction header of
+// SOAP 1.1 or the action parameter of the SOAP 1.2 content type.
+func soapAction(header string, contentType string) string {
+	if action := strings.Trim(header, `"`); action != "" {
+		return action
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "application/soap+xml" {
+		return ""
+	}
+	return params["action"]
+}
+
+// headerValue returns the value of a header in a map built by the callers of the client
+// loggers, matching the name case-insensitively.
+func headerValue(header map[string]interface{}, name string) string {
+	for key, value := range header {
+		if strings.EqualFold(key, name) {
+			if s, ok := value.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// charsetOf returns the charset parameter of a content type, or an empty string if it has none.
+func charsetOf(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(params["charset"])
+}
+
+// captureRequestBody reports whether the request body of a request to path with contentType is
+// captured under config.RequestBodySkipPaths and config.RequestBodySkipTypes.
+func captureRequestBody(config Config, path, contentType string) bool {
+	if matchPath(config.RequestBodySkipPaths, path) {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return !matchMediaType(config.RequestBodySkipTypes, mediaType)
+}
+
+// reserveBody reserves n bytes of captured bodies from the memory budget, see logger.ReserveMemory.
+// The bytes of forced requests, see ForceLog, are claimed whatever the budget, so they are
+// captured in full.
+func reserveBody(n int64, force bool) bool {
+	if force {
+		logger.ClaimMemory(n)
+		return true
+	}
+	return logger.ReserveMemory(n)
+}
+
+// captureResponseBody reports whether the response body of a request ending with status is
+// captured under config.ResponseBodyOnError.
+func captureResponseBody(config Config, status int, body []byte) bool {
+	if !config.ResponseBodyOnError || status >= 400 {
+		return true
+	}
+	return config.ResponseBodyFunc != nil && config.ResponseBodyFunc(status, body)
+}
@@ -0,0 +1,40 @@
+package welog
+
+import (
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	"sync"
+)
+
+// Budget limits the volume of a log category with a token bucket: PerSecond entries are
+// allowed per second on average, with bursts of up to Burst entries. A zero PerSecond
+// means unlimited; a zero Burst allows one second worth of entries at once.
+type Budget struct {
+	PerSecond float64
+	Burst     int
+}
+
+var (
+	targetBudget      *util.TokenBucket // Budget of the target sub-entries, nil if unlimited
+	targetBudgetMutex sync.RWMutex      // Protects access to targetBudget
+)
+
+// applyBudgets installs the budgets of the configuration. Request documents and application
+// logs are limited by the ElasticSearch hook, target sub-entries by the client log functions.
+func applyBudgets(config Config) {
+	logger.SetBudget(logger.CategoryRequest, config.RequestBudget.PerSecond, config.RequestBudget.Burst)
+	logger.SetBudget(logger.CategoryApp, config.AppLogBudget.PerSecond, config.AppLogBudget.Burst)
+
+	targetBudgetMutex.Lock()
+	defer targetBudgetMutex.Unlock()
+
+	targetBudget = util.NewTokenBucket(config.TargetBudget.PerSecond, config.TargetBudget.Burst)
+}
+
+// allowTarget reports whether another target sub-entry fits into the budget.
+func allowTarget() bool {
+	targetBudgetMutex.RLock()
+	defer targetBudgetMutex.RUnlock()
+
+	return targetBudget.Allow()
+}
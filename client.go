@@ -0,0 +1,185 @@
+package welog
+
+import (
+	"context"
+	"errors"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/model"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"syscall"
+	"time"
+)
+
+// clientTransport is an http.RoundTripper that records the calls it sends as target entries
+// of the request document of ctx and propagates its request ID downstream.
+type clientTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+// NewTransport returns an http.RoundTripper sending requests through base, or
+// http.DefaultTransport if base is nil. Every call is recorded with LogTarget in the request
+// document of ctx, the request context of a Gin or net/http handler or the user context of a
+// Fiber handler, and requests without an X-Request-ID header get the request ID of ctx.
+func NewTransport(ctx context.Context, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &clientTransport{ctx: ctx, base: base}
+}
+
+// NewHTTPClient returns an http.Client sending its requests through NewTransport.
+func NewHTTPClient(ctx context.Context) *http.Client {
+	return &http.Client{Transport: NewTransport(ctx, nil)}
+}
+
+// WithTargetAttempt returns a copy of ctx recording attempt for the requests sent with it
+// through NewTransport, for callers retrying calls themselves:
+//
+//	req = req.WithContext(welog.WithTargetAttempt(req.Context(), model.TargetAttempt{Number: 2, MaxRetries: 3}))
+func WithTargetAttempt(ctx context.Context, attempt model.TargetAttempt) context.Context {
+	return context.WithValue(ctx, generalkey.TargetAttemptKey, attempt)
+}
+
+// RoundTrip sends req and records the call. The bodies are buffered so they can be logged
+// and still be read by the base transport and the caller, unless DisableBodyCapture is set.
+// The phases of the call are traced with httptrace. Calls failing without a response are
+// recorded with their error.
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	capture := !currentConfig().DisableBodyCapture
+
+	// Requests must not be modified by a RoundTripper, so the header, body, and trace go to a clone.
+	tracer := &clientTracer{}
+	req = req.Clone(httptrace.WithClientTrace(req.Context(), tracer.trace()))
+	if requestID := RequestIDFromContext(t.ctx); requestID != "" && req.Header.Get("X-Request-ID") == "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	request := model.TargetRequest{
+		URL:         req.URL.String(),
+		Method:      req.Method,
+		ContentType: req.Header.Get("Content-Type"),
+		Header:      util.HeaderToMap(req.Header),
+		Timestamp:   logger.Now(),
+	}
+	if capture {
+		request = model.TargetRequestFromHTTP(req)
+	}
+	request.Attempt, _ = req.Context().Value(generalkey.TargetAttemptKey).(model.TargetAttempt)
+
+	res, err := t.base.RoundTrip(req)
+	latency := logger.Since(request.Timestamp)
+	if err != nil {
+		LogTarget(t.ctx, request, model.TargetResponse{Latency: latency, Error: err, Timing: tracer.result()})
+		return res, err
+	}
+
+	response := model.TargetResponse{
+		Header:  util.HeaderToMap(res.Header),
+		Status:  res.StatusCode,
+		Latency: latency,
+	}
+	if capture {
+		response = model.TargetResponseFromHTTP(res, latency)
+	}
+	response.Timing = tracer.result()
+
+	LogTarget(t.ctx, request, response)
+	return res, nil
+}
+
+// LogTarget records a call to a target in the request document of ctx, like the LogClient
+// functions of the framework integrations. Describe calls made with net/http with
+// model.TargetRequestFromHTTP and model.TargetResponseFromHTTP.
+func LogTarget(ctx context.Context, request model.TargetRequest, response model.TargetResponse) {
+	AddTarget(ctx, BuildTargetLogFields(request, response))
+}
+
+// BuildTargetLogFields returns the target entry of a call, as recorded by LogTarget and the
+// LogClient functions of the framework integrations.
+func BuildTargetLogFields(request model.TargetRequest, response model.TargetResponse) logrus.Fields {
+	requestField := ParseBody(request.ContentType, request.Body)
+	responseContentType := headerValue(response.Header, "Content-Type")
+	responseField := ParseBody(responseContentType, response.Body)
+
+	logData := logrus.Fields{
+		"targetRequestBody":        requestField,
+		"targetRequestBodyString":  bodyString(request.ContentType, request.Body),
+		"targetRequestContentType": request.ContentType,
+		"targetRequestHeader":      request.Header,
+		"targetRequestMethod":      request.Method,
+		"targetRequestTimestamp":   request.Timestamp.Format(time.RFC3339Nano),
+		"targetRequestURL":         request.URL,
+		"targetResponseBody":       responseField,
+		"targetResponseBodyString": bodyString(responseContentType, response.Body),
+		"targetResponseHeader":     response.Header,
+		"targetResponseLatency":    response.Latency.String(),
+		"targetResponseLatencyMs":  util.Milliseconds(response.Latency),
+		"targetResponseStatus":     response.Status,
+		"targetResponseTimestamp":  request.Timestamp.Add(response.Latency).Format(time.RFC3339Nano),
+	}
+
+	if class := statusClass(response.Status); class != "" {
+		logData["targetResponseStatusClass"] = class
+	}
+
+	if action := soapAction(headerValue(request.Header, "SOAPAction"), request.ContentType); action != "" {
+		logData["targetSoapAction"] = action
+	}
+
+	if attempt := request.Attempt; attempt.Number > 0 {
+		logData["targetRequestAttempt"] = attempt.Number
+		logData["targetRequestMaxRetries"] = attempt.MaxRetries
+		logData["targetRequestBackoff"] = attempt.Backoff.String()
+		logData["targetRequestBackoffMs"] = util.Milliseconds(attempt.Backoff)
+	}
+
+	if timing := response.Timing; timing != (model.TargetTiming{}) {
+		logData["targetTimingDns"] = timing.DNS.String()
+		logData["targetTimingDnsMs"] = util.Milliseconds(timing.DNS)
+		logData["targetTimingConnect"] = timing.Connect.String()
+		logData["targetTimingConnectMs"] = util.Milliseconds(timing.Connect)
+		logData["targetTimingTlsHandshake"] = timing.TLSHandshake.String()
+		logData["targetTimingTlsHandshakeMs"] = util.Milliseconds(timing.TLSHandshake)
+		logData["targetTimingFirstByte"] = timing.FirstByte.String()
+		logData["targetTimingFirstByteMs"] = util.Milliseconds(timing.FirstByte)
+		logData["targetConnectionReused"] = timing.Reused
+	}
+
+	if response.Error != nil {
+		logData["targetResponseError"] = response.Error.Error()
+		logData["targetResponseErrorType"] = response.ErrorType
+		if response.ErrorType == "" {
+			logData["targetResponseErrorType"] = errorType(response.Error)
+		}
+	}
+
+	return logData
+}
+
+// errorType classifies a transport error for the targetResponseErrorType field.
+func errorType(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	default:
+		return "other"
+	}
+}
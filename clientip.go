@@ -0,0 +1,91 @@
+package welog
+
+import (
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// clientIPHeaders are the headers naming the client IP, in order of precedence. X-Forwarded-For
+// is handled separately because it lists every hop.
+var clientIPHeaders = []string{"CF-Connecting-IP", "True-Client-IP", "X-Real-IP"}
+
+var (
+	trustedProxies      []netip.Prefix // Networks of the proxies allowed to set client IP headers
+	trustedProxiesMutex sync.RWMutex   // Protects access to trustedProxies
+)
+
+// applyTrustedProxies parses the trusted proxies of the configuration. Entries are CIDRs or
+// single addresses; invalid entries are logged and ignored.
+func applyTrustedProxies(config Config) {
+	var prefixes []netip.Prefix
+	for _, proxy := range config.TrustedProxies {
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else {
+			logger.Logger().Errorf("invalid trusted proxy %q", proxy)
+		}
+	}
+
+	trustedProxiesMutex.Lock()
+	defer trustedProxiesMutex.Unlock()
+
+	trustedProxies = prefixes
+}
+
+// trusted reports whether ip belongs to a trusted proxy.
+func trusted(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP resolves the client IP of a request received from remoteAddr. Client IP headers
+// are only honored when remoteAddr is a trusted proxy: CF-Connecting-IP, True-Client-IP, and
+// X-Real-IP are taken as they are, and X-Forwarded-For is walked from the right, skipping
+// trusted proxies. fallback is returned when no proxies are configured, to keep the
+// resolution of the framework.
+func clientIP(remoteAddr string, getHeader func(string) string, fallback string) string {
+	trustedProxiesMutex.RLock()
+	prefixes := trustedProxies
+	trustedProxiesMutex.RUnlock()
+
+	if len(prefixes) == 0 {
+		return fallback
+	}
+	if !trusted(prefixes, remoteAddr) {
+		return remoteAddr
+	}
+
+	for _, name := range clientIPHeaders {
+		if ip := strings.TrimSpace(getHeader(name)); ip != "" {
+			if _, err := netip.ParseAddr(ip); err == nil {
+				return ip
+			}
+		}
+	}
+
+	hops := strings.Split(getHeader("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		if !trusted(prefixes, hop) {
+			return hop
+		}
+	}
+
+	return remoteAddr
+}
@@ -0,0 +1,82 @@
+package welog
+
+import (
+	"crypto/tls"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/model"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// clientTracer records the timing of a call sent by the transport of NewTransport. The hooks of
+// an httptrace.ClientTrace may run on other goroutines, so the timing is guarded by a mutex.
+type clientTracer struct {
+	mu       sync.Mutex
+	timing   model.TargetTiming
+	dnsStart time.Time
+	dialed   time.Time // Start of the TCP connection
+	tlsStart time.Time
+	wrote    time.Time // Time the request was written
+}
+
+// trace returns the hooks recording the timing of the call.
+func (t *clientTracer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = logger.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.DNS = logger.Since(t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dialed = logger.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.Connect = logger.Since(t.dialed)
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = logger.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.TLSHandshake = logger.Since(t.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.timing.Reused = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wrote = logger.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if !t.wrote.IsZero() {
+				t.timing.FirstByte = logger.Since(t.wrote)
+			}
+		},
+	}
+}
+
+// result returns the timing recorded so far.
+func (t *clientTracer) result() model.TargetTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.timing
+}
@@ -0,0 +1,113 @@
+package welog
+
+import (
+	"context"
+	"fmt"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// clientLogStore accumulates the target log entries of a single request. It is stored
+// behind generalkey.ClientLogKey and guarded by a mutex so handlers may log client calls
+// from multiple goroutines.
+type clientLogStore struct {
+	mu      sync.Mutex
+	entries []logrus.Fields
+	dropped int // Entries rejected because the target budget was exhausted
+}
+
+// append adds an entry to the store, unless the target budget is exhausted, and returns a
+// snapshot of all entries.
+func (s *clientLogStore) append(fields logrus.Fields) []logrus.Fields {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if allowTarget() {
+		s.entries = append(s.entries, fields)
+	} else {
+		s.dropped++
+	}
+
+	return s.snapshot()
+}
+
+// droppedCount returns the number of entries rejected because of the target budget.
+func (s *clientLogStore) droppedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// list returns a snapshot of all entries in the store.
+func (s *clientLogStore) list() []logrus.Fields {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snapshot()
+}
+
+// snapshot copies the entries so callers can't observe later appends. The caller must hold mu.
+func (s *clientLogStore) snapshot() []logrus.Fields {
+	return append([]logrus.Fields{}, s.entries...)
+}
+
+// recoveredPanic describes a panic recovered by the middlewares. It is recorded by
+// Request.Recovered and turned into fields of the request log.
+type recoveredPanic struct {
+	value any
+	stack []byte
+}
+
+// fields returns the log fields describing the panic.
+func (p *recoveredPanic) fields() logrus.Fields {
+	return logrus.Fields{
+		"panic":      fmt.Sprint(p.value),
+		"stackTrace": string(p.stack),
+	}
+}
+
+// LoggerFromContext returns the request-scoped logger stored in ctx, such as the
+// request context of a Gin or net/http handler or the user context of a Fiber handler.
+// If no logger is stored, an entry of the global logger is returned.
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(generalkey.LoggerKey).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logger.Logger())
+}
+
+// Component returns a child of the request-scoped logger of ctx tagged with the component
+// field, such as "billing", so the entries of a module can be filtered while keeping the
+// request ID. Outside of requests, the child of the global logger only carries the component.
+func Component(ctx context.Context, name string) *logrus.Entry {
+	return LoggerFromContext(ctx).WithContext(ctx).WithField("component", name)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or an empty string if none is set.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(generalkey.RequestIDKey).(string)
+	return requestID
+}
+
+// AddTarget appends fields as a sub-entry to the target field of the request document of
+// ctx, like the LogClient functions of the framework integrations, for integrations that only
+// have the context, such as GraphQL servers. Pass the request context of a Gin or net/http
+// handler or the user context of a Fiber handler. It does nothing if ctx doesn't belong to a
+// request handled by the middlewares.
+func AddTarget(ctx context.Context, fields logrus.Fields) {
+	if store, ok := ctx.Value(generalkey.ClientLogKey).(*clientLogStore); ok {
+		store.append(fields)
+	}
+}
+
+// Targets returns the sub-entries of the target field of the request document of ctx, so far.
+func Targets(ctx context.Context) []logrus.Fields {
+	store, _ := ctx.Value(generalkey.ClientLogKey).(*clientLogStore)
+	if store == nil {
+		return nil
+	}
+	return store.list()
+}
@@ -0,0 +1,223 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/sirupsen/logrus"
+	"maps"
+	"reflect"
+	"sync"
+)
+
+// DarkLaunchReport compares the documents of the current configuration with those of a
+// dark-launched candidate configuration.
+type DarkLaunchReport struct {
+	Requests   uint64            // Requests seen since the dark launch started
+	Current    uint64            // Requests logged by the current configuration
+	Candidate  uint64            // Requests logged by the candidate configuration
+	FieldDiffs map[string]uint64 // Number of requests logged by both whose documents differ, per field
+}
+
+// darkRequest holds the parts of a request from which the candidate configuration builds its
+// own version of the request document.
+type darkRequest struct {
+	method              string
+	path                string
+	url                 string              // URL of the request, not redacted
+	query               string              // Raw query string
+	params              map[string]string   // Route parameters, not redacted, nil without routes
+	headers             map[string][]string // Request headers
+	requestContentType  string
+	requestBody         []byte // Request body, whatever the capture settings of the current configuration
+	responseContentType string
+	responseBody        []byte // Response body, whatever the capture settings of the current configuration
+	status              int
+}
+
+// darkLaunch runs a candidate configuration next to the current one.
+type darkLaunch struct {
+	config  Config
+	limiter rateLimiter // Per-second cap of the candidate, separate from the current one
+
+	mu     sync.Mutex
+	report DarkLaunchReport
+}
+
+var (
+	activeDarkLaunch *darkLaunch  // Running dark launch, nil if none
+	darkLaunchMutex  sync.RWMutex // Protects access to activeDarkLaunch
+)
+
+// StartDarkLaunch runs candidate side by side with the configuration set by SetConfig to
+// de-risk a configuration rollout. Every request is also evaluated with the skip, sampling,
+// and body capture settings of candidate, and its document is built with the redacted keys,
+// body settings, client hints, ECS fields, and field naming of candidate. The documents it
+// keeps are written to the index prefix index, e.g. "welog-staging", and the differences are
+// reported by DarkLaunchStatus. The other settings, such as the ElasticSearch settings and the
+// memory budget, are those of the current configuration. Starting a dark launch resets the report.
+func StartDarkLaunch(candidate Config, index string) {
+	logger.SetCategoryIndex(logger.CategoryDarkLaunch, index)
+
+	darkLaunchMutex.Lock()
+	defer darkLaunchMutex.Unlock()
+
+	activeDarkLaunch = &darkLaunch{
+		config: candidate,
+		report: DarkLaunchReport{FieldDiffs: map[string]uint64{}},
+	}
+}
+
+// StopDarkLaunch stops the running dark launch and returns its final report.
+func StopDarkLaunch() DarkLaunchReport {
+	report := DarkLaunchStatus()
+
+	darkLaunchMutex.Lock()
+	activeDarkLaunch = nil
+	darkLaunchMutex.Unlock()
+
+	logger.SetCategoryIndex(logger.CategoryDarkLaunch, "")
+
+	return report
+}
+
+// DarkLaunchStatus returns the report of the running dark launch, empty if none is running.
+func DarkLaunchStatus() DarkLaunchReport {
+	d := currentDarkLaunch()
+	if d == nil {
+		return DarkLaunchReport{}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	report := d.report
+	report.FieldDiffs = make(map[string]uint64, len(d.report.FieldDiffs))
+	for field, count := range d.report.FieldDiffs {
+		report.FieldDiffs[field] = count
+	}
+	return report
+}
+
+// currentDarkLaunch returns the running dark launch, nil if none is running.
+func currentDarkLaunch() *darkLaunch {
+	darkLaunchMutex.RLock()
+	defer darkLaunchMutex.RUnlock()
+
+	return activeDarkLaunch
+}
+
+// skips reports whether the candidate excludes the requests with method to path, see
+// shouldSkip. It returns true when no dark launch is running.
+func (d *darkLaunch) skips(method, path string) bool {
+	return d == nil || shouldSkip(d.config, method, path)
+}
+
+// sampled reports whether the candidate keeps a request, see sampled. It returns false when
+// no dark launch is running or the candidate excludes the request.
+func (d *darkLaunch) sampled(force bool, method, path, requestID string, level logrus.Level) bool {
+	if d.skips(method, path) {
+		return false
+	}
+	return force || sampled(d.config, &d.limiter, requestID, level)
+}
+
+// capturesBodies reports whether the candidate captures some bodies, so they must be kept for
+// it even if the current configuration doesn't capture them.
+func (d *darkLaunch) capturesBodies() bool {
+	return d != nil && !d.config.DisableBodyCapture
+}
+
+// capturesRequestBody reports whether the candidate captures the body of a request to path
+// with contentType, see captureRequestBody.
+func (d *darkLaunch) capturesRequestBody(force bool, path, contentType string) bool {
+	return d.capturesBodies() && (force || captureRequestBody(d.config, path, contentType))
+}
+
+// log writes the candidate's version of a request document, built by document, to the dark
+// launch index and compares it with the document of the current configuration, nil if that
+// one was dropped.
+func (d *darkLaunch) log(
+	ctx context.Context,
+	entry *logrus.Entry,
+	level logrus.Level,
+	current logrus.Fields,
+	candidate logrus.Fields,
+) {
+	plugin.Apply(candidate)
+	applyECSFields(d.config, candidate)
+	applyFieldNaming(d.config, candidate)
+
+	entry.WithContext(logger.WithCategory(ctx, logger.CategoryDarkLaunch)).
+		WithFields(candidate).
+		Log(level)
+
+	d.record(current, candidate)
+}
+
+// document returns the candidate's version of a request document: a copy of fields, the
+// current document before the plugins, ECS, and field naming apply, with the parts depending
+// on the configuration rebuilt from request under the candidate configuration.
+func (d *darkLaunch) document(force bool, request darkRequest, fields logrus.Fields) logrus.Fields {
+	config := d.config
+	candidate := maps.Clone(fields)
+	candidate["requestUrl"] = redactURLFor(config, request.url)
+	candidate["requestQuery"] = queryFieldsFor(config, request.query)
+	if request.params != nil {
+		candidate["requestParams"] = paramFieldsFor(config, request.params)
+	}
+
+	requestBody, responseBody := request.requestBody, request.responseBody
+	if config.DisableBodyCapture {
+		requestBody, responseBody = nil, nil
+	}
+	if !force && !captureRequestBody(config, request.path, request.requestContentType) {
+		requestBody = nil
+	}
+	if !force && !captureResponseBody(config, request.status, responseBody) {
+		responseBody = nil
+	}
+	candidate["requestBody"] = parseBodyFor(config, request.requestContentType, requestBody)
+	candidate["requestBodyString"] = bodyStringFor(config, request.requestContentType, requestBody)
+	candidate["responseBody"] = parseBodyFor(config, request.responseContentType, responseBody)
+	candidate["responseBodyString"] = bodyStringFor(config, request.responseContentType, responseBody)
+
+	delete(candidate, "requestLanguages")
+	delete(candidate, "requestClientHints")
+	addClientHints(config, request.headers, candidate)
+
+	return candidate
+}
+
+// record counts a request and the fields in which its documents differ. Either document
+// is nil if its configuration dropped the request.
+func (d *darkLaunch) record(current, candidate logrus.Fields) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.report.Requests++
+	if current != nil {
+		d.report.Current++
+	}
+	if candidate != nil {
+		d.report.Candidate++
+	}
+	if current == nil || candidate == nil {
+		return
+	}
+
+	for key, value := range current {
+		if other, ok := candidate[key]; !ok || !reflect.DeepEqual(value, other) {
+			d.report.FieldDiffs[key]++
+		}
+	}
+	for key := range candidate {
+		if _, ok := current[key]; !ok {
+			d.report.FieldDiffs[key]++
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+)
+
+// Doctor checks why the logs may not show up in ElasticSearch, and returns a report with a
+// check of the configuration followed by those of logger.Doctor: connectivity, credentials,
+// index template, write permission, and fallback file. Print it at startup or expose it on an
+// internal admin route to save guessing:
+//
+//	if report := welog.Doctor(ctx); !report.OK() {
+//		log.Printf("welog: %+v", report.Checks)
+//	}
+func Doctor(ctx context.Context) logger.Report {
+	check := logger.Check{Name: "configuration", Status: logger.CheckOK}
+	if err := validateConfig(currentConfig()); err != nil {
+		check.Status, check.Detail = logger.CheckFailed, err.Error()
+	}
+
+	report := logger.Doctor(ctx)
+	report.Checks = append([]logger.Check{check}, report.Checks...)
+	return report
+}
@@ -0,0 +1,65 @@
+package welog
+
+import (
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// ecsFieldNames maps the fields of the request documents to their Elastic Common Schema
+// equivalent, used under Config.ECSFields. Fields without an equivalent keep their name.
+var ecsFieldNames = map[string]string{
+	"requestAgent":        "user_agent.original",
+	"requestBodyString":   "http.request.body.content",
+	"requestBytes":        "http.request.body.bytes",
+	"requestContentType":  "http.request.mime_type",
+	"requestIp":           "client.ip",
+	"requestMethod":       "http.request.method",
+	"requestRemoteAddr":   "source.ip",
+	"requestTimestamp":    "event.start",
+	"requestUrl":          "url.full",
+	"responseBodyString":  "http.response.body.content",
+	"responseBytes":       "http.response.body.bytes",
+	"responseContentType": "http.response.mime_type",
+	"responseStatus":      "http.response.status_code",
+	"responseTimestamp":   "event.end",
+	"transactionName":     "transaction.name",
+}
+
+// addEventFields adds the ECS event fields of a request document logged at level, which the
+// built-in views of Elastic Observability rely on: event.category "web", event.duration in
+// nanoseconds, and event.outcome, "failure" for requests logged at error level or above, such
+// as server errors and panics, and "success" otherwise.
+func addEventFields(fields logrus.Fields, level logrus.Level, latency time.Duration) {
+	outcome := "success"
+	if level <= logrus.ErrorLevel {
+		outcome = "failure"
+	}
+
+	fields["event.category"] = "web"
+	fields["event.duration"] = latency.Nanoseconds()
+	fields["event.outcome"] = outcome
+}
+
+// applyECSFields renames the fields of a request document after ECS if enabled by config,
+// so the Kibana and APM dashboards work without reindexing. The latency is left to
+// event.duration and the protocol becomes http.version, e.g. "1.1".
+func applyECSFields(config Config, fields logrus.Fields) {
+	if !config.ECSFields {
+		return
+	}
+
+	for name, ecsName := range ecsFieldNames {
+		if value, ok := fields[name]; ok {
+			delete(fields, name)
+			fields[ecsName] = value
+		}
+	}
+
+	delete(fields, "responseLatency")
+	delete(fields, "responseLatencyMs")
+	if protocol, ok := fields["requestProtocol"].(string); ok {
+		delete(fields, "requestProtocol")
+		fields["http.version"] = strings.TrimPrefix(protocol, "HTTP/")
+	}
+}
@@ -0,0 +1,59 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// eventStore accumulates the business events of a single request. It is stored behind
+// generalkey.EventKey and guarded by a mutex, like clientLogStore.
+type eventStore struct {
+	mu     sync.Mutex
+	events []logrus.Fields
+}
+
+// append adds an event to the store.
+func (s *eventStore) append(event logrus.Fields) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+}
+
+// list returns a snapshot of the events in the store, nil for a nil store.
+func (s *eventStore) list() []logrus.Fields {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]logrus.Fields(nil), s.events...)
+}
+
+// LogEvent records a business event, such as "order_created" or "payment_failed", so product
+// analytics can be derived from the logs. Within a request handled by the middlewares or a
+// job started with NewJobContext, the event is attached to the events field of its document,
+// and shares its sampling. Elsewhere, it is logged as an entry of its own, carrying the
+// request ID of ctx if any. Events have the eventName and eventTimestamp fields besides fields.
+func LogEvent(ctx context.Context, name string, fields logrus.Fields) {
+	event := logrus.Fields{}
+	for key, value := range fields {
+		event[key] = value
+	}
+	event["eventName"] = name
+	event["eventTimestamp"] = logger.Now().Format(time.RFC3339Nano)
+
+	if store, ok := ctx.Value(generalkey.EventKey).(*eventStore); ok {
+		store.append(event)
+		return
+	}
+
+	applyFieldNaming(currentConfig(), event)
+	LoggerFromContext(ctx).WithContext(ctx).WithFields(event).Info(name)
+}
@@ -0,0 +1,64 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+)
+
+// exampleFields are the fields of a request document copied into its example.
+var exampleFields = []string{
+	"requestBody", "requestContentType", "requestHeader", "requestMethod",
+	"responseBody", "responseHeader", "responseStatus",
+}
+
+var (
+	examplesSeen  = map[string]struct{}{} // Route/status combinations that already have an example
+	examplesMutex sync.Mutex              // Protects access to examplesSeen
+)
+
+// collectExample writes a sanitized copy of the request document to the example index
+// if CollectExamples is enabled and the route/status combination has no example yet.
+// Requests without a matched route are grouped under "unmatched".
+func collectExample(ctx context.Context, route string, status int, fields logrus.Fields) {
+	if !currentConfig().CollectExamples {
+		return
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+
+	method, _ := fields["requestMethod"].(string)
+	key := method + " " + route + " " + strconv.Itoa(status)
+
+	examplesMutex.Lock()
+	_, seen := examplesSeen[key]
+	examplesSeen[key] = struct{}{}
+	examplesMutex.Unlock()
+
+	if seen {
+		return
+	}
+
+	example := logrus.Fields{"exampleRoute": route}
+	for _, name := range exampleFields {
+		example[name] = util.Sanitize(fields[name])
+	}
+
+	logrus.NewEntry(logger.Logger()).
+		WithContext(logger.WithCategory(ctx, logger.CategoryExample)).
+		WithFields(example).
+		Info("example")
+}
+
+// applyExampleIndex routes the examples to their own index.
+func applyExampleIndex(config Config) {
+	index := config.ExampleIndex
+	if index == "" && config.CollectExamples {
+		index = config.ElasticIndex + "-examples"
+	}
+	logger.SetCategoryIndex(logger.CategoryExample, index)
+}
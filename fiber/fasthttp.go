@@ -0,0 +1,125 @@
+package welogfiber
+
+import (
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// fastHTTPFieldsFunc holds the function set by SetFastHTTPFieldsFunc.
+var fastHTTPFieldsFunc atomic.Pointer[func(ctx *fasthttp.RequestCtx) logrus.Fields]
+
+// SetFastHTTPFieldsFunc sets a function returning fields merged into the request documents of
+// NewFastHTTP, such as a user ID or feature flags. It runs after the handler, before the
+// plugins. Nil removes it.
+func SetFastHTTPFieldsFunc(f func(ctx *fasthttp.RequestCtx) logrus.Fields) {
+	fastHTTPFieldsFunc.Store(&f)
+}
+
+// NewFastHTTP wraps a fasthttp handler, for services using fasthttp without Fiber, so it logs
+// requests and responses like New. The *fasthttp.RequestCtx passed to next is also the context
+// to use with welog.LoggerFromContext, welog.RequestIDFromContext, welog.AddTarget, and
+// welog.SetUser. Without a router there are no routes, so the requestRoute, requestHandler, and
+// requestParams fields, the anomaly detection, and the examples are left out.
+func NewFastHTTP(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		getHeader := func(name string) string { return string(ctx.Request.Header.Peek(name)) }
+		r := welog.StartRequest(string(ctx.Method()), string(ctx.Path()), getHeader, func(name string) string {
+			return string(ctx.Request.Header.Cookie(name))
+		})
+
+		// Set the request ID to the response.
+		ctx.Response.Header.Set("X-Request-ID", r.ID)
+
+		// Set request-related values to the user values, which are the values of the context.
+		values := r.Values()
+		for i := 0; i+1 < len(values); i += 2 {
+			ctx.SetUserValue(values[i], values[i+1])
+		}
+
+		// Emit progress documents while the request runs. fasthttp reads the body before the handler.
+		r.StartProgress(ctx, string(ctx.URI().FullURI()))
+		r.BodyRead(int64(len(ctx.Request.Body())))
+
+		// Proceed to the handler, recovering from panics.
+		nextFastHTTP(ctx, next, r)
+
+		// Log the request and response details.
+		remoteAddr := ctx.RemoteIP().String()
+		r.Log(welog.Exchange{
+			Context:    ctx,
+			URL:        string(ctx.URI().FullURI()),
+			Query:      string(ctx.URI().QueryString()),
+			Host:       string(ctx.Host()),
+			Protocol:   string(ctx.Request.Header.Protocol()),
+			NoRoutes:   true,
+			Header:     requestHeader(&ctx.Request.Header),
+			GetHeader:  getHeader,
+			RemoteAddr: remoteAddr,
+			ClientIP:   remoteAddr,
+
+			RequestContentType: string(ctx.Request.Header.ContentType()),
+			RequestBody:        ctx.Request.Body(),
+			RequestBytes:       requestBytes(&ctx.Request),
+
+			Status:                  ctx.Response.StatusCode(),
+			ResponseHeader:          util.HeaderToMap(&ctx.Response.Header),
+			ResponseContentType:     string(ctx.Response.Header.ContentType()),
+			ResponseContentEncoding: string(ctx.Response.Header.ContentEncoding()),
+			ResponseBody:            ctx.Response.Body(),
+			ResponseBytes:           responseBytes(&ctx.Response),
+
+			Fields: func() logrus.Fields {
+				if f := fastHTTPFieldsFunc.Load(); f != nil && *f != nil {
+					return (*f)(ctx)
+				}
+				return nil
+			},
+		})
+	}
+}
+
+// nextFastHTTP calls next and converts a panic into a 500 response. The recovered value and
+// stack trace are recorded in r.
+func nextFastHTTP(ctx *fasthttp.RequestCtx, next fasthttp.RequestHandler, r *welog.Request) {
+	defer func() {
+		if value := recover(); value != nil {
+			r.Recovered(value, debug.Stack())
+			ctx.Response.Reset()
+			ctx.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError), fasthttp.StatusInternalServerError)
+		}
+	}()
+
+	next(ctx)
+}
+
+// requestHeader returns the request headers in the shape of Fiber's GetReqHeaders, with every
+// value of a repeated header.
+func requestHeader(header *fasthttp.RequestHeader) map[string][]string {
+	headers := make(map[string][]string)
+	header.VisitAll(func(key, value []byte) {
+		headers[string(key)] = append(headers[string(key)], string(value))
+	})
+	return headers
+}
+
+// requestBytes returns the size of the body of req, from its Content-Length if the body is
+// streamed, which reading would consume.
+func requestBytes(req *fasthttp.Request) int64 {
+	if req.IsBodyStream() {
+		return int64(max(req.Header.ContentLength(), 0))
+	}
+	return int64(len(req.Body()))
+}
+
+// responseBytes returns the size of the body of res, from its Content-Length if the body is
+// streamed, which reading would consume.
+func responseBytes(res *fasthttp.Response) int64 {
+	if res.IsBodyStream() {
+		return int64(max(res.Header.ContentLength(), 0))
+	}
+	return int64(len(res.Body()))
+}
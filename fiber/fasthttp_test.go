@@ -0,0 +1,55 @@
+package welogfiber
+
+import (
+	"github.com/christiandoxa/welog"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"testing"
+)
+
+// TestNewFastHTTP tests that NewFastHTTP logs raw fasthttp requests, recovers from panics, and
+// makes the request context usable as a welog context.
+func TestNewFastHTTP(t *testing.T) {
+	// Call the SetConfig function
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Wrap a handler recording a target and reading the request ID.
+	var requestID string
+	handler := NewFastHTTP(func(ctx *fasthttp.RequestCtx) {
+		requestID = welog.RequestIDFromContext(ctx)
+		welog.AddTarget(ctx, logrus.Fields{"targetRequestURL": "http://downstream/"})
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"ok":true}`)
+	})
+
+	var ctx fasthttp.RequestCtx
+	ctx.Request.Header.SetMethod(http.MethodPost)
+	ctx.Request.SetRequestURI("http://example.com/items?page=2")
+	ctx.Request.Header.Set("X-Request-ID", "fasthttp-id")
+	ctx.Request.Header.SetContentType("application/json")
+	ctx.Request.SetBodyString(`{"key":"value"}`)
+	handler(&ctx)
+
+	// Assert that the request ID is propagated and the document carries the usual fields.
+	assert.Equal(t, "fasthttp-id", requestID)
+	assert.Equal(t, "fasthttp-id", string(ctx.Response.Header.Peek("X-Request-ID")))
+	assert.Contains(t, buf.String(), `"requestMethod":"POST"`)
+	assert.Contains(t, buf.String(), `"requestUrl":"http://example.com/items?page=2"`)
+	assert.Contains(t, buf.String(), `"requestBody":{"key":"value"}`)
+	assert.Contains(t, buf.String(), `"responseBody":{"ok":true}`)
+	assert.Contains(t, buf.String(), `"responseStatus":200`)
+	assert.Contains(t, buf.String(), `"targetRequestURL":"http://downstream/"`)
+	assert.NotContains(t, buf.String(), `"requestRoute"`)
+
+	// Assert that a panic is converted into a 500 response logged at error level.
+	buf.Reset()
+	var panicCtx fasthttp.RequestCtx
+	panicCtx.Request.SetRequestURI("/")
+	NewFastHTTP(func(ctx *fasthttp.RequestCtx) { panic("boom") })(&panicCtx)
+	assert.Equal(t, fasthttp.StatusInternalServerError, panicCtx.Response.StatusCode())
+	assert.Contains(t, buf.String(), `"log.level":"error"`)
+	assert.Contains(t, buf.String(), `"panic":"boom"`)
+}
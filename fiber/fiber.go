@@ -0,0 +1,244 @@
+// Package welogfiber logs the requests of Fiber applications, and of services using fasthttp
+// without Fiber, with welog. It is a module of its own, so the applications of other frameworks
+// don't depend on Fiber and fasthttp:
+//
+//	import welogfiber "github.com/christiandoxa/welog/fiber"
+//
+//	app := fiber.New(fiberConfig)
+//	app.Use(welogfiber.New(fiberConfig))
+package welogfiber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/model"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// fieldsFunc holds the function set by SetFieldsFunc.
+var fieldsFunc atomic.Pointer[func(c *fiber.Ctx) logrus.Fields]
+
+// SetFieldsFunc sets a function returning fields merged into the request documents of New,
+// such as a user ID or feature flags. It runs after the handlers, before the plugins. Nil
+// removes it.
+func SetFieldsFunc(f func(c *fiber.Ctx) logrus.Fields) {
+	fieldsFunc.Store(&f)
+}
+
+// New creates a new Fiber middleware that logs requests and responses. The errors returned by
+// the handlers are passed to the ErrorHandler of fiberConfig first, so the request document has
+// their status.
+func New(fiberConfig fiber.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		r := welog.StartRequest(c.Method(), c.Path(), func(name string) string { return c.Get(name) }, func(name string) string {
+			return c.Cookies(name)
+		})
+
+		// Set the request ID to the response.
+		c.Set("X-Request-ID", r.ID)
+
+		// Set request-related values to the context.
+		setValues(c, r.Values()...)
+
+		// Keep the legacy string keys populated for handlers that haven't migrated yet.
+		c.Locals(generalkey.RequestID, r.ID)
+		c.Locals(generalkey.Logger, r.Logger)
+		c.Locals(generalkey.ClientLog, []logrus.Fields{})
+
+		// Emit progress documents while the request runs. Fiber reads the body before the handlers.
+		r.StartProgress(c.UserContext(), c.BaseURL()+c.OriginalURL())
+		r.BodyRead(int64(len(c.Body())))
+
+		// Proceed to the next middleware and handle any errors, including recovered panics.
+		err := next(c, r)
+		handlerErr := err
+		if err != nil {
+			errorHandler := fiber.DefaultErrorHandler
+			if fiberConfig.ErrorHandler != nil {
+				errorHandler = fiberConfig.ErrorHandler
+			}
+			err = errorHandler(c, err)
+		}
+
+		// Log the request and response details.
+		logRequest(c, r, handlerErr)
+
+		return err
+	}
+}
+
+// NewE is like New, but first validates the configuration set by welog.SetConfig and, under
+// welog.StartupRequireElastic, the connection to ElasticSearch. It returns an error instead of
+// a middleware that can only log the problems.
+func NewE(fiberConfig fiber.Config) (fiber.Handler, error) {
+	if err := welog.CheckStartup(); err != nil {
+		return nil, err
+	}
+	return New(fiberConfig), nil
+}
+
+// next calls the next handler and converts a panic into fiber.ErrInternalServerError. The
+// recovered value and stack trace are recorded in r.
+func next(c *fiber.Ctx, r *welog.Request) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			r.Recovered(value, debug.Stack())
+			err = fiber.ErrInternalServerError
+		}
+	}()
+
+	return c.Next()
+}
+
+// logRequest logs the details of the Fiber request and response. handlerErr is the error
+// returned by the handlers, before the error handler rewrote the response.
+func logRequest(c *fiber.Ctx, r *welog.Request, handlerErr error) {
+	var errorFields logrus.Fields
+	if handlerErr != nil {
+		errorFields = errorFieldsOf(handlerErr)
+	}
+
+	r.Log(welog.Exchange{
+		Context:    c.UserContext(),
+		URL:        c.BaseURL() + c.OriginalURL(),
+		Query:      string(c.Request().URI().QueryString()),
+		Host:       c.Hostname(),
+		Protocol:   c.Protocol(),
+		Route:      c.Route().Path,
+		Handler:    handlerName(c.Route()),
+		Params:     c.AllParams(),
+		Header:     c.GetReqHeaders(),
+		GetHeader:  func(name string) string { return c.Get(name) },
+		RemoteAddr: c.Context().RemoteIP().String(),
+		ClientIP:   c.IP(),
+
+		RequestContentType: c.Get(fiber.HeaderContentType),
+		RequestBody:        c.Body(),
+		RequestBytes:       requestBytes(c.Request()),
+
+		Status:                  c.Response().StatusCode(),
+		ResponseHeader:          util.HeaderToMap(&c.Response().Header),
+		ResponseContentType:     string(c.Response().Header.ContentType()),
+		ResponseContentEncoding: string(c.Response().Header.Peek(fiber.HeaderContentEncoding)),
+		ResponseBody:            c.Response().Body(),
+		ResponseBytes:           responseBytes(c.Response()),
+
+		Err:         handlerErr,
+		ErrorFields: errorFields,
+		Fields: func() logrus.Fields {
+			if f := fieldsFunc.Load(); f != nil && *f != nil {
+				return (*f)(c)
+			}
+			return nil
+		},
+	})
+}
+
+// errorFieldsOf describes an error returned by the handlers with its message, its Go type, and,
+// for a *fiber.Error anywhere in its chain, its status code.
+func errorFieldsOf(err error) logrus.Fields {
+	fields := logrus.Fields{
+		"responseError":     err.Error(),
+		"responseErrorType": fmt.Sprintf("%T", err),
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		fields["responseErrorCode"] = fiberErr.Code
+	}
+
+	return fields
+}
+
+// handlerName returns the function name of the last handler of route, which is the endpoint
+// handler, or an empty string if the route has no handlers.
+func handlerName(route *fiber.Route) string {
+	if route == nil || len(route.Handlers) == 0 {
+		return ""
+	}
+	return runtime.FuncForPC(reflect.ValueOf(route.Handlers[len(route.Handlers)-1]).Pointer()).Name()
+}
+
+// Logger returns the request-scoped logger stored by New. If the middleware is not installed,
+// an entry of the global logger is returned so callers never get nil.
+func Logger(c *fiber.Ctx) *logrus.Entry {
+	if entry, ok := c.Locals(generalkey.LoggerKey).(*logrus.Entry); ok {
+		return entry
+	}
+	if entry, ok := c.Locals(generalkey.Logger).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logger.Logger())
+}
+
+// RequestID returns the request ID stored by New, or an empty string if none is set.
+func RequestID(c *fiber.Ctx) string {
+	if requestID, ok := c.Locals(generalkey.RequestIDKey).(string); ok {
+		return requestID
+	}
+	requestID, _ := c.Locals(generalkey.RequestID).(string)
+	return requestID
+}
+
+// LogClient logs a custom client request and response in the target field of the request
+// document of c.
+func LogClient(
+	c *fiber.Ctx,
+	requestURL string,
+	requestMethod string,
+	requestContentType string,
+	requestHeader map[string]interface{},
+	requestBody []byte,
+	responseHeader map[string]interface{},
+	responseBody []byte,
+	responseStatus int,
+	requestTime time.Time,
+	responseLatency time.Duration,
+) {
+	logData := welog.BuildTargetLogFields(model.TargetRequest{
+		URL:         requestURL,
+		Method:      requestMethod,
+		ContentType: requestContentType,
+		Header:      requestHeader,
+		Body:        requestBody,
+		Timestamp:   requestTime,
+	}, model.TargetResponse{
+		Header:  responseHeader,
+		Body:    responseBody,
+		Status:  responseStatus,
+		Latency: responseLatency,
+	})
+
+	// Without the middleware, the entries only accumulate under the legacy string key.
+	store := c.Locals(generalkey.ClientLogKey)
+	if store == nil {
+		legacy, _ := c.Locals(generalkey.ClientLog).([]logrus.Fields)
+		c.Locals(generalkey.ClientLog, append(legacy, logData))
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), generalkey.ClientLogKey, store)
+	welog.AddTarget(ctx, logData)
+	c.Locals(generalkey.ClientLog, welog.Targets(ctx))
+}
+
+// setValues stores key/value pairs in the locals and in the user context of c.
+func setValues(c *fiber.Ctx, keyValues ...any) {
+	ctx := c.UserContext()
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		c.Locals(keyValues[i], keyValues[i+1])
+		ctx = context.WithValue(ctx, keyValues[i], keyValues[i+1])
+	}
+	c.SetUserContext(ctx)
+}
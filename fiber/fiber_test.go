@@ -0,0 +1,303 @@
+package welogfiber
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	welogConfig = welog.Config{
+		ElasticIndex:    "welog",
+		ElasticURL:      "http://127.0.0.1:9200",
+		ElasticUsername: "elastic",
+		ElasticPassword: "changeme",
+	}
+)
+
+// captureOutput redirects the output of the global logger into a buffer for the duration of the test.
+func captureOutput(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+	return buf
+}
+
+// TestNew tests that New logs the requests and stores the request values for the handlers.
+func TestNew(t *testing.T) {
+	// Call the SetConfig function
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Fiber app with an endpoint asserting the values stored by the middleware.
+	app := fiber.New()
+	app.Use(New(fiber.Config{}))
+	app.Post("/users/:id", func(c *fiber.Ctx) error {
+		assert.Equal(t, "test-request-id", RequestID(c))
+		assert.Equal(t, "test-request-id", welog.RequestIDFromContext(c.UserContext()))
+		assert.Equal(t, "test-request-id", Logger(c).Data[generalkey.RequestID])
+		assert.Same(t, Logger(c), welog.LoggerFromContext(c.UserContext()))
+
+		// A string key set by another middleware must not override the typed keys.
+		c.Locals(generalkey.RequestID, "other-middleware")
+		assert.Equal(t, "test-request-id", RequestID(c))
+
+		return c.JSON(fiber.Map{"ok": true})
+	})
+
+	// Perform a request with a custom Request ID.
+	req := httptest.NewRequest(http.MethodPost, "/users/42?page=2", strings.NewReader(`{"key":"value"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "test-request-id")
+	resp, err := app.Test(req, -1) //nolint:bodyclose
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test-request-id", resp.Header.Get("X-Request-ID"))
+
+	// Assert that the document carries the route, the handler, and the bodies.
+	assert.Contains(t, buf.String(), `"requestUrl":"http://example.com/users/42?page=2"`)
+	assert.Contains(t, buf.String(), `"requestRoute":"/users/:id"`)
+	assert.Contains(t, buf.String(), `"requestParams":{"id":"42"}`)
+	assert.Contains(t, buf.String(), `"requestHandler":"github.com/christiandoxa/welog/fiber.TestNew.func1"`)
+	assert.Contains(t, buf.String(), `"requestBody":{"key":"value"}`)
+	assert.Contains(t, buf.String(), `"responseBody":{"ok":true}`)
+	assert.Contains(t, buf.String(), `"requestBytes":15`)
+	assert.Contains(t, buf.String(), `"responseBytes":11`)
+}
+
+// TestNewRecover tests that New recovers from panics and still logs the request.
+func TestNewRecover(t *testing.T) {
+	// Call the SetConfig function
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Fiber app with a panicking endpoint.
+	app := fiber.New()
+	app.Use(New(fiber.Config{}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		panic("boom")
+	})
+
+	// Perform the request and capture the response.
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1) //nolint:bodyclose
+
+	// Assert that the panic was converted and logged at error level with a stack trace.
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+	assert.Contains(t, buf.String(), `"log.level":"error"`)
+	assert.Contains(t, buf.String(), `"panic":"boom"`)
+	assert.Contains(t, buf.String(), `"stackTrace":"goroutine`)
+	assert.Contains(t, buf.String(), `"requestMethod":"GET"`)
+}
+
+// TestNewE tests that NewE reports an invalid configuration instead of returning a middleware.
+func TestNewE(t *testing.T) {
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+
+	// Assert that a valid configuration yields the middleware.
+	config := welogConfig
+	config.StdoutOnly = true
+	welog.SetConfig(config)
+	handler, err := NewE(fiber.Config{})
+	assert.NoError(t, err)
+	assert.NotNil(t, handler)
+
+	// Assert that an invalid configuration is reported.
+	config.SampleRate = 2
+	welog.SetConfig(config)
+	handler, err = NewE(fiber.Config{})
+	assert.ErrorContains(t, err, "SampleRate 2 is not between 0 and 1")
+	assert.Nil(t, handler)
+}
+
+// TestLevelFunc tests that the errors returned by the handlers are passed to LevelFunc.
+func TestLevelFunc(t *testing.T) {
+	// Log 404 responses at error level and carry the handler error to the hook.
+	config := welogConfig
+	var gotErr error
+	config.LevelFunc = func(status int, err error) logrus.Level {
+		gotErr = err
+		if status == http.StatusNotFound {
+			return logrus.ErrorLevel
+		}
+		return welog.DefaultLevelFunc(status, err)
+	}
+	welog.SetConfig(config)
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a new Fiber app without routes so every request is answered with 404.
+	app := fiber.New()
+	app.Use(New(fiber.Config{}))
+
+	// Perform the request and capture the response.
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/missing", nil), -1) //nolint:bodyclose
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	// Assert that the hook received the handler error and its level was used.
+	var fiberErr *fiber.Error
+	assert.ErrorAs(t, gotErr, &fiberErr)
+	assert.Contains(t, buf.String(), `"log.level":"error"`)
+}
+
+// TestErrorFields tests that the errors returned by the handlers are logged even when the error
+// handler hides them.
+func TestErrorFields(t *testing.T) {
+	// Call the SetConfig function
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Fiber app whose error handler hides the original error.
+	app := fiber.New()
+	app.Use(New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return c.Status(fiber.StatusServiceUnavailable).SendString("try again later")
+		},
+	}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return fmt.Errorf("loading profile: %w", fiber.NewError(fiber.StatusConflict, "version mismatch"))
+	})
+
+	// Perform the request.
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1) //nolint:bodyclose
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+
+	// Assert that the original error is logged with its type and code.
+	assert.Contains(t, buf.String(), `"responseError":"loading profile: version mismatch"`)
+	assert.Contains(t, buf.String(), `"responseErrorType":"*fmt.wrapError"`)
+	assert.Contains(t, buf.String(), `"responseErrorCode":409`)
+}
+
+// TestFieldsFunc tests that the fields of SetFieldsFunc are merged into the request documents.
+func TestFieldsFunc(t *testing.T) {
+	welog.SetConfig(welogConfig)
+	SetFieldsFunc(func(c *fiber.Ctx) logrus.Fields {
+		return logrus.Fields{"userId": c.Locals("userId")}
+	})
+	t.Cleanup(func() { SetFieldsFunc(nil) })
+	buf := captureOutput(t)
+
+	// Create a new Fiber app whose handler sets a request-scoped value.
+	app := fiber.New()
+	app.Use(New(fiber.Config{}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Locals("userId", "user-42")
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// Perform the request.
+	_, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1) //nolint:bodyclose
+	assert.NoError(t, err)
+
+	// Assert that the field is merged into the request document.
+	assert.Contains(t, buf.String(), `"userId":"user-42"`)
+}
+
+// TestForceLog tests that the bodies of forced requests are captured whatever the capture
+// limits say.
+func TestForceLog(t *testing.T) {
+	config := welogConfig
+	config.SampleRate = 0.000001
+	config.RequestBodySkipTypes = []string{"text/plain"}
+	config.ResponseBodyOnError = true
+	config.MemoryBudget = 1
+	welog.SetConfig(config)
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a new Fiber app with an endpoint forcing its log.
+	app := fiber.New()
+	app.Use(New(fiber.Config{}))
+	app.Post("/echo", func(c *fiber.Ctx) error {
+		welog.ForceLog(c.UserContext())
+		return c.SendString("pong")
+	})
+
+	// Perform the request.
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("ping"))
+	req.Header.Set("Content-Type", "text/plain")
+	_, err := app.Test(req, -1) //nolint:bodyclose
+	assert.NoError(t, err)
+
+	// Assert that both bodies are logged and all the memory was returned.
+	assert.Contains(t, buf.String(), `"forceLogged":true`)
+	assert.Contains(t, buf.String(), `"requestBodyString":"ping"`)
+	assert.Contains(t, buf.String(), `"responseBodyString":"pong"`)
+	assert.NotContains(t, buf.String(), `"bodyOmitted"`)
+	assert.Zero(t, logger.MemoryInUse())
+}
+
+// TestSkipPaths tests that excluded requests get their request ID without being logged.
+func TestSkipPaths(t *testing.T) {
+	config := welogConfig
+	config.SkipPaths = []string{"/healthz"}
+	welog.SetConfig(config)
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a new Fiber app with a health check endpoint.
+	app := fiber.New()
+	app.Use(New(fiber.Config{}))
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	// Perform the request.
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/healthz", nil), -1) //nolint:bodyclose
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+
+	// Assert that no request log was written.
+	assert.NotContains(t, buf.String(), `requestMethod`)
+}
+
+// TestLogClient tests that LogClient records the client requests in the request document, and
+// under the legacy string key without the middleware.
+func TestLogClient(t *testing.T) {
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Fiber app whose handler logs a client request.
+	app := fiber.New()
+	app.Use(New(fiber.Config{}))
+	app.Get("/", func(c *fiber.Ctx) error {
+		LogClient(c, "https://example.com", "GET", "application/json",
+			map[string]interface{}{"Content-Type": "application/json"}, []byte(`{"test":"data"}`),
+			map[string]interface{}{"Content-Type": "application/json"}, []byte(`{"response":"ok"}`),
+			http.StatusOK, time.Now(), 100*time.Millisecond)
+		assert.Len(t, c.Locals(generalkey.ClientLog), 1)
+		return c.SendStatus(fiber.StatusOK)
+	})
+	_, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil), -1) //nolint:bodyclose
+	assert.NoError(t, err)
+
+	// Assert that the call is a target of the request document.
+	assert.Contains(t, buf.String(), `"targetRequestURL":"https://example.com"`)
+	assert.Contains(t, buf.String(), `"targetResponseBody":{"response":"ok"}`)
+
+	// Acquire a context of the app without the middleware.
+	fiberCtx := app.AcquireCtx(&fasthttp.RequestCtx{})
+	defer app.ReleaseCtx(fiberCtx)
+	LogClient(fiberCtx, "https://example.com", "GET", "", nil, nil, nil, nil, http.StatusOK, time.Now(), time.Millisecond)
+
+	// Assert that the entry accumulates under the legacy string key.
+	clientLog := fiberCtx.Locals(generalkey.ClientLog).([]logrus.Fields)
+	if assert.Len(t, clientLog, 1) {
+		assert.Equal(t, http.StatusOK, clientLog[0]["targetResponseStatus"])
+	}
+}
@@ -0,0 +1,47 @@
+// Package fiberv3 logs the requests of Fiber v3 applications with welog, whose Ctx API differs
+// from the one of Fiber v2 used by welogfiber.New:
+//
+//	app := fiber.New()
+//	app.Use(fiberv3.New())
+//
+// Requests are logged like welogfiber.NewFastHTTP does, on the fasthttp request of the Ctx, with
+// the errors returned by the handlers passed to the error handler of the application first.
+package fiberv3
+
+import (
+	"context"
+	welogfiber "github.com/christiandoxa/welog/fiber"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+)
+
+// New returns a Fiber v3 middleware that logs requests and responses. The request-scoped
+// values of welog are set to both the Locals and the context of the Ctx, so handlers can pass
+// c.Context() to welog.LoggerFromContext, welog.AddTarget, and welog.SetUser.
+func New() fiber.Handler {
+	return func(c fiber.Ctx) error {
+		var err error
+		welogfiber.NewFastHTTP(func(ctx *fasthttp.RequestCtx) {
+			userContext := c.Context()
+			for _, key := range []any{
+				generalkey.RequestIDKey,
+				generalkey.LoggerKey,
+				generalkey.ClientLogKey,
+				generalkey.EventKey,
+				generalkey.ForceLogKey,
+				generalkey.TransactionNameKey,
+				generalkey.UserKey,
+			} {
+				userContext = context.WithValue(userContext, key, ctx.UserValue(key))
+			}
+			c.SetContext(userContext)
+
+			// Handle the errors before the request is logged, so the document has their status.
+			if err = c.Next(); err != nil {
+				err = c.App().ErrorHandler(c, err)
+			}
+		})(c.RequestCtx())
+		return err
+	}
+}
@@ -0,0 +1,51 @@
+package fiberv3
+
+import (
+	"bytes"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/gofiber/fiber/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestNew tests that requests are logged with their targets and the status set by the error
+// handler of the application.
+func TestNew(t *testing.T) {
+	welog.SetConfig(welog.Config{StdoutOnly: true})
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+
+	app := fiber.New()
+	app.Use(New())
+	app.Get("/items", func(c fiber.Ctx) error {
+		welog.AddTarget(c.Context(), logrus.Fields{"targetRequestURL": "https://example.com"})
+		return c.SendString("ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("X-Request-ID", "fiberv3-id")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "fiberv3-id", resp.Header.Get("X-Request-ID"))
+
+	// Assert that the request is logged with the target recorded by the handler.
+	assert.Contains(t, buf.String(), `"requestId":"fiberv3-id"`)
+	assert.Contains(t, buf.String(), `"requestMethod":"GET"`)
+	assert.Contains(t, buf.String(), `"responseStatus":200`)
+	assert.Contains(t, buf.String(), `"target":[{"targetRequestURL":"https://example.com"}]`)
+
+	// Assert that unknown routes are logged with the status of the error handler.
+	buf.Reset()
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Contains(t, buf.String(), `"log.level":"warning"`)
+	assert.Contains(t, buf.String(), `"responseStatus":404`)
+}
@@ -0,0 +1,78 @@
+package welog
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DebugHeader is the request header carrying a debug token, see DebugToken.
+	DebugHeader = "X-Welog-Debug"
+
+	// DebugCookie is the cookie carrying a debug token, see DebugToken.
+	DebugCookie = "welog_debug"
+)
+
+// ForceLog exempts the request of ctx from sampling, so it is logged whatever SampleRate and
+// SamplePerSecond say, and from the limits of body capture, so its bodies are captured whatever
+// RequestBodySkipPaths, RequestBodySkipTypes, ResponseBodyOnError, and MemoryBudget say. Pass the
+// request context of a Gin handler, before writing the response, or the user context of a Fiber
+// handler. It does nothing if ctx doesn't belong to a request handled by the middlewares.
+func ForceLog(ctx context.Context) {
+	if forced, ok := ctx.Value(generalkey.ForceLogKey).(*atomic.Bool); ok {
+		forced.Store(true)
+	}
+}
+
+// DebugToken creates a token that exempts the requests carrying it in the DebugHeader header
+// or the DebugCookie cookie from sampling and body capture limits, like ForceLog, until ttl has elapsed. secret must match
+// Config.DebugSecret. The token has the form "<expiry>.<signature>", where expiry is a Unix
+// time and signature is the hex-encoded HMAC-SHA256 of expiry under secret.
+func DebugToken(secret string, ttl time.Duration) string {
+	expiry := strconv.FormatInt(logger.Now().Add(ttl).Unix(), 10)
+	return expiry + "." + debugSignature(secret, expiry)
+}
+
+// validDebugToken reports whether token was created by DebugToken with the configured
+// DebugSecret and hasn't expired. Tokens are rejected when no secret is configured.
+func validDebugToken(token string) bool {
+	secret := currentConfig().DebugSecret
+	if secret == "" || token == "" {
+		return false
+	}
+
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || logger.Now().Unix() >= unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(debugSignature(secret, expiry)))
+}
+
+// debugSignature returns the hex-encoded HMAC-SHA256 of expiry under secret.
+func debugSignature(secret string, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(expiry))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// forced reports whether a request is exempt from sampling and body capture limits, because
+// its handler called ForceLog or it carries a valid debug token.
+func forced(flag *atomic.Bool, header string, cookie string) bool {
+	if flag != nil && flag.Load() {
+		return true
+	}
+	return validDebugToken(header) || validDebugToken(cookie)
+}
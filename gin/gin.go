@@ -0,0 +1,287 @@
+// Package weloggin logs the requests of Gin applications with welog. It is a module of its own,
+// so the applications of other frameworks don't depend on Gin:
+//
+//	import weloggin "github.com/christiandoxa/welog/gin"
+//
+//	r := gin.New()
+//	r.Use(weloggin.New())
+package weloggin
+
+import (
+	"bytes"
+	"context"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/model"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fieldsFunc holds the function set by SetFieldsFunc.
+var fieldsFunc atomic.Pointer[func(c *gin.Context) logrus.Fields]
+
+// SetFieldsFunc sets a function returning fields merged into the request documents of New,
+// such as a user ID or feature flags. It runs after the handlers, before the plugins. Nil
+// removes it.
+func SetFieldsFunc(f func(c *gin.Context) logrus.Fields) {
+	fieldsFunc.Store(&f)
+}
+
+// responseBodyWriter is a custom response writer that captures the response body.
+type responseBodyWriter struct {
+	gin.ResponseWriter
+	capture *welog.ResponseCapture
+}
+
+// Write writes the response body to both the underlying ResponseWriter and the capture.
+func (w *responseBodyWriter) Write(b []byte) (int, error) {
+	w.capture.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// New creates a new Gin middleware that logs requests and responses.
+func New() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cookie := func(name string) string {
+			value, _ := c.Cookie(name)
+			return value
+		}
+		r := welog.StartRequest(c.Request.Method, c.Request.URL.Path, c.GetHeader, cookie)
+
+		// Set the request ID to the response.
+		c.Header("X-Request-ID", r.ID)
+
+		// Set request-related values to the context.
+		setValues(c, r.Values()...)
+
+		// Keep the legacy string keys populated for handlers that haven't migrated yet.
+		c.Set(generalkey.RequestID, r.ID)
+		c.Set(generalkey.Logger, r.Logger)
+		c.Set(generalkey.ClientLog, []logrus.Fields{})
+
+		// Excluded requests only get the request-related values, not the logging.
+		if r.Skipped() {
+			next(c, r)
+			return
+		}
+
+		// Create a response writer that captures the response body, unless disabled.
+		if capture := r.CaptureResponse(); capture != nil {
+			c.Writer = &responseBodyWriter{ResponseWriter: c.Writer, capture: capture}
+		}
+
+		// Emit progress documents while the request runs, counting the body as it is read.
+		r.StartProgress(c.Request.Context(), c.Request.RequestURI)
+		c.Request.Body = r.CountBody(c.Request.Body, c.Request.ContentLength)
+
+		// Proceed to the next middleware, recovering from panics.
+		next(c, r)
+
+		// Log the request and response details.
+		logRequest(c, r)
+	}
+}
+
+// NewE is like New, but first validates the configuration set by welog.SetConfig and, under
+// welog.StartupRequireElastic, the connection to ElasticSearch. It returns an error instead of
+// a middleware that can only log the problems.
+func NewE() (gin.HandlerFunc, error) {
+	if err := welog.CheckStartup(); err != nil {
+		return nil, err
+	}
+	return New(), nil
+}
+
+// next calls the next handler and converts a panic into a 500 response. The recovered value
+// and stack trace are recorded in r.
+func next(c *gin.Context, r *welog.Request) {
+	defer func() {
+		if value := recover(); value != nil {
+			r.Recovered(value, debug.Stack())
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+	}()
+
+	c.Next()
+}
+
+// logRequest logs the details of the Gin request and response. Requests that collected errors
+// through c.Error are logged at error level.
+func logRequest(c *gin.Context, r *welog.Request) {
+	var handlerErr error
+	var errorFields logrus.Fields
+	if last := c.Errors.Last(); last != nil {
+		handlerErr = last
+		errorFields = logrus.Fields{"responseErrors": errorsOf(c.Errors)}
+	}
+
+	params := make(map[string]string, len(c.Params))
+	for _, param := range c.Params {
+		params[param.Key] = param.Value
+	}
+
+	r.Log(welog.Exchange{
+		Context:    c.Request.Context(),
+		URL:        c.Request.RequestURI,
+		Query:      c.Request.URL.RawQuery,
+		Host:       c.Request.Host,
+		Protocol:   c.Request.Proto,
+		Route:      c.FullPath(),
+		Handler:    c.HandlerName(),
+		Params:     params,
+		Header:     c.Request.Header,
+		GetHeader:  c.GetHeader,
+		RemoteAddr: c.RemoteIP(),
+		ClientIP:   c.ClientIP(),
+
+		RequestContentType: c.GetHeader("Content-Type"),
+		ReadRequestBody: func() ([]byte, error) {
+			if c.Request.Body == nil {
+				return nil, nil
+			}
+			body, err := io.ReadAll(c.Request.Body)
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+			return body, err
+		},
+		RequestBytes: c.Request.ContentLength,
+
+		Status:                  c.Writer.Status(),
+		ResponseHeader:          c.Writer.Header(),
+		ResponseContentType:     c.Writer.Header().Get("Content-Type"),
+		ResponseContentEncoding: c.Writer.Header().Get("Content-Encoding"),
+		ResponseBytes:           int64(max(c.Writer.Size(), 0)),
+
+		Err:         handlerErr,
+		ErrorFields: errorFields,
+		Failed:      len(c.Errors) > 0,
+		Fields: func() logrus.Fields {
+			if f := fieldsFunc.Load(); f != nil && *f != nil {
+				return (*f)(c)
+			}
+			return nil
+		},
+	})
+}
+
+// errorTypes names the types of gin errors.
+var errorTypes = []struct {
+	flag gin.ErrorType
+	name string
+}{
+	{gin.ErrorTypeBind, "bind"},
+	{gin.ErrorTypeRender, "render"},
+	{gin.ErrorTypePrivate, "private"},
+	{gin.ErrorTypePublic, "public"},
+}
+
+// errorsOf converts the errors collected through c.Error into the responseErrors field, with
+// the message, the type names, and the metadata of each error.
+func errorsOf(errs []*gin.Error) []logrus.Fields {
+	fields := make([]logrus.Fields, 0, len(errs))
+	for _, err := range errs {
+		var types []string
+		for _, t := range errorTypes {
+			if err.IsType(t.flag) {
+				types = append(types, t.name)
+			}
+		}
+
+		entry := logrus.Fields{
+			"error": err.Error(),
+			"type":  strings.Join(types, ","),
+		}
+		if err.Meta != nil {
+			entry["meta"] = err.Meta
+		}
+		fields = append(fields, entry)
+	}
+	return fields
+}
+
+// Logger returns the request-scoped logger stored by New. If the middleware is not installed,
+// an entry of the global logger is returned so callers never get nil.
+func Logger(c *gin.Context) *logrus.Entry {
+	if entry, ok := value(c, generalkey.LoggerKey).(*logrus.Entry); ok {
+		return entry
+	}
+	if entry, ok := c.Value(generalkey.Logger).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logger.Logger())
+}
+
+// RequestID returns the request ID stored by New, or an empty string if none is set.
+func RequestID(c *gin.Context) string {
+	if requestID, ok := value(c, generalkey.RequestIDKey).(string); ok {
+		return requestID
+	}
+	return c.GetString(generalkey.RequestID)
+}
+
+// LogClient logs a custom client request and response in the target field of the request
+// document of c.
+func LogClient(
+	c *gin.Context,
+	requestURL string,
+	requestMethod string,
+	requestContentType string,
+	requestHeader map[string]interface{},
+	requestBody []byte,
+	responseHeader map[string]interface{},
+	responseBody []byte,
+	responseStatus int,
+	requestTime time.Time,
+	responseLatency time.Duration,
+) {
+	logData := welog.BuildTargetLogFields(model.TargetRequest{
+		URL:         requestURL,
+		Method:      requestMethod,
+		ContentType: requestContentType,
+		Header:      requestHeader,
+		Body:        requestBody,
+		Timestamp:   requestTime,
+	}, model.TargetResponse{
+		Header:  responseHeader,
+		Body:    responseBody,
+		Status:  responseStatus,
+		Latency: responseLatency,
+	})
+
+	// Without the middleware, the entries only accumulate under the legacy string key.
+	if value(c, generalkey.ClientLogKey) == nil {
+		legacy, _ := c.Value(generalkey.ClientLog).([]logrus.Fields)
+		c.Set(generalkey.ClientLog, append(legacy, logData))
+		return
+	}
+
+	welog.AddTarget(c.Request.Context(), logData)
+	c.Set(generalkey.ClientLog, welog.Targets(c.Request.Context()))
+}
+
+// value looks up a typed key in the request context of c. Gin only supports string keys in its
+// own key store, so typed keys live in the request context.
+func value(c *gin.Context, key any) any {
+	if c.Request == nil {
+		return nil
+	}
+	return c.Request.Context().Value(key)
+}
+
+// setValues stores key/value pairs in the request context of c.
+func setValues(c *gin.Context, keyValues ...any) {
+	if c.Request == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		ctx = context.WithValue(ctx, keyValues[i], keyValues[i+1])
+	}
+	c.Request = c.Request.WithContext(ctx)
+}
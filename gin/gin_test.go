@@ -0,0 +1,268 @@
+package weloggin
+
+import (
+	"bytes"
+	"errors"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	welogConfig = welog.Config{
+		ElasticIndex:    "welog",
+		ElasticURL:      "http://127.0.0.1:9200",
+		ElasticUsername: "elastic",
+		ElasticPassword: "changeme",
+	}
+)
+
+// captureOutput redirects the output of the global logger into a buffer for the duration of the test.
+func captureOutput(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+	return buf
+}
+
+// TestNew tests that New logs the requests and stores the request values for the handlers.
+func TestNew(t *testing.T) {
+	// Call the SetConfig function
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Gin router with an endpoint asserting the values stored by the middleware.
+	r := gin.New()
+	r.Use(New())
+	r.POST("/users/:id", func(c *gin.Context) {
+		assert.Equal(t, "test-request-id", RequestID(c))
+		assert.Equal(t, "test-request-id", welog.RequestIDFromContext(c.Request.Context()))
+		assert.Equal(t, "test-request-id", Logger(c).Data[generalkey.RequestID])
+		assert.Same(t, Logger(c), welog.LoggerFromContext(c.Request.Context()))
+
+		// A string key set by another middleware must not override the typed keys.
+		c.Set(generalkey.RequestID, "other-middleware")
+		assert.Equal(t, "test-request-id", RequestID(c))
+
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	// Serve a request with a custom Request ID.
+	req := httptest.NewRequest(http.MethodPost, "/users/42?page=2", strings.NewReader(`{"key":"value"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "test-request-id")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "test-request-id", w.Header().Get("X-Request-ID"))
+
+	// Assert that the document carries the route, the handler, and the bodies.
+	assert.Contains(t, buf.String(), `"requestUrl":"/users/42?page=2"`)
+	assert.Contains(t, buf.String(), `"requestRoute":"/users/:id"`)
+	assert.Contains(t, buf.String(), `"requestParams":{"id":"42"}`)
+	assert.Contains(t, buf.String(), `"requestHandler":"github.com/christiandoxa/welog/gin.TestNew.func1"`)
+	assert.Contains(t, buf.String(), `"requestBody":{"key":"value"}`)
+	assert.Contains(t, buf.String(), `"responseBody":{"ok":true}`)
+	assert.Contains(t, buf.String(), `"transactionName":"POST /users/:id"`)
+
+	// Assert that the 404 page Gin writes after the middlewares returned isn't held in memory.
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Zero(t, logger.MemoryInUse())
+}
+
+// TestNewRecover tests that New recovers from panics and still logs the request.
+func TestNewRecover(t *testing.T) {
+	// Call the SetConfig function
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Gin router with a panicking endpoint.
+	r := gin.New()
+	r.Use(New())
+	r.GET("/", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	// Serve the request and capture the response.
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that the panic was converted and logged at error level with a stack trace.
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, buf.String(), `"log.level":"error"`)
+	assert.Contains(t, buf.String(), `"panic":"boom"`)
+	assert.Contains(t, buf.String(), `"stackTrace":"goroutine`)
+	assert.Contains(t, buf.String(), `"requestMethod":"GET"`)
+}
+
+// TestNewE tests that NewE reports an invalid configuration instead of returning a middleware.
+func TestNewE(t *testing.T) {
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+
+	// Assert that a valid configuration yields the middleware.
+	config := welogConfig
+	config.StdoutOnly = true
+	welog.SetConfig(config)
+	handler, err := NewE()
+	assert.NoError(t, err)
+	assert.NotNil(t, handler)
+
+	// Assert that an invalid configuration is reported.
+	config.SampleRate = 2
+	welog.SetConfig(config)
+	handler, err = NewE()
+	assert.ErrorContains(t, err, "SampleRate 2 is not between 0 and 1")
+	assert.Nil(t, handler)
+}
+
+// TestErrors tests that the errors collected through c.Error are logged and bump the level.
+func TestErrors(t *testing.T) {
+	// Call the SetConfig function
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Gin router with an endpoint collecting an error but responding successfully.
+	r := gin.New()
+	r.Use(New())
+	r.GET("/", func(c *gin.Context) {
+		_ = c.Error(errors.New("cache miss")).SetType(gin.ErrorTypePrivate).SetMeta("user-42")
+		c.Status(http.StatusOK)
+	})
+
+	// Serve the request.
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that the errors are logged and bump the level.
+	assert.Contains(t, buf.String(), `"log.level":"error"`)
+	assert.Contains(t, buf.String(), `"responseErrors":[{"error":"cache miss","meta":"user-42","type":"private"}]`)
+}
+
+// TestFieldsFunc tests that the fields of SetFieldsFunc are merged into the request documents.
+func TestFieldsFunc(t *testing.T) {
+	welog.SetConfig(welogConfig)
+	SetFieldsFunc(func(c *gin.Context) logrus.Fields {
+		return logrus.Fields{"featureFlags": c.GetStringSlice("flags")}
+	})
+	t.Cleanup(func() { SetFieldsFunc(nil) })
+	buf := captureOutput(t)
+
+	// Create a new Gin router whose handler sets a request-scoped value.
+	r := gin.New()
+	r.Use(New())
+	r.GET("/", func(c *gin.Context) {
+		c.Set("flags", []string{"beta"})
+		c.Status(http.StatusOK)
+	})
+
+	// Serve the request.
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that the field is merged into the request document.
+	assert.Contains(t, buf.String(), `"featureFlags":["beta"]`)
+}
+
+// TestForceLog tests that the bodies of requests forced by a debug token are captured whatever
+// the capture limits say.
+func TestForceLog(t *testing.T) {
+	config := welogConfig
+	config.SampleRate = 0.000001
+	config.DebugSecret = "secret"
+	config.RequestBodySkipTypes = []string{"text/plain"}
+	config.ResponseBodyOnError = true
+	config.MemoryBudget = 1
+	welog.SetConfig(config)
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a new Gin router with an echo endpoint.
+	r := gin.New()
+	r.Use(New())
+	r.POST("/echo", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+
+	// Serve a request with a valid debug token.
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("ping"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(welog.DebugHeader, welog.DebugToken("secret", time.Minute))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert that both bodies are logged and all the memory was returned.
+	assert.Contains(t, buf.String(), `"forceLogged":true`)
+	assert.Contains(t, buf.String(), `"requestBodyString":"ping"`)
+	assert.Contains(t, buf.String(), `"responseBodyString":"pong"`)
+	assert.NotContains(t, buf.String(), `"bodyOmitted"`)
+	assert.Zero(t, logger.MemoryInUse())
+}
+
+// TestSkipPaths tests that excluded requests get their request ID without being logged.
+func TestSkipPaths(t *testing.T) {
+	config := welogConfig
+	config.SkipPaths = []string{"/healthz"}
+	welog.SetConfig(config)
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a new Gin router with a health check endpoint.
+	r := gin.New()
+	r.Use(New())
+	r.GET("/healthz", func(c *gin.Context) {
+		assert.NotEmpty(t, RequestID(c))
+		c.String(http.StatusOK, "ok")
+	})
+
+	// Serve the request.
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+
+	// Assert that no request log was written.
+	assert.NotContains(t, buf.String(), `requestMethod`)
+}
+
+// TestLogClient tests that LogClient records the client requests in the request document, and
+// under the legacy string key without the middleware.
+func TestLogClient(t *testing.T) {
+	welog.SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a new Gin router whose handler logs a client request.
+	r := gin.New()
+	r.Use(New())
+	r.GET("/", func(c *gin.Context) {
+		LogClient(c, "https://example.com", "POST", "application/json",
+			map[string]interface{}{"Content-Type": "application/json"}, []byte(`{"test":"data"}`),
+			map[string]interface{}{"Content-Type": "application/json"}, []byte(`{"response":"ok"}`),
+			http.StatusOK, time.Now(), 100*time.Millisecond)
+		clientLog, _ := c.Get(generalkey.ClientLog)
+		assert.Len(t, clientLog, 1)
+		c.Status(http.StatusOK)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that the call is a target of the request document.
+	assert.Contains(t, buf.String(), `"targetRequestMethod":"POST"`)
+	assert.Contains(t, buf.String(), `"targetResponseBody":{"response":"ok"}`)
+
+	// Create a Gin context without the middleware.
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	LogClient(c, "https://example.com", "GET", "", nil, nil, nil, nil, http.StatusOK, time.Now(), time.Millisecond)
+
+	// Assert that the entry accumulates under the legacy string key.
+	clientLog, exists := c.Get(generalkey.ClientLog)
+	assert.True(t, exists)
+	if logFields := clientLog.([]logrus.Fields); assert.Len(t, logFields, 1) {
+		assert.Equal(t, http.StatusOK, logFields[0]["targetResponseStatus"])
+	}
+}
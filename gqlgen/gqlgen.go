@@ -0,0 +1,89 @@
+// Package gqlgen logs the operations of gqlgen GraphQL servers with welog. Every operation
+// gets an entry of its own, carrying the request ID of the HTTP request, and is listed in the
+// target field of the HTTP request document, so GraphQL traffic isn't one opaque entry per
+// HTTP request:
+//
+//	srv := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: resolver}))
+//	srv.Use(gqlgen.Extension{})
+//
+// Install welog.NewHTTP or a framework middleware in front of the GraphQL handler to tie the
+// operations to the HTTP request. Without it, the operations are logged with the global logger.
+package gqlgen
+
+import (
+	"context"
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// Extension is a gqlgen handler extension logging every operation response. Subscriptions
+// are logged once per event.
+type Extension struct{}
+
+var (
+	_ graphql.HandlerExtension    = Extension{}
+	_ graphql.ResponseInterceptor = Extension{}
+)
+
+// ExtensionName returns the name of the extension.
+func (Extension) ExtensionName() string {
+	return "WelogExtension"
+}
+
+// Validate accepts every schema.
+func (Extension) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptResponse logs the operation of a response with its name, type, sanitized
+// variables, latency, and errors. Operations with errors are logged at error level.
+func (Extension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	response := next(ctx)
+	if response == nil || !graphql.HasOperationContext(ctx) {
+		return response
+	}
+
+	fields := operationFields(graphql.GetOperationContext(ctx), response)
+	welog.AddTarget(ctx, fields)
+
+	level := logrus.InfoLevel
+	if len(response.Errors) > 0 {
+		level = logrus.ErrorLevel
+	}
+	welog.LoggerFromContext(ctx).WithContext(ctx).WithFields(fields).Log(level, "graphql operation")
+
+	return response
+}
+
+// operationFields describes an operation and its response.
+func operationFields(operation *graphql.OperationContext, response *graphql.Response) logrus.Fields {
+	start := operation.Stats.OperationStart
+	latency := time.Since(start)
+	fields := logrus.Fields{
+		"graphqlOperationName": operation.OperationName,
+		"graphqlLatency":       latency.String(),
+		"graphqlLatencyMs":     util.Milliseconds(latency),
+		"graphqlTimestamp":     start.Format(time.RFC3339Nano),
+		"graphqlVariables":     util.Sanitize(operation.Variables),
+	}
+
+	if op := operation.Operation; op != nil {
+		fields["graphqlOperationType"] = string(op.Operation)
+		if op.Name != "" {
+			fields["graphqlOperationName"] = op.Name
+		}
+	}
+
+	if len(response.Errors) > 0 {
+		errors := make([]string, len(response.Errors))
+		for i, err := range response.Errors {
+			errors[i] = err.Message
+		}
+		fields["graphqlErrors"] = errors
+	}
+
+	return fields
+}
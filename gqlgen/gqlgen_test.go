@@ -0,0 +1,46 @@
+package gqlgen
+
+import (
+	"bytes"
+	"github.com/99designs/gqlgen/graphql/handler/testserver"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestExtension tests that operations get their own entry and are listed in the request document.
+func TestExtension(t *testing.T) {
+	welog.SetConfig(welog.Config{StdoutOnly: true})
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+
+	// Serve a GraphQL server behind the net/http middleware.
+	srv := testserver.New()
+	srv.AddTransport(transport.POST{})
+	srv.Use(Extension{})
+	handler := welog.NewHTTP(srv)
+
+	body := `{"query":"query Find($id: Int!) { find(id: $id) }","variables":{"id":1}}`
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "graphql-request")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert that the operation is logged on its own and as a target of the request document.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"message":"graphql operation"`)
+	assert.Contains(t, lines[0], `"requestId":"graphql-request"`)
+	assert.Contains(t, lines[0], `"graphqlOperationName":"Find"`)
+	assert.Contains(t, lines[0], `"graphqlOperationType":"query"`)
+	assert.Contains(t, lines[0], `"graphqlVariables":{"id":1}`)
+	assert.Contains(t, lines[1], `"target":[{`)
+	assert.Contains(t, lines[1], `"graphqlOperationName":"Find"`)
+}
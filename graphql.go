@@ -0,0 +1,150 @@
+package welog
+
+import (
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// defaultGraphQLPaths are the paths of GraphQL endpoints when Config.GraphQLPaths is nil.
+var defaultGraphQLPaths = []string{"/graphql"}
+
+// graphqlRequest is the body of a GraphQL request over HTTP.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// addGraphQL describes the operation of a POST request to a GraphQL endpoint with the
+// graphqlOperationName, graphqlOperationType, and graphqlVariables fields, as every GraphQL
+// request shares the same URL. The variables are sanitized like the collected examples.
+func addGraphQL(config Config, method, path string, body []byte, fields logrus.Fields) {
+	paths := config.GraphQLPaths
+	if paths == nil {
+		paths = defaultGraphQLPaths
+	}
+	if method != http.MethodPost || !matchPath(paths, path) {
+		return
+	}
+
+	var request graphqlRequest
+	if err := json.Unmarshal(body, &request); err != nil || request.Query == "" {
+		return
+	}
+
+	operationType, operationName := graphqlOperation(request.Query, request.OperationName)
+	if operationType == "" {
+		return
+	}
+	fields["graphqlOperationType"] = operationType
+	if operationName != "" {
+		fields["graphqlOperationName"] = operationName
+	}
+	if request.Variables != nil {
+		fields["graphqlVariables"] = util.Sanitize(request.Variables)
+	}
+}
+
+// graphqlOperation returns the type and name of the operation of a GraphQL document that is
+// executed: the one named operationName, or else the first one. Shorthand queries, written
+// as a bare selection set, are anonymous queries. It returns empty strings if the document
+// has no such operation.
+func graphqlOperation(document string, operationName string) (string, string) {
+	var types, names []string
+	depth := 0          // Nesting depth of braces, parentheses, and brackets
+	defining := false   // Inside the header of a definition, before its selection set
+	expectName := false // The previous token started an operation, which may be named next
+
+	lex := graphqlLexer{src: document}
+	for token, ok := lex.next(); ok; token, ok = lex.next() {
+		named := expectName
+		expectName = false
+
+		switch {
+		case token == "{" || token == "(" || token == "[":
+			if depth == 0 && token == "{" {
+				if !defining {
+					types, names = append(types, "query"), append(names, "")
+				}
+				defining = false
+			}
+			depth++
+		case token == "}" || token == ")" || token == "]":
+			depth--
+		case depth != 0 || defining && !named:
+		case named:
+			if isGraphQLNameChar(token[0]) {
+				names[len(names)-1] = token
+			}
+		case token == "query" || token == "mutation" || token == "subscription":
+			types, names = append(types, token), append(names, "")
+			defining, expectName = true, true
+		case token == "fragment":
+			defining = true
+		}
+	}
+
+	for i := range types {
+		if operationName == "" || names[i] == operationName {
+			return types[i], names[i]
+		}
+	}
+	return "", ""
+}
+
+// graphqlLexer splits a GraphQL document into names and punctuators, skipping whitespace,
+// commas, comments, and strings, which is enough to find its operations.
+type graphqlLexer struct {
+	src string
+	pos int
+}
+
+// next returns the next name or punctuator, or false at the end of the document.
+func (l *graphqlLexer) next() (string, bool) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			if end := strings.IndexByte(l.src[l.pos:], '\n'); end >= 0 {
+				l.pos += end + 1
+			} else {
+				l.pos = len(l.src)
+			}
+		case strings.HasPrefix(l.src[l.pos:], `"""`):
+			if end := strings.Index(l.src[l.pos+3:], `"""`); end >= 0 {
+				l.pos += end + 6
+			} else {
+				l.pos = len(l.src)
+			}
+		case c == '"':
+			l.pos++
+			for l.pos < len(l.src) && l.src[l.pos] != '"' {
+				if l.src[l.pos] == '\\' {
+					l.pos++
+				}
+				l.pos++
+			}
+			l.pos++
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := l.pos
+			for l.pos < len(l.src) && isGraphQLNameChar(l.src[l.pos]) {
+				l.pos++
+			}
+			return l.src[start:l.pos], true
+		default:
+			l.pos++
+			return string(c), true
+		}
+	}
+	return "", false
+}
+
+// isGraphQLNameChar reports whether c may appear in a GraphQL name.
+func isGraphQLNameChar(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,71 @@
+package welog
+
+import (
+	"github.com/sirupsen/logrus"
+	"math/rand/v2"
+	"strings"
+)
+
+// GRPCMethodConfig tunes the logging of the calls of a gRPC method, see Config.GRPCMethods.
+type GRPCMethodConfig struct {
+	// Skip stops recording the calls, e.g. of health checks.
+	Skip bool
+
+	// SampleRate is the fraction of the calls that are recorded, between 0 and 1. Failed calls
+	// are always recorded. Zero records every call.
+	SampleRate float64
+
+	// DisablePayloads leaves the messages out of the recorded calls.
+	DisablePayloads bool
+}
+
+// GRPCMethod returns the configuration of method from Config.GRPCMethods, for the integrations
+// recording gRPC calls, such as LogTarget of github.com/christiandoxa/welog/grpc.
+func GRPCMethod(method string) GRPCMethodConfig {
+	return grpcMethodConfig(currentConfig().GRPCMethods, method)
+}
+
+// Record reports whether a call is recorded under c: it isn't skipped and, unless it failed,
+// i.e. ended with another code than OK, it is sampled.
+func (c GRPCMethodConfig) Record(failed bool) bool {
+	if c.Skip {
+		return false
+	}
+	if rate := c.SampleRate; !failed && rate > 0 && rate < 1 && rand.Float64() >= rate {
+		return false
+	}
+	return true
+}
+
+// grpcMethodConfig returns the configuration of method among methods: the one of its exact
+// name, or else the one of the longest matching prefix pattern.
+func grpcMethodConfig(methods map[string]GRPCMethodConfig, method string) GRPCMethodConfig {
+	if methodConfig, ok := methods[method]; ok {
+		return methodConfig
+	}
+
+	var match GRPCMethodConfig
+	longest := -1
+	for pattern, methodConfig := range methods {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(method, prefix) && len(prefix) > longest {
+			match, longest = methodConfig, len(prefix)
+		}
+	}
+	return match
+}
+
+// GRPCMethodFields splits a full method name such as "/pkg.Service/Method" into the
+// targetGrpcService and targetGrpcMethodName fields of a target entry, so aggregations don't
+// need to parse targetGrpcMethod. Under Config.ECSFields they are named rpc.service and
+// rpc.method, with rpc.system set to "grpc". Malformed names return no fields.
+func GRPCMethodFields(method string) logrus.Fields {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok || service == "" || name == "" {
+		return logrus.Fields{}
+	}
+
+	if currentConfig().ECSFields {
+		return logrus.Fields{"rpc.system": "grpc", "rpc.service": service, "rpc.method": name}
+	}
+	return logrus.Fields{"targetGrpcService": service, "targetGrpcMethodName": name}
+}
@@ -0,0 +1,100 @@
+// Package weloggrpc records the gRPC calls of handlers in the target field of their request
+// documents with welog. It is a module of its own, with the grpc-gateway interceptors of its
+// grpcgateway package, so the applications without gRPC don't depend on gRPC and protobuf:
+//
+//	import weloggrpc "github.com/christiandoxa/welog/grpc"
+//
+//	res, err := client.GetUser(ctx, req)
+//	weloggrpc.LogTarget(ctx, "/users.UserService/GetUser", req, res, status.Code(err), latency, err)
+package weloggrpc
+
+import (
+	"context"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"time"
+)
+
+// LogTarget records a gRPC call to a target in the request document of ctx, like welog.LogTarget
+// does for HTTP calls. method is the full method name, e.g. "/pkg.Service/Method", and latency
+// the duration of the call, which ended now. The messages are logged as their protojson form
+// and may be nil, e.g. the response of a failed call. welog.Config.GRPCMethods may skip the
+// call, sample it, or leave out the messages.
+func LogTarget(
+	ctx context.Context,
+	method string,
+	req, res proto.Message,
+	code codes.Code,
+	latency time.Duration,
+	err error,
+) {
+	if logData := BuildTargetLogFields(method, req, res, code, logger.Now().Add(-latency), latency, err); logData != nil {
+		welog.AddTarget(ctx, logData)
+	}
+}
+
+// BuildTargetLogFields returns the target entry of a gRPC call sent at requestTime, as recorded
+// by LogTarget, or nil if welog.Config.GRPCMethods doesn't record the call.
+func BuildTargetLogFields(
+	method string,
+	req, res proto.Message,
+	code codes.Code,
+	requestTime time.Time,
+	latency time.Duration,
+	err error,
+) logrus.Fields {
+	methodConfig := welog.GRPCMethod(method)
+	if !methodConfig.Record(code != codes.OK) {
+		return nil
+	}
+	if methodConfig.DisablePayloads {
+		req, res = nil, nil
+	}
+
+	requestBody := marshalPayload(req)
+	responseBody := marshalPayload(res)
+
+	logData := logrus.Fields{
+		"targetGrpcCode":           code.String(),
+		"targetGrpcStatusCode":     int(code),
+		"targetGrpcMethod":         method,
+		"targetRequestBody":        welog.ParseBody("application/json", requestBody),
+		"targetRequestBodyString":  string(requestBody),
+		"targetRequestTimestamp":   requestTime.Format(time.RFC3339Nano),
+		"targetResponseBody":       welog.ParseBody("application/json", responseBody),
+		"targetResponseBodyString": string(responseBody),
+		"targetResponseLatency":    latency.String(),
+		"targetResponseLatencyMs":  util.Milliseconds(latency),
+		"targetResponseTimestamp":  requestTime.Add(latency).Format(time.RFC3339Nano),
+	}
+
+	for key, value := range welog.GRPCMethodFields(method) {
+		logData[key] = value
+	}
+
+	if err != nil {
+		logData["targetResponseError"] = status.Convert(err).Message()
+	}
+
+	return logData
+}
+
+// marshalPayload returns the protojson form of a message, or nil for nil messages, including
+// typed nil pointers, and messages that can't be marshalled.
+func marshalPayload(message proto.Message) []byte {
+	if message == nil || !message.ProtoReflect().IsValid() {
+		return nil
+	}
+
+	payload, err := protojson.Marshal(message)
+	if err != nil {
+		return nil
+	}
+	return payload
+}
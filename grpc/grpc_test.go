@@ -0,0 +1,102 @@
+package weloggrpc
+
+import (
+	"context"
+	"errors"
+	"github.com/christiandoxa/welog"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"testing"
+	"time"
+)
+
+var (
+	welogConfig = welog.Config{
+		ElasticIndex:    "welog",
+		ElasticURL:      "http://127.0.0.1:9200",
+		ElasticUsername: "elastic",
+		ElasticPassword: "changeme",
+	}
+)
+
+// TestLogTarget tests that gRPC calls are recorded with their messages in protojson form.
+func TestLogTarget(t *testing.T) {
+	welog.SetConfig(welogConfig)
+	ctx := welog.NewJobContext(context.Background(), "")
+	req, err := structpb.NewStruct(map[string]any{"id": 1})
+	assert.NoError(t, err)
+	res := wrapperspb.String("gopher")
+
+	LogTarget(ctx, "/users.UserService/GetUser", req, res, codes.OK, time.Second, nil)
+	LogTarget(ctx, "/users.UserService/GetUser", req, (*wrapperspb.StringValue)(nil), codes.NotFound, time.Second,
+		status.Error(codes.NotFound, "user not found"))
+
+	// Assert that both calls are recorded, the failed one without a response.
+	entries := welog.Targets(ctx)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "/users.UserService/GetUser", entries[0]["targetGrpcMethod"])
+		assert.Equal(t, "OK", entries[0]["targetGrpcCode"])
+		assert.Equal(t, logrus.Fields{"id": float64(1)}, entries[0]["targetRequestBody"])
+		assert.Equal(t, `"gopher"`, entries[0]["targetResponseBodyString"])
+		assert.Equal(t, "1s", entries[0]["targetResponseLatency"])
+		assert.Equal(t, "users.UserService", entries[0]["targetGrpcService"])
+		assert.NotContains(t, entries[0], "targetResponseError")
+
+		assert.Equal(t, "NotFound", entries[1]["targetGrpcCode"])
+		assert.Equal(t, 5, entries[1]["targetGrpcStatusCode"])
+		assert.Equal(t, "", entries[1]["targetResponseBodyString"])
+		assert.Equal(t, "user not found", entries[1]["targetResponseError"])
+	}
+}
+
+// TestMethods tests that Config.GRPCMethods skips, samples, and strips the recorded calls.
+func TestMethods(t *testing.T) {
+	config := welogConfig
+	config.GRPCMethods = map[string]welog.GRPCMethodConfig{
+		"/grpc.health.v1.Health/*":   {Skip: true},
+		"/users.UserService/*":       {DisablePayloads: true},
+		"/users.UserService/GetUser": {SampleRate: 0.000001},
+	}
+	welog.SetConfig(config)
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+
+	ctx := welog.NewJobContext(context.Background(), "")
+	req := wrapperspb.String("gopher")
+	LogTarget(ctx, "/grpc.health.v1.Health/Check", req, req, codes.OK, time.Second, nil)
+	LogTarget(ctx, "/users.UserService/GetUser", req, req, codes.OK, time.Second, nil)
+	LogTarget(ctx, "/users.UserService/GetUser", req, nil, codes.Internal, time.Second, errors.New("boom"))
+	LogTarget(ctx, "/users.UserService/ListUsers", req, req, codes.OK, time.Second, nil)
+
+	// Assert that skipped and unsampled calls are missing, and payloads are left out.
+	entries := welog.Targets(ctx)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "Internal", entries[0]["targetGrpcCode"])
+		assert.Equal(t, "/users.UserService/ListUsers", entries[1]["targetGrpcMethod"])
+		assert.Equal(t, "", entries[1]["targetRequestBodyString"])
+	}
+
+	// Assert that skipped calls have no entry to record.
+	assert.Nil(t, BuildTargetLogFields("/grpc.health.v1.Health/Check", req, req, codes.OK, time.Now(), time.Second, nil))
+}
+
+// TestMethodFields tests that the entries are named after rpc.* under ECS.
+func TestMethodFields(t *testing.T) {
+	config := welogConfig
+	config.ECSFields = true
+	welog.SetConfig(config)
+	t.Cleanup(func() { welog.SetConfig(welogConfig) })
+
+	ctx := welog.NewJobContext(context.Background(), "")
+	LogTarget(ctx, "/users.v1.UserService/GetUser", nil, nil, codes.OK, time.Second, nil)
+	entries := welog.Targets(ctx)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "grpc", entries[0]["rpc.system"])
+		assert.Equal(t, "users.v1.UserService", entries[0]["rpc.service"])
+		assert.Equal(t, "GetUser", entries[0]["rpc.method"])
+		assert.Equal(t, "/users.v1.UserService/GetUser", entries[0]["targetGrpcMethod"])
+	}
+}
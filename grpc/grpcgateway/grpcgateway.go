@@ -0,0 +1,157 @@
+// Package grpcgateway correlates the logs of grpc-gateway proxies with the logs of the gRPC
+// servers behind them, so a single call doesn't produce two unlinked documents. The request ID
+// of the HTTP request document is forwarded in the x-request-id metadata of the gRPC call, and
+// the calls of the gateway are recorded in the target field of the HTTP request document:
+//
+//	mux := runtime.NewServeMux(runtime.WithMetadata(grpcgateway.Metadata))
+//	err := gw.RegisterUserServiceHandlerFromEndpoint(ctx, mux, endpoint, []grpc.DialOption{
+//		grpc.WithUnaryInterceptor(grpcgateway.UnaryClientInterceptor),
+//	})
+//	http.Handle("/v1/", welog.NewHTTP(mux))
+//
+// The gRPC servers read the request ID with RequestID, e.g. to log their work in documents
+// sharing it with welog.NewJobContext, and echo it back with UnaryServerInterceptor.
+package grpcgateway
+
+import (
+	"context"
+	"github.com/christiandoxa/welog"
+	weloggrpc "github.com/christiandoxa/welog/grpc"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"net/http"
+)
+
+// requestIDKey is the metadata key of the request ID.
+const requestIDKey = "x-request-id"
+
+// Metadata is a runtime.WithMetadata annotator forwarding the request ID of the HTTP request
+// document, or else the X-Request-ID header, to the gRPC server.
+func Metadata(ctx context.Context, req *http.Request) metadata.MD {
+	requestID := welog.RequestIDFromContext(ctx)
+	if requestID == "" {
+		requestID = req.Header.Get("X-Request-ID")
+	}
+	if requestID == "" {
+		return nil
+	}
+	return metadata.Pairs(requestIDKey, requestID)
+}
+
+// UnaryClientInterceptor records the unary calls of the gateway in the target field of the
+// HTTP request document with weloggrpc.LogTarget. Calls without a request ID in their outgoing
+// metadata, e.g. from a gateway without Metadata, get the one of the HTTP request document. The
+// request ID echoed by servers with UnaryServerInterceptor, in the header or, after errors, in
+// the trailer, is recorded in the targetResponseRequestId field, and the IP of the server in the
+// targetGrpcPeer field.
+func UnaryClientInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	ctx = withRequestID(ctx)
+	var header, trailer metadata.MD
+	var server peer.Peer
+	opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer), grpc.Peer(&server))
+
+	start := logger.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	latency := logger.Since(start)
+
+	request, _ := req.(proto.Message)
+	response, _ := reply.(proto.Message)
+	if err != nil {
+		response = nil
+	}
+	fields := weloggrpc.BuildTargetLogFields(method, request, response, status.Code(err), start, latency, err)
+	if fields == nil {
+		return err
+	}
+	if requestID := echoedRequestID(header, trailer); requestID != "" {
+		fields["targetResponseRequestId"] = requestID
+	}
+	if ip := peerIP(&server); ip != "" {
+		fields["targetGrpcPeer"] = ip
+	}
+	welog.AddTarget(ctx, fields)
+
+	return err
+}
+
+// UnaryServerInterceptor echoes the request ID forwarded by the gateway to the client in both
+// the header and the trailer of the response, since clients only read the trailer after errors.
+// Calls without a request ID get a new one, which handlers read with RequestID.
+func UnaryServerInterceptor(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	requestID := RequestID(ctx)
+	if requestID == "" {
+		requestID = uuid.NewString()
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		md.Set(requestIDKey, requestID)
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+
+	md := metadata.Pairs(requestIDKey, requestID)
+	_ = grpc.SetHeader(ctx, md)
+	_ = grpc.SetTrailer(ctx, md)
+
+	return handler(ctx, req)
+}
+
+// echoedRequestID returns the request ID echoed by the server in header, or else in trailer.
+func echoedRequestID(header, trailer metadata.MD) string {
+	for _, md := range []metadata.MD{header, trailer} {
+		if values := md.Get(requestIDKey); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// peerIP returns the IP of the server p, or an empty string if the call never reached one.
+func peerIP(p *peer.Peer) string {
+	if p == nil || p.Addr == nil {
+		return ""
+	}
+	if addr, ok := p.Addr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil && net.ParseIP(host) != nil {
+		return host
+	}
+	return ""
+}
+
+// withRequestID adds the request ID of the HTTP request document to the outgoing metadata of
+// ctx, unless the metadata already has one.
+func withRequestID(ctx context.Context) context.Context {
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(requestIDKey)) == 0 {
+		if requestID := welog.RequestIDFromContext(ctx); requestID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, requestIDKey, requestID)
+		}
+	}
+	return ctx
+}
+
+// RequestID returns the request ID forwarded by the gateway in the incoming metadata of the
+// context of a gRPC server handler, or an empty string if there is none.
+func RequestID(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, requestIDKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package grpcgateway
+
+import (
+	"bytes"
+	"context"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCorrelation tests that the gRPC server gets the request ID of the HTTP request document.
+func TestCorrelation(t *testing.T) {
+	welog.SetConfig(welog.Config{StdoutOnly: true})
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+
+	// Serve the health service, recording the forwarded request ID.
+	var serverRequestID string
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(UnaryServerInterceptor, func(
+		ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (any, error) {
+		serverRequestID = RequestID(ctx)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor),
+	)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	// Call it like the gateway does for an HTTP request.
+	ctx := welog.NewJobContext(context.Background(), "gateway-request")
+	assert.Equal(t, []string{"gateway-request"}, Metadata(ctx, httptest.NewRequest("GET", "/v1/health", nil)).Get("x-request-id"))
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	welog.LogJob(ctx, nil, nil)
+
+	// Assert that the server got the request ID and the call is a target of the document.
+	assert.Equal(t, "gateway-request", serverRequestID)
+	assert.Contains(t, buf.String(), `"requestId":"gateway-request"`)
+	assert.Contains(t, buf.String(), `"targetGrpcMethod":"/grpc.health.v1.Health/Check"`)
+	assert.Contains(t, buf.String(), `"targetResponseBody":{"status":"SERVING"}`)
+	assert.Contains(t, buf.String(), `"targetResponseRequestId":"gateway-request"`)
+
+	// Assert that the server echoes a new request ID to calls without one, in the header and trailer.
+	var header, trailer metadata.MD
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"},
+		grpc.Header(&header), grpc.Trailer(&trailer))
+	assert.Error(t, err)
+	assert.Len(t, trailer.Get("x-request-id"), 1)
+	assert.Equal(t, trailer.Get("x-request-id"), header.Get("x-request-id"))
+	assert.Equal(t, trailer.Get("x-request-id")[0], serverRequestID)
+}
+
+// TestStreamClientInterceptor tests that streams are recorded with their message counts and sampled payloads.
+func TestStreamClientInterceptor(t *testing.T) {
+	welog.SetConfig(welog.Config{StdoutOnly: true})
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+
+	// Serve the health service.
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStreamInterceptor(NewStreamClientInterceptor(1)),
+	)
+	assert.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	// Watch the health until the first status, then cancel the stream.
+	ctx := welog.NewJobContext(context.Background(), "")
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream, err := healthpb.NewHealthClient(conn).Watch(streamCtx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+	cancel()
+	_, err = stream.Recv()
+	assert.Error(t, err)
+	welog.LogJob(ctx, nil, nil)
+
+	// Assert that the stream is recorded once with its counts and payloads.
+	assert.Equal(t, 1, strings.Count(buf.String(), `"targetGrpcMethod":"/grpc.health.v1.Health/Watch"`))
+	assert.Contains(t, buf.String(), `"targetGrpcCode":"Canceled"`)
+	assert.Contains(t, buf.String(), `"targetGrpcSentMessages":1`)
+	assert.Contains(t, buf.String(), `"targetGrpcReceivedMessages":1`)
+	assert.Contains(t, buf.String(), `{"body":"{\"status\":\"SERVING\"}","direction":"received"}`)
+}
+
+// TestPeerIP tests the IP recorded in the targetGrpcPeer field for the address of the server.
+func TestPeerIP(t *testing.T) {
+	for _, test := range []struct {
+		peer *peer.Peer
+		want string
+	}{
+		{nil, ""},
+		{&peer.Peer{}, ""},
+		{&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 443}}, "203.0.113.7"},
+		{&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}}, "2001:db8::1"},
+		{&peer.Peer{Addr: &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}}, "198.51.100.1"},
+		{&peer.Peer{Addr: &net.UnixAddr{Name: "/tmp/grpc.sock", Net: "unix"}}, ""},
+	} {
+		assert.Equal(t, test.want, peerIP(test.peer), test.peer)
+	}
+}
@@ -0,0 +1,173 @@
+package grpcgateway
+
+import (
+	"context"
+	"errors"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// maxStreamPayloads caps the payloads sampled per stream, so long-lived streams don't produce
+// unbounded target entries.
+const maxStreamPayloads = 10
+
+// NewStreamClientInterceptor returns an interceptor recording the streaming calls of the
+// gateway in the target field of the HTTP request document once they end. The entry counts the
+// messages sent and received and their protobuf sizes, records the IP of the server in
+// targetGrpcPeer, and keeps the protojson form of each message with the probability
+// payloadSampleRate, up to 10 per stream. A payloadSampleRate of zero logs no payloads. The
+// GRPCMethods configuration of welog applies like to weloggrpc.LogTarget.
+func NewStreamClientInterceptor(payloadSampleRate float64) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx = withRequestID(ctx)
+		methodConfig := welog.GRPCMethod(method)
+		if methodConfig.Skip {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		stream := &loggedStream{ctx: ctx, method: method, config: methodConfig, start: logger.Now()}
+		if !methodConfig.DisablePayloads {
+			stream.sampleRate = payloadSampleRate
+		}
+		clientStream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			stream.finish(err)
+			return nil, err
+		}
+		stream.ClientStream = clientStream
+
+		return stream, nil
+	}
+}
+
+// loggedStream is a grpc.ClientStream counting its messages and recording the stream once it
+// ends.
+type loggedStream struct {
+	grpc.ClientStream
+	ctx        context.Context
+	method     string
+	config     welog.GRPCMethodConfig
+	sampleRate float64
+	start      time.Time
+
+	mu            sync.Mutex
+	sent          int
+	received      int
+	sentBytes     int
+	receivedBytes int
+	payloads      []logrus.Fields
+	once          sync.Once
+}
+
+// SendMsg sends m and counts it. Failing sends end the stream.
+func (s *loggedStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	s.count("sent", m, &s.sent, &s.sentBytes)
+	return nil
+}
+
+// RecvMsg receives m and counts it. The stream ends with io.EOF or another error.
+func (s *loggedStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	default:
+		s.count("received", m, &s.received, &s.receivedBytes)
+	}
+	return err
+}
+
+// count adds a message to the counters and samples its payload.
+func (s *loggedStream) count(direction string, m any, messages *int, bytes *int) {
+	message, _ := m.(proto.Message)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	*messages++
+	if message == nil {
+		return
+	}
+	*bytes += proto.Size(message)
+
+	if len(s.payloads) < maxStreamPayloads && s.sampleRate > 0 && rand.Float64() < s.sampleRate {
+		if payload, err := protojson.Marshal(message); err == nil {
+			s.payloads = append(s.payloads, logrus.Fields{"direction": direction, "body": string(payload)})
+		}
+	}
+}
+
+// finish records the stream ended by err once.
+func (s *loggedStream) finish(err error) {
+	s.once.Do(func() {
+		if !s.config.Record(status.Code(err) != codes.OK) {
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		latency := logger.Since(s.start)
+		fields := logrus.Fields{
+			"targetGrpcCode":             status.Code(err).String(),
+			"targetGrpcStatusCode":       int(status.Code(err)),
+			"targetGrpcMethod":           s.method,
+			"targetGrpcReceivedBytes":    s.receivedBytes,
+			"targetGrpcReceivedMessages": s.received,
+			"targetGrpcSentBytes":        s.sentBytes,
+			"targetGrpcSentMessages":     s.sent,
+			"targetRequestTimestamp":     s.start.Format(time.RFC3339Nano),
+			"targetResponseLatency":      latency.String(),
+			"targetResponseLatencyMs":    util.Milliseconds(latency),
+			"targetResponseTimestamp":    s.start.Add(latency).Format(time.RFC3339Nano),
+		}
+		for key, value := range welog.GRPCMethodFields(s.method) {
+			fields[key] = value
+		}
+		if s.ClientStream != nil {
+			header, _ := s.ClientStream.Header()
+			if requestID := echoedRequestID(header, s.ClientStream.Trailer()); requestID != "" {
+				fields["targetResponseRequestId"] = requestID
+			}
+			if server, ok := peer.FromContext(s.ClientStream.Context()); ok {
+				if ip := peerIP(server); ip != "" {
+					fields["targetGrpcPeer"] = ip
+				}
+			}
+		}
+		if len(s.payloads) > 0 {
+			fields["targetGrpcPayloads"] = s.payloads
+		}
+		if err != nil {
+			fields["targetResponseError"] = status.Convert(err).Message()
+		}
+
+		welog.AddTarget(s.ctx, fields)
+	})
+}
@@ -0,0 +1,36 @@
+package welog
+
+import (
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// clientHintPrefix is the lower-cased prefix of the User-Agent Client Hints headers.
+const clientHintPrefix = "sec-ch-ua"
+
+// addClientHints adds the requestLanguages field parsed from Accept-Language and the
+// requestClientHints field holding the Sec-CH-UA* headers, keyed by their lower-cased
+// names, unless config disables client hints.
+func addClientHints(config Config, headers map[string][]string, fields logrus.Fields) {
+	if config.DisableClientHints {
+		return
+	}
+
+	hints := map[string]string{}
+	for name, values := range headers {
+		lower := strings.ToLower(name)
+		switch {
+		case lower == "accept-language" && len(values) > 0:
+			if languages := util.ParseAcceptLanguage(strings.Join(values, ",")); len(languages) > 0 {
+				fields["requestLanguages"] = languages
+			}
+		case strings.HasPrefix(lower, clientHintPrefix) && len(values) > 0:
+			hints[lower] = strings.Trim(values[0], `"`)
+		}
+	}
+
+	if len(hints) > 0 {
+		fields["requestClientHints"] = hints
+	}
+}
@@ -0,0 +1,78 @@
+package welog
+
+import (
+	"github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// namespaceFile holds the namespace of the pod in Kubernetes, mounted with the service account.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var (
+	hostFields     logrus.Fields // Host and Kubernetes fields detected by detectHost
+	hostFieldsOnce sync.Once     // Ensures the host is only inspected once
+
+	// containerIDPattern matches the 64 hexadecimal digits of a Docker or containerd container ID.
+	containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+)
+
+// detectHost returns the host.hostname, kubernetes.pod.name, kubernetes.namespace,
+// kubernetes.node.name, and container.id fields of the process, leaving out the ones that
+// can't be detected. The pod, namespace, and node are read from the POD_NAME, POD_NAMESPACE,
+// and NODE_NAME variables, which are set with the downward API. The result is cached, since
+// it doesn't change during the life of the process.
+func detectHost() logrus.Fields {
+	hostFieldsOnce.Do(func() {
+		hostname, _ := os.Hostname()
+		namespace := os.Getenv("POD_NAMESPACE")
+		if namespace == "" {
+			data, _ := os.ReadFile(namespaceFile)
+			namespace = strings.TrimSpace(string(data))
+		}
+		pod := os.Getenv("POD_NAME")
+		if pod == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			// The hostname of a pod is its name unless the pod spec overrides it.
+			pod = hostname
+		}
+
+		hostFields = logrus.Fields{}
+		for key, value := range map[string]string{
+			"host.hostname":        hostname,
+			"kubernetes.pod.name":  pod,
+			"kubernetes.namespace": namespace,
+			"kubernetes.node.name": os.Getenv("NODE_NAME"),
+			"container.id":         detectContainerID(),
+		} {
+			if value != "" {
+				hostFields[key] = value
+			}
+		}
+	})
+
+	return hostFields
+}
+
+// detectContainerID returns the ID of the container running the process, found in its
+// cgroups with cgroup v1 or in its mounts with cgroup v2, or "" outside of a container.
+func detectContainerID() string {
+	if data, err := os.ReadFile("/proc/self/cgroup"); err == nil {
+		if id := containerIDPattern.FindString(string(data)); id != "" {
+			return id
+		}
+	}
+
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// The runtime mounts the hostname file from the directory of the container.
+		if strings.Contains(line, "/etc/hostname") && (strings.Contains(line, "/containers/") || strings.Contains(line, "/sandboxes/")) {
+			return containerIDPattern.FindString(line)
+		}
+	}
+	return ""
+}
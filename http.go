@@ -0,0 +1,181 @@
+package welog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// responseWriter records the status and the size of a net/http response, and feeds its body
+// to the capture of the request, if any.
+type responseWriter struct {
+	http.ResponseWriter
+	capture *ResponseCapture
+	status  int
+	size    int64
+	written bool // Set once the header is written
+}
+
+// WriteHeader records the status and writes it to the underlying ResponseWriter.
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.written {
+		w.status, w.written = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write captures the response body and writes it to the underlying ResponseWriter.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	if w.capture != nil {
+		w.capture.Write(b)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush sends the buffered response to the client, for handlers streaming their response, such
+// as server-sent events. It does nothing if the underlying ResponseWriter can't flush.
+func (w *responseWriter) Flush() {
+	w.written = true
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack lets the handler take over the connection, such as for websockets. It fails with
+// http.ErrNotSupported if the underlying ResponseWriter can't be hijacked.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.written = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// NewHTTP wraps a net/http handler so it logs requests and responses like the middlewares of
+// the framework integrations, for services without a framework. The request context passed to
+// next is the context to use with LoggerFromContext, RequestIDFromContext, AddTarget, and
+// SetUser. The route is the pattern of the http.ServeMux that served the request, if any,
+// without its method. The response writer passed to next keeps flushing and hijacking the
+// connection, for server-sent events and websockets.
+func NewHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r := StartRequest(req.Method, req.URL.Path, req.Header.Get, func(name string) string {
+			cookie, err := req.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		})
+
+		// Set the request ID to the response and the request-related values to the context.
+		w.Header().Set("X-Request-ID", r.ID)
+		ctx := req.Context()
+		values := r.Values()
+		for i := 0; i+1 < len(values); i += 2 {
+			ctx = context.WithValue(ctx, values[i], values[i+1])
+		}
+		req = req.WithContext(ctx)
+
+		// Excluded requests only get the request-related values, not the logging.
+		if r.Skipped() {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		// Capture the response body and emit progress documents, counting the body as it is read.
+		writer := &responseWriter{ResponseWriter: w, capture: r.CaptureResponse(), status: http.StatusOK}
+		r.StartProgress(ctx, req.RequestURI)
+		req.Body = r.CountBody(req.Body, req.ContentLength)
+
+		// Proceed to the handler, recovering from panics.
+		nextHTTP(writer, req, next, r)
+
+		remoteAddr := req.RemoteAddr
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			remoteAddr = host
+		}
+		route := httpRoute(req.Pattern)
+		r.Log(Exchange{
+			Context:    ctx,
+			URL:        req.RequestURI,
+			Query:      req.URL.RawQuery,
+			Host:       req.Host,
+			Protocol:   req.Proto,
+			Route:      route,
+			Params:     httpParams(req, route),
+			Header:     req.Header,
+			GetHeader:  req.Header.Get,
+			RemoteAddr: remoteAddr,
+			ClientIP:   remoteAddr,
+
+			RequestContentType: req.Header.Get("Content-Type"),
+			ReadRequestBody: func() ([]byte, error) {
+				if req.Body == nil {
+					return nil, nil
+				}
+				body, err := io.ReadAll(req.Body)
+				req.Body = io.NopCloser(bytes.NewBuffer(body))
+				return body, err
+			},
+			RequestBytes: req.ContentLength,
+
+			Status:                  writer.status,
+			ResponseHeader:          w.Header(),
+			ResponseContentType:     w.Header().Get("Content-Type"),
+			ResponseContentEncoding: w.Header().Get("Content-Encoding"),
+			ResponseBytes:           writer.size,
+		})
+	})
+}
+
+// nextHTTP calls next and converts a panic into a 500 response, unless the handler already
+// wrote the header. The recovered value and stack trace are recorded in r.
+func nextHTTP(w *responseWriter, req *http.Request, next http.Handler, r *Request) {
+	defer func() {
+		if value := recover(); value != nil {
+			r.Recovered(value, debug.Stack())
+			if !w.written {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}
+	}()
+
+	next.ServeHTTP(w, req)
+}
+
+// httpRoute returns the path of a http.ServeMux pattern such as "GET /users/{id}", without its
+// method.
+func httpRoute(pattern string) string {
+	if method, path, ok := strings.Cut(pattern, " "); ok && method != "" {
+		return strings.TrimLeft(path, " \t")
+	}
+	return pattern
+}
+
+// httpParams returns the path wildcards of route, such as "{id}" in "/users/{id}", with their
+// values in req.
+func httpParams(req *http.Request, route string) map[string]string {
+	params := make(map[string]string)
+	for _, segment := range strings.Split(route, "/") {
+		name, ok := strings.CutPrefix(segment, "{")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSuffix(strings.TrimSuffix(name, "}"), "...")
+		if name != "$" && name != "" {
+			params[name] = req.PathValue(name)
+		}
+	}
+	return params
+}
@@ -0,0 +1,80 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"sync/atomic"
+)
+
+// NewJobContext returns a copy of ctx carrying the same request-scoped values as the request
+// context of NewHTTP, for work that doesn't start with an HTTP request, such as the tasks of a
+// queue worker. LoggerFromContext, RequestIDFromContext, AddTarget, and SetUser all work on
+// the returned context. An empty requestID is replaced by a new UUID.
+func NewJobContext(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+
+	entry := logger.Logger().WithField(currentConfig().FieldNaming.name(generalkey.RequestID), requestID)
+	for _, keyValue := range [][2]any{
+		{generalkey.RequestIDKey, requestID},
+		{generalkey.LoggerKey, entry},
+		{generalkey.ClientLogKey, &clientLogStore{}},
+		{generalkey.EventKey, &eventStore{}},
+		{generalkey.ForceLogKey, &atomic.Bool{}},
+		{generalkey.TransactionNameKey, &atomic.Pointer[string]{}},
+		{generalkey.UserKey, &requestUser{}},
+	} {
+		ctx = context.WithValue(ctx, keyValue[0], keyValue[1])
+	}
+
+	return ctx
+}
+
+// LogJob logs the document of a job run with a context returned by NewJobContext. The
+// document carries fields, the target entries accumulated during the run, the events logged
+// with LogEvent, the name set by SetTransactionName, the user set by SetUser, and, for failed
+// runs, the error in the jobError field, in which case it is logged at error level.
+func LogJob(ctx context.Context, fields logrus.Fields, err error) {
+	config := currentConfig()
+
+	document := logrus.Fields{}
+	for key, value := range fields {
+		document[key] = value
+	}
+
+	if store, ok := ctx.Value(generalkey.ClientLogKey).(*clientLogStore); ok {
+		document["target"] = store.list()
+		if dropped := store.droppedCount(); dropped > 0 {
+			document["targetDropped"] = dropped
+		}
+	}
+
+	events, _ := ctx.Value(generalkey.EventKey).(*eventStore)
+	if list := events.list(); len(list) > 0 {
+		document["events"] = list
+	}
+
+	stored, _ := ctx.Value(generalkey.TransactionNameKey).(*atomic.Pointer[string])
+	if name := transactionName(stored, "", ""); name != "" {
+		document["transactionName"] = name
+	}
+
+	requester, _ := ctx.Value(generalkey.UserKey).(*requestUser)
+	for key, value := range requester.fields() {
+		document[key] = value
+	}
+
+	level := logrus.InfoLevel
+	if err != nil {
+		document["jobError"] = err.Error()
+		level = logrus.ErrorLevel
+	}
+
+	applyFieldNaming(config, document)
+
+	LoggerFromContext(ctx).WithContext(ctx).WithFields(document).Log(level)
+}
@@ -0,0 +1,57 @@
+package welog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"slices"
+	"strings"
+)
+
+// addJWTClaims adds the requestClaims field holding the claims listed in config.JWTClaims
+// that are found in the payload of the bearer token of the Authorization header. The token
+// is decoded without verifying its signature, and neither the token nor the other claims
+// are logged. Malformed tokens are ignored.
+func addJWTClaims(config Config, headers map[string][]string, fields logrus.Fields) {
+	if len(config.JWTClaims) == 0 {
+		return
+	}
+
+	var authorization string
+	for name, values := range headers {
+		if strings.EqualFold(name, "Authorization") && len(values) > 0 {
+			authorization = values[0]
+			break
+		}
+	}
+
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return
+	}
+
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return
+	}
+
+	var claims map[string]interface{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return
+	}
+
+	for name := range claims {
+		if !slices.Contains(config.JWTClaims, name) {
+			delete(claims, name)
+		}
+	}
+
+	if len(claims) > 0 {
+		fields["requestClaims"] = claims
+	}
+}
@@ -0,0 +1,157 @@
+package welog
+
+import (
+	"fmt"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// LevelFunc maps the response status and the error of a request, if any, to the level
+// at which the request is logged.
+type LevelFunc func(status int, err error) logrus.Level
+
+// DefaultLevelFunc logs server errors (5xx) at error level, client errors (4xx) at
+// warning level, and everything else at info level.
+func DefaultLevelFunc(status int, _ error) logrus.Level {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return logrus.ErrorLevel
+	case status >= http.StatusBadRequest:
+		return logrus.WarnLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
+
+// statusClass returns the class of an HTTP status, such as "2xx" or "5xx", for error-rate
+// queries that don't need a range over the status, or empty if status isn't a valid status.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return ""
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// requestLevel returns the level of a request log using the configured LevelFunc.
+func requestLevel(status int, err error) logrus.Level {
+	if levelFunc := currentConfig().LevelFunc; levelFunc != nil {
+		return levelFunc(status, err)
+	}
+	return DefaultLevelFunc(status, err)
+}
+
+// SetLevel changes the minimum level of the entries logged through welog at runtime, e.g. to
+// DebugLevel while diagnosing a production incident. Request documents below the level, such
+// as successful requests under WarnLevel, are not logged either.
+func SetLevel(level logrus.Level) {
+	logger.Logger().SetLevel(level)
+}
+
+// Level returns the minimum level of the entries logged through welog.
+func Level() logrus.Level {
+	return logger.Logger().GetLevel()
+}
+
+// SetSinkLevels changes the minimum levels of the entries written to the logger's output and
+// shipped to ElasticSearch at runtime, i.e. the Level of OutputFilter and ElasticFilter, e.g. to
+// ship debug entries while diagnosing an incident. Empty passes every entry the logger logs.
+func SetSinkLevels(output, elastic string) {
+	configMutex.Lock()
+	activeConfig.OutputFilter.Level = output
+	activeConfig.ElasticFilter.Level = elastic
+	config := activeConfig
+	configMutex.Unlock()
+
+	logger.SetSinkFilters(config.OutputFilter, config.ElasticFilter)
+}
+
+// SinkLevels returns the minimum levels of the logger's output and of ElasticSearch, see
+// SetSinkLevels.
+func SinkLevels() (output, elastic string) {
+	config := currentConfig()
+	return config.OutputFilter.Level, config.ElasticFilter.Level
+}
+
+// levelBody is the request and response body of LevelHandler. Omitted levels are left as is.
+type levelBody struct {
+	Level        *string `json:"level,omitempty"`
+	OutputLevel  *string `json:"outputLevel,omitempty"`
+	ElasticLevel *string `json:"elasticLevel,omitempty"`
+}
+
+// LevelHandler returns an http.Handler reporting the levels as
+// {"level":"info","outputLevel":"","elasticLevel":"warn"} on GET and changing them on PUT or
+// POST with a body of the same shape, so the level of the logger and those of its sinks, see
+// SetSinkLevels, can be switched without a restart. Omitted levels are left as is, and empty
+// sink levels pass every entry. Mount it on an internal admin route only, with gin.WrapH or
+// Fiber's adaptor package.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var body levelBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+				return
+			}
+			if err := applyLevels(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		level := Level().String()
+		output, elastic := SinkLevels()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(levelBody{Level: &level, OutputLevel: &output, ElasticLevel: &elastic})
+	})
+}
+
+// applyLevels sets the levels of body, or none of them if one is invalid.
+func applyLevels(body levelBody) error {
+	var level logrus.Level
+	if body.Level != nil {
+		parsed, err := logrus.ParseLevel(*body.Level)
+		if err != nil {
+			return err
+		}
+		level = parsed
+	}
+
+	output, elastic := SinkLevels()
+	output, err := sinkLevel("outputLevel", body.OutputLevel, output)
+	if err != nil {
+		return err
+	}
+	elastic, err = sinkLevel("elasticLevel", body.ElasticLevel, elastic)
+	if err != nil {
+		return err
+	}
+
+	if body.Level != nil {
+		SetLevel(level)
+	}
+	if body.OutputLevel != nil || body.ElasticLevel != nil {
+		SetSinkLevels(output, elastic)
+	}
+	return nil
+}
+
+// sinkLevel returns the sink level named name set to level, or current if level is omitted.
+func sinkLevel(name string, level *string, current string) (string, error) {
+	if level == nil {
+		return current, nil
+	}
+	if _, err := logrus.ParseLevel(*level); *level != "" && err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return *level, nil
+}
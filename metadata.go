@@ -0,0 +1,29 @@
+package welog
+
+import (
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// applyMetadata stamps the ECS service.name, service.version, and labels.env fields of the
+// configured service onto every entry, leaving out the empty ones, along with the fields of
+// the host unless config disables them.
+func applyMetadata(config Config) {
+	fields := logrus.Fields{}
+	if !config.DisableHostMetadata {
+		for key, value := range detectHost() {
+			fields[key] = value
+		}
+	}
+	for key, value := range map[string]string{
+		"service.name":    config.ServiceName,
+		"service.version": config.ServiceVersion,
+		"labels.env":      config.ServiceEnvironment,
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+
+	logger.SetMetadata(fields)
+}
@@ -0,0 +1,77 @@
+package welog
+
+import (
+	"github.com/sirupsen/logrus"
+	"strings"
+	"unicode"
+)
+
+// FieldNaming is the naming convention of the fields of the request documents.
+type FieldNaming int
+
+const (
+	// CamelCase names the fields like requestMethod or targetResponseStatus. It is the default.
+	CamelCase FieldNaming = iota
+
+	// SnakeCase names the fields like request_method or target_response_status.
+	SnakeCase
+)
+
+// name returns key following the naming convention. Keys are written in camel case, so
+// only SnakeCase converts them; acronyms are kept together, e.g. targetRequestURL becomes
+// target_request_url.
+func (n FieldNaming) name(key string) string {
+	if n != SnakeCase {
+		return key
+	}
+
+	runes := []rune(key)
+	var b strings.Builder
+	b.Grow(len(key) + 4)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// applyFieldNaming renames the fields of a request document, and those of its target and
+// event sub-entries, after the naming convention of config.
+func applyFieldNaming(config Config, fields logrus.Fields) {
+	if config.FieldNaming == CamelCase {
+		return
+	}
+
+	renameFields(config.FieldNaming, fields)
+	for _, name := range []string{"target", "events"} {
+		entries, ok := fields[config.FieldNaming.name(name)].([]logrus.Fields)
+		if !ok {
+			continue
+		}
+		renamed := make([]logrus.Fields, len(entries))
+		for i, entry := range entries {
+			renamed[i] = make(logrus.Fields, len(entry))
+			for key, value := range entry {
+				renamed[i][key] = value
+			}
+			renameFields(config.FieldNaming, renamed[i])
+		}
+		fields[config.FieldNaming.name(name)] = renamed
+	}
+}
+
+// renameFields renames the keys of fields in place after the naming convention.
+func renameFields(naming FieldNaming, fields logrus.Fields) {
+	for key, value := range fields {
+		if renamed := naming.name(key); renamed != key {
+			delete(fields, key)
+			fields[renamed] = value
+		}
+	}
+}
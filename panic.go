@@ -0,0 +1,40 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+	"runtime/debug"
+)
+
+// flushPipeline flushes the default pipeline before a panic may kill the process, see HandlePanic.
+var flushPipeline = logger.Flush
+
+// HandlePanic logs the panic of the calling goroutine, with its value and stack trace, and
+// flushes the entries buffered for ElasticSearch, writing those that can't be shipped within
+// logger.ExitTimeout to the fallback file, then panics again with the same value. An uncaught
+// panic would otherwise kill the process before they are delivered. The pipeline keeps running,
+// so logging goes on if a frame higher up recovers the panic. Defer it at the top of main and
+// of the goroutines started by the application:
+//
+//	defer welog.HandlePanic()
+//
+// Panics raised by the Panic methods of logrus were logged already and are only flushed. Calls
+// to logrus.Fatal need nothing, as the pipelines drain themselves on logrus exits, see logger.Drain.
+func HandlePanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if _, logged := r.(*logrus.Entry); !logged {
+		recovered := &recoveredPanic{value: r, stack: debug.Stack()}
+		logger.Logger().WithFields(recovered.fields()).Error("panic")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logger.ExitTimeout)
+	defer cancel()
+	_ = flushPipeline(ctx)
+
+	panic(r)
+}
@@ -0,0 +1,100 @@
+package welog
+
+import (
+	"github.com/christiandoxa/welog/pkg/util"
+	"net/url"
+	"strings"
+)
+
+// defaultRedactKeys are the query and route parameters redacted when Config.RedactKeys is nil.
+var defaultRedactKeys = []string{
+	"access_token", "api_key", "apikey", "password", "secret", "signature", "token",
+}
+
+// redactKeys returns the parameter names whose values are redacted under config.
+func redactKeys(config Config) []string {
+	if keys := config.RedactKeys; keys != nil {
+		return keys
+	}
+	return defaultRedactKeys
+}
+
+// redacted reports whether the values of the parameter name are redacted, comparing the
+// name case-insensitively.
+func redacted(keys []string, name string) bool {
+	for _, key := range keys {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryFields parses a raw query string into the requestQuery field. Keys with a single value
+// map to a string, repeated keys to a slice of strings, and redacted keys to util.Redacted.
+func queryFields(rawQuery string) map[string]any {
+	return queryFieldsFor(currentConfig(), rawQuery)
+}
+
+// queryFieldsFor is like queryFields, with the redacted keys of config.
+func queryFieldsFor(config Config, rawQuery string) map[string]any {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil && len(values) == 0 {
+		return nil
+	}
+
+	keys := redactKeys(config)
+	query := make(map[string]any, len(values))
+	for key, value := range values {
+		if redacted(keys, key) {
+			query[key] = util.Redacted
+		} else {
+			query[key] = formValue(value)
+		}
+	}
+	return query
+}
+
+// paramFields copies route parameters into the requestParams field, redacting the values
+// of redacted keys.
+func paramFields(params map[string]string) map[string]string {
+	return paramFieldsFor(currentConfig(), params)
+}
+
+// paramFieldsFor is like paramFields, with the redacted keys of config.
+func paramFieldsFor(config Config, params map[string]string) map[string]string {
+	keys := redactKeys(config)
+	fields := make(map[string]string, len(params))
+	for key, value := range params {
+		if redacted(keys, key) {
+			fields[key] = util.Redacted
+		} else {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// redactURL replaces the values of redacted query parameters in a URL by util.Redacted,
+// leaving the rest of the URL untouched.
+func redactURL(rawURL string) string {
+	return redactURLFor(currentConfig(), rawURL)
+}
+
+// redactURLFor is like redactURL, with the redacted keys of config.
+func redactURLFor(config Config, rawURL string) string {
+	path, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+
+	keys := redactKeys(config)
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil && redacted(keys, name) {
+			pairs[i] = key + "=" + url.QueryEscape(util.Redacted)
+		}
+	}
+	return path + "?" + strings.Join(pairs, "&")
+}
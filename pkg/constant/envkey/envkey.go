@@ -4,18 +4,140 @@
 // are not hardcoded within the application.
 package envkey
 
+// DeadLetterPath is the environment variable key used to specify the file that receives log entries permanently
+// rejected by ElasticSearch, e.g. because of a mapping conflict, together with the error ElasticSearch returned.
+const DeadLetterPath = "DEAD_LETTER_PATH__"
+
+// Development is the environment variable key used to enable, as "true" or "false", the development mode, which
+// writes log entries to stdout in a colorized, human-readable format without connecting to ElasticSearch.
+const Development = "DEVELOPMENT__"
+
+// ElasticAPIKey is the environment variable key used to specify the base64-encoded API key authenticating
+// with ElasticSearch. It takes precedence over the username and password.
+const ElasticAPIKey = "ELASTIC_API_KEY__"
+
+// ElasticBatchSize is the environment variable key used to specify the maximum number of log entries written
+// to ElasticSearch by one bulk request. Empty or 1 writes every entry with its own request.
+const ElasticBatchSize = "ELASTIC_BATCH_SIZE__"
+
+// ElasticBypassDuration is the environment variable key used to specify, as a Go duration string, how long
+// ElasticSearch is bypassed in favor of the fallback file once its write latency is found to be too high.
+const ElasticBypassDuration = "ELASTIC_BYPASS_DURATION__"
+
+// ElasticCACertPath is the environment variable key used to specify the path of a PEM file holding the
+// certificate authorities trusted, in addition to the system ones, to verify the ElasticSearch certificate.
+const ElasticCACertPath = "ELASTIC_CA_CERT_PATH__"
+
+// ElasticCACertPEM is the environment variable key used to specify PEM-encoded certificate authorities
+// trusted, in addition to the system ones, to verify the ElasticSearch certificate.
+const ElasticCACertPEM = "ELASTIC_CA_CERT_PEM__"
+
+// ElasticCloudID is the environment variable key used to specify the Cloud ID of an Elastic Cloud
+// deployment, which replaces the URL of the ElasticSearch instance.
+const ElasticCloudID = "ELASTIC_CLOUD_ID__"
+
+// ElasticClientCertPath is the environment variable key used to specify the path of the PEM-encoded client
+// certificate presented to ElasticSearch for mutual TLS, together with the key at ElasticClientKeyPath.
+const ElasticClientCertPath = "ELASTIC_CLIENT_CERT_PATH__"
+
+// ElasticClientKeyPath is the environment variable key used to specify the path of the PEM-encoded private
+// key of the client certificate at ElasticClientCertPath.
+const ElasticClientKeyPath = "ELASTIC_CLIENT_KEY_PATH__"
+
+// ElasticDiscoverInterval is the environment variable key used to specify, as a Go duration string, how often
+// the nodes of the ElasticSearch cluster are discovered, on top of the configured URLs. Empty disables discovery.
+const ElasticDiscoverInterval = "ELASTIC_DISCOVER_INTERVAL__"
+
+// ElasticEntryTTL is the environment variable key used to specify, as a Go duration string, the age after which
+// a log entry still buffered for ElasticSearch is written to the fallback file instead. Empty disables the limit.
+const ElasticEntryTTL = "ELASTIC_ENTRY_TTL__"
+
+// ElasticFailbackInterval is the environment variable key used to specify, as a Go duration string, how often
+// the primary ElasticSearch cluster is checked while logs are shipped to the secondary one. Empty uses 30s.
+const ElasticFailbackInterval = "ELASTIC_FAILBACK_INTERVAL__"
+
 // ElasticIndex is the environment variable key used to specify the index name for ElasticSearch.
 // This index is used to store logs and other structured data within the ElasticSearch cluster.
 const ElasticIndex = "ELASTIC_INDEX__"
 
+// ElasticIndexDateLayout is the environment variable key used to specify the Go time layout of the date
+// appended to the index name, such as "2006-01" for monthly indices. Empty uses "2006-01-02".
+const ElasticIndexDateLayout = "ELASTIC_INDEX_DATE_LAYOUT__"
+
+// ElasticInsecureSkipVerify is the environment variable key used to disable, as "true" or "false", the
+// verification of the ElasticSearch certificate. It must only be used for testing.
+const ElasticInsecureSkipVerify = "ELASTIC_INSECURE_SKIP_VERIFY__"
+
+// ElasticMaxRetries is the environment variable key used to specify how many times a failed request to
+// ElasticSearch is retried on another node. Empty uses the client's default of 3.
+const ElasticMaxRetries = "ELASTIC_MAX_RETRIES__"
+
 // ElasticPassword is the environment variable key used to specify the password for authenticating
 // with ElasticSearch. This password, together with the username, secures the connection to ElasticSearch.
 const ElasticPassword = "ELASTIC_PASSWORD__"
 
+// ElasticPipeline is the environment variable key used to specify the ingest pipeline that processes the
+// documents written to ElasticSearch, such as a pipeline running the geoip or user_agent processors.
+const ElasticPipeline = "ELASTIC_PIPELINE__"
+
+// ElasticQueueBytes is the environment variable key used to specify the maximum bytes of the log entries
+// buffered for ElasticSearch. Entries logged while the buffer is full are dropped. Empty disables the limit.
+const ElasticQueueBytes = "ELASTIC_QUEUE_BYTES__"
+
+// ElasticQueueSize is the environment variable key used to specify the number of log entries buffered for
+// ElasticSearch. Entries logged while the buffer is full are dropped.
+const ElasticQueueSize = "ELASTIC_QUEUE_SIZE__"
+
+// ElasticRetryOnStatus is the environment variable key used to specify, as comma-separated codes, the HTTP
+// statuses of ElasticSearch responses that are retried. Empty uses the client's default of 502, 503, and 504.
+const ElasticRetryOnStatus = "ELASTIC_RETRY_ON_STATUS__"
+
+// ElasticSecondaryURLs is the environment variable key used to specify, as comma-separated URLs, the nodes of a
+// secondary ElasticSearch cluster that logs are shipped to while the primary one is unreachable.
+const ElasticSecondaryURLs = "ELASTIC_SECONDARY_URLS__"
+
+// ElasticServiceToken is the environment variable key used to specify the service account token
+// authenticating with ElasticSearch. It takes precedence over the username and password.
+const ElasticServiceToken = "ELASTIC_SERVICE_TOKEN__"
+
+// ElasticSlowThreshold is the environment variable key used to specify, as a Go duration string, the 95th
+// percentile of write latency above which ElasticSearch is considered slow and temporarily bypassed.
+// A negative duration disables the detection.
+const ElasticSlowThreshold = "ELASTIC_SLOW_THRESHOLD__"
+
+// ElasticSpoolDir is the environment variable key used to specify the directory of the disk-backed spool of the
+// log entries buffered for ElasticSearch, which keeps them across restarts. Empty disables the spool.
+const ElasticSpoolDir = "ELASTIC_SPOOL_DIR__"
+
 // ElasticURL is the environment variable key used to specify the URL of the ElasticSearch instance.
 // This URL is required to connect the application to the ElasticSearch service for logging and data storage.
 const ElasticURL = "ELASTIC_URL__"
 
+// ElasticURLs is the environment variable key used to specify, as comma-separated URLs, further nodes of
+// the ElasticSearch cluster. Requests are load balanced over them and the URL at ElasticURL.
+const ElasticURLs = "ELASTIC_URLS__"
+
 // ElasticUsername is the environment variable key used to specify the username for authenticating
 // with ElasticSearch. This username, in combination with the password, provides secure access to ElasticSearch.
 const ElasticUsername = "ELASTIC_USERNAME__"
+
+// ElasticWorkers is the environment variable key used to specify the number of workers writing log entries to
+// ElasticSearch concurrently, so one slow write doesn't stall every entry. Empty uses a single worker.
+const ElasticWorkers = "ELASTIC_WORKERS__"
+
+// ElasticWriteTimeout is the environment variable key used to specify the deadline of a single write to
+// ElasticSearch, as a Go duration string such as "5s". It prevents a hung connection from blocking log shipping.
+const ElasticWriteTimeout = "ELASTIC_WRITE_TIMEOUT__"
+
+// FallbackPath is the environment variable key used to specify the file that receives log entries which can't
+// be written to ElasticSearch, either because a write failed or because ElasticSearch is temporarily bypassed.
+const FallbackPath = "FALLBACK_PATH__"
+
+// LogLevel is the environment variable key used to specify the minimum level of the log entries shipped to
+// ElasticSearch, such as "info", so debug entries only reach the local output. Unlike the other keys, it follows
+// the common LOG_LEVEL convention, so operators can set it directly. Empty ships every entry.
+const LogLevel = "LOG_LEVEL"
+
+// StdoutOnly is the environment variable key used to make the logger write to stdout only, as "true" or
+// "false", without connecting to ElasticSearch.
+const StdoutOnly = "STDOUT_ONLY__"
@@ -1,16 +1,69 @@
 // Package generalkey defines common keys used within the application's context for logging
 // and request handling. These keys are used to store and retrieve specific values from
-// the Fiber context, facilitating consistent and structured logging throughout the application.
+// the Fiber and Gin contexts, facilitating consistent and structured logging throughout the application.
 package generalkey
 
-// ClientLog is the context key used to store log entries related to client requests.
-// This key helps in accumulating log data for outgoing HTTP requests that the server makes.
+// contextKey is the type of the keys defined in this package. Because the type is
+// unexported, no other package can construct a key that collides with the ones below,
+// unlike plain string keys which may clash with values set by other middlewares.
+type contextKey struct {
+	name string
+}
+
+// String returns a readable representation of the key, which is useful when debugging.
+func (k *contextKey) String() string {
+	return "welog context key " + k.name
+}
+
+var (
+	// ClientLogKey is the context key used to store log entries related to client requests.
+	// This key helps in accumulating log data for outgoing HTTP requests that the server makes.
+	ClientLogKey = &contextKey{"client-log"}
+
+	// EventKey is the context key used to store the business events logged by welog.LogEvent, which
+	// are attached to the request log.
+	EventKey = &contextKey{"event"}
+
+	// ForceLogKey is the context key used to store the flag set by ForceLog, which exempts a
+	// request from sampling.
+	ForceLogKey = &contextKey{"force-log"}
+
+	// LoggerKey is the context key used to store the logger instance within the context of each request.
+	// It allows middleware and handlers to access a logger pre-configured with request-specific fields.
+	LoggerKey = &contextKey{"logger"}
+
+	// RequestIDKey is the context key used to store the unique request identifier for each incoming request.
+	// This key helps track individual requests across various logs and enhances traceability.
+	RequestIDKey = &contextKey{"requestId"}
+
+	// TargetAttemptKey is the context key used to store the attempt of a retried client call, set by
+	// welog.WithTargetAttempt, so the logging transport can record it.
+	TargetAttemptKey = &contextKey{"target-attempt"}
+
+	// TransactionNameKey is the context key used to store the name of the operation of a request, set
+	// by welog.SetTransactionName.
+	TransactionNameKey = &contextKey{"transaction-name"}
+
+	// UserKey is the context key used to store the identity of the user making the request,
+	// set by welog.SetUser.
+	UserKey = &contextKey{"user"}
+)
+
+// ClientLog is the legacy string key under which client log entries are stored.
+//
+// Deprecated: use ClientLogKey or the accessor functions of the welog package instead.
+// The string key is still populated for one release to keep existing handlers working.
 const ClientLog = "client-log"
 
-// Logger is the context key used to store the logger instance within the context of each request.
-// It allows middleware and handlers to access a logger pre-configured with request-specific fields.
+// Logger is the legacy string key under which the request-scoped logger is stored.
+//
+// Deprecated: use LoggerKey or the accessor functions of the welog package instead.
+// The string key is still populated for one release to keep existing handlers working.
 const Logger = "logger"
 
-// RequestID is the context key used to store the unique request identifier for each incoming request.
-// This key helps track individual requests across various logs and enhances traceability.
+// RequestID is the legacy string key under which the request identifier is stored. It is
+// also used as the field name of the request identifier in log entries.
+//
+// Deprecated: use RequestIDKey or the accessor functions of the welog package instead.
+// The string key is still populated for one release to keep existing handlers working.
 const RequestID = "requestId"
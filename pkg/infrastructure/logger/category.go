@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// Category classifies log entries so each kind can have its own volume budget.
+type Category int
+
+const (
+	// CategoryApp is the category of entries logged by the application, which is the
+	// default for entries without a category.
+	CategoryApp Category = iota
+
+	// CategoryRequest is the category of the request documents written by the middlewares.
+	CategoryRequest
+
+	// CategoryExample is the category of the sanitized request/response examples collected
+	// for API documentation.
+	CategoryExample
+
+	// CategoryDarkLaunch is the category of the request documents written by a dark-launched
+	// candidate configuration.
+	CategoryDarkLaunch
+
+	// CategoryAudit is the category of the audit entries. They have no budget and are never
+	// dropped by the ElasticSearch hook, which waits for room in its queue instead.
+	CategoryAudit
+)
+
+// categoryKey is the context key under which the category of an entry is stored.
+type categoryKey struct{}
+
+var (
+	budgets         = map[Category]*util.TokenBucket{} // Budgets of the categories that are limited
+	categoryIndexes = map[Category]string{}            // Index prefixes of the categories with their own index
+	budgetsMutex    sync.RWMutex                       // Protects access to budgets and categoryIndexes
+)
+
+// WithCategory returns a copy of ctx carrying the category. Pass it to logrus.Entry.WithContext
+// to classify the entry.
+func WithCategory(ctx context.Context, category Category) context.Context {
+	return context.WithValue(ctx, categoryKey{}, category)
+}
+
+// SetBudget limits the entries of a category shipped to ElasticSearch to perSecond entries per
+// second with bursts of up to burst entries. A non-positive perSecond removes the limit.
+func SetBudget(category Category, perSecond float64, burst int) {
+	budgetsMutex.Lock()
+	defer budgetsMutex.Unlock()
+
+	if bucket := util.NewTokenBucket(perSecond, burst); bucket != nil {
+		budgets[category] = bucket
+	} else {
+		delete(budgets, category)
+	}
+}
+
+// SetCategoryIndex routes the entries of a category to their own index, named after prefix
+// followed by the current date. An empty prefix routes them back to the default index.
+func SetCategoryIndex(category Category, prefix string) {
+	budgetsMutex.Lock()
+	defer budgetsMutex.Unlock()
+
+	if prefix != "" {
+		categoryIndexes[category] = prefix
+	} else {
+		delete(categoryIndexes, category)
+	}
+}
+
+// categoryOf returns the category of the entry, CategoryApp if none is set.
+func categoryOf(entry *logrus.Entry) Category {
+	if entry.Context != nil {
+		if c, ok := entry.Context.Value(categoryKey{}).(Category); ok {
+			return c
+		}
+	}
+	return CategoryApp
+}
+
+// categoryIndex returns the index prefix of the category, empty if it uses the default index.
+func categoryIndex(category Category) string {
+	budgetsMutex.RLock()
+	defer budgetsMutex.RUnlock()
+
+	return categoryIndexes[category]
+}
+
+// withinBudget reports whether the entry fits into the budget of its category.
+func withinBudget(entry *logrus.Entry) bool {
+	budgetsMutex.RLock()
+	bucket := budgets[categoryOf(entry)]
+	budgetsMutex.RUnlock()
+
+	return bucket.Allow()
+}
@@ -0,0 +1,73 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the current time. welog reads the timestamps and latencies of the documents
+// it logs from the clock set with SetClock, so tests can make them deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock of the system, used unless another one is set.
+type systemClock struct{}
+
+// Now returns time.Now().
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockHolder wraps a Clock so clocks of different types can be stored in an atomic.Value.
+type clockHolder struct {
+	Clock
+}
+
+var clock atomic.Value // Current clockHolder, the system clock if never set
+
+// SetClock makes welog read the time from c, e.g. a fixed clock in golden-file tests. The
+// timestamps of all entries logged through Logger are then taken from c too, replacing those
+// set with WithTime. Nil restores the system clock.
+func SetClock(c Clock) {
+	if c == nil {
+		c = systemClock{}
+	}
+	clock.Store(clockHolder{c})
+}
+
+// Now returns the current time of the clock set with SetClock.
+func Now() time.Time {
+	if holder, ok := clock.Load().(clockHolder); ok {
+		return holder.Now()
+	}
+	return time.Now()
+}
+
+// Since returns the time elapsed since t on the clock set with SetClock.
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// clockHook is a logrus hook stamping the time of the clock set with SetClock onto every entry.
+// It is installed before the other hooks, so they see the time too.
+type clockHook struct{}
+
+// clocks is the hook installed on the logger by start and installHook.
+var clocks clockHook
+
+// Levels returns all log levels, so every entry is stamped.
+func (clockHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire sets the time of the entry, unless the system clock is used.
+func (clockHook) Fire(entry *logrus.Entry) error {
+	if holder, ok := clock.Load().(clockHolder); ok {
+		if _, system := holder.Clock.(systemClock); !system {
+			entry.Time = holder.Now()
+		}
+	}
+	return nil
+}
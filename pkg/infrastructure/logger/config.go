@@ -0,0 +1,296 @@
+package logger
+
+import (
+	"errors"
+	"github.com/christiandoxa/welog/pkg/constant/envkey"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the ElasticSearch connection and shipping settings of a Pipeline. Zero values
+// select the same defaults as the welog.Config fields of the same name.
+type Config struct {
+	ElasticURL          string
+	ElasticURLs         []string
+	ElasticUsername     string
+	ElasticPassword     string
+	ElasticAPIKey       string
+	ElasticServiceToken string
+	ElasticCloudID      string
+
+	// ElasticClient is used instead of a client built from the settings above when set.
+	ElasticClient *elasticsearch.Client
+
+	ElasticDiscoverInterval time.Duration
+	ElasticMaxRetries       int
+	ElasticRetryOnStatus    []int
+
+	// ElasticSecondaryURLs lists the nodes of a cluster shipped to, with the same credentials and
+	// TLS settings, while the primary one is unreachable. The primary cluster is checked every
+	// ElasticFailbackInterval, 30s if zero, and shipped to again once it is back.
+	ElasticSecondaryURLs    []string
+	ElasticFailbackInterval time.Duration
+
+	ElasticCACertPath         string
+	ElasticCACertPEM          string
+	ElasticClientCertPath     string
+	ElasticClientKeyPath      string
+	ElasticInsecureSkipVerify bool
+
+	// ElasticIndex is the prefix of the daily indices, followed by the date in
+	// ElasticIndexDateLayout. IndexNameFunc replaces both when set.
+	ElasticIndex           string
+	ElasticIndexDateLayout string
+	IndexNameFunc          func(entry *logrus.Entry) string
+
+	// DocumentIDFunc computes the ElasticSearch document ID of every entry, see
+	// SetDocumentIDFunc. DefaultDocumentID is used when nil.
+	DocumentIDFunc func(entry *logrus.Entry) string
+
+	ElasticPipeline       string
+	ElasticWriteTimeout   time.Duration
+	ElasticSlowThreshold  time.Duration
+	ElasticBypassDuration time.Duration
+	ElasticQueueSize      int
+	ElasticQueueBytes     int64
+	ElasticEntryTTL       time.Duration
+	ElasticSpoolDir       string
+	ElasticWorkers        int
+	ElasticBatchSize      int
+	FallbackPath          string
+	DeadLetterPath        string
+
+	// LogLevel is the minimum level of the entries shipped to ElasticSearch, such as "info".
+	// Empty or invalid ships every entry.
+	LogLevel string
+
+	// Formatter formats the entries in the output and in ElasticSearch instead of ECS JSON when
+	// set, see SetFormatter.
+	Formatter logrus.Formatter
+
+	// OutputFilter and ElasticFilter select the entries and fields written to the output and
+	// shipped to ElasticSearch, see SetSinkFilters.
+	OutputFilter  SinkFilter
+	ElasticFilter SinkFilter
+
+	// StdoutOnly writes the entries to stdout only, without connecting to ElasticSearch.
+	StdoutOnly bool
+
+	// Development writes the entries to stdout in a colorized, human-readable format instead of
+	// ECS JSON, without connecting to ElasticSearch.
+	Development bool
+}
+
+// configFromEnv reads the configuration of the default pipeline from the environment, as
+// set by welog.SetConfig, and the credentials set with SetCredentials. Unset or invalid values
+// yield zero, which selects the defaults.
+func configFromEnv() Config {
+	outputFilter, elasticFilter := SinkFilters()
+	credentials := injectedCredentials()
+
+	return Config{
+		ElasticURL:                os.Getenv(envkey.ElasticURL),
+		ElasticURLs:               strings.Split(os.Getenv(envkey.ElasticURLs), ","),
+		ElasticUsername:           os.Getenv(envkey.ElasticUsername),
+		ElasticPassword:           os.Getenv(envkey.ElasticPassword),
+		ElasticAPIKey:             orEnv(credentials.APIKey, envkey.ElasticAPIKey),
+		ElasticServiceToken:       orEnv(credentials.ServiceToken, envkey.ElasticServiceToken),
+		ElasticCloudID:            os.Getenv(envkey.ElasticCloudID),
+		ElasticDiscoverInterval:   durationFromEnv(envkey.ElasticDiscoverInterval),
+		ElasticMaxRetries:         intFromEnv(envkey.ElasticMaxRetries),
+		ElasticRetryOnStatus:      intsFromEnv(envkey.ElasticRetryOnStatus),
+		ElasticSecondaryURLs:      strings.Split(os.Getenv(envkey.ElasticSecondaryURLs), ","),
+		ElasticFailbackInterval:   durationFromEnv(envkey.ElasticFailbackInterval),
+		ElasticCACertPath:         orEnv(credentials.CACertPath, envkey.ElasticCACertPath),
+		ElasticCACertPEM:          orEnv(credentials.CACertPEM, envkey.ElasticCACertPEM),
+		ElasticClientCertPath:     orEnv(credentials.ClientCertPath, envkey.ElasticClientCertPath),
+		ElasticClientKeyPath:      orEnv(credentials.ClientKeyPath, envkey.ElasticClientKeyPath),
+		ElasticInsecureSkipVerify: boolFromEnv(envkey.ElasticInsecureSkipVerify),
+		IndexNameFunc:             indexNameFunc,
+		DocumentIDFunc:            documentIDFunc,
+		Formatter:                 injectedFormatter(),
+		ElasticPipeline:           os.Getenv(envkey.ElasticPipeline),
+		ElasticWriteTimeout:       durationFromEnv(envkey.ElasticWriteTimeout),
+		ElasticSlowThreshold:      durationFromEnv(envkey.ElasticSlowThreshold),
+		ElasticBypassDuration:     durationFromEnv(envkey.ElasticBypassDuration),
+		ElasticQueueSize:          intFromEnv(envkey.ElasticQueueSize),
+		ElasticQueueBytes:         int64(intFromEnv(envkey.ElasticQueueBytes)),
+		ElasticEntryTTL:           durationFromEnv(envkey.ElasticEntryTTL),
+		ElasticSpoolDir:           os.Getenv(envkey.ElasticSpoolDir),
+		ElasticWorkers:            intFromEnv(envkey.ElasticWorkers),
+		ElasticBatchSize:          intFromEnv(envkey.ElasticBatchSize),
+		FallbackPath:              os.Getenv(envkey.FallbackPath),
+		DeadLetterPath:            os.Getenv(envkey.DeadLetterPath),
+		LogLevel:                  injectedLogLevel(),
+		OutputFilter:              outputFilter,
+		ElasticFilter:             elasticFilter,
+		StdoutOnly:                boolFromEnv(envkey.StdoutOnly),
+		Development:               boolFromEnv(envkey.Development),
+	}
+}
+
+// newClient returns config.ElasticClient, or else creates an ElasticSearch client from the
+// connection settings of config.
+func newClient(config Config) (*elasticsearch.Client, error) {
+	if config.ElasticClient != nil {
+		return config.ElasticClient, nil
+	}
+
+	clientConfig := elasticsearch.Config{
+		Username:      config.ElasticUsername,
+		Password:      config.ElasticPassword,
+		APIKey:        config.ElasticAPIKey,
+		ServiceToken:  config.ElasticServiceToken,
+		CloudID:       config.ElasticCloudID,
+		MaxRetries:    config.ElasticMaxRetries,
+		RetryOnStatus: config.ElasticRetryOnStatus,
+	}
+	for _, address := range append([]string{config.ElasticURL}, config.ElasticURLs...) {
+		if address = strings.TrimSpace(address); address != "" {
+			clientConfig.Addresses = append(clientConfig.Addresses, address)
+		}
+	}
+	if len(clientConfig.Addresses) == 0 && clientConfig.CloudID == "" {
+		return nil, errors.New("ElasticURL is not set")
+	}
+
+	if config.ElasticDiscoverInterval > 0 {
+		clientConfig.DiscoverNodesOnStart = true
+		clientConfig.DiscoverNodesInterval = config.ElasticDiscoverInterval
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		clientConfig.Transport = transport
+	}
+
+	return elasticsearch.NewClient(clientConfig)
+}
+
+// hasSecondary reports whether config has a secondary cluster.
+func (c Config) hasSecondary() bool {
+	for _, address := range c.ElasticSecondaryURLs {
+		if strings.TrimSpace(address) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// secondary returns the configuration of the secondary cluster of config: the same settings,
+// with the secondary URLs in place of the primary cluster.
+func (c Config) secondary() Config {
+	c.ElasticURL, c.ElasticURLs, c.ElasticCloudID, c.ElasticClient = "", c.ElasticSecondaryURLs, "", nil
+	c.ElasticSecondaryURLs = nil
+	return c
+}
+
+// failbackInterval returns how often the primary cluster is checked while the secondary one is used.
+func (c Config) failbackInterval() time.Duration {
+	if c.ElasticFailbackInterval <= 0 {
+		return defaultFailbackInterval
+	}
+	return c.ElasticFailbackInterval
+}
+
+// hookOptions returns the options of the ElasticSearch hook of config.
+func (c Config) hookOptions() hookOptions {
+	opts := hookOptions{
+		timeout:        c.ElasticWriteTimeout,
+		slowThreshold:  c.ElasticSlowThreshold,
+		bypassDuration: c.ElasticBypassDuration,
+		fallbackPath:   c.FallbackPath,
+		deadLetterPath: c.DeadLetterPath,
+		queueSize:      c.ElasticQueueSize,
+		queueBytes:     c.ElasticQueueBytes,
+		ttl:            c.ElasticEntryTTL,
+		spoolDir:       c.ElasticSpoolDir,
+		workers:        c.ElasticWorkers,
+		batchSize:      c.ElasticBatchSize,
+		pipeline:       c.ElasticPipeline,
+		documentID:     c.DocumentIDFunc,
+		levels:         levelsFrom(c.LogLevel),
+		filter:         c.ElasticFilter,
+	}
+	if opts.documentID == nil {
+		opts.documentID = DefaultDocumentID
+	}
+
+	return opts
+}
+
+// indexName returns the function naming the index of the entries of config.
+func (c Config) indexName() func(*logrus.Entry) string {
+	if c.IndexNameFunc != nil {
+		return c.IndexNameFunc
+	}
+	return func(entry *logrus.Entry) string {
+		return indexNameOf(c.ElasticIndex, c.ElasticIndexDateLayout, entry)
+	}
+}
+
+// levelsFrom returns the levels at or above the minimum level named by minimum, or nil for
+// every level if it is empty or invalid.
+func levelsFrom(minimum string) []logrus.Level {
+	level, err := logrus.ParseLevel(minimum)
+	if minimum == "" || err != nil {
+		return nil
+	}
+
+	var levels []logrus.Level
+	for _, l := range logrus.AllLevels {
+		if l <= level {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+// intFromEnv parses the environment variable key as an integer, returning zero if it is
+// unset or invalid.
+func intFromEnv(key string) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// intsFromEnv parses the environment variable key as comma-separated integers, skipping
+// the invalid ones.
+func intsFromEnv(key string) []int {
+	var numbers []int
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	return numbers
+}
+
+// boolFromEnv parses the environment variable key as a boolean, returning false if it is
+// unset or invalid.
+func boolFromEnv(key string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(key))
+	return b
+}
+
+// durationFromEnv parses the environment variable key as a duration, returning zero if
+// it is unset or invalid.
+func durationFromEnv(key string) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.elastic.co/ecslogrus"
+)
+
+// consoleTimestampFormat is the layout of the timestamps written by the console formatter.
+// The date is left out, as local runs rarely span days.
+const consoleTimestampFormat = "15:04:05.000"
+
+// newFormatter returns the formatter of the logger's output: the configured one, or else ECS
+// JSON, or a colorized, human-readable format in development mode.
+func (c Config) newFormatter() logrus.Formatter {
+	if c.Formatter != nil {
+		return c.Formatter
+	}
+	if c.Development {
+		return &logrus.TextFormatter{
+			ForceColors:     true,
+			FullTimestamp:   true,
+			TimestampFormat: consoleTimestampFormat,
+		}
+	}
+	return &ecslogrus.Formatter{}
+}
+
+// elasticFormatter returns the formatter of the documents shipped to ElasticSearch: the
+// configured one, or else ECS JSON.
+func (c Config) elasticFormatter() logrus.Formatter {
+	if c.Formatter != nil {
+		return c.Formatter
+	}
+	return &ecslogrus.Formatter{}
+}
+
+// local reports whether the entries are only written to stdout, without ElasticSearch.
+func (c Config) local() bool {
+	return c.StdoutOnly || c.Development
+}
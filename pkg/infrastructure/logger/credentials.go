@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"os"
+	"sync"
+)
+
+// Credentials are the secrets the default pipeline authenticates with ElasticSearch with,
+// see SetCredentials.
+type Credentials struct {
+	APIKey         string
+	ServiceToken   string
+	CACertPath     string
+	CACertPEM      string
+	ClientCertPath string
+	ClientKeyPath  string
+}
+
+var (
+	credentials      Credentials  // Credentials set by SetCredentials
+	credentialsMutex sync.RWMutex // Protects access to credentials
+)
+
+// SetCredentials makes the default pipeline authenticate with the API key, service token, and
+// TLS material of c, so they don't have to be written to the environment, where child processes
+// inherit them. Empty fields fall back to the environment.
+func SetCredentials(c Credentials) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	credentials = c
+}
+
+// injectedCredentials returns the credentials set with SetCredentials.
+func injectedCredentials() Credentials {
+	credentialsMutex.RLock()
+	defer credentialsMutex.RUnlock()
+
+	return credentials
+}
+
+// orEnv returns value, or else the environment variable key.
+func orEnv(value, key string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(key)
+}
@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// repeatCountField is the field of the entry summarizing the repeats of a deduplicated entry.
+const repeatCountField = "repeatCount"
+
+var dedupeWindow atomic.Int64 // Window of the deduplication in nanoseconds, zero if disabled
+
+// SetDedupeWindow aggregates identical application entries shipped to ElasticSearch, with the
+// same level, message, and fields: the first one is shipped, and its repeats within window are
+// shipped as a single entry at the end of the window, with their number in the repeatCount
+// field. A non-positive window disables the deduplication.
+func SetDedupeWindow(window time.Duration) {
+	dedupeWindow.Store(int64(max(window, 0)))
+}
+
+// repeat is an entry being deduplicated, with the number of its repeats so far.
+type repeat struct {
+	entry *logrus.Entry
+	count int
+}
+
+// deduper tracks the entries being deduplicated by an elasticHook.
+type deduper struct {
+	mu      sync.Mutex
+	repeats map[uint64]*repeat // Entries of the current windows by dedupeKey
+}
+
+// admit reports whether the entry is shipped. Repeats of an entry shipped within the window
+// are counted instead, and summarized by fire at the end of the window.
+func (d *deduper) admit(entry *logrus.Entry, fire func(*logrus.Entry) error) bool {
+	window := time.Duration(dedupeWindow.Load())
+	if window == 0 || categoryOf(entry) != CategoryApp {
+		return true
+	}
+	if _, ok := entry.Data[repeatCountField]; ok {
+		return true
+	}
+
+	key := dedupeKey(entry)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if r, ok := d.repeats[key]; ok {
+		r.count++
+		return false
+	}
+	if d.repeats == nil {
+		d.repeats = map[uint64]*repeat{}
+	}
+	r := &repeat{entry: entry}
+	d.repeats[key] = r
+
+	time.AfterFunc(window, func() {
+		d.mu.Lock()
+		delete(d.repeats, key)
+		count := r.count
+		d.mu.Unlock()
+
+		if count > 0 {
+			summary := r.entry.WithField(repeatCountField, count)
+			summary.Time, summary.Level, summary.Message = Now(), r.entry.Level, r.entry.Message
+			_ = fire(summary)
+		}
+	})
+	return true
+}
+
+// dedupeKey hashes the level, message, and fields of an entry.
+func dedupeKey(entry *logrus.Entry) uint64 {
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d\x00%s", entry.Level, entry.Message)
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(h, "\x00%s=%v", key, entry.Data[key])
+	}
+	return h.Sum64()
+}
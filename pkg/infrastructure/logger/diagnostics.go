@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Diagnostics is a snapshot of welog's own health, revealing entries that are lost or
+// diverted instead of reaching ElasticSearch. Counts are totals since the process started
+// and cover every pipeline.
+type Diagnostics struct {
+	Dropped         int64 // Entries dropped because their category, the queue, or the memory budget was full
+	FallbackWrites  int64 // Entries written to the fallback file
+	DeadLetters     int64 // Entries written to the dead-letter file
+	FileErrors      int64 // Entries lost because the fallback or dead-letter file couldn't be written
+	WriteFailures   int64 // Requests to ElasticSearch that failed
+	Reconnects      int64 // Attempts to reconnect to ElasticSearch
+	ReconnectErrors int64 // Attempts to reconnect to ElasticSearch that failed
+	Failovers       int64 // Switches from the primary ElasticSearch cluster to the secondary one
+	QueueDepth      int64 // Entries currently queued for ElasticSearch
+}
+
+var (
+	dropped         atomic.Int64 // Counts Diagnostics.Dropped
+	fallbackWrites  atomic.Int64 // Counts Diagnostics.FallbackWrites
+	deadLetters     atomic.Int64 // Counts Diagnostics.DeadLetters
+	fileErrors      atomic.Int64 // Counts Diagnostics.FileErrors
+	writeFailures   atomic.Int64 // Counts Diagnostics.WriteFailures
+	reconnects      atomic.Int64 // Counts Diagnostics.Reconnects
+	reconnectErrors atomic.Int64 // Counts Diagnostics.ReconnectErrors
+	failovers       atomic.Int64 // Counts Diagnostics.Failovers
+	queueDepth      atomic.Int64 // Counts Diagnostics.QueueDepth
+
+	reporterStop  chan struct{} // Closed to stop the reporter started by SetDiagnostics, nil if none
+	reporterMutex sync.Mutex    // Protects access to reporterStop
+)
+
+// ReadDiagnostics returns the current diagnostics.
+func ReadDiagnostics() Diagnostics {
+	return Diagnostics{
+		Dropped:         dropped.Load(),
+		FallbackWrites:  fallbackWrites.Load(),
+		DeadLetters:     deadLetters.Load(),
+		FileErrors:      fileErrors.Load(),
+		WriteFailures:   writeFailures.Load(),
+		Reconnects:      reconnects.Load(),
+		ReconnectErrors: reconnectErrors.Load(),
+		Failovers:       failovers.Load(),
+		QueueDepth:      queueDepth.Load(),
+	}
+}
+
+// SetDiagnostics reports the diagnostics to fn every interval, or to stderr if fn is nil,
+// replacing the reporter of a previous call. A non-positive interval stops reporting.
+func SetDiagnostics(interval time.Duration, fn func(Diagnostics)) {
+	reporterMutex.Lock()
+	defer reporterMutex.Unlock()
+
+	if reporterStop != nil {
+		close(reporterStop)
+		reporterStop = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	if fn == nil {
+		fn = printDiagnostics
+	}
+
+	reporterStop = make(chan struct{})
+	go report(interval, fn, reporterStop)
+}
+
+// report calls fn with the diagnostics every interval until stop is closed.
+func report(interval time.Duration, fn func(Diagnostics), stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			fn(ReadDiagnostics())
+		}
+	}
+}
+
+// printDiagnostics writes the diagnostics to stderr on one line.
+func printDiagnostics(d Diagnostics) {
+	_, _ = fmt.Fprintf(
+		os.Stderr,
+		"Welog diagnostics: dropped=%d fallback=%d deadLetters=%d fileErrors=%d writeFailures=%d "+
+			"reconnects=%d reconnectErrors=%d failovers=%d queueDepth=%d\n",
+		d.Dropped, d.FallbackWrites, d.DeadLetters, d.FileErrors, d.WriteFailures,
+		d.Reconnects, d.ReconnectErrors, d.Failovers, d.QueueDepth,
+	)
+}
@@ -0,0 +1,206 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"path"
+)
+
+// CheckStatus is the outcome of a Check.
+type CheckStatus string
+
+const (
+	CheckOK      CheckStatus = "ok"      // The check passed
+	CheckWarning CheckStatus = "warning" // Logging works, but not as well as it could
+	CheckFailed  CheckStatus = "failed"  // Entries won't reach their destination
+	CheckSkipped CheckStatus = "skipped" // The check doesn't apply or depends on a failed one
+)
+
+// Check is the result of one of the checks of Doctor.
+type Check struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// Report is the result of Doctor, one Check per aspect of the pipeline.
+type Report struct {
+	Checks []Check `json:"checks"`
+}
+
+// OK reports whether no check failed.
+func (r Report) OK() bool {
+	for _, check := range r.Checks {
+		if check.Status == CheckFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// Doctor checks the default pipeline, see Pipeline.Doctor.
+func Doctor(ctx context.Context) Report {
+	return defaultPipeline.Doctor(ctx)
+}
+
+// Doctor checks why the entries of the pipeline may not show up in ElasticSearch: whether the
+// cluster is reachable, accepts the credentials, has an index template matching the index of
+// new entries, and lets them be written there, and whether the fallback file is writable.
+// Under StdoutOnly or Development, the checks of ElasticSearch are skipped.
+func (p *Pipeline) Doctor(ctx context.Context) Report {
+	config := p.config()
+	opts := config.hookOptions().withDefaults()
+
+	var report Report
+	if config.local() {
+		for _, name := range []string{"connectivity", "authentication", "index template", "write permission"} {
+			report.Checks = append(report.Checks, Check{Name: name, Status: CheckSkipped, Detail: "ElasticSearch is disabled"})
+		}
+	} else {
+		report.Checks = append(report.Checks, p.checkElastic(ctx, config)...)
+	}
+	report.Checks = append(report.Checks, checkFallback(opts.fallbackPath))
+
+	return report
+}
+
+// checkElastic checks the connectivity, the credentials, the index template, and the write
+// permission of the cluster of the pipeline.
+func (p *Pipeline) checkElastic(ctx context.Context, config Config) []Check {
+	connectivity := Check{Name: "connectivity", Status: CheckOK}
+	authentication := Check{Name: "authentication", Status: CheckOK}
+	skipped := []Check{
+		{Name: "index template", Status: CheckSkipped, Detail: "ElasticSearch is unavailable"},
+		{Name: "write permission", Status: CheckSkipped, Detail: "ElasticSearch is unavailable"},
+	}
+
+	c, err := p.currentClient()
+	if err != nil {
+		connectivity.Status, connectivity.Detail = CheckFailed, err.Error()
+		authentication.Status, authentication.Detail = CheckSkipped, "ElasticSearch is unavailable"
+		return append([]Check{connectivity, authentication}, skipped...)
+	}
+
+	res, err := c.Ping(c.Ping.WithContext(ctx))
+	if err != nil {
+		connectivity.Status, connectivity.Detail = CheckFailed, fmt.Sprintf("elasticsearch is unreachable: %v", err)
+		authentication.Status, authentication.Detail = CheckSkipped, "ElasticSearch is unavailable"
+		return append([]Check{connectivity, authentication}, skipped...)
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		authentication.Status, authentication.Detail = CheckFailed, fmt.Sprintf("elasticsearch responded with %s", res.Status())
+		return append([]Check{connectivity, authentication}, skipped...)
+	case res.IsError():
+		connectivity.Status, connectivity.Detail = CheckFailed, fmt.Sprintf("elasticsearch responded with %s", res.Status())
+		authentication.Status, authentication.Detail = CheckSkipped, "ElasticSearch is unavailable"
+		return append([]Check{connectivity, authentication}, skipped...)
+	}
+
+	entry := logrus.NewEntry(logrus.New())
+	entry.Time = Now()
+	index := config.indexName()(entry)
+
+	return []Check{connectivity, authentication, checkTemplate(ctx, c, index), checkWrite(ctx, c, index)}
+}
+
+// checkTemplate checks that an index template matches index, so its fields get explicit
+// mappings rather than dynamic ones.
+func checkTemplate(ctx context.Context, c *elasticsearch.Client, index string) Check {
+	check := Check{Name: "index template"}
+
+	res, err := esapi.IndicesGetIndexTemplateRequest{}.Do(ctx, c)
+	if err != nil {
+		check.Status, check.Detail = CheckWarning, fmt.Sprintf("index templates can't be listed: %v", err)
+		return check
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var templates struct {
+		IndexTemplates []struct {
+			Name          string `json:"name"`
+			IndexTemplate struct {
+				IndexPatterns []string `json:"index_patterns"`
+			} `json:"index_template"`
+		} `json:"index_templates"`
+	}
+	if res.IsError() || json.NewDecoder(res.Body).Decode(&templates) != nil {
+		check.Status, check.Detail = CheckWarning, fmt.Sprintf("index templates can't be listed: %s", res.Status())
+		return check
+	}
+
+	for _, template := range templates.IndexTemplates {
+		for _, pattern := range template.IndexTemplate.IndexPatterns {
+			if matched, _ := path.Match(pattern, index); matched {
+				check.Status, check.Detail = CheckOK, fmt.Sprintf("template %q matches %q", template.Name, index)
+				return check
+			}
+		}
+	}
+
+	check.Status, check.Detail = CheckWarning, fmt.Sprintf("no index template matches %q, fields are mapped dynamically", index)
+	return check
+}
+
+// checkWrite checks that the credentials may create documents in index.
+func checkWrite(ctx context.Context, c *elasticsearch.Client, index string) Check {
+	check := Check{Name: "write permission"}
+
+	body, _ := json.Marshal(map[string]any{
+		"index": []map[string]any{{"names": []string{index}, "privileges": []string{"create_doc"}}},
+	})
+	res, err := esapi.SecurityHasPrivilegesRequest{Body: bytes.NewReader(body)}.Do(ctx, c)
+	if err != nil {
+		check.Status, check.Detail = CheckWarning, fmt.Sprintf("privileges can't be checked: %v", err)
+		return check
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	var privileges struct {
+		HasAllRequested bool `json:"has_all_requested"`
+	}
+	if res.IsError() || json.NewDecoder(res.Body).Decode(&privileges) != nil {
+		check.Status, check.Detail = CheckWarning, fmt.Sprintf("privileges can't be checked, security may be disabled: %s", res.Status())
+		return check
+	}
+
+	if !privileges.HasAllRequested {
+		check.Status, check.Detail = CheckFailed, fmt.Sprintf("the credentials may not create documents in %q", index)
+		return check
+	}
+	check.Status = CheckOK
+	return check
+}
+
+// checkFallback checks that the fallback file at path can be appended to, without leaving a
+// file behind if it doesn't exist yet.
+func checkFallback(path string) Check {
+	check := Check{Name: "fallback file", Status: CheckOK, Detail: path}
+
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		check.Status, check.Detail = CheckFailed, err.Error()
+		return check
+	}
+	_ = f.Close()
+
+	if errors.Is(statErr, os.ErrNotExist) {
+		_ = os.Remove(path)
+	}
+	return check
+}
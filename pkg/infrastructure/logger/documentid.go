@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	documentID      func(*logrus.Entry) string // Document ID function set by SetDocumentIDFunc
+	documentIDMutex sync.RWMutex               // Protects access to documentID
+)
+
+// SetDocumentIDFunc makes fn compute the ElasticSearch document ID of every entry, so a
+// retried or replayed entry overwrites its previous copy instead of being duplicated. An
+// empty ID lets ElasticSearch generate one. Nil restores DefaultDocumentID.
+func SetDocumentIDFunc(fn func(*logrus.Entry) string) {
+	documentIDMutex.Lock()
+	defer documentIDMutex.Unlock()
+
+	documentID = fn
+}
+
+// documentIDFunc returns the document ID of the entry, computed by the function set with
+// SetDocumentIDFunc or else by DefaultDocumentID.
+func documentIDFunc(entry *logrus.Entry) string {
+	documentIDMutex.RLock()
+	fn := documentID
+	documentIDMutex.RUnlock()
+
+	if fn != nil {
+		return fn(entry)
+	}
+	return DefaultDocumentID(entry)
+}
+
+// DefaultDocumentID returns a hash of the timestamp, level, message, and fields of the entry,
+// including its request ID, which identifies the entry across retries and replays. Only
+// identical entries share an ID, so distinct entries logged at the same time with the same
+// message, e.g. under a fixed Clock, don't overwrite each other.
+func DefaultDocumentID(entry *logrus.Entry) string {
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	hash := sha256.New()
+	_, _ = fmt.Fprint(hash, entry.Time.Format(time.RFC3339Nano), "\x00", entry.Level, "\x00", entry.Message)
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(hash, "\x00%s=%v", key, entry.Data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
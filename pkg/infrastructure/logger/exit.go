@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ExitTimeout bounds the time spent draining a pipeline when the process exits, see Drain.
+const ExitTimeout = 5 * time.Second
+
+// flushInterval is how often Flush checks whether the queue is empty.
+const flushInterval = 10 * time.Millisecond
+
+var (
+	running      = map[*Pipeline]struct{}{} // Pipelines started and not closed yet, drained on logrus exits
+	runningMutex sync.Mutex                 // Protects access to running
+	exitOnce     sync.Once                  // Ensures drainOnExit is registered only once
+)
+
+// Drain stops the default pipeline like Close, but the entries that couldn't be shipped to
+// ElasticSearch before ctx is done are written to the fallback file instead of being discarded.
+// It is meant for a process about to exit, and runs on its own with ExitTimeout for every
+// running pipeline when logrus.Fatal or logrus.Exit terminates the process, as the package
+// registers a handler with logrus.RegisterExitHandler. Deferred calls don't run on those exits,
+// so Close wouldn't.
+func Drain(ctx context.Context) error {
+	return defaultPipeline.Drain(ctx)
+}
+
+// Drain stops the pipeline the same way as the package-level Drain.
+func (p *Pipeline) Drain(ctx context.Context) error {
+	return p.shutdown(ctx, (*elasticHook).drain)
+}
+
+// Flush waits until the entries buffered by the default pipeline are shipped to ElasticSearch,
+// without stopping it, see Pipeline.Flush.
+func Flush(ctx context.Context) error {
+	return defaultPipeline.Flush(ctx)
+}
+
+// Flush waits until the entries buffered for ElasticSearch are shipped, or written to the
+// fallback file if they can't be. Unlike Drain, the pipeline keeps running, e.g. when a panic
+// may still be recovered. If ctx is done first, the entries still queued are written to the
+// fallback file, and the context's error is returned.
+func (p *Pipeline) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	h := p.hook
+	p.mu.Unlock()
+
+	if h == nil {
+		return nil
+	}
+	return h.flush(ctx)
+}
+
+// track adds the pipeline to the pipelines drained on logrus exits, registering the handler
+// draining them the first time.
+func (p *Pipeline) track() {
+	exitOnce.Do(func() {
+		logrus.RegisterExitHandler(drainOnExit)
+	})
+
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	running[p] = struct{}{}
+}
+
+// untrack removes the pipeline from the pipelines drained on logrus exits, once it is closed.
+func (p *Pipeline) untrack() {
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	delete(running, p)
+}
+
+// drainOnExit drains every running pipeline within ExitTimeout. It is the logrus exit handler of
+// the package.
+func drainOnExit() {
+	runningMutex.Lock()
+	pipelines := make([]*Pipeline, 0, len(running))
+	for p := range running {
+		pipelines = append(pipelines, p)
+	}
+	runningMutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ExitTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, p := range pipelines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.Drain(ctx)
+		}()
+	}
+	wg.Wait()
+}
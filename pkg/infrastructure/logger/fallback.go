@@ -0,0 +1,194 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/goccy/go-json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultFallbackPath is the file entries are written to when ElasticSearch can't take them.
+const defaultFallbackPath = "logs.txt"
+
+// defaultDeadLetterPath is the file entries are written to when ElasticSearch rejects them permanently.
+const defaultDeadLetterPath = "deadletter.txt"
+
+// fallbackIndexKey and fallbackIDKey are the fields of the fallback file holding the index and
+// the document ID of an entry. ElasticSearch reserves the names, so they can't clash with the
+// fields of the entry, and ReplayFallback removes them before shipping it.
+const (
+	fallbackIndexKey = "_index"
+	fallbackIDKey    = "_id"
+)
+
+// ecsVersion is the ECS version of the documents wrapping the entries that aren't JSON objects
+// in the fallback file, the one of ecslogrus.
+const ecsVersion = "1.6.0"
+
+// fallbackMutex serializes appends to the fallback and dead-letter files across hooks.
+var fallbackMutex sync.Mutex
+
+// appendFallback appends formatted entries to the fallback file at path, so entries that
+// can't be shipped to ElasticSearch are kept locally instead of being lost.
+func appendFallback(path string, data []byte) error {
+	fallbackMutex.Lock()
+	defer fallbackMutex.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// fallbackRecord returns a document as a record of the fallback file: a JSON object on a single
+// line. Entries formatted as indented JSON are compacted, and those that aren't JSON objects
+// become the message of an ECS document, so the file is always NDJSON whatever the formatter.
+// The index and the ID of the document are recorded under the fallbackIndexKey and
+// fallbackIDKey fields, so ReplayFallback ships it where it would have gone, under the same ID.
+func fallbackRecord(doc document) []byte {
+	data := bytes.TrimSpace(doc.data)
+
+	var record bytes.Buffer
+	if len(data) == 0 || data[0] != '{' || json.Compact(&record, data) != nil {
+		record.Reset()
+		wrapped, _ := json.Marshal(map[string]string{
+			"@timestamp":  Now().UTC().Format(time.RFC3339Nano),
+			"ecs.version": ecsVersion,
+			"message":     string(data),
+		})
+		record.Write(wrapped)
+	}
+
+	var meta []byte
+	if doc.index != "" {
+		index, _ := json.Marshal(doc.index)
+		meta = append(append(append(meta, `"`+fallbackIndexKey+`":`...), index...), ',')
+	}
+	if doc.id != "" {
+		id, _ := json.Marshal(doc.id)
+		meta = append(append(append(meta, `"`+fallbackIDKey+`":`...), id...), ',')
+	}
+	if len(meta) > 0 {
+		fields := record.Bytes()[1:]
+		if bytes.Equal(bytes.TrimSpace(fields), []byte("}")) {
+			meta = meta[:len(meta)-1]
+		}
+		return append(append(append([]byte{'{'}, meta...), fields...), '\n')
+	}
+	return append(record.Bytes(), '\n')
+}
+
+// FallbackEntry is an entry of the fallback file, as read by ReadFallback.
+type FallbackEntry struct {
+	Line     int             // Line of the entry in the file, starting at 1
+	Data     json.RawMessage // The entry as written, a JSON object
+	Document map[string]any  // The fields of the entry, decoded from Data, along with its "_index" and "_id"
+}
+
+// ReadFallback calls fn with every entry of the fallback file of the default pipeline, in the
+// order they were written, e.g. to replay them. Lines that aren't JSON objects, written by
+// earlier versions or truncated by a crash, are skipped. It stops at the first error returned
+// by fn, or when ctx is done, and returns that error. A missing file has no entries.
+func ReadFallback(ctx context.Context, fn func(FallbackEntry) error) error {
+	return defaultPipeline.ReadFallback(ctx, fn)
+}
+
+// ReadFallback reads the fallback file of the pipeline the same way as the package-level
+// ReadFallback.
+func (p *Pipeline) ReadFallback(ctx context.Context, fn func(FallbackEntry) error) error {
+	return readFallback(ctx, p.config().hookOptions().withDefaults().fallbackPath, fn)
+}
+
+// readFallback calls fn with every entry of the fallback file at path, see ReadFallback.
+func readFallback(ctx context.Context, path string, fn func(FallbackEntry) error) error {
+	return scanFallback(ctx, path, func(line int, data []byte, _ int64) error {
+		entry := FallbackEntry{Line: line, Data: data}
+		if json.Unmarshal(entry.Data, &entry.Document) != nil || entry.Document == nil {
+			return nil
+		}
+		return fn(entry)
+	})
+}
+
+// scanFallback calls fn with the number and the content of every non-empty line of the
+// fallback file at path, without its line break, and the offset of the end of the line. It
+// stops at the first error returned by fn, or when ctx is done, and returns that error. A
+// missing file has no lines.
+func scanFallback(ctx context.Context, path string, fn func(line int, data []byte, offset int64) error) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for line := 1; ; line++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return readErr
+		}
+		offset += int64(len(data))
+
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			if err = fn(line, data, offset); err != nil {
+				return err
+			}
+		}
+
+		if readErr != nil {
+			return nil
+		}
+	}
+}
+
+// deadLetter is a record of the dead-letter file: an entry rejected by ElasticSearch along with
+// the status and error of the rejection.
+type deadLetter struct {
+	Status   int             `json:"status"`
+	Error    json.RawMessage `json:"error"`
+	Document json.RawMessage `json:"document"`
+}
+
+// permanent reports whether ElasticSearch rejecting a document with status means that writing
+// it again would fail the same way, e.g. because of a mapping conflict or its size.
+func permanent(status int) bool {
+	return status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge
+}
+
+// appendDeadLetter appends a document rejected by ElasticSearch to the dead-letter file at path,
+// with the status and error of the rejection. An error that isn't JSON is recorded as a string.
+func appendDeadLetter(path string, status int, reason []byte, data []byte) error {
+	if !json.Valid(reason) {
+		reason, _ = json.Marshal(string(reason))
+	}
+
+	record, err := json.Marshal(deadLetter{Status: status, Error: reason, Document: bytes.TrimRight(data, "\n")})
+	if err != nil {
+		return err
+	}
+
+	return appendFallback(path, append(record, '\n'))
+}
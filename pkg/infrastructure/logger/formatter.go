@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+var (
+	formatter      logrus.Formatter // Formatter set by SetFormatter
+	formatterMutex sync.RWMutex     // Protects access to formatter
+)
+
+// SetFormatter makes f format the entries of the logger, both in its output and in
+// ElasticSearch, instead of ECS JSON, for teams with their own field schema. If the logger
+// is already initialized, it switches to f at once. Nil restores the ECS formatter.
+func SetFormatter(f logrus.Formatter) {
+	formatterMutex.Lock()
+	changed := f != formatter
+	formatter = f
+	formatterMutex.Unlock()
+
+	if changed {
+		defaultPipeline.reformat()
+	}
+}
+
+// injectedFormatter returns the formatter set with SetFormatter, nil if none is set.
+func injectedFormatter() logrus.Formatter {
+	formatterMutex.RLock()
+	defer formatterMutex.RUnlock()
+
+	return formatter
+}
+
+// reformat applies the formatter of the pipeline's configuration to its logger and, when
+// connected to ElasticSearch, to a new hook.
+func (p *Pipeline) reformat() {
+	log := p.log.Load()
+	if log == nil {
+		return
+	}
+
+	config := p.config()
+	log.SetFormatter(processedFormatter{config.newFormatter(), config.OutputFilter})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.hook != nil && !p.closed {
+		p.installHook(p.client, config)
+	}
+}
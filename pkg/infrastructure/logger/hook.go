@@ -0,0 +1,829 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWriteTimeout   = 10 * time.Second // Deadline of a single write when none is configured
+	defaultSlowThreshold  = 2 * time.Second  // p95 write latency above which the cluster is bypassed
+	defaultBypassDuration = time.Minute      // Time the cluster is bypassed once it is found slow
+	defaultQueueSize      = 1000             // Number of documents buffered for the workers
+	defaultWorkers        = 1                // Number of workers writing to the cluster concurrently
+	defaultBatchSize      = 1                // Maximum number of documents written by one request
+	defaultAuditWait      = 5 * time.Second  // Time an audit entry waits for room in the queue and memory budget
+
+	failuresBeforeReconnect = 3 // Consecutive failed writes after which the connection is considered lost
+)
+
+// hookOptions configures an elasticHook. Zero values select the defaults.
+type hookOptions struct {
+	timeout        time.Duration              // Deadline of a single write
+	slowThreshold  time.Duration              // p95 write latency above which the cluster is bypassed, negative disables
+	bypassDuration time.Duration              // Time the cluster is bypassed once it is found slow
+	fallbackPath   string                     // File receiving entries that can't be written to ElasticSearch
+	deadLetterPath string                     // File receiving entries permanently rejected by ElasticSearch
+	queueSize      int                        // Number of documents buffered for the workers, per priority
+	workers        int                        // Number of workers writing to the cluster concurrently
+	batchSize      int                        // Maximum number of documents written by one request, above 1 uses the bulk API
+	queueBytes     int64                      // Maximum bytes of the queued documents, zero for no limit
+	ttl            time.Duration              // Age after which a queued document goes to the fallback file, zero for no limit
+	spoolDir       string                     // Directory of the disk-backed spool of the queue, empty for none
+	spool          *spool                     // Spool taken over from a draining hook instead of opening spoolDir, see passSpool
+	pipeline       string                     // Ingest pipeline processing the documents, empty for none
+	documentID     func(*logrus.Entry) string // Computes the document IDs, nil to let ElasticSearch generate them
+	levels         []logrus.Level             // Levels of the entries shipped, nil for every level
+	filter         SinkFilter                 // Selects the entries and fields shipped
+	onFailures     func()                     // Called after failuresBeforeReconnect consecutive failed writes, may be nil
+	auditWait      time.Duration              // Time an audit entry waits for room before going to the fallback file
+}
+
+// withDefaults returns a copy of the options with the zero values replaced by the defaults.
+func (o hookOptions) withDefaults() hookOptions {
+	if o.timeout <= 0 {
+		o.timeout = defaultWriteTimeout
+	}
+	if o.slowThreshold == 0 {
+		o.slowThreshold = defaultSlowThreshold
+	}
+	if o.bypassDuration <= 0 {
+		o.bypassDuration = defaultBypassDuration
+	}
+	if o.fallbackPath == "" {
+		o.fallbackPath = defaultFallbackPath
+	}
+	if o.deadLetterPath == "" {
+		o.deadLetterPath = defaultDeadLetterPath
+	}
+	if o.queueSize <= 0 {
+		o.queueSize = defaultQueueSize
+	}
+	if o.workers <= 0 {
+		o.workers = defaultWorkers
+	}
+	if o.batchSize <= 0 {
+		o.batchSize = defaultBatchSize
+	}
+	if o.auditWait <= 0 {
+		o.auditWait = defaultAuditWait
+	}
+	return o
+}
+
+// document is a formatted entry waiting to be indexed.
+type document struct {
+	index  string    // Name of the target index, resolved when the entry is fired
+	id     string    // Document ID, empty to let ElasticSearch generate one
+	data   []byte    // Entry formatted as JSON
+	queued time.Time // Time the entry was queued
+
+	segment *segment // Spool segment recording the document, nil without a spool
+}
+
+// elasticHook is a logrus hook that ships entries to ElasticSearch. Entries are formatted
+// synchronously in Fire and indexed by a pool of background workers, which write up to a
+// batch of queued entries at once, so a slow cluster never blocks the caller. Warning and
+// higher entries have their own queue, drained first. Every write runs with its own deadline
+// derived from the hook's context, which is cancelled when a shutdown runs out of time, so a
+// hung connection can't block the worker indefinitely.
+//
+// The hook tracks the latency of recent writes. When the 95th percentile exceeds the slow
+// threshold, the cluster is bypassed for a while and entries go to the fallback file, so a
+// slow cluster can't back up the queue. Failed writes go to the fallback file as well, except
+// for documents the cluster rejects permanently, which go to the dead-letter file.
+type elasticHook struct {
+	client    *elasticsearch.Client
+	formatter logrus.Formatter
+	index     func(*logrus.Entry) string
+	opts      hookOptions
+
+	latency     latencyTracker // Latencies of recent writes
+	bypassUntil atomic.Int64   // Unix nanoseconds until which the cluster is bypassed, zero if not
+	failures    atomic.Int32   // Consecutive failed writes
+	queuedBytes atomic.Int64   // Bytes of the queued documents
+	spool       *spool         // Disk-backed log of the queue, nil if disabled
+	spoolPassed atomic.Bool    // Set when the spool is passed to the hook replacing this one, see passSpool
+	dedupe      deduper        // Entries being deduplicated, see SetDedupeWindow
+
+	urgent  chan document      // Queued Warning and higher entries, drained before queue
+	queue   chan document      // Queued entries of lower levels and replayed documents
+	ctx     context.Context    // Parent of every write context, cancelled to abort in-flight writes
+	cancel  context.CancelFunc // Cancels ctx
+	closing chan struct{}      // Closed when a shutdown starts
+	keep    atomic.Bool        // Set when the shutdown keeps the entries in the fallback file, see drain
+	done    chan struct{}      // Closed when every worker has exited
+	workers sync.WaitGroup     // Running workers
+	once    sync.Once          // Ensures closing is closed only once
+}
+
+// newElasticHook creates an elasticHook and starts its worker.
+func newElasticHook(
+	client *elasticsearch.Client,
+	formatter logrus.Formatter,
+	index func(*logrus.Entry) string,
+	opts hookOptions,
+) *elasticHook {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	opts = opts.withDefaults()
+
+	hook := &elasticHook{
+		client:    client,
+		formatter: formatter,
+		index:     index,
+		opts:      opts,
+		urgent:    make(chan document, opts.queueSize),
+		queue:     make(chan document, opts.queueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if opts.spool != nil {
+		hook.spool = opts.spool
+	} else if opts.spoolDir != "" {
+		var replay []document
+		var err error
+		hook.spool, replay, err = openSpool(opts.spoolDir)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to open the elasticsearch spool, entries won't survive restarts: %v\n", err)
+		} else {
+			hook.workers.Add(1)
+			go hook.replay(replay)
+		}
+	}
+
+	hook.workers.Add(opts.workers)
+	for range opts.workers {
+		go hook.run()
+	}
+	go func() {
+		hook.workers.Wait()
+		close(hook.done)
+	}()
+
+	return hook
+}
+
+// Levels returns the levels of the entries shipped to ElasticSearch, all of them unless a
+// minimum level is configured.
+func (h *elasticHook) Levels() []logrus.Level {
+	if h.opts.levels != nil {
+		return h.opts.levels
+	}
+	return logrus.AllLevels
+}
+
+// Fire formats the entry and enqueues it for the worker. The entry is dropped silently if
+// its category is over budget, and with an error if the queue is full, the memory budget
+// is exhausted, or the hook is closed. Audit entries are never dropped, see enqueueAudit.
+// Repeated entries are aggregated, see SetDedupeWindow, and those dropped by a Processor
+// are left out.
+func (h *elasticHook) Fire(entry *logrus.Entry) error {
+	if isDropped(entry) || !h.opts.filter.admits(entry.Level) {
+		return nil
+	}
+	if !h.dedupe.admit(entry, h.Fire) {
+		return nil
+	}
+
+	audit := categoryOf(entry) == CategoryAudit
+	if !audit && !withinBudget(entry) {
+		dropped.Add(1)
+		return nil
+	}
+
+	data, err := h.formatter.Format(h.opts.filter.apply(entry))
+	if err != nil {
+		return err
+	}
+
+	if audit {
+		h.enqueueAudit(entry, data)
+		return nil
+	}
+
+	select {
+	case <-h.closing:
+		if h.keep.Load() {
+			h.fallback(h.document(entry, data))
+			return nil
+		}
+		dropped.Add(1)
+		return fmt.Errorf("elasticsearch hook is closed, dropping entry")
+	default:
+	}
+
+	size := int64(len(data))
+	if queued := h.queuedBytes.Add(size); h.opts.queueBytes > 0 && queued > h.opts.queueBytes {
+		h.queuedBytes.Add(-size)
+		dropped.Add(1)
+		return fmt.Errorf("elasticsearch queue is full (%d bytes), dropping entry", h.opts.queueBytes)
+	}
+
+	if !ReserveMemory(size) {
+		h.queuedBytes.Add(-size)
+		dropped.Add(1)
+		return fmt.Errorf("memory budget is exhausted, dropping entry")
+	}
+	queueDepth.Add(1)
+
+	doc := h.document(entry, data)
+	if h.spool != nil {
+		if err = h.spool.append(&doc); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to write entry to the elasticsearch spool: %v\n", err)
+		}
+	}
+
+	queue := h.queue
+	if entry.Level <= logrus.WarnLevel {
+		queue = h.urgent
+	}
+
+	select {
+	case queue <- doc:
+		return nil
+	default:
+		h.dequeued(doc)
+		if doc.segment != nil {
+			return fmt.Errorf("elasticsearch queue is full, entry is kept in the spool until restart")
+		}
+		dropped.Add(1)
+		return fmt.Errorf("elasticsearch queue is full, dropping entry")
+	}
+}
+
+// document returns the document of an entry formatted as data, queued now.
+func (h *elasticHook) document(entry *logrus.Entry, data []byte) document {
+	doc := document{index: h.index(entry), data: data, queued: Now()}
+	if h.opts.documentID != nil {
+		doc.id = h.opts.documentID(entry)
+	}
+	return doc
+}
+
+// enqueueAudit queues the document of an audit entry ahead of the other entries, ignoring the
+// queue budget, and blocks the caller until there is room in the memory budget and the queue.
+// If there is none within the audit wait, the document goes to the fallback file instead. If
+// the hook is closed before, it is kept in the spool or goes to the fallback file.
+func (h *elasticHook) enqueueAudit(entry *logrus.Entry, data []byte) {
+	doc := h.document(entry, data)
+	size := int64(len(data))
+
+	timeout := time.NewTimer(h.opts.auditWait)
+	defer timeout.Stop()
+
+	for !ReserveMemory(size) {
+		select {
+		case <-h.closing:
+			h.fallback(doc)
+			return
+		case <-timeout.C:
+			h.fallback(doc)
+			return
+		case <-time.After(flushInterval):
+		}
+	}
+	h.queuedBytes.Add(size)
+	queueDepth.Add(1)
+
+	if h.spool != nil {
+		if err := h.spool.append(&doc); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to write entry to the elasticsearch spool: %v\n", err)
+		}
+	}
+
+	select {
+	case <-h.closing:
+	default:
+		select {
+		case h.urgent <- doc:
+			return
+		case <-h.closing:
+		case <-timeout.C:
+			// The document is written now, not replayed from the spool on restart.
+			h.dequeued(doc)
+			h.fallback(doc)
+			if h.spool != nil {
+				h.spool.ack(doc)
+			}
+			return
+		}
+	}
+
+	// Documents in the spool are replayed on restart, the others would be lost.
+	h.dequeued(doc)
+	if doc.segment == nil {
+		h.fallback(doc)
+	}
+}
+
+// replay queues the documents left in the spool by a previous process, waiting for room
+// in the queue and the memory budget, until the hook is closed.
+func (h *elasticHook) replay(docs []document) {
+	defer h.workers.Done()
+
+	for _, doc := range docs {
+		doc.queued = Now()
+		size := int64(len(doc.data))
+		for !ReserveMemory(size) {
+			select {
+			case <-h.closing:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		h.queuedBytes.Add(size)
+		queueDepth.Add(1)
+
+		select {
+		case h.queue <- doc:
+		case <-h.closing:
+			h.dequeued(doc)
+			return
+		}
+	}
+}
+
+// dequeued returns the bytes of a document taken off the queue to the queue and memory budgets.
+func (h *elasticHook) dequeued(doc document) {
+	queueDepth.Add(-1)
+	h.queuedBytes.Add(-int64(len(doc.data)))
+	ReleaseMemory(int64(len(doc.data)))
+}
+
+// run indexes batches of queued documents until the hook is closed. On close, the
+// remaining documents are drained unless the shutdown is cancelled.
+func (h *elasticHook) run() {
+	defer h.workers.Done()
+
+	for h.ctx.Err() == nil {
+		if doc, ok := h.poll(); ok {
+			h.process(h.batch(doc))
+			continue
+		}
+
+		select {
+		case doc := <-h.urgent:
+			h.process(h.batch(doc))
+		case doc := <-h.queue:
+			h.process(h.batch(doc))
+		case <-h.closing:
+			for h.ctx.Err() == nil {
+				doc, ok := h.poll()
+				if !ok {
+					return
+				}
+				h.process(h.batch(doc))
+			}
+			return
+		}
+	}
+}
+
+// poll returns the next queued document without waiting, taking Warning and higher entries
+// first, so the most important diagnostics reach the cluster first when the queue backs up.
+func (h *elasticHook) poll() (document, bool) {
+	select {
+	case doc := <-h.urgent:
+		return doc, true
+	default:
+	}
+
+	select {
+	case doc := <-h.queue:
+		return doc, true
+	default:
+		return document{}, false
+	}
+}
+
+// batch returns first followed by the documents already queued, up to the batch size,
+// without waiting for more.
+func (h *elasticHook) batch(first document) []document {
+	docs := []document{first}
+	for len(docs) < h.opts.batchSize {
+		doc, ok := h.poll()
+		if !ok {
+			break
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// process writes documents to ElasticSearch, or to the fallback file if the cluster is
+// bypassed or the write fails. Failures are reported on stderr, the same way logrus
+// reports failing hooks.
+func (h *elasticHook) process(docs []document) {
+	aborted := false // Set when a cancelled shutdown aborts the write, leaving the documents in the spool
+	defer func(docs []document) {
+		for _, doc := range docs {
+			h.dequeued(doc)
+			if h.spool != nil && !aborted {
+				h.spool.ack(doc)
+			}
+		}
+	}(docs)
+
+	if h.spool != nil {
+		if err := h.spool.sync(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to sync the elasticsearch spool: %v\n", err)
+		}
+	}
+
+	docs = h.expire(docs)
+	if len(docs) == 0 {
+		return
+	}
+
+	if h.bypassed() {
+		for _, doc := range docs {
+			h.fallback(doc)
+		}
+		return
+	}
+
+	start := Now()
+	rejected, err := h.write(docs)
+	h.latency.add(Since(start))
+
+	if err != nil && h.spool != nil && h.ctx.Err() != nil {
+		aborted = true
+		return
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Failed to write entry to elasticsearch: %v\n", err)
+		writeFailures.Add(1)
+		h.countFailure()
+	} else {
+		h.failures.Store(0)
+	}
+	for _, doc := range rejected {
+		h.fallback(doc)
+	}
+
+	h.detectSlow()
+}
+
+// expire sends the documents queued for longer than the TTL to the fallback file, so
+// after a long outage fresh entries reach the cluster before stale ones, and returns the
+// others. The bytes of every document are released by the caller.
+func (h *elasticHook) expire(docs []document) []document {
+	if h.opts.ttl <= 0 {
+		return docs
+	}
+
+	fresh := docs[:0:0]
+	for _, doc := range docs {
+		if Since(doc.queued) > h.opts.ttl {
+			h.fallback(doc)
+		} else {
+			fresh = append(fresh, doc)
+		}
+	}
+	return fresh
+}
+
+// countFailure counts a failed write and calls onFailures once the writes have failed
+// failuresBeforeReconnect times in a row, unless the hook is shutting down.
+func (h *elasticHook) countFailure() {
+	if h.failures.Add(1) < failuresBeforeReconnect || h.opts.onFailures == nil || h.ctx.Err() != nil {
+		return
+	}
+
+	h.failures.Store(0)
+	h.opts.onFailures()
+}
+
+// fallback appends a document to the fallback file, as a line of NDJSON.
+func (h *elasticHook) fallback(doc document) {
+	if err := appendFallback(h.opts.fallbackPath, fallbackRecord(doc)); err != nil {
+		fileErrors.Add(1)
+		_, _ = fmt.Fprintf(os.Stderr, "Failed to write entry to fallback file: %v\n", err)
+		return
+	}
+	fallbackWrites.Add(1)
+}
+
+// deadLetter appends a document permanently rejected by ElasticSearch to the dead-letter
+// file, along with the error of the rejection, so it isn't retried or mixed with the
+// entries of the fallback file.
+func (h *elasticHook) deadLetter(doc document, status int, reason []byte) {
+	_, _ = fmt.Fprintf(os.Stderr, "Elasticsearch rejected entry permanently (%d), writing it to %s\n", status, h.opts.deadLetterPath)
+	if err := appendDeadLetter(h.opts.deadLetterPath, status, reason, doc.data); err != nil {
+		fileErrors.Add(1)
+		_, _ = fmt.Fprintf(os.Stderr, "Failed to write entry to dead-letter file: %v\n", err)
+		return
+	}
+	deadLetters.Add(1)
+}
+
+// bypassed reports whether the cluster is currently bypassed. When the bypass expires,
+// an event is emitted and writes to the cluster resume.
+func (h *elasticHook) bypassed() bool {
+	until := h.bypassUntil.Load()
+	if until == 0 {
+		return false
+	}
+	if Now().UnixNano() < until {
+		return true
+	}
+	if h.bypassUntil.CompareAndSwap(until, 0) {
+		_, _ = fmt.Fprintln(os.Stderr, "Resuming writes to elasticsearch after slow-sink bypass")
+	}
+	return false
+}
+
+// detectSlow bypasses the cluster when the 95th percentile of a full window of write
+// latencies exceeds the slow threshold, and emits an event for operators.
+func (h *elasticHook) detectSlow() {
+	if h.opts.slowThreshold < 0 {
+		return
+	}
+
+	p95, full := h.latency.percentile(0.95)
+	if !full || p95 <= h.opts.slowThreshold {
+		return
+	}
+
+	h.latency.reset()
+	h.bypassUntil.Store(Now().Add(h.opts.bypassDuration).UnixNano())
+	_, _ = fmt.Fprintf(
+		os.Stderr,
+		"Elasticsearch is slow (p95 write latency %s exceeds %s), writing to %s for %s\n",
+		p95, h.opts.slowThreshold, h.opts.fallbackPath, h.opts.bypassDuration,
+	)
+}
+
+// write indexes documents with the configured deadline, a single one with the index API
+// and several with the bulk API. Documents rejected permanently go to the dead-letter file.
+// It returns the other documents that weren't indexed, and an error if the request as a
+// whole failed.
+func (h *elasticHook) write(docs []document) ([]document, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, h.opts.timeout)
+	defer cancel()
+
+	if len(docs) == 1 {
+		return h.writeOne(ctx, docs[0])
+	}
+	return h.writeBulk(ctx, docs)
+}
+
+// writeOne indexes a single document.
+func (h *elasticHook) writeOne(ctx context.Context, doc document) ([]document, error) {
+	req := esapi.IndexRequest{
+		Index:      doc.index,
+		DocumentID: doc.id,
+		Body:       bytes.NewReader(doc.data),
+		Pipeline:   h.opts.pipeline,
+	}
+
+	res, err := req.Do(ctx, h.client)
+	if err != nil {
+		return []document{doc}, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.IsError() {
+		if permanent(res.StatusCode) {
+			var parsed struct {
+				Error json.RawMessage `json:"error"`
+			}
+			body, _ := io.ReadAll(res.Body)
+			if json.Unmarshal(body, &parsed) == nil && parsed.Error != nil {
+				body = parsed.Error
+			}
+			h.deadLetter(doc, res.StatusCode, body)
+			return nil, nil
+		}
+		return []document{doc}, fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	return nil, nil
+}
+
+// bulkResponse is the part of a bulk API response telling which documents failed.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// writeBulk indexes several documents with one request to the bulk API.
+func (h *elasticHook) writeBulk(ctx context.Context, docs []document) ([]document, error) {
+	var body bytes.Buffer
+	for _, doc := range docs {
+		meta := map[string]string{"_index": doc.index}
+		if doc.id != "" {
+			meta["_id"] = doc.id
+		}
+		action, _ := json.Marshal(map[string]map[string]string{"index": meta})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(bytes.TrimRight(doc.data, "\n"))
+		body.WriteByte('\n')
+	}
+
+	req := esapi.BulkRequest{
+		Body:     &body,
+		Pipeline: h.opts.pipeline,
+	}
+
+	res, err := req.Do(ctx, h.client)
+	if err != nil {
+		return docs, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.IsError() {
+		return docs, fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	var parsed bulkResponse
+	if err = json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return docs, fmt.Errorf("decoding the bulk response: %w", err)
+	}
+	if !parsed.Errors {
+		return nil, nil
+	}
+
+	var rejected []document
+	for i, item := range parsed.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300 || i >= len(docs):
+			case permanent(result.Status):
+				h.deadLetter(docs[i], result.Status, result.Error)
+			default:
+				_, _ = fmt.Fprintf(os.Stderr, "Elasticsearch rejected entry: %s\n", result.Error)
+				rejected = append(rejected, docs[i])
+			}
+		}
+	}
+	return rejected, nil
+}
+
+// flush waits until every document queued or being written is done with, polling the bytes
+// of the queued documents, which are released once written. If ctx is done first, the documents
+// still queued go to the fallback file, spooled ones included, and the context's error is returned.
+func (h *elasticHook) flush(ctx context.Context) error {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for h.queuedBytes.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			for {
+				doc, ok := h.poll()
+				if !ok {
+					return ctx.Err()
+				}
+				h.fallback(doc)
+				h.dequeued(doc)
+				if h.spool != nil {
+					h.spool.ack(doc)
+				}
+			}
+		case <-h.done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// close stops accepting entries and waits for the workers to drain the queue. If ctx is
+// done first, in-flight writes are cancelled, the remaining entries are discarded, and
+// the context's error is returned.
+func (h *elasticHook) close(ctx context.Context) error {
+	return h.shutdown(ctx, false)
+}
+
+// drain stops the hook like close, but the entries remaining when ctx is done, and those fired
+// after the shutdown started, go to the fallback file instead of being discarded.
+func (h *elasticHook) drain(ctx context.Context) error {
+	return h.shutdown(ctx, true)
+}
+
+// shutdown stops accepting entries and waits for the workers to drain the queue. The entries
+// remaining when ctx is done go to the fallback file if keep is set, and are discarded otherwise.
+func (h *elasticHook) shutdown(ctx context.Context, keep bool) error {
+	err := h.stop(ctx, keep)
+	for _, doc := range h.remaining() {
+		if keep {
+			h.fallback(doc)
+		} else {
+			dropped.Add(1)
+		}
+	}
+	return err
+}
+
+// handover stops the hook at once, aborting its in-flight writes, and returns the documents left
+// in its queue for the hook replacing it to write, see adopt. Spooled documents aren't returned,
+// as the next hook replays them from the spool, and entries fired meanwhile go to the fallback file.
+func (h *elasticHook) handover() []document {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = h.stop(ctx, true)
+	return h.remaining()
+}
+
+// stop stops accepting entries and waits for the workers to exit, cancelling their writes once
+// ctx is done. Entries fired afterwards go to the fallback file if keep is set.
+func (h *elasticHook) stop(ctx context.Context, keep bool) error {
+	if keep {
+		h.keep.Store(true)
+	}
+	h.once.Do(func() {
+		close(h.closing)
+	})
+
+	select {
+	case <-h.done:
+		h.cancel()
+		return nil
+	case <-ctx.Done():
+		h.cancel()
+		<-h.done
+		return ctx.Err()
+	}
+}
+
+// remaining takes the documents left in the queue after the workers exited, returning their
+// memory to the budget, and closes the spool unless it was passed on. The spool keeps its
+// documents for the next process.
+// It returns the documents that aren't in the spool.
+func (h *elasticHook) remaining() []document {
+	if h.spool != nil && !h.spoolPassed.Load() {
+		defer h.spool.close()
+	}
+
+	var docs []document
+	for {
+		doc, ok := h.poll()
+		if !ok {
+			return docs
+		}
+		h.dequeued(doc)
+		if doc.segment == nil {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// passSpool passes the spool to the hook replacing this one, which closes it, so this hook can
+// be drained while its successor spools the new entries. It returns nil without a spool.
+func (h *elasticHook) passSpool() *spool {
+	h.spoolPassed.Store(true)
+	return h.spool
+}
+
+// adopt queues the documents handed over by the hook this one replaces, see handover. Those
+// there is no room for in the queue or the memory budget go to the fallback file.
+func (h *elasticHook) adopt(docs []document) {
+	for _, doc := range docs {
+		size := int64(len(doc.data))
+		if !ReserveMemory(size) {
+			h.fallback(doc)
+			continue
+		}
+		h.queuedBytes.Add(size)
+		queueDepth.Add(1)
+
+		if h.spool != nil {
+			if err := h.spool.append(&doc); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Failed to write entry to the elasticsearch spool: %v\n", err)
+			}
+		}
+
+		select {
+		case h.queue <- doc:
+		default:
+			h.dequeued(doc)
+			h.fallback(doc)
+			if h.spool != nil {
+				h.spool.ack(doc)
+			}
+		}
+	}
+}
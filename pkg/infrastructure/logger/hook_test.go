@@ -0,0 +1,1358 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/christiandoxa/welog/pkg/constant/envkey"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.elastic.co/ecslogrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient creates an ElasticSearch client talking to a test server backed by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *elasticsearch.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	assert.NoError(t, err)
+
+	return c
+}
+
+// TestElasticHookWrite tests that fired entries are indexed and flushed on close.
+func TestElasticHookWrite(t *testing.T) {
+	var writes atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writes.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	opts := hookOptions{timeout: time.Second, fallbackPath: filepath.Join(t.TempDir(), "logs.txt")}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.AddHook(hook)
+	log.Info("first")
+	log.Info("second")
+
+	// Assert that closing drains the queue.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Equal(t, int32(2), writes.Load())
+
+	// Assert that entries fired after close are rejected.
+	assert.Error(t, hook.Fire(logrus.NewEntry(log)))
+}
+
+// TestElasticHookTimeout tests that a hung cluster can't block the worker beyond the write
+// timeout, and that a cancelled shutdown aborts in-flight writes.
+func TestElasticHookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	t.Cleanup(func() { close(release) }) // Runs before the server is closed
+
+	opts := hookOptions{timeout: 50 * time.Millisecond, fallbackPath: filepath.Join(t.TempDir(), "logs.txt")}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+
+	// Assert that a single write returns once its deadline expires.
+	start := time.Now()
+	_, err := hook.write([]document{{index: "welog", data: []byte(`{}`)}})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// Assert that a shutdown with an expired context doesn't wait for the queue.
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, hook.Fire(logrus.NewEntry(logrus.New())))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.ErrorIs(t, hook.close(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+// TestElasticHookDrain tests that the entries left when a drain times out go to the fallback file.
+func TestElasticHookDrain(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	t.Cleanup(func() { close(release) }) // Runs before the server is closed
+
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	opts := hookOptions{timeout: time.Minute, workers: 1, batchSize: 1, fallbackPath: fallbackPath}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+	log.Info("first")
+	log.Info("second")
+	log.Info("third")
+
+	// Assert that the drain gives up on the stuck cluster and keeps the queued entries.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, hook.drain(ctx), context.DeadlineExceeded)
+	log.Info("fourth")
+	data, err := os.ReadFile(fallbackPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "second")
+	assert.Contains(t, string(data), "third")
+
+	// Assert that the entries fired after the drain started are kept as well.
+	assert.Contains(t, string(data), "fourth")
+}
+
+// TestElasticHookHandover tests that the entries queued for a replaced hook are written by
+// the hook replacing it.
+func TestElasticHookHandover(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	stuck := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	t.Cleanup(func() { close(release) }) // Runs before the server is closed
+	var writes atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writes.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	opts := hookOptions{timeout: time.Minute, workers: 1, batchSize: 1, fallbackPath: fallbackPath}
+	index := func(*logrus.Entry) string { return "welog" }
+	old := newElasticHook(stuck, &ecslogrus.Formatter{}, index, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(old)
+	log.Info("first")
+	<-started
+	log.Info("second")
+	log.Info("third")
+
+	// Assert that the queued entries are handed over rather than discarded.
+	left := old.handover()
+	assert.Len(t, left, 2)
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, index, opts)
+	hook.adopt(left)
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Equal(t, int32(2), writes.Load())
+
+	// Assert that the aborted write goes to the fallback file.
+	data, err := os.ReadFile(fallbackPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "first")
+	assert.NotContains(t, string(data), "second")
+}
+
+// TestElasticHookFlush tests that a flush waits for the queued entries and leaves the hook running.
+func TestElasticHookFlush(t *testing.T) {
+	var indexed atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		time.Sleep(10 * time.Millisecond)
+		indexed.Add(int32(strings.Count(string(body), `"message"`)))
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	opts := hookOptions{timeout: time.Minute, workers: 1, batchSize: 1}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+	log.Info("first")
+	log.Info("second")
+
+	// Assert that the flush returns once both entries are written.
+	assert.NoError(t, hook.flush(context.Background()))
+	assert.EqualValues(t, 2, indexed.Load())
+
+	// Assert that the hook still ships the entries fired after the flush.
+	log.Info("third")
+	assert.NoError(t, hook.close(context.Background()))
+	assert.EqualValues(t, 3, indexed.Load())
+}
+
+// queuedDocuments accounts for a document as Fire does, for the tests processing it directly.
+func queuedDocuments(h *elasticHook, data string) []document {
+	size := int64(len(data))
+	h.queuedBytes.Add(size)
+	ReserveMemory(size)
+	queueDepth.Add(1)
+	return []document{{index: "welog", data: []byte(data)}}
+}
+
+// TestElasticHookSlowBypass tests that a consistently slow cluster is bypassed in favor of
+// the fallback file.
+func TestElasticHookSlowBypass(t *testing.T) {
+	var writes atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writes.Add(1)
+		time.Sleep(2 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	opts := hookOptions{slowThreshold: time.Millisecond, bypassDuration: time.Hour, fallbackPath: fallbackPath}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+
+	// Fill the latency window with slow writes, then write once more.
+	for i := 0; i < latencyWindow; i++ {
+		hook.process(queuedDocuments(hook, "{}\n"))
+	}
+	assert.True(t, hook.bypassed())
+	hook.process(queuedDocuments(hook, `{"bypassed":true}`+"\n"))
+
+	// Assert that the last entry went to the fallback file instead of the cluster.
+	assert.Equal(t, int32(latencyWindow), writes.Load())
+	data, err := os.ReadFile(fallbackPath)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"_index":"welog","bypassed":true}`+"\n", string(data))
+	assert.NoError(t, hook.close(context.Background()))
+}
+
+// TestWithinBudget tests that each category is limited by its own budget.
+func TestWithinBudget(t *testing.T) {
+	SetBudget(CategoryRequest, 0.001, 1)
+	t.Cleanup(func() { SetBudget(CategoryRequest, 0, 0) })
+
+	request := logrus.NewEntry(logrus.New()).WithContext(WithCategory(context.Background(), CategoryRequest))
+	app := logrus.NewEntry(logrus.New())
+
+	// Assert that the request budget is exhausted after one entry while app logs are unlimited.
+	assert.True(t, withinBudget(request))
+	assert.False(t, withinBudget(request))
+	assert.True(t, withinBudget(app))
+	assert.True(t, withinBudget(app))
+}
+
+func TestSubscribe(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.Hooks.Add(subscribers)
+
+	var received []Document
+	unsubscribe := Subscribe(func(doc Document) {
+		received = append(received, doc)
+	})
+	ch := make(chan Document, 1)
+	unsubscribeChan := SubscribeChan(ch)
+	t.Cleanup(unsubscribeChan)
+
+	// Assert that both subscribers receive the entry with its category and fields.
+	log.WithContext(WithCategory(context.Background(), CategoryRequest)).WithField("key", "value").Warn("hello")
+	assert.Len(t, received, 1)
+	assert.Equal(t, CategoryRequest, received[0].Category)
+	assert.Equal(t, logrus.WarnLevel, received[0].Level)
+	assert.Equal(t, "hello", received[0].Message)
+	assert.Equal(t, logrus.Fields{"key": "value"}, received[0].Fields)
+	assert.Equal(t, "hello", (<-ch).Message)
+
+	// Assert that a full channel doesn't block and an unsubscribed callback receives nothing.
+	unsubscribe()
+	log.Info("first")
+	log.Info("second")
+	assert.Len(t, received, 1)
+	assert.Equal(t, "first", (<-ch).Message)
+}
+
+func TestReserveMemory(t *testing.T) {
+	SetMemoryBudget(10)
+	t.Cleanup(func() { SetMemoryBudget(0) })
+	used := MemoryInUse()
+
+	// Assert that reservations fail once the budget is exhausted and succeed after a release.
+	assert.True(t, ReserveMemory(8-used))
+	assert.False(t, ReserveMemory(3))
+	ReleaseMemory(8 - used)
+	assert.True(t, ReserveMemory(3))
+	ReleaseMemory(3)
+	assert.Equal(t, used, MemoryInUse())
+}
+
+func TestIndexName(t *testing.T) {
+	t.Setenv(envkey.ElasticIndex, "welog")
+	entry := logrus.NewEntry(logrus.New())
+
+	// Assert that the default name ends with the date in the configured layout.
+	assert.Equal(t, "welog-"+time.Now().Format("2006-01-02"), indexNameFunc(entry))
+	t.Setenv(envkey.ElasticIndexDateLayout, "2006-01")
+	assert.Equal(t, "welog-"+time.Now().Format("2006-01"), indexNameFunc(entry))
+
+	// Assert that a custom function takes over until it is reset.
+	SetIndexNameFunc(func(entry *logrus.Entry) string {
+		return DefaultIndexName(entry) + "-" + entry.Level.String()
+	})
+	t.Cleanup(func() { SetIndexNameFunc(nil) })
+	entry.Level = logrus.ErrorLevel
+	assert.Equal(t, "welog-"+time.Now().Format("2006-01")+"-error", indexNameFunc(entry))
+	SetIndexNameFunc(nil)
+	assert.Equal(t, "welog-"+time.Now().Format("2006-01"), indexNameFunc(entry))
+}
+
+func TestNewClientAuth(t *testing.T) {
+	var authorization atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv(envkey.ElasticURL, server.URL)
+	t.Setenv(envkey.ElasticUsername, "user")
+	t.Setenv(envkey.ElasticPassword, "password")
+
+	// Assert that the API key takes precedence over basic authentication.
+	t.Setenv(envkey.ElasticAPIKey, "a2V5")
+	assert.NoError(t, Ping(context.Background()))
+	assert.Equal(t, "APIKey a2V5", authorization.Load())
+
+	// Assert that the credentials set with SetCredentials take precedence over the environment.
+	SetCredentials(Credentials{APIKey: "c2V0"})
+	t.Cleanup(func() { SetCredentials(Credentials{}) })
+	assert.NoError(t, Ping(context.Background()))
+	assert.Equal(t, "APIKey c2V0", authorization.Load())
+
+	// Assert that a Cloud ID replaces the URL.
+	t.Setenv(envkey.ElasticURL, "")
+	t.Setenv(envkey.ElasticCloudID, "deployment:ZXhhbXBsZS5jb20kYWJjJGRlZg==")
+	c, err := newClient(configFromEnv())
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+}
+
+func TestNewClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv(envkey.ElasticURL, server.URL)
+
+	// Assert that the certificate of a private authority is rejected until the authority is trusted.
+	assert.ErrorContains(t, Ping(context.Background()), "certificate")
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caPath, ca, 0o600))
+	t.Setenv(envkey.ElasticCACertPath, caPath)
+	assert.NoError(t, Ping(context.Background()))
+
+	// Assert that an invalid authority fails the client creation.
+	t.Setenv(envkey.ElasticCACertPath, "")
+	t.Setenv(envkey.ElasticCACertPEM, "not a certificate")
+	assert.ErrorContains(t, Ping(context.Background()), "no valid PEM certificate")
+}
+
+func TestNewClientAddresses(t *testing.T) {
+	var hits atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	}))
+	t.Cleanup(up.Close)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	// Assert that requests failing on a stopped node are retried on the others.
+	t.Setenv(envkey.ElasticURL, down.URL)
+	t.Setenv(envkey.ElasticURLs, " "+up.URL+" ,")
+	for range 3 {
+		assert.NoError(t, Ping(context.Background()))
+	}
+	assert.Equal(t, int32(3), hits.Load())
+}
+
+func TestElasticHookPipeline(t *testing.T) {
+	var pipeline atomic.Value
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		pipeline.Store(r.URL.Query().Get("pipeline"))
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	opts := hookOptions{pipeline: "enrich", fallbackPath: filepath.Join(t.TempDir(), "logs.txt")}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+	log.Info("enriched")
+
+	// Assert that documents are indexed through the pipeline.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Equal(t, "enrich", pipeline.Load())
+}
+
+func TestSetClient(t *testing.T) {
+	var pings atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		pings.Add(1)
+	})
+	t.Setenv(envkey.ElasticURL, "http://127.0.0.1:1")
+
+	// Assert that the injected client is used instead of the environment until it is reset.
+	SetClient(c)
+	t.Cleanup(func() { SetClient(nil) })
+	assert.NoError(t, Ping(context.Background()))
+	assert.Equal(t, int32(1), pings.Load())
+	SetClient(nil)
+	assert.ErrorContains(t, Ping(context.Background()), "elasticsearch is unreachable")
+}
+
+func TestElasticHookReconnect(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "failed") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	var reconnections atomic.Int32
+	opts := hookOptions{
+		slowThreshold: -1,
+		fallbackPath:  filepath.Join(t.TempDir(), "logs.txt"),
+		onFailures:    func() { reconnections.Add(1) },
+	}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+
+	// Assert that a reconnection is requested after consecutive failures only.
+	for range failuresBeforeReconnect - 1 {
+		log.Info("failed")
+	}
+	log.Info("written")
+	for range failuresBeforeReconnect {
+		log.Info("failed")
+	}
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Equal(t, int32(1), reconnections.Load())
+}
+
+func TestPipelineClose(t *testing.T) {
+	var writes atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			writes.Add(1)
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+	t.Setenv(envkey.FallbackPath, filepath.Join(t.TempDir(), "logs.txt"))
+
+	p := newPipeline(configFromEnv)
+	p.setClient(c)
+	p.start()
+	p.Logger().SetOutput(io.Discard)
+	p.Logger().Info("shipped")
+
+	// Assert that closing flushes the entry and stops every goroutine of the pipeline.
+	assert.NoError(t, p.Close(context.Background()))
+	assert.Equal(t, int32(1), writes.Load())
+	select {
+	case <-p.done:
+	default:
+		t.Fatal("monitor is still running")
+	}
+
+	// Assert that only the ElasticSearch hook is removed.
+	hooks := p.Logger().Hooks[logrus.InfoLevel]
+	assert.Contains(t, hooks, logrus.Hook(metadata))
+	assert.Contains(t, hooks, logrus.Hook(processors))
+	for _, hook := range hooks {
+		_, isElastic := hook.(*elasticHook)
+		assert.False(t, isElastic)
+	}
+}
+
+func TestNew(t *testing.T) {
+	newServer := func(writes *atomic.Int32) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			if r.Method == http.MethodPost || r.Method == http.MethodPut {
+				writes.Add(1)
+				w.WriteHeader(http.StatusCreated)
+			}
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	var first, second atomic.Int32
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+
+	// Create two pipelines shipping to different clusters.
+	a := New(Config{ElasticURL: newServer(&first), ElasticIndex: "a", FallbackPath: fallbackPath})
+	b := New(Config{ElasticURL: newServer(&second), ElasticIndex: "b", FallbackPath: fallbackPath})
+	a.Logger().SetOutput(io.Discard)
+	b.Logger().SetOutput(io.Discard)
+	a.Logger().Info("first")
+	b.Logger().Info("second")
+	b.Logger().Info("third")
+
+	// Assert that each pipeline ships to its own cluster.
+	assert.NoError(t, a.Close(context.Background()))
+	assert.NoError(t, b.Close(context.Background()))
+	assert.Equal(t, int32(1), first.Load())
+	assert.Equal(t, int32(2), second.Load())
+}
+
+// TestSecondaryCluster tests that the pipeline fails over to the secondary cluster while the
+// primary one is down, and fails back once it recovers.
+func TestSecondaryCluster(t *testing.T) {
+	newServer := func(down *atomic.Bool, writes *atomic.Int32) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			if down.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.Method == http.MethodPost || r.Method == http.MethodPut {
+				writes.Add(1)
+				w.WriteHeader(http.StatusCreated)
+			}
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	var primaryDown, secondaryDown atomic.Bool
+	var primary, secondary atomic.Int32
+	primaryDown.Store(true)
+	failoversBefore := ReadDiagnostics().Failovers
+
+	p := New(Config{
+		ElasticURL:              newServer(&primaryDown, &primary),
+		ElasticSecondaryURLs:    []string{newServer(&secondaryDown, &secondary)},
+		ElasticFailbackInterval: 10 * time.Millisecond,
+		ElasticSpoolDir:         t.TempDir(),
+		ElasticIndex:            "welog",
+		FallbackPath:            filepath.Join(t.TempDir(), "logs.txt"),
+	})
+	p.Logger().SetOutput(io.Discard)
+
+	// Assert that entries go to the secondary cluster while the primary one is down.
+	p.Logger().Info("failed over")
+	assert.Eventually(t, func() bool { return secondary.Load() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(0), primary.Load())
+	assert.Equal(t, failoversBefore+1, ReadDiagnostics().Failovers)
+
+	// Assert that entries go back to the primary cluster once it recovers.
+	primaryDown.Store(false)
+	assert.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return !p.onSecondary
+	}, time.Second, 5*time.Millisecond)
+	p.Logger().Info("failed back")
+	assert.NoError(t, p.Close(context.Background()))
+	assert.Equal(t, int32(1), primary.Load())
+	assert.Equal(t, int32(1), secondary.Load())
+
+	// Assert that reconnecting fails when both clusters are down.
+	primaryDown.Store(true)
+	secondaryDown.Store(true)
+	config := Config{ElasticURL: "http://127.0.0.1:1", ElasticSecondaryURLs: []string{"http://127.0.0.1:1"}}
+	q := newPipeline(func() Config { return config })
+	assert.ErrorContains(t, q.reconnect(), "secondary cluster")
+}
+
+// TestFailbackDrain tests that failing back doesn't block the pipeline while the hook of the
+// secondary cluster drains.
+func TestFailbackDrain(t *testing.T) {
+	var primaryDown atomic.Bool
+	primaryDown.Store(true)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if primaryDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(primary.Close)
+	writing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			select {
+			case writing <- struct{}{}:
+			default:
+			}
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		}
+	}))
+	t.Cleanup(secondary.Close)
+	t.Cleanup(func() { close(release) }) // Runs before the servers are closed
+
+	p := New(Config{
+		ElasticURL:              primary.URL,
+		ElasticSecondaryURLs:    []string{secondary.URL},
+		ElasticFailbackInterval: 10 * time.Millisecond,
+		ElasticSpoolDir:         t.TempDir(),
+		ElasticIndex:            "welog",
+		FallbackPath:            filepath.Join(t.TempDir(), "logs.txt"),
+	})
+	p.Logger().SetOutput(io.Discard)
+	p.Logger().Info("stuck")
+	<-writing
+
+	// Assert that the pipeline fails back while the write to the secondary cluster hangs.
+	primaryDown.Store(false)
+	assert.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return !p.onSecondary
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, p.Close(context.Background()))
+}
+
+func TestElasticHookBulk(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		items := make([]string, 0, len(lines)/2)
+		for i := 1; i < len(lines); i += 2 {
+			if strings.Contains(lines[i], "rejected") {
+				items = append(items, `{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}`)
+			} else {
+				items = append(items, `{"index":{"status":201}}`)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	})
+
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	opts := hookOptions{workers: 2, batchSize: 10, slowThreshold: -1, fallbackPath: fallbackPath}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+
+	// Queue entries while the cluster is blocked, then let the workers write them in batches.
+	log.Info("first")
+	for range 8 {
+		log.Info("queued")
+	}
+	log.Info("rejected")
+	close(release)
+
+	// Assert that the entries took fewer requests than entries and that only the rejected one fell back.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Less(t, requests.Load(), int32(10))
+	data, err := os.ReadFile(fallbackPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), `"message":"rejected"`)
+}
+
+func TestElasticHookQueueBytesAndTTL(t *testing.T) {
+	release := make(chan struct{})
+	var writes atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		writes.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	opts := hookOptions{queueBytes: 1024, ttl: 50 * time.Millisecond, slowThreshold: -1, fallbackPath: fallbackPath}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+
+	// Assert that entries beyond the byte limit are dropped while the cluster is blocked.
+	var dropped int
+	for range 20 {
+		if hook.Fire(logrus.NewEntry(log).WithField("message", "queued")) != nil {
+			dropped++
+		}
+	}
+	assert.Positive(t, dropped)
+
+	// Assert that entries outliving the TTL go to the fallback file instead of the cluster.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Equal(t, int32(1), writes.Load())
+	data, err := os.ReadFile(fallbackPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 19-dropped, strings.Count(string(data), "\n"))
+	assert.Zero(t, hook.queuedBytes.Load())
+}
+
+func TestElasticHookSpool(t *testing.T) {
+	dir := t.TempDir()
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	newLogger := func(c *elasticsearch.Client) (*logrus.Logger, *elasticHook) {
+		opts := hookOptions{spoolDir: dir, slowThreshold: -1, fallbackPath: fallbackPath}
+		hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+		log := logrus.New()
+		log.SetOutput(io.Discard)
+		log.AddHook(hook)
+		return log, hook
+	}
+
+	// Log entries while the cluster hangs, then stop without delivering them.
+	release := make(chan struct{})
+	hung := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	t.Cleanup(func() { close(release) }) // Runs before the server is closed
+	log, hook := newLogger(hung)
+	for range 3 {
+		log.Info("spooled")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, hook.close(ctx), context.Canceled)
+
+	// Assert that the next process delivers the spooled entries and deletes the segments.
+	var writes atomic.Int32
+	up := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "spooled") {
+			writes.Add(1)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	_, hook = newLogger(up)
+	assert.Eventually(t, func() bool { return writes.Load() >= 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, hook.close(context.Background()))
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.NoError(t, err)
+	assert.Empty(t, segments)
+}
+
+// TestSpoolRecords tests that documents spanning several lines survive the spool intact, and
+// that a truncated last record is skipped.
+func TestSpoolRecords(t *testing.T) {
+	dir := t.TempDir()
+	s, _, err := openSpool(dir)
+	assert.NoError(t, err)
+	docs := []document{
+		{index: "welog", id: "a", data: []byte("{\n  \"message\": \"multi\tline\"\n}\n")},
+		{index: "welog", data: []byte("plain text\n\n")},
+	}
+	for i := range docs {
+		assert.NoError(t, s.append(&docs[i]))
+	}
+	assert.NoError(t, s.sync())
+	s.close()
+
+	// Simulate a crash in the middle of a write.
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.NoError(t, err)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.NoError(t, err)
+	_, err = f.WriteString("5 0 100\nwelog{\"trunc")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	// Assert that the next process replays the documents as they were appended.
+	s, replay, err := openSpool(dir)
+	assert.NoError(t, err)
+	defer s.close()
+	if assert.Len(t, replay, 2) {
+		for i, doc := range replay {
+			assert.Equal(t, docs[i].index, doc.index)
+			assert.Equal(t, docs[i].id, doc.id)
+			assert.Equal(t, docs[i].data, doc.data)
+		}
+	}
+}
+
+func TestDocumentID(t *testing.T) {
+	var paths []string
+	var mu sync.Mutex
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	opts := Config{FallbackPath: filepath.Join(t.TempDir(), "logs.txt")}.hookOptions()
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+
+	// Log the same entry twice, as a retry would, and entries differing by a field or the level.
+	now := time.Now()
+	log.WithTime(now).WithField("requestId", "abc").Info("same")
+	log.WithTime(now).WithField("requestId", "abc").Info("same")
+	log.WithTime(now).WithField("requestId", "def").Info("same")
+	log.WithTime(now).WithFields(logrus.Fields{"requestId": "abc", "user": "a"}).Info("same")
+	log.WithTime(now).WithField("requestId", "abc").Warn("same")
+
+	// Assert that the identical entries share their document ID and the others don't.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Len(t, paths, 5)
+	distinct := map[string]bool{}
+	for _, path := range paths {
+		distinct[path] = true
+		assert.True(t, strings.HasPrefix(path, "/welog/_doc/"))
+	}
+	assert.Len(t, distinct, 4)
+}
+
+func TestElasticHookPriority(t *testing.T) {
+	release := make(chan struct{})
+	var messages []string
+	var mu sync.Mutex
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		messages = append(messages, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	opts := hookOptions{slowThreshold: -1, fallbackPath: filepath.Join(t.TempDir(), "logs.txt")}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+
+	// Queue info entries while the worker is blocked on the first one, then an error.
+	log.Info("first")
+	time.Sleep(50 * time.Millisecond)
+	log.Info("second")
+	log.Info("third")
+	log.Error("failure")
+	close(release)
+
+	// Assert that the error was written before the queued info entries.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Len(t, messages, 4)
+	assert.Contains(t, messages[0], `"message":"first"`)
+	assert.Contains(t, messages[1], `"message":"failure"`)
+}
+
+func TestElasticHookAudit(t *testing.T) {
+	release := make(chan struct{})
+	var writes atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		writes.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	opts := hookOptions{queueSize: 1, batchSize: 1, slowThreshold: -1, fallbackPath: fallbackPath}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	entry := func(category Category, level logrus.Level, message string) *logrus.Entry {
+		e := logrus.NewEntry(log).WithContext(WithCategory(context.Background(), category))
+		e.Level, e.Message = level, message
+		return e
+	}
+
+	// Block the worker on a first entry and fill the queue of urgent entries.
+	assert.NoError(t, hook.Fire(entry(CategoryApp, logrus.InfoLevel, "first")))
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, hook.Fire(entry(CategoryApp, logrus.ErrorLevel, "failure")))
+
+	// Assert that an audit entry waits for room in the queue instead of being dropped.
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, hook.Fire(entry(CategoryAudit, logrus.InfoLevel, "login")))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("audit entry was not blocked by the full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-done
+
+	// Assert that an audit entry waits for the memory budget, then goes to the fallback file.
+	SetMemoryBudget(1)
+	t.Cleanup(func() { SetMemoryBudget(0) })
+	hook.opts.auditWait = 50 * time.Millisecond
+	start := time.Now()
+	assert.NoError(t, hook.Fire(entry(CategoryAudit, logrus.InfoLevel, "denied")))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	SetMemoryBudget(0)
+
+	// Assert that audit entries of a closed hook go to the fallback file.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Equal(t, int32(3), writes.Load())
+	assert.NoError(t, hook.Fire(entry(CategoryAudit, logrus.InfoLevel, "logout")))
+	data, err := os.ReadFile(fallbackPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"message":"denied"`)
+	assert.Contains(t, string(data), `"message":"logout"`)
+	assert.Zero(t, hook.queuedBytes.Load())
+	assert.Zero(t, MemoryInUse())
+}
+
+func TestElasticHookDedupe(t *testing.T) {
+	var messages []string
+	var mu sync.Mutex
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		messages = append(messages, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	SetDedupeWindow(50 * time.Millisecond)
+	t.Cleanup(func() { SetDedupeWindow(0) })
+	opts := hookOptions{batchSize: 1, slowThreshold: -1, fallbackPath: filepath.Join(t.TempDir(), "logs.txt")}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+
+	// Log a failing dependency repeatedly, along with a different entry.
+	for range 5 {
+		log.WithField("dependency", "db").Error("connection refused")
+	}
+	log.WithField("dependency", "cache").Error("connection refused")
+	time.Sleep(100 * time.Millisecond)
+
+	// Assert that the repeats were shipped as a single summary at the end of the window.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Len(t, messages, 3)
+	assert.Contains(t, strings.Join(messages, "\n"), `"repeatCount":4`)
+}
+
+func TestElasticHookDeadLetter(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(string(body), "conflict"):
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"type":"mapper_parsing_exception"},"status":400}`))
+		case strings.Contains(string(body), "overloaded"):
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	})
+
+	dir := t.TempDir()
+	opts := hookOptions{
+		slowThreshold:  -1,
+		fallbackPath:   filepath.Join(dir, "logs.txt"),
+		deadLetterPath: filepath.Join(dir, "deadletter.txt"),
+	}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+	log.Info("conflict")
+	log.Info("overloaded")
+	log.Info("indexed")
+
+	// Assert that the permanent rejection went to the dead-letter file with its error, and the other to the fallback file.
+	assert.NoError(t, hook.close(context.Background()))
+	data, err := os.ReadFile(opts.deadLetterPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), `"status":400,"error":{"type":"mapper_parsing_exception"}`)
+	assert.Contains(t, string(data), `"message":"conflict"`)
+	data, err = os.ReadFile(opts.fallbackPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), `"message":"overloaded"`)
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	opts := hookOptions{slowThreshold: -1, fallbackPath: filepath.Join(t.TempDir(), "logs.txt")}
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	log.AddHook(hook)
+
+	// Fail a write, then drop an entry logged after the hook is closed.
+	before := ReadDiagnostics()
+	log.Info("failed")
+	assert.NoError(t, hook.close(context.Background()))
+	log.Info("dropped")
+
+	// Assert that the failure, the fallback write, and the drop were counted.
+	after := ReadDiagnostics()
+	assert.Equal(t, int64(1), after.WriteFailures-before.WriteFailures)
+	assert.Equal(t, int64(1), after.FallbackWrites-before.FallbackWrites)
+	assert.Equal(t, int64(1), after.Dropped-before.Dropped)
+	assert.Equal(t, before.QueueDepth, after.QueueDepth)
+
+	// Assert that the diagnostics are reported periodically until reporting stops.
+	reports := make(chan Diagnostics, 1)
+	SetDiagnostics(time.Millisecond, func(d Diagnostics) {
+		select {
+		case reports <- d:
+		default:
+		}
+	})
+	defer SetDiagnostics(0, nil)
+	assert.Equal(t, after.Dropped, (<-reports).Dropped)
+}
+
+// TestElasticHookLevels tests that entries below the minimum level stay in the local output.
+func TestElasticHookLevels(t *testing.T) {
+	var writes atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writes.Add(1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	opts := Config{LogLevel: "info", FallbackPath: filepath.Join(t.TempDir(), "logs.txt")}.hookOptions()
+	hook := newElasticHook(c, &ecslogrus.Formatter{}, func(*logrus.Entry) string { return "welog" }, opts)
+	var output bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&output)
+	log.SetLevel(logrus.DebugLevel)
+	log.AddHook(hook)
+	log.Debug("local")
+	log.Info("shipped")
+
+	// Assert that only the info entry is shipped, and both are written locally.
+	assert.NoError(t, hook.close(context.Background()))
+	assert.Equal(t, int32(1), writes.Load())
+	assert.Contains(t, output.String(), "local")
+
+	// Assert that an empty or invalid level ships every entry.
+	assert.Nil(t, levelsFrom(""))
+	assert.Nil(t, levelsFrom("loud"))
+	assert.Equal(t, []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}, levelsFrom("error"))
+
+	// Assert that the level set with SetLogLevel wins over LOG_LEVEL, which applies if it is empty.
+	t.Setenv(envkey.LogLevel, "warn")
+	SetLogLevel("info")
+	assert.Equal(t, "info", configFromEnv().LogLevel)
+	SetLogLevel("")
+	assert.Equal(t, "warn", configFromEnv().LogLevel)
+}
+
+// TestDevelopment tests that the development mode writes readable entries without ElasticSearch.
+func TestDevelopment(t *testing.T) {
+	var writes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writes.Add(1)
+	}))
+	t.Cleanup(server.Close)
+
+	p := New(Config{ElasticURL: server.URL, Development: true})
+	var output bytes.Buffer
+	p.Logger().SetOutput(&output)
+	p.Logger().WithField("requestId", "abc").Info("hello")
+
+	// Assert that the entry is formatted as text and ElasticSearch is never contacted.
+	assert.NoError(t, p.Close(context.Background()))
+	assert.Contains(t, output.String(), "hello")
+	assert.Contains(t, output.String(), "requestId")
+	assert.False(t, strings.HasPrefix(output.String(), "{"))
+	assert.Equal(t, int32(0), writes.Load())
+}
+
+// TestFormatter tests that a custom formatter replaces ECS in the output and in ElasticSearch.
+func TestFormatter(t *testing.T) {
+	var body atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			data, _ := io.ReadAll(r.Body)
+			body.Store(string(data))
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	p := New(Config{ElasticURL: server.URL, Formatter: &logrus.JSONFormatter{}, FallbackPath: filepath.Join(t.TempDir(), "logs.txt")})
+	var output bytes.Buffer
+	p.Logger().SetOutput(&output)
+	p.Logger().Info("hello")
+
+	// Assert that both copies of the entry use the custom schema.
+	assert.NoError(t, p.Close(context.Background()))
+	assert.Contains(t, output.String(), `"msg":"hello"`)
+	assert.Contains(t, body.Load(), `"msg":"hello"`)
+}
+
+// TestSinkFilters tests that each sink receives the entries and fields passing its filter.
+func TestSinkFilters(t *testing.T) {
+	var mu sync.Mutex
+	var shipped strings.Builder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			data, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			shipped.Write(data)
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	p := New(Config{
+		ElasticURL:    server.URL,
+		FallbackPath:  filepath.Join(t.TempDir(), "logs.txt"),
+		ElasticFilter: SinkFilter{Level: "info", OmitFields: []string{"*Body"}},
+		OutputFilter:  SinkFilter{Fields: []string{"request*"}},
+	})
+	var docs, omitted, full []Document
+	unsubscribe := SubscribeFiltered(SinkFilter{Level: "warn"}, func(doc Document) { docs = append(docs, doc) })
+	t.Cleanup(unsubscribe)
+	unsubscribe = SubscribeFiltered(SinkFilter{OmitFields: []string{"team"}}, func(doc Document) { omitted = append(omitted, doc) })
+	t.Cleanup(unsubscribe)
+	unsubscribe = Subscribe(func(doc Document) { full = append(full, doc) })
+	t.Cleanup(unsubscribe)
+	var output bytes.Buffer
+	p.Logger().SetOutput(&output)
+	p.Logger().SetLevel(logrus.DebugLevel)
+	p.Logger().Debug("local")
+	p.Logger().WithFields(logrus.Fields{"requestBody": "secret", "requestId": "abc", "team": "billing"}).Warn("shipped")
+
+	// Assert that ElasticSearch gets the warning without its body, and the output every entry
+	// with the allowed fields only.
+	assert.NoError(t, p.Close(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotContains(t, shipped.String(), "local")
+	assert.Contains(t, shipped.String(), "abc")
+	assert.NotContains(t, shipped.String(), "secret")
+	assert.Contains(t, output.String(), "local")
+	assert.Contains(t, output.String(), "secret")
+	assert.NotContains(t, output.String(), "billing")
+
+	// Assert that the filtered subscriber only receives the warning, with every field.
+	if assert.Len(t, docs, 1) {
+		assert.Equal(t, "billing", docs[0].Fields["team"])
+	}
+
+	// Assert that omitting a field for one subscriber leaves it to the others.
+	if assert.Len(t, omitted, 2) && assert.Len(t, full, 2) {
+		assert.NotContains(t, omitted[1].Fields, "team")
+		assert.Equal(t, "billing", full[1].Fields["team"])
+	}
+}
+
+// TestReadFallback tests that the fallback file is NDJSON whatever the formatter, and is read back
+// skipping the lines that aren't JSON objects.
+func TestReadFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.txt")
+	hook := &elasticHook{opts: hookOptions{fallbackPath: path}}
+	hook.fallback(document{data: []byte("{\n  \"message\": \"indented\"\n}\n")})
+	hook.fallback(document{data: []byte("time=now level=info msg=text\n")})
+	assert.NoError(t, appendFallback(path, []byte("legacy line\n")))
+	hook.fallback(document{index: "welog", id: "abc", data: []byte(`{"message":"last"}`)})
+
+	// Assert that every entry is read back as a JSON object.
+	var entries []FallbackEntry
+	assert.NoError(t, readFallback(context.Background(), path, func(entry FallbackEntry) error {
+		entries = append(entries, entry)
+		return nil
+	}))
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, `{"message":"indented"}`, string(entries[0].Data))
+		assert.Equal(t, "time=now level=info msg=text", entries[1].Document["message"])
+		assert.Equal(t, "1.6.0", entries[1].Document["ecs.version"])
+		assert.Equal(t, 4, entries[2].Line)
+		assert.Equal(t, "last", entries[2].Document["message"])
+		assert.Equal(t, `{"_index":"welog","_id":"abc","message":"last"}`, string(entries[2].Data))
+	}
+
+	// Assert that the errors of fn stop the iteration, and that a missing file has no entries.
+	stop := errors.New("stop")
+	calls := 0
+	assert.ErrorIs(t, readFallback(context.Background(), path, func(FallbackEntry) error {
+		calls++
+		return stop
+	}), stop)
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, readFallback(context.Background(), filepath.Join(t.TempDir(), "missing.txt"), func(FallbackEntry) error {
+		return stop
+	}))
+}
+
+// TestDoctor tests the checks of a pipeline against a healthy cluster, a cluster rejecting the
+// credentials, and without ElasticSearch.
+func TestDoctor(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.WriteHeader(int(status.Load()))
+		case "/_index_template":
+			_, _ = w.Write([]byte(`{"index_templates":[{"name":"welog","index_template":{"index_patterns":["welog-*"]}}]}`))
+		case "/_security/user/_has_privileges":
+			_, _ = w.Write([]byte(`{"has_all_requested":false}`))
+		}
+	})
+	fallbackPath := filepath.Join(t.TempDir(), "logs.txt")
+	config := Config{ElasticClient: c, ElasticIndex: "welog", FallbackPath: fallbackPath}
+	p := newPipeline(func() Config { return config })
+
+	// Assert that a missing privilege fails the report, and the fallback file isn't created.
+	report := p.Doctor(context.Background())
+	statuses := map[string]CheckStatus{}
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	assert.Equal(t, map[string]CheckStatus{
+		"connectivity":     CheckOK,
+		"authentication":   CheckOK,
+		"index template":   CheckOK,
+		"write permission": CheckFailed,
+		"fallback file":    CheckOK,
+	}, statuses)
+	assert.False(t, report.OK())
+	assert.NoFileExists(t, fallbackPath)
+
+	// Assert that rejected credentials skip the checks depending on them.
+	status.Store(http.StatusUnauthorized)
+	report = p.Doctor(context.Background())
+	assert.Equal(t, CheckFailed, report.Checks[1].Status)
+	assert.Equal(t, CheckSkipped, report.Checks[2].Status)
+
+	// Assert that ElasticSearch isn't checked without it.
+	config = Config{StdoutOnly: true, FallbackPath: fallbackPath}
+	report = p.Doctor(context.Background())
+	assert.True(t, report.OK())
+	assert.Equal(t, CheckSkipped, report.Checks[0].Status)
+}
+
+// TestReplayFallback tests that the fallback file is shipped with create actions, that repeated
+// and conflicting entries count as duplicates, that rejected entries are kept, and that an
+// interrupted replay is resumed.
+func TestReplayFallback(t *testing.T) {
+	var down atomic.Bool
+	var mu sync.Mutex
+	var actions, sources []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		var items []string
+		for i := 0; i+1 < len(lines); i += 2 {
+			mu.Lock()
+			actions = append(actions, lines[i])
+			sources = append(sources, lines[i+1])
+			mu.Unlock()
+
+			status := http.StatusCreated
+			switch {
+			case strings.Contains(lines[i+1], "conflict"):
+				status = http.StatusConflict
+			case strings.Contains(lines[i+1], "mapping"):
+				status = http.StatusBadRequest
+			case strings.Contains(lines[i+1], "busy"):
+				status = http.StatusTooManyRequests
+			}
+			items = append(items, fmt.Sprintf(`{"create":{"status":%d,"error":{"type":"test"}}}`, status))
+		}
+		_, _ = fmt.Fprintf(w, `{"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	})
+
+	dir := t.TempDir()
+	fallbackPath := filepath.Join(dir, "logs.txt")
+	deadLetterPath := filepath.Join(dir, "deadletter.txt")
+	config := Config{ElasticClient: c, ElasticIndex: "welog", FallbackPath: fallbackPath, DeadLetterPath: deadLetterPath}
+	p := newPipeline(func() Config { return config })
+
+	hook := &elasticHook{opts: hookOptions{fallbackPath: fallbackPath}}
+	for _, message := range []string{"first", "first", "conflict", "mapping", "busy", "last"} {
+		hook.fallback(document{index: "welog-2024-01-02", id: message, data: []byte(`{"message":"` + message + `"}`)})
+	}
+	assert.NoError(t, appendFallback(fallbackPath, []byte(`{"@timestamp":"2024-01-03T01:00:00.000+0700","message":"same"}`+"\n")))
+	assert.NoError(t, appendFallback(fallbackPath, []byte(`{"@timestamp":"2024-01-03T01:00:00.000+0700","message":"same"}`+"\n")))
+	assert.NoError(t, appendFallback(fallbackPath, []byte("legacy line\n")))
+
+	// Assert that the replay fails while the cluster is down, and leaves the file to resume.
+	down.Store(true)
+	_, err := p.ReplayFallback(context.Background(), ReplayOptions{BatchSize: 2})
+	assert.Error(t, err)
+	assert.NoFileExists(t, fallbackPath)
+	assert.FileExists(t, fallbackPath+replayingSuffix)
+
+	// Assert that the resumed replay sorts out every entry and reports its progress.
+	down.Store(false)
+	var reports []ReplayProgress
+	progress, err := p.ReplayFallback(context.Background(), ReplayOptions{BatchSize: 2, Progress: func(progress ReplayProgress) {
+		reports = append(reports, progress)
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, progress.Replayed)
+	assert.Equal(t, 2, progress.Duplicates)
+	assert.Equal(t, 2, progress.Failed)
+	assert.Equal(t, progress.TotalBytes, progress.ReadBytes)
+	assert.Equal(t, progress, reports[len(reports)-1])
+	assert.Len(t, actions, 8)
+	assert.Equal(t, `{"create":{"_id":"first","_index":"welog-2024-01-02"}}`, actions[0])
+	assert.Equal(t, `{"message":"first"}`, sources[0])
+
+	// Assert that the entries recorded without an ID aren't mistaken for one another, and land in
+	// the index of their own day.
+	assert.Equal(t, `{"create":{"_index":"welog-2024-01-03"}}`, actions[5])
+	assert.Equal(t, actions[5], actions[6])
+
+	// Assert that the file only keeps the entry to retry, and the rejected one is dead-lettered.
+	assert.NoFileExists(t, fallbackPath+replayingSuffix)
+	kept, _ := os.ReadFile(fallbackPath)
+	assert.Equal(t, `{"_index":"welog-2024-01-02","_id":"busy","message":"busy"}`+"\n", string(kept))
+	deadLetters, _ := os.ReadFile(deadLetterPath)
+	assert.Contains(t, string(deadLetters), `"message":"mapping"`)
+
+	// Assert that there is nothing to replay without a file, nor without ElasticSearch.
+	assert.NoError(t, os.Remove(fallbackPath))
+	progress, err = p.ReplayFallback(context.Background(), ReplayOptions{})
+	assert.NoError(t, err)
+	assert.Zero(t, progress)
+	config = Config{StdoutOnly: true, FallbackPath: fallbackPath}
+	_, err = p.ReplayFallback(context.Background(), ReplayOptions{})
+	assert.Error(t, err)
+}
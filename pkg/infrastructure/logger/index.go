@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"fmt"
+	"github.com/christiandoxa/welog/pkg/constant/envkey"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sync"
+)
+
+// defaultIndexDateLayout is the date layout of the index names unless the environment sets one.
+const defaultIndexDateLayout = "2006-01-02"
+
+var (
+	indexName      func(*logrus.Entry) string // Index name function set by SetIndexNameFunc
+	indexNameMutex sync.RWMutex               // Protects access to indexName
+)
+
+// SetIndexNameFunc makes fn name the ElasticSearch index of every entry, e.g. to route
+// entries by month, level, or tenant. fn may call DefaultIndexName to build upon the
+// default name. Nil restores DefaultIndexName.
+func SetIndexNameFunc(fn func(*logrus.Entry) string) {
+	indexNameMutex.Lock()
+	defer indexNameMutex.Unlock()
+
+	indexName = fn
+}
+
+// indexNameFunc returns the index of the entry, named by the function set with
+// SetIndexNameFunc or else by DefaultIndexName.
+func indexNameFunc(entry *logrus.Entry) string {
+	indexNameMutex.RLock()
+	fn := indexName
+	indexNameMutex.RUnlock()
+
+	if fn != nil {
+		return fn(entry)
+	}
+	return DefaultIndexName(entry)
+}
+
+// DefaultIndexName generates the index name for ElasticSearch by concatenating the
+// environment-specific index prefix, or the prefix of the entry's category if one is
+// set with SetCategoryIndex, and the date of the entry in the environment's date layout,
+// YYYY-MM-DD by default. Entries without a time get the current date.
+func DefaultIndexName(entry *logrus.Entry) string {
+	return indexNameOf(os.Getenv(envkey.ElasticIndex), os.Getenv(envkey.ElasticIndexDateLayout), entry)
+}
+
+// indexNameOf concatenates prefix, or the prefix of the entry's category if one is set
+// with SetCategoryIndex, and the date of the entry, or else the current date, in layout,
+// YYYY-MM-DD if empty. The date of the entry puts replayed entries in the index of their day.
+func indexNameOf(prefix, layout string, entry *logrus.Entry) string {
+	if category := categoryIndex(categoryOf(entry)); category != "" {
+		prefix = category
+	}
+	if layout == "" {
+		layout = defaultIndexDateLayout
+	}
+	date := entry.Time
+	if date.IsZero() {
+		date = Now()
+	}
+
+	return fmt.Sprint(prefix, "-", date.Format(layout))
+}
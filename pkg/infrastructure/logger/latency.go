@@ -0,0 +1,64 @@
+package logger
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is the number of recent writes the percentiles are computed from.
+const latencyWindow = 64
+
+// latencyTracker keeps the latencies of the most recent writes of a sink.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencyWindow]time.Duration
+	count   int // Number of samples recorded, capped at latencyWindow
+	next    int // Index the next sample is written to
+}
+
+// add records the latency of a write.
+func (t *latencyTracker) add(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % latencyWindow
+	if t.count < latencyWindow {
+		t.count++
+	}
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of the recorded latencies and
+// whether the window is full. Percentiles of a partial window aren't representative.
+func (t *latencyTracker) percentile(p float64) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.count == 0 {
+		return 0, false
+	}
+
+	sorted := make([]time.Duration, t.count)
+	copy(sorted, t.samples[:t.count])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	index := int(p*float64(t.count)+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= t.count {
+		index = t.count - 1
+	}
+
+	return sorted[index], t.count == latencyWindow
+}
+
+// reset discards all recorded latencies.
+func (t *latencyTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.count = 0
+	t.next = 0
+}
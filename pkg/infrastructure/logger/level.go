@@ -0,0 +1,38 @@
+package logger
+
+import (
+	"github.com/christiandoxa/welog/pkg/constant/envkey"
+	"os"
+	"sync"
+)
+
+var (
+	logLevel      string       // Minimum level of the entries shipped, set by SetLogLevel
+	logLevelMutex sync.RWMutex // Protects access to logLevel
+)
+
+// SetLogLevel sets the minimum level of the entries shipped to ElasticSearch, such as "info".
+// Empty restores the level of the LOG_LEVEL environment variable, which is never overwritten,
+// so operators keep control of it. If the logger is already initialized, it applies at once.
+func SetLogLevel(level string) {
+	logLevelMutex.Lock()
+	changed := level != logLevel
+	logLevel = level
+	logLevelMutex.Unlock()
+
+	if changed {
+		defaultPipeline.reformat()
+	}
+}
+
+// injectedLogLevel returns the level set with SetLogLevel, or else the LOG_LEVEL environment
+// variable.
+func injectedLogLevel() string {
+	logLevelMutex.RLock()
+	defer logLevelMutex.RUnlock()
+
+	if logLevel != "" {
+		return logLevel
+	}
+	return os.Getenv(envkey.LogLevel)
+}
@@ -4,209 +4,401 @@
 package logger
 
 import (
+	"context"
 	"fmt"
-	"github.com/christiandoxa/welog/pkg/constant/envkey"
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/goccy/go-json"
 	"github.com/sirupsen/logrus"
-	"go.elastic.co/ecslogrus"
-	"gopkg.in/go-extras/elogrus.v8"
-	"net/url"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	minReconnectBackoff     = time.Second      // Delay before retrying a failed reconnection
+	maxReconnectBackoff     = time.Minute      // Upper bound of the delay between reconnection attempts
+	defaultFailbackInterval = 30 * time.Second // Delay between checks of the primary cluster while the secondary one is used
+)
+
+// Pipeline is a logrus logger together with the hook shipping its entries to ElasticSearch
+// and the monitor reconnecting the hook when the connection is lost, to the secondary cluster
+// if the primary one is unreachable. It owns its background goroutines, the monitor and the
+// hook's worker, which exit when the pipeline is closed.
+//
+// Logger returns the logger of the default pipeline, configured by welog.SetConfig. New
+// creates isolated pipelines, e.g. to ship to several clusters from one binary. Categories,
+// budgets, the memory budget, metadata, and subscribers are shared by all pipelines.
+type Pipeline struct {
+	config func() Config // Returns the configuration, read anew on every connection
+
+	log atomic.Pointer[logrus.Logger] // Logger of the pipeline, nil until started
+
+	mu          sync.Mutex            // Protects access to the fields below
+	client      *elasticsearch.Client // ElasticSearch client for sending log data
+	hook        *elasticHook          // Hook shipping entries to ElasticSearch
+	injected    *elasticsearch.Client // Client set with setClient, used instead of building one
+	closed      bool                  // Set by close to keep the monitor from installing a new hook
+	onSecondary bool                  // Set while the hook ships to the secondary cluster
+
+	reconnects chan struct{}      // Reconnection requests for the monitor
+	ctx        context.Context    // Cancelled by Close to stop the monitor and its pings
+	cancel     context.CancelFunc // Cancels ctx
+	done       chan struct{}      // Closed when the monitor has exited
+}
+
 var (
-	client   *elasticsearch.Client // ElasticSearch client for sending log data
-	instance *logrus.Logger        // Singleton instance of the logger
-	once     sync.Once             // Ensures the logger is initialized only once
-	mutex    sync.Mutex            // Protects access to the logger instance and client
+	defaultPipeline = newPipeline(configFromEnv) // Pipeline behind Logger
+	once            sync.Once                    // Ensures the default pipeline is started only once
 )
 
-// ecsLogMessageModifierFunc returns a function that modifies log messages
-// using the ECS log formatter. If an error occurs during formatting, the original
-// log entry is preserved.
-func ecsLogMessageModifierFunc(formatter *ecslogrus.Formatter) func(*logrus.Entry, *elogrus.Message) any {
-	return func(entry *logrus.Entry, _ *elogrus.Message) any {
-		var data json.RawMessage
+// New creates and starts a pipeline shipping to the ElasticSearch cluster of config. If the
+// cluster is unavailable, the pipeline logs to stderr until it reconnects. Call Close to flush
+// the pipeline and stop its goroutines.
+func New(config Config) *Pipeline {
+	p := newPipeline(func() Config { return config })
+	p.start()
 
-		data, err := formatter.Format(entry)
-		if err != nil {
-			return entry // in case of an error just preserve the original entry
-		}
+	return p
+}
 
-		return data
+// newPipeline creates a pipeline that is not started yet.
+func newPipeline(config func() Config) *Pipeline {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Pipeline{
+		config:     config,
+		reconnects: make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
 	}
 }
 
-// indexNameFunc generates the index name for ElasticSearch by concatenating the
-// environment-specific index prefix and the current date in YYYY-MM-DD format.
-func indexNameFunc() string {
-	return fmt.Sprint(os.Getenv(envkey.ElasticIndex), "-", time.Now().Format("2006-01-02"))
+// SetClient makes the logger ship entries with c instead of a client built from the connection
+// settings in the environment, so applications can reuse a client with their own transport,
+// proxy, or instrumentation. If the logger is already initialized, it switches to c at once.
+// Nil restores the client built from the environment.
+func SetClient(c *elasticsearch.Client) {
+	defaultPipeline.setClient(c)
 }
 
-// logger initializes and configures a new instance of the logrus.Logger. It sets up
-// the logger with ECS formatting and integrates it with ElasticSearch for centralized logging.
-func logger() *logrus.Logger {
-	log := logrus.New()
-	log.SetFormatter(&ecslogrus.Formatter{})
-	log.SetReportCaller(true)
+// setClient makes the pipeline ship entries with c, see SetClient.
+func (p *Pipeline) setClient(c *elasticsearch.Client) {
+	p.mu.Lock()
+	changed := c != p.injected
+	p.injected = c
+	started := p.log.Load() != nil
+	p.mu.Unlock()
+
+	if changed && started {
+		if err := p.reconnect(); err != nil {
+			p.requestReconnect()
+		}
+	}
+}
+
+// currentClient returns the client set with setClient, or else the client of the
+// pipeline's configuration.
+func (p *Pipeline) currentClient() (*elasticsearch.Client, error) {
+	p.mu.Lock()
+	injected := p.injected
+	p.mu.Unlock()
 
-	elasticURL := os.Getenv(envkey.ElasticURL)
-	if elasticURL == "" {
-		log.Error("ElasticURL is not set")
-		return log
+	if injected != nil {
+		return injected, nil
 	}
+	return newClient(p.config())
+}
 
-	c, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{elasticURL},
-		Username:  os.Getenv(envkey.ElasticUsername),
-		Password:  os.Getenv(envkey.ElasticPassword),
-	})
+// Ping checks that ElasticSearch is reachable with the client set with SetClient or the
+// connection settings in the environment, and accepts the credentials. Unlike Logger, which
+// degrades to its output when ElasticSearch is unavailable, it reports the problem to the caller.
+func Ping(ctx context.Context) error {
+	return defaultPipeline.Ping(ctx)
+}
 
+// Ping checks that the cluster of the pipeline is reachable and accepts the credentials.
+func (p *Pipeline) Ping(ctx context.Context) error {
+	c, err := p.currentClient()
 	if err != nil {
-		log.Error(err)
-		return log
+		return err
 	}
 
-	res, err := c.Ping()
+	return ping(ctx, c)
+}
+
+// ping checks that the cluster of c is reachable and accepts the credentials.
+func ping(ctx context.Context, c *elasticsearch.Client) error {
+	res, err := c.Ping(c.Ping.WithContext(ctx))
 	if err != nil {
-		log.Error(err)
-		return log
+		return fmt.Errorf("elasticsearch is unreachable: %w", err)
 	}
-	if res != nil {
-		err = res.Body.Close()
-		if err != nil {
-			log.Error(err)
-			return log
-		}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
 	}
 
-	client = c
+	return nil
+}
+
+// start initializes the logger of the pipeline with ECS formatting, connects it to
+// ElasticSearch for centralized logging, and starts the monitor. If ElasticSearch is
+// unavailable, the logger writes to its output until the monitor reconnects.
+func (p *Pipeline) start() {
+	config := p.config()
 
-	// Parse URL
-	parsedURL, err := url.Parse(elasticURL)
-	if err != nil {
-		log.Error(err)
-		return log
-	}
+	log := logrus.New()
+	log.SetFormatter(processedFormatter{config.newFormatter(), config.OutputFilter})
+	log.SetReportCaller(true)
+	log.Hooks.Add(clocks)
+	log.Hooks.Add(metadata)
+	log.Hooks.Add(processors)
+	log.Hooks.Add(subscribers)
 
-	// Parse hostname
-	host := parsedURL.Hostname()
+	p.log.Store(log)
+	p.track()
 
-	hook, err := elogrus.NewElasticHookWithFunc(client, host, logrus.TraceLevel, indexNameFunc)
-	if err != nil {
-		log.Error(err)
-		return log
-	}
+	go p.monitor()
 
-	hook.MessageModifierFunc = ecsLogMessageModifierFunc(&ecslogrus.Formatter{})
-	log.Hooks.Add(hook)
+	if config.local() {
+		log.SetOutput(os.Stdout)
+		return
+	}
 
-	return log
+	if err := p.reconnect(); err != nil {
+		log.Error(err)
+		p.requestReconnect() // ElasticSearch was unavailable, keep trying in the background
+	}
 }
 
-// monitorConnection starts a goroutine that periodically checks the connection to ElasticSearch.
-// If the connection is lost, it re-initializes the ElasticSearch client and hooks.
-// This ensures that even if the ElasticSearch instance is restarted, the application
-// will continue to log to ElasticSearch once the connection is re-established.
-func monitorConnection() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+// monitor reconnects to ElasticSearch whenever a reconnection is requested, i.e. after
+// consecutive failed writes or when the pipeline couldn't connect at startup. Failed
+// attempts are retried with an exponential backoff until one succeeds, so the application
+// resumes logging to ElasticSearch once the cluster is back. While the secondary cluster is
+// used, it fails back to the primary one once it is reachable again. It returns when the
+// pipeline is closed.
+func (p *Pipeline) monitor() {
+	defer close(p.done)
 
 	for {
 		select {
-		case <-ticker.C:
-			mutex.Lock()
-			if client != nil {
-				_, err := client.Ping()
-				if err != nil {
-					// Re-initialize the client and hooks
-					reinitializeLogger(instance)
-				}
-			} else {
-				reinitializeLogger(instance)
+		case <-p.ctx.Done():
+			return
+		case <-p.reconnects:
+		case <-time.After(p.config().failbackInterval()):
+			p.failback()
+			continue
+		}
+
+		for backoff := minReconnectBackoff; ; backoff = min(2*backoff, maxReconnectBackoff) {
+			reconnects.Add(1)
+			err := p.reconnect()
+			if err == nil {
+				break
+			}
+			reconnectErrors.Add(1)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to reconnect to elasticsearch, retrying in %s: %v\n", backoff, err)
+
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(backoff):
 			}
-			mutex.Unlock()
 		}
 	}
 }
 
-// reinitializeLogger reinitialize the ElasticSearch client and logger if the connection
-// to ElasticSearch is lost. This function is used by the connection monitoring goroutine.
-// It pings the ElasticSearch server and reinitialize the logger if the connection is
-// successful.
-func reinitializeLogger(log *logrus.Logger) {
-	elasticURL := os.Getenv(envkey.ElasticURL)
-	if elasticURL == "" {
-		log.Error("ElasticURL is not set")
-		return
+// requestReconnect asks the monitor to reconnect to ElasticSearch. It never blocks, and
+// requests made while one is pending are merged.
+func (p *Pipeline) requestReconnect() {
+	select {
+	case p.reconnects <- struct{}{}:
+	default:
 	}
+}
 
-	c, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{elasticURL},
-		Username:  os.Getenv(envkey.ElasticUsername),
-		Password:  os.Getenv(envkey.ElasticPassword),
-	})
+// reconnect creates a client and checks that ElasticSearch is reachable, or else the
+// secondary cluster if there is one, then installs a new hook shipping to it in place of the
+// previous one. The mutex isn't held while the clusters are contacted, so logging isn't
+// blocked by an unreachable cluster.
+func (p *Pipeline) reconnect() error {
+	config := p.config()
 
-	if err != nil {
-		log.Error(err)
-		return
+	c, err := p.currentClient()
+	if err == nil {
+		err = ping(p.ctx, c)
 	}
 
-	res, err := c.Ping()
-	if err != nil {
-		log.Error(err)
-		return
-	}
-	if res != nil {
-		err = res.Body.Close()
-		if err != nil {
-			log.Error(err)
-			return
+	secondary := err != nil && config.hasSecondary()
+	if secondary {
+		var secondaryErr error
+		if c, secondaryErr = newClient(config.secondary()); secondaryErr == nil {
+			secondaryErr = ping(p.ctx, c)
 		}
+		if secondaryErr != nil {
+			return fmt.Errorf("%w, secondary cluster: %w", err, secondaryErr)
+		}
+	} else if err != nil {
+		return err
 	}
 
-	client = c
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	// Remove all existing hooks
-	log.ReplaceHooks(make(logrus.LevelHooks))
+	if p.closed || p.log.Load() == nil || config.local() {
+		return nil
+	}
+	if secondary && !p.onSecondary {
+		failovers.Add(1)
+		_, _ = fmt.Fprintf(os.Stderr, "Failing over to the secondary elasticsearch cluster: %v\n", err)
+	}
+	p.installHook(c, config)
+	p.onSecondary = secondary
 
-	// Parse URL
-	parsedURL, err := url.Parse(elasticURL)
-	if err != nil {
-		log.Error(err)
+	return nil
+}
+
+// failback installs a hook shipping to the primary cluster if the secondary one is used and the
+// primary one is reachable again. The hook of the secondary cluster is drained rather than
+// aborted, as its cluster is still up, for up to ExitTimeout. The hooks are swapped under the
+// mutex and the drain runs after it is released, so the pipeline isn't blocked meanwhile; the
+// new hook takes over the spool of the drained one, so the spool directory is never opened twice.
+func (p *Pipeline) failback() {
+	p.mu.Lock()
+	onSecondary := p.onSecondary
+	p.mu.Unlock()
+	if !onSecondary {
 		return
 	}
 
-	// Parse hostname
-	host := parsedURL.Hostname()
+	c, err := p.currentClient()
+	if err != nil || ping(p.ctx, c) != nil {
+		return
+	}
 
-	hook, err := elogrus.NewElasticHookWithFunc(client, host, logrus.TraceLevel, indexNameFunc)
-	if err != nil {
-		log.Error(err)
+	config := p.config()
+
+	p.mu.Lock()
+	if p.closed || !p.onSecondary || p.hook == nil {
+		p.mu.Unlock()
 		return
 	}
+	_, _ = fmt.Fprintln(os.Stderr, "Failing back to the primary elasticsearch cluster")
+	p.client = c
+	previous := p.hook
+	p.hook = p.newHook(c, config, previous.passSpool())
+	replaceHook(p.log.Load(), previous, p.hook)
+	p.onSecondary = false
+	p.mu.Unlock()
+
+	// Close cancels p.ctx, which cuts the drain short before the new hook closes the spool.
+	ctx, cancel := context.WithTimeout(p.ctx, ExitTimeout)
+	_ = previous.drain(ctx)
+	cancel()
+}
 
-	hook.MessageModifierFunc = ecsLogMessageModifierFunc(&ecslogrus.Formatter{})
-	log.Hooks.Add(hook)
+// installHook replaces the ElasticSearch hook of the logger with a new one shipping to c, leaving
+// its other hooks in place, and aborts the writes of the previous one, whose cluster is gone or
+// replaced, handing the entries left in its queue over to the new hook. The previous hook is
+// stopped before the new one is created, so their spools never share the spool directory. The
+// caller must hold the mutex.
+func (p *Pipeline) installHook(c *elasticsearch.Client, config Config) {
+	p.client = c
+
+	log := p.log.Load()
+	var left []document
+	if p.hook != nil {
+		replaceHook(log, p.hook, nil)
+		left = p.hook.handover()
+	}
+
+	p.hook = p.newHook(c, config, nil)
+	p.hook.adopt(left)
+	log.Hooks.Add(p.hook)
 }
 
-// Logger returns the singleton instance of the logrus.Logger. It initializes the logger
-// on the first call and starts a background goroutine to monitor the ElasticSearch connection.
-func Logger() *logrus.Logger {
-	once.Do(func() {
-		mutex.Lock()
-		defer mutex.Unlock()
+// newHook creates an ElasticSearch hook shipping to c, with the given spool if not nil.
+func (p *Pipeline) newHook(c *elasticsearch.Client, config Config, spool *spool) *elasticHook {
+	opts := config.hookOptions()
+	opts.onFailures = p.requestReconnect
+	opts.spool = spool
+
+	return newElasticHook(c, config.elasticFormatter(), config.indexName(), opts)
+}
+
+// replaceHook replaces h with replacement in the hooks of log at once, or removes it if
+// replacement is nil, leaving the others in place, e.g. the metadata hooks and those added by
+// the application.
+func replaceHook(log *logrus.Logger, h, replacement logrus.Hook) {
+	hooks := make(logrus.LevelHooks)
+	for level, levelHooks := range log.Hooks {
+		for _, hook := range levelHooks {
+			if hook != h {
+				hooks[level] = append(hooks[level], hook)
+			}
+		}
+	}
+	if replacement != nil {
+		hooks.Add(replacement)
+	}
+	log.ReplaceHooks(hooks)
+}
+
+// Close flushes the entries buffered for ElasticSearch and stops the hook and the monitor
+// reconnecting to ElasticSearch. It waits until the buffer is drained or ctx is done; in
+// the latter case in-flight writes are cancelled, the remaining entries are discarded, and
+// the context's error is returned. Entries logged after Close are only written to the
+// logger's output.
+func Close(ctx context.Context) error {
+	return defaultPipeline.Close(ctx)
+}
 
-		instance = logger()
+// Close stops the pipeline the same way as the package-level Close. Once it returns, the
+// pipeline has no goroutine left.
+func (p *Pipeline) Close(ctx context.Context) error {
+	return p.shutdown(ctx, (*elasticHook).close)
+}
+
+// shutdown stops the pipeline, then stops its hook with stop.
+func (p *Pipeline) shutdown(ctx context.Context, stop func(*elasticHook, context.Context) error) error {
+	p.untrack()
+
+	p.mu.Lock()
+	p.closed = true
+	p.cancel()
+	started := p.log.Load() != nil
+	h := p.hook
+	p.hook = nil
+	if h != nil {
+		replaceHook(p.log.Load(), h, nil)
+	}
+	p.mu.Unlock()
 
-		go monitorConnection() // Start the connection monitoring in a separate goroutine
-	})
+	if started {
+		<-p.done
+	}
+	if h == nil {
+		return nil
+	}
 
-	mutex.Lock()
-	defer mutex.Unlock()
+	return stop(h, ctx)
+}
+
+// Logger returns the logger of the pipeline. It doesn't lock, so it is cheap enough to
+// call on every log statement.
+func (p *Pipeline) Logger() *logrus.Logger {
+	return p.log.Load()
+}
+
+// Logger returns the singleton instance of the logrus.Logger. It initializes the logger
+// on the first call and starts a background goroutine reconnecting to ElasticSearch when
+// the connection is lost. Later calls take no lock, as reconnections replace the hooks of
+// the logger rather than the logger itself.
+func Logger() *logrus.Logger {
+	once.Do(defaultPipeline.start)
 
-	return instance
+	return defaultPipeline.Logger()
 }
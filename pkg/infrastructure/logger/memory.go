@@ -0,0 +1,42 @@
+package logger
+
+import "sync/atomic"
+
+var (
+	memoryLimit atomic.Int64 // Bytes welog may hold in buffers, zero if unlimited
+	memoryUsed  atomic.Int64 // Bytes currently held in buffers
+)
+
+// SetMemoryBudget caps the memory held by welog's buffers, such as queued entries and
+// captured bodies, to limit bytes. A non-positive limit removes the cap.
+func SetMemoryBudget(limit int64) {
+	memoryLimit.Store(max(limit, 0))
+}
+
+// ReserveMemory accounts for n bytes about to be buffered. It returns false, reserving
+// nothing, if the bytes don't fit into the memory budget; the caller must then drop or
+// truncate the data. Every successful reservation must be released with ReleaseMemory.
+func ReserveMemory(n int64) bool {
+	used := memoryUsed.Add(n)
+	if limit := memoryLimit.Load(); limit > 0 && used > limit {
+		memoryUsed.Add(-n)
+		return false
+	}
+	return true
+}
+
+// ClaimMemory accounts for n bytes buffered whatever the memory budget, e.g. the bodies of a
+// request that must be logged in full. It must be released with ReleaseMemory like a reservation.
+func ClaimMemory(n int64) {
+	memoryUsed.Add(n)
+}
+
+// ReleaseMemory returns n bytes reserved with ReserveMemory or ClaimMemory to the budget.
+func ReleaseMemory(n int64) {
+	memoryUsed.Add(-n)
+}
+
+// MemoryInUse returns the bytes currently held in welog's buffers.
+func MemoryInUse() int64 {
+	return memoryUsed.Load()
+}
@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// metadataHook is a logrus hook stamping static fields, such as the service name, onto
+// every entry. It is installed right after clockHook, before the other hooks, so they see the
+// fields too.
+type metadataHook struct {
+	mu     sync.RWMutex
+	fields logrus.Fields
+}
+
+// metadata is the hook installed on the logger by logger and installHook.
+var metadata = &metadataHook{}
+
+// Levels returns all log levels, so every entry is stamped.
+func (h *metadataHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire adds the static fields the entry doesn't already have.
+func (h *metadataHook) Fire(entry *logrus.Entry) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for key, value := range h.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+
+	return nil
+}
+
+// SetMetadata replaces the fields stamped onto every entry logged through Logger. Fields
+// set on an entry take precedence. Nil or empty fields stamp nothing.
+func SetMetadata(fields logrus.Fields) {
+	copied := make(logrus.Fields, len(fields))
+	for key, value := range fields {
+		copied[key] = value
+	}
+
+	metadata.mu.Lock()
+	defer metadata.mu.Unlock()
+
+	metadata.fields = copied
+}
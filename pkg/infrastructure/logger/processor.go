@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// Processor transforms an entry before it reaches the output, the subscribers, and
+// ElasticSearch, e.g. to redact, enrich, or rename fields. It may modify the entry in place
+// and return it, or return a derived entry, such as entry.WithField("team", "billing"), whose
+// fields replace those of the entry. Returning nil drops the entry. It is called concurrently
+// and must therefore be safe for concurrent use.
+type Processor func(entry *logrus.Entry) *logrus.Entry
+
+// droppedKey is the context key marking the entries dropped by a Processor.
+type droppedKey struct{}
+
+// processorHook is a logrus hook running the processors set with SetProcessors. It is
+// installed after metadataHook, so the processors see the static fields, and before the
+// other hooks, so they only see processed entries.
+type processorHook struct {
+	mu         sync.RWMutex
+	processors []Processor
+}
+
+// processors is the hook installed on the logger by start and installHook.
+var processors = &processorHook{}
+
+// SetProcessors replaces the processors run, in order, on every entry logged through Logger.
+// An entry dropped by a processor isn't passed to the next ones. No processors run by default.
+func SetProcessors(chain ...Processor) {
+	processors.mu.Lock()
+	defer processors.mu.Unlock()
+
+	processors.processors = append([]Processor(nil), chain...)
+}
+
+// Levels returns all log levels, so every entry is processed.
+func (h *processorHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire runs the processors on the entry, marking it as dropped if one of them returns nil.
+func (h *processorHook) Fire(entry *logrus.Entry) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, process := range h.processors {
+		result := process(entry)
+		if result == nil {
+			ctx := entry.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			entry.Context = context.WithValue(ctx, droppedKey{}, true)
+			return nil
+		}
+		if result != entry {
+			entry.Data = result.Data
+		}
+	}
+
+	return nil
+}
+
+// isDropped reports whether a Processor dropped the entry.
+func isDropped(entry *logrus.Entry) bool {
+	if entry.Context == nil {
+		return false
+	}
+	dropped, _ := entry.Context.Value(droppedKey{}).(bool)
+	return dropped
+}
+
+// processedFormatter wraps the formatter of the output of the logger, leaving out the entries
+// dropped by a Processor and those, or the fields, rejected by the output's SinkFilter.
+type processedFormatter struct {
+	logrus.Formatter
+	filter SinkFilter
+}
+
+// Format formats the entry with the wrapped formatter, unless it was dropped or filtered out.
+func (f processedFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if isDropped(entry) || !f.filter.admits(entry.Level) {
+		return nil, nil
+	}
+	return f.Formatter.Format(f.filter.apply(entry))
+}
@@ -0,0 +1,290 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultReplayBatchSize is the number of entries written by one bulk request of ReplayFallback
+// when none is configured.
+const defaultReplayBatchSize = 500
+
+// ecsTimestampLayout is the layout of the timestamps written by ecslogrus.
+const ecsTimestampLayout = "2006-01-02T15:04:05.000Z0700"
+
+// replayingSuffix is appended to the name of the fallback file while it is being replayed.
+const replayingSuffix = ".replaying"
+
+// replayMutex keeps replays of the same process from running concurrently.
+var replayMutex sync.Mutex
+
+// ReplayOptions configures ReplayFallback. The zero value selects the defaults.
+type ReplayOptions struct {
+	// BatchSize is the number of entries written by one bulk request, 500 if zero.
+	BatchSize int
+
+	// Progress, if set, is called after every bulk request with the progress so far.
+	Progress func(ReplayProgress)
+}
+
+// ReplayProgress reports how far ReplayFallback went through the fallback file.
+type ReplayProgress struct {
+	Replayed   int   // Entries indexed
+	Duplicates int   // Entries already in ElasticSearch, or repeated in the file
+	Failed     int   // Entries rejected, written back to the fallback file or to the dead-letter file
+	ReadBytes  int64 // Bytes of the file replayed so far
+	TotalBytes int64 // Size of the file
+}
+
+// ReplayFallback ships the fallback file of the default pipeline to ElasticSearch, see
+// Pipeline.ReplayFallback.
+func ReplayFallback(ctx context.Context, opts ReplayOptions) (ReplayProgress, error) {
+	return defaultPipeline.ReplayFallback(ctx, opts)
+}
+
+// ReplayFallback ships the entries of the fallback file to the cluster of the pipeline with the
+// bulk API, e.g. once it is back after an outage, and removes them from the file. The file is
+// first renamed with a ".replaying" suffix, so the hook keeps writing new entries to a fresh
+// file meanwhile. Every entry is created in the index and with the document ID the hook
+// recorded in the file, so entries repeated in the file, or already indexed by the hook or an
+// earlier replay, are counted as duplicates instead of being indexed twice. Entries recorded
+// without an ID, e.g. by earlier versions, get one from ElasticSearch instead, so they are
+// never mistaken for one another, but may be indexed twice by a resumed replay. Entries the
+// cluster rejects for good go to the dead-letter file, and every other entry that isn't indexed
+// is written back to the fallback file for the next replay.
+//
+// If the replay fails or ctx is done, the renamed file is left in place and the next replay
+// resumes it, skipping the entries already indexed; the entries written since then are replayed
+// by the call after. Under StdoutOnly or Development, there is nothing to replay to.
+func (p *Pipeline) ReplayFallback(ctx context.Context, opts ReplayOptions) (ReplayProgress, error) {
+	config := p.config()
+	if config.local() {
+		return ReplayProgress{}, errors.New("elasticsearch is disabled, the fallback file can't be replayed")
+	}
+
+	c, err := p.currentClient()
+	if err != nil {
+		return ReplayProgress{}, err
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultReplayBatchSize
+	}
+
+	r := &replayer{client: c, index: config.indexName(), hook: config.hookOptions().withDefaults(), opts: opts}
+	return r.run(ctx)
+}
+
+// replayer ships a fallback file to ElasticSearch, see Pipeline.ReplayFallback.
+type replayer struct {
+	client *elasticsearch.Client
+	index  func(*logrus.Entry) string
+	hook   hookOptions
+	opts   ReplayOptions
+
+	progress ReplayProgress
+	seen     map[string]struct{} // Document IDs of the entries read so far
+	pending  []document          // Documents waiting for the next bulk request
+	retry    [][]byte            // Entries to write back to the fallback file
+}
+
+// run replays the fallback file, resuming an interrupted replay if there is one.
+func (r *replayer) run(ctx context.Context) (ReplayProgress, error) {
+	replayMutex.Lock()
+	defer replayMutex.Unlock()
+
+	path, err := claimFallback(r.hook.fallbackPath)
+	if err != nil || path == "" {
+		return r.progress, err
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		r.progress.TotalBytes = info.Size()
+	}
+
+	r.seen = make(map[string]struct{})
+	err = scanFallback(ctx, path, func(_ int, data []byte, offset int64) error {
+		r.add(data)
+		if len(r.pending) < r.opts.BatchSize {
+			return nil
+		}
+		return r.flush(ctx, offset)
+	})
+	if err == nil {
+		err = r.flush(ctx, r.progress.TotalBytes)
+	}
+	if err != nil {
+		return r.progress, err
+	}
+
+	if len(r.retry) > 0 {
+		if err = appendFallback(r.hook.fallbackPath, bytes.Join(r.retry, nil)); err != nil {
+			return r.progress, err
+		}
+	}
+	return r.progress, os.Remove(path)
+}
+
+// claimFallback renames the fallback file at path for the replay and returns its new name, or
+// the name of the file left by an interrupted replay. It returns an empty name if there is
+// nothing to replay.
+func claimFallback(path string) (string, error) {
+	fallbackMutex.Lock()
+	defer fallbackMutex.Unlock()
+
+	replaying := path + replayingSuffix
+	if _, err := os.Stat(replaying); err == nil {
+		return replaying, nil
+	}
+
+	if err := os.Rename(path, replaying); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return replaying, nil
+}
+
+// add queues an entry of the fallback file for the next bulk request, unless an entry with the
+// same document ID was read before. Lines that aren't JSON objects, written by earlier versions,
+// are replayed as the message of an ECS document.
+func (r *replayer) add(data []byte) {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(data, &raw) != nil || raw == nil {
+		data = bytes.TrimSpace(fallbackRecord(document{data: data}))
+		_ = json.Unmarshal(data, &raw)
+	}
+
+	var doc document
+	_ = json.Unmarshal(raw[fallbackIndexKey], &doc.index)
+	_ = json.Unmarshal(raw[fallbackIDKey], &doc.id)
+	delete(raw, fallbackIndexKey)
+	delete(raw, fallbackIDKey)
+	doc.data, _ = json.Marshal(raw)
+	if doc.index == "" {
+		doc.index = r.index(recordEntry(doc.data))
+	}
+
+	if doc.id != "" {
+		if _, ok := r.seen[doc.id]; ok {
+			r.progress.Duplicates++
+			return
+		}
+		r.seen[doc.id] = struct{}{}
+	}
+
+	r.pending = append(r.pending, doc)
+}
+
+// recordEntry rebuilds the entry of a record of the fallback file written without its index,
+// so the index can be named again, in the day of the entry.
+func recordEntry(data []byte) *logrus.Entry {
+	var fields logrus.Fields
+	_ = json.Unmarshal(data, &fields)
+
+	entry := &logrus.Entry{Data: fields, Level: logrus.InfoLevel}
+	entry.Message, _ = fields["message"].(string)
+	if timestamp, ok := fields["@timestamp"].(string); ok {
+		for _, layout := range []string{ecsTimestampLayout, time.RFC3339Nano} {
+			if parsed, err := time.Parse(layout, timestamp); err == nil {
+				entry.Time = parsed
+				break
+			}
+		}
+	}
+	if level, ok := fields["log.level"].(string); ok {
+		if parsed, err := logrus.ParseLevel(level); err == nil {
+			entry.Level = parsed
+		}
+	}
+	return entry
+}
+
+// flush creates the pending documents with one bulk request and reports the progress, offset
+// being the bytes of the file read so far. Unlike the hook, it uses the create action, so the
+// documents already indexed are rejected as conflicts rather than overwritten. It returns an
+// error if the request as a whole failed.
+func (r *replayer) flush(ctx context.Context, offset int64) error {
+	if len(r.pending) > 0 {
+		if err := r.write(ctx); err != nil {
+			return err
+		}
+	}
+
+	r.progress.ReadBytes = offset
+	if r.opts.Progress != nil {
+		r.opts.Progress(r.progress)
+	}
+	return nil
+}
+
+// write sends the pending documents with the configured deadline and sorts out their results.
+func (r *replayer) write(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.hook.timeout)
+	defer cancel()
+
+	var body bytes.Buffer
+	for _, doc := range r.pending {
+		meta := map[string]string{"_index": doc.index}
+		if doc.id != "" {
+			meta["_id"] = doc.id
+		}
+		action, _ := json.Marshal(map[string]map[string]string{"create": meta})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(doc.data)
+		body.WriteByte('\n')
+	}
+
+	res, err := esapi.BulkRequest{Body: &body, Pipeline: r.hook.pipeline}.Do(ctx, r.client)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	var parsed bulkResponse
+	if err = json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return fmt.Errorf("decoding the bulk response: %w", err)
+	}
+
+	for i, doc := range r.pending {
+		status := http.StatusCreated
+		var reason json.RawMessage
+		if i < len(parsed.Items) {
+			for _, result := range parsed.Items[i] {
+				status, reason = result.Status, result.Error
+			}
+		}
+
+		switch {
+		case status < 300:
+			r.progress.Replayed++
+		case status == http.StatusConflict:
+			r.progress.Duplicates++
+		case permanent(status) && appendDeadLetter(r.hook.deadLetterPath, status, reason, doc.data) == nil:
+			r.progress.Failed++
+		default:
+			r.progress.Failed++
+			r.retry = append(r.retry, fallbackRecord(doc))
+		}
+	}
+
+	r.pending = r.pending[:0]
+	return nil
+}
@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"path"
+	"slices"
+	"sync"
+)
+
+// SinkFilter selects what a sink receives, e.g. everything in the output but only info and
+// above, without bodies, in ElasticSearch. Fields and OmitFields hold patterns matched against
+// the top-level field names with path.Match, such as "requestBody" or "*BodyString". The zero
+// value passes everything.
+type SinkFilter struct {
+	// Level is the minimum level of the entries received, such as "info". Empty or invalid
+	// passes every level.
+	Level string
+
+	// Fields lists the fields kept, every field if empty.
+	Fields []string
+
+	// OmitFields lists the fields left out, including those kept by Fields.
+	OmitFields []string
+}
+
+var (
+	outputFilter  SinkFilter   // Filter of the output, set by SetSinkFilters
+	elasticFilter SinkFilter   // Filter of ElasticSearch, set by SetSinkFilters
+	filterMutex   sync.RWMutex // Protects access to outputFilter and elasticFilter
+)
+
+// SetSinkFilters sets the filters of the logger's output and of the documents shipped to
+// ElasticSearch. If the logger is already initialized, they apply at once. The subscribers
+// choose their own filter with SubscribeFiltered.
+func SetSinkFilters(output, elastic SinkFilter) {
+	filterMutex.Lock()
+	changed := !output.equal(outputFilter) || !elastic.equal(elasticFilter)
+	outputFilter, elasticFilter = output, elastic
+	filterMutex.Unlock()
+
+	if changed {
+		defaultPipeline.reformat()
+	}
+}
+
+// SinkFilters returns the filters set with SetSinkFilters.
+func SinkFilters() (output, elastic SinkFilter) {
+	filterMutex.RLock()
+	defer filterMutex.RUnlock()
+
+	return outputFilter, elasticFilter
+}
+
+// equal reports whether f and other are the same filter.
+func (f SinkFilter) equal(other SinkFilter) bool {
+	return f.Level == other.Level && slices.Equal(f.Fields, other.Fields) && slices.Equal(f.OmitFields, other.OmitFields)
+}
+
+// admits reports whether the entries of level pass the filter.
+func (f SinkFilter) admits(level logrus.Level) bool {
+	minimum, err := logrus.ParseLevel(f.Level)
+	return f.Level == "" || err != nil || level <= minimum
+}
+
+// apply returns the entry with only the fields passing the filter. The entry is returned as is
+// when every field passes, or else a copy is, so the other sinks still receive every field.
+func (f SinkFilter) apply(entry *logrus.Entry) *logrus.Entry {
+	if len(f.Fields) == 0 && len(f.OmitFields) == 0 {
+		return entry
+	}
+
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		if f.keeps(key) {
+			data[key] = value
+		}
+	}
+
+	filtered := *entry
+	filtered.Data = data
+	return &filtered
+}
+
+// keeps reports whether the field name passes the filter.
+func (f SinkFilter) keeps(name string) bool {
+	return (len(f.Fields) == 0 || matchField(f.Fields, name)) && !matchField(f.OmitFields, name)
+}
+
+// matchField reports whether name matches one of patterns.
+func matchField(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
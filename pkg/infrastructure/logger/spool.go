@@ -0,0 +1,225 @@
+package logger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// segmentRecords is the number of records after which the spool starts a new segment.
+const segmentRecords = 1000
+
+// segment is a spool file. It is deleted once it is sealed, i.e. receives no more
+// records, and every record has been acknowledged.
+type segment struct {
+	path    string
+	pending atomic.Int64 // Records not acknowledged yet
+	sealed  atomic.Bool  // Set when the spool moves on to the next segment
+}
+
+// release deletes the segment file if it is sealed and fully acknowledged.
+func (s *segment) release() {
+	if s.sealed.Load() && s.pending.Load() == 0 {
+		_ = os.Remove(s.path)
+	}
+}
+
+// spool is a disk-backed log of the documents queued for ElasticSearch, giving at-least-once
+// delivery across restarts. Documents are appended to segment files before they are queued
+// and acknowledged once written to the cluster or the fallback file. Segments left by a
+// previous process are replayed when the spool is opened. Acknowledgement is tracked per
+// segment, so a crash may replay documents that were already delivered.
+//
+// Each record is a header line with the lengths of the index, the ID, and the data, followed
+// by those bytes and a newline, so formatters writing several lines can't corrupt a segment.
+// Appending only writes to the file, which survives a crash of the process. The workers sync
+// the file before each batch, so a crash of the machine loses at most the records appended
+// since, without an fsync per entry on the caller's path.
+type spool struct {
+	dir string
+
+	mu      sync.Mutex
+	file    *os.File // File of the current segment
+	current *segment // Segment receiving new records
+	records int      // Records appended to the current segment
+	dirty   bool     // Set when records were appended since the last sync
+	next    int      // Sequence number of the next segment
+}
+
+// openSpool opens the spool in dir, creating the directory if needed, and returns the
+// documents of the segments left by a previous process, to be replayed.
+func openSpool(dir string) (*spool, []document, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+
+	s := &spool{dir: dir}
+	var replay []document
+	for _, path := range paths {
+		seq, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".seg"))
+		if err != nil {
+			continue
+		}
+		s.next = max(s.next, seq+1)
+
+		docs, err := readSegment(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		seg := &segment{path: path}
+		seg.sealed.Store(true)
+		seg.pending.Store(int64(len(docs)))
+		for i := range docs {
+			docs[i].segment = seg
+		}
+		seg.release()
+		replay = append(replay, docs...)
+	}
+
+	if err = s.roll(); err != nil {
+		return nil, nil, err
+	}
+
+	return s, replay, nil
+}
+
+// readSegment reads the documents of a segment file. A truncated last record, left by a
+// crash in the middle of a write, is skipped.
+func readSegment(path string) ([]document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var docs []document
+	reader := bufio.NewReader(f)
+	for {
+		var indexLen, idLen, dataLen int
+		_, err = fmt.Fscanf(reader, "%d %d %d\n", &indexLen, &idLen, &dataLen)
+		if err != nil || indexLen < 0 || idLen < 0 || dataLen < 0 {
+			return docs, nil
+		}
+		record := make([]byte, indexLen+idLen+dataLen+1)
+		if _, err = io.ReadFull(reader, record); err != nil || record[len(record)-1] != '\n' {
+			return docs, nil
+		}
+		docs = append(docs, document{
+			index: string(record[:indexLen]),
+			id:    string(record[indexLen : indexLen+idLen]),
+			data:  record[indexLen+idLen : len(record)-1],
+		})
+	}
+}
+
+// roll seals the current segment and starts a new one. The caller must hold the mutex
+// unless the spool isn't shared yet.
+func (s *spool) roll() error {
+	if s.current != nil {
+		_ = s.file.Sync()
+		_ = s.file.Close()
+		s.current.sealed.Store(true)
+		s.current.release()
+	}
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.seg", s.next))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		s.current = nil
+		return err
+	}
+
+	s.next++
+	s.file = f
+	s.current = &segment{path: path}
+	s.records = 0
+	s.dirty = false
+
+	return nil
+}
+
+// append records doc in the current segment and attaches the segment to doc, so it can be
+// acknowledged. The record is durable once the spool is synced.
+func (s *spool) append(doc *document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil || s.records >= segmentRecords {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+
+	record := fmt.Appendf(nil, "%d %d %d\n", len(doc.index), len(doc.id), len(doc.data))
+	record = append(record, doc.index...)
+	record = append(record, doc.id...)
+	record = append(record, doc.data...)
+	record = append(record, '\n')
+	if _, err := s.file.Write(record); err != nil {
+		return err
+	}
+
+	s.records++
+	s.dirty = true
+	s.current.pending.Add(1)
+	doc.segment = s.current
+
+	return nil
+}
+
+// sync flushes the records appended since the last sync to disk. The mutex isn't held during
+// the flush, so appends aren't blocked by a slow disk.
+func (s *spool) sync() error {
+	s.mu.Lock()
+	if !s.dirty || s.current == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.dirty = false
+	f := s.file
+	s.mu.Unlock()
+
+	// A segment sealed meanwhile was synced by roll or close.
+	if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// ack acknowledges the delivery of doc, deleting its segment once fully acknowledged.
+func (s *spool) ack(doc document) {
+	if doc.segment != nil && doc.segment.pending.Add(-1) == 0 {
+		doc.segment.release()
+	}
+}
+
+// close closes the current segment. Its unacknowledged records are replayed by the next
+// process opening the spool.
+func (s *spool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil {
+		_ = s.file.Sync()
+		_ = s.file.Close()
+		s.current.sealed.Store(true)
+		s.current.release()
+		s.current = nil
+	}
+}
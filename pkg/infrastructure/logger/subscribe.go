@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Document is a log entry delivered to subscribers.
+type Document struct {
+	Category Category
+	Level    logrus.Level
+	Time     time.Time
+	Message  string
+	Fields   logrus.Fields // Copy of the entry's fields, safe to retain
+}
+
+// subscriberHook is a logrus hook delivering every entry to the registered subscribers.
+type subscriberHook struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]func(Document)
+}
+
+// subscribers is the hook installed on the logger by logger and installHook.
+var subscribers = &subscriberHook{subscribers: map[int]func(Document){}}
+
+// Levels returns all log levels, so subscribers receive every entry.
+func (h *subscriberHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire delivers the entry to every subscriber, unless a Processor dropped it.
+func (h *subscriberHook) Fire(entry *logrus.Entry) error {
+	if isDropped(entry) {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.subscribers) == 0 {
+		return nil
+	}
+
+	fields := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		fields[key] = value
+	}
+
+	doc := Document{
+		Category: categoryOf(entry),
+		Level:    entry.Level,
+		Time:     entry.Time,
+		Message:  entry.Message,
+		Fields:   fields,
+	}
+	for _, fn := range h.subscribers {
+		fn(doc)
+	}
+
+	return nil
+}
+
+// Subscribe registers fn to receive every entry logged through Logger, including request
+// documents, regardless of budgets and of the state of ElasticSearch. fn runs synchronously
+// on the logging goroutine, so it must be fast and must not log through Logger itself;
+// use SubscribeChan to process entries asynchronously. Call the returned function to
+// unsubscribe.
+func Subscribe(fn func(Document)) (unsubscribe func()) {
+	subscribers.mu.Lock()
+	defer subscribers.mu.Unlock()
+
+	id := subscribers.nextID
+	subscribers.nextID++
+	subscribers.subscribers[id] = fn
+
+	return func() {
+		subscribers.mu.Lock()
+		defer subscribers.mu.Unlock()
+
+		delete(subscribers.subscribers, id)
+	}
+}
+
+// SubscribeFiltered registers fn like Subscribe, but only for the entries and with the fields
+// passing filter. The fields are copied, as the other subscribers receive the same document.
+func SubscribeFiltered(filter SinkFilter, fn func(Document)) (unsubscribe func()) {
+	return Subscribe(func(doc Document) {
+		if !filter.admits(doc.Level) {
+			return
+		}
+		fields := make(logrus.Fields, len(doc.Fields))
+		for key, value := range doc.Fields {
+			if filter.keeps(key) {
+				fields[key] = value
+			}
+		}
+		doc.Fields = fields
+		fn(doc)
+	})
+}
+
+// SubscribeChan registers ch to receive every entry logged through Logger. Entries are sent
+// without blocking and dropped when ch is full, so a slow consumer never delays logging.
+// Call the returned function to unsubscribe; ch is not closed.
+func SubscribeChan(ch chan<- Document) (unsubscribe func()) {
+	return Subscribe(func(doc Document) {
+		select {
+		case ch <- doc:
+		default:
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig builds the TLS configuration of the ElasticSearch transport of config. It
+// returns nil if no TLS setting is set, which keeps the default transport.
+func newTLSConfig(config Config) (*tls.Config, error) {
+	caPath := config.ElasticCACertPath
+	caPEM := config.ElasticCACertPEM
+	certPath := config.ElasticClientCertPath
+	keyPath := config.ElasticClientKeyPath
+	insecure := config.ElasticInsecureSkipVerify
+
+	if caPath == "" && caPEM == "" && certPath == "" && keyPath == "" && !insecure {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: insecure} //nolint:gosec
+
+	if caPath != "" || caPEM != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if caPath != "" {
+			data, err := os.ReadFile(caPath)
+			if err != nil {
+				return nil, fmt.Errorf("reading the CA certificate: %w", err)
+			}
+			caPEM += "\n" + string(data)
+		}
+		if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, errors.New("the CA certificate contains no valid PEM certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
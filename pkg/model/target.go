@@ -0,0 +1,115 @@
+// Package model defines the descriptions of the calls made to targets, the downstream services
+// called while handling a request, which welog records in the target field of request documents.
+package model
+
+import (
+	"bytes"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	"io"
+	"net/http"
+	"time"
+)
+
+// TargetRequest describes a request sent to a target.
+type TargetRequest struct {
+	URL         string
+	Method      string
+	ContentType string
+	Header      map[string]interface{}
+	Body        []byte
+	Timestamp   time.Time     // Time the request was sent
+	Attempt     TargetAttempt // Attempt of the request if the caller retries it
+}
+
+// TargetAttempt describes an attempt of a call retried by the caller, so the attempts of a call
+// can be told apart from independent calls. The zero value describes calls that aren't retried.
+type TargetAttempt struct {
+	Number     int           // Number of the attempt, starting at 1
+	MaxRetries int           // Maximum number of retries after the first attempt
+	Backoff    time.Duration // Wait before the attempt
+}
+
+// TargetResponse describes the response of a target. Calls failing without a response, e.g.
+// because of a timeout or a refused connection, are described by Error and a zero Status.
+type TargetResponse struct {
+	Header    map[string]interface{}
+	Body      []byte
+	Status    int
+	Latency   time.Duration // Time between sending the request and receiving the response or error
+	Error     error         // Transport error of calls without a response
+	ErrorType string        // Kind of Error, such as "timeout"; derived from Error when empty
+	Timing    TargetTiming  // Breakdown of Latency, if it was traced
+}
+
+// TargetTiming breaks the latency of a call down into its phases, so slow targets can be told
+// apart from slow networks. Phases that didn't happen, such as the DNS lookup and connection of
+// calls reusing a connection, are zero. The zero value describes calls that weren't traced.
+type TargetTiming struct {
+	DNS          time.Duration // Duration of the DNS lookup
+	Connect      time.Duration // Duration of the TCP connection
+	TLSHandshake time.Duration // Duration of the TLS handshake
+	FirstByte    time.Duration // Time between sending the request and the first response byte
+	Reused       bool          // Whether the call reused an idle connection
+}
+
+// TargetRequestFromHTTP describes req, sent now. The body is read and replaced by a reader
+// returning the same bytes, so req can still be sent.
+func TargetRequestFromHTTP(req *http.Request) TargetRequest {
+	body := rebuffer(&req.Body)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	return TargetRequest{
+		URL:         req.URL.String(),
+		Method:      req.Method,
+		ContentType: req.Header.Get("Content-Type"),
+		Header:      util.HeaderToMap(req.Header),
+		Body:        body,
+		Timestamp:   logger.Now(),
+	}
+}
+
+// TargetResponseFromHTTP describes res, received latency after sending its request. The body
+// is read and replaced by a reader returning the same bytes, so the caller can still read it.
+func TargetResponseFromHTTP(res *http.Response, latency time.Duration) TargetResponse {
+	return TargetResponse{
+		Header:  util.HeaderToMap(res.Header),
+		Body:    rebuffer(&res.Body),
+		Status:  res.StatusCode,
+		Latency: latency,
+	}
+}
+
+// rebuffer reads the body behind body and replaces it by a reader returning the bytes read.
+// If reading fails, the replacement returns the same error after the bytes, so the failure
+// isn't hidden from the reader of the body.
+func rebuffer(body *io.ReadCloser) []byte {
+	if *body == nil || *body == http.NoBody {
+		return nil
+	}
+
+	data, err := io.ReadAll(*body)
+	_ = (*body).Close()
+
+	var reader io.Reader = bytes.NewReader(data)
+	if err != nil {
+		reader = io.MultiReader(reader, errorReader{err})
+	}
+	*body = io.NopCloser(reader)
+
+	return data
+}
+
+// errorReader is a reader failing with err.
+type errorReader struct {
+	err error
+}
+
+// Read returns the error of the reader.
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
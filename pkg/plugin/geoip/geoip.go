@@ -0,0 +1,174 @@
+// Package geoip registers a plugin that derives the country, city, and autonomous system of
+// the requestIp field of request documents, and of the targetGrpcPeer field of their gRPC
+// targets, from MaxMind databases. Import it and list the databases in the welog configuration
+// to enable the enrichment:
+//
+//	import _ "github.com/christiandoxa/welog/pkg/plugin/geoip"
+//
+//	welog.SetConfig(welog.Config{
+//		GeoIPDatabases: []string{"GeoLite2-City.mmdb", "GeoLite2-ASN.mmdb"},
+//	})
+//
+// Applications that don't configure welog through SetConfig call Open instead.
+package geoip
+
+import (
+	"errors"
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+)
+
+// record holds the fields looked up in the databases. City, Country, and ASN databases
+// each fill a subset of them.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASNumber       uint   `maxminddb:"autonomous_system_number"`
+	ASOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+var (
+	mutex   sync.RWMutex        // Protects access to readers and paths
+	readers []*maxminddb.Reader // Databases opened by Open
+	paths   []string            // Paths of the databases opened by Open
+)
+
+func init() {
+	plugin.Register("geoip", geoIP{})
+}
+
+// geoIP is the registered plugin, configured from plugin.Settings.GeoIPDatabases.
+type geoIP struct{}
+
+// Process calls Process.
+func (geoIP) Process(fields logrus.Fields) {
+	Process(fields)
+}
+
+// Configure opens the databases listed in settings, unless they are the ones already open.
+// An empty list keeps the databases opened by Open.
+func (geoIP) Configure(settings plugin.Settings) error {
+	if len(settings.GeoIPDatabases) == 0 {
+		return nil
+	}
+
+	mutex.RLock()
+	opened := slices.Equal(paths, settings.GeoIPDatabases)
+	mutex.RUnlock()
+	if opened {
+		return nil
+	}
+
+	return Open(settings.GeoIPDatabases...)
+}
+
+// Open opens the MaxMind databases at paths, such as GeoLite2-City and GeoLite2-ASN, and
+// makes Process look up request IPs in them, replacing the databases of a previous call.
+// If a database can't be opened, the previous databases are kept.
+func Open(databases ...string) error {
+	opened := make([]*maxminddb.Reader, 0, len(databases))
+	for _, path := range databases {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			for _, r := range opened {
+				_ = r.Close()
+			}
+			return err
+		}
+		opened = append(opened, reader)
+	}
+
+	mutex.Lock()
+	previous := readers
+	readers, paths = opened, slices.Clone(databases)
+	mutex.Unlock()
+
+	return closeAll(previous)
+}
+
+// Close closes the databases opened by Open, which disables the enrichment.
+func Close() error {
+	mutex.Lock()
+	previous := readers
+	readers, paths = nil, nil
+	mutex.Unlock()
+
+	return closeAll(previous)
+}
+
+// closeAll closes the readers and joins their errors.
+func closeAll(rs []*maxminddb.Reader) error {
+	var errs []error
+	for _, r := range rs {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Process adds the requestGeoCountry, requestGeoCity, requestAsNumber, and
+// requestAsOrganization fields found for the requestIp field, and the targetGeoCountry,
+// targetGeoCity, targetAsNumber, and targetAsOrganization fields found for the targetGrpcPeer
+// field of the target entries. Fields without a value in the databases, entries without a
+// valid IP, and documents processed before Open are left untouched.
+func Process(fields logrus.Fields) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if len(readers) == 0 {
+		return
+	}
+
+	enrich(fields, "requestIp", "request")
+
+	// The target entries are shared with the request context, so the enriched ones are copies.
+	targets, _ := fields["target"].([]logrus.Fields)
+	enriched := make([]logrus.Fields, len(targets))
+	for i, target := range targets {
+		enriched[i] = target
+		if _, ok := target["targetGrpcPeer"]; ok {
+			enriched[i] = maps.Clone(target)
+			enrich(enriched[i], "targetGrpcPeer", "target")
+		}
+	}
+	if len(targets) > 0 {
+		fields["target"] = enriched
+	}
+}
+
+// enrich adds the fields found for the IP of the key field, named after prefix. The caller
+// must hold mutex.
+func enrich(fields logrus.Fields, key, prefix string) {
+	address, _ := fields[key].(string)
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return
+	}
+
+	var found record
+	for _, reader := range readers {
+		// A failed lookup leaves the fields of the other databases usable.
+		_ = reader.Lookup(ip, &found)
+	}
+
+	if found.Country.ISOCode != "" {
+		fields[prefix+"GeoCountry"] = found.Country.ISOCode
+	}
+	if city := found.City.Names["en"]; city != "" {
+		fields[prefix+"GeoCity"] = city
+	}
+	if found.ASNumber != 0 {
+		fields[prefix+"AsNumber"] = found.ASNumber
+	}
+	if found.ASOrganization != "" {
+		fields[prefix+"AsOrganization"] = found.ASOrganization
+	}
+}
@@ -0,0 +1,111 @@
+package geoip
+
+import (
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// The fixture databases are written by testdata/generate.go. city.mmdb locates 203.0.113.0/24
+// in Sydney, AU, and 198.51.100.0/24 in NL without a city; asn.mmdb assigns 203.0.113.0/24 to
+// AS64496, Example Networks.
+var databases = []string{"testdata/city.mmdb", "testdata/asn.mmdb"}
+
+func TestProcess(t *testing.T) {
+	assert.NoError(t, Open(databases...))
+	t.Cleanup(func() { _ = Close() })
+
+	// Assert that the request IP is looked up in every database.
+	for _, test := range []struct {
+		fields, want logrus.Fields
+	}{
+		{
+			logrus.Fields{"requestIp": "203.0.113.7"},
+			logrus.Fields{
+				"requestIp":             "203.0.113.7",
+				"requestGeoCountry":     "AU",
+				"requestGeoCity":        "Sydney",
+				"requestAsNumber":       uint(64496),
+				"requestAsOrganization": "Example Networks",
+			},
+		},
+		{
+			logrus.Fields{"requestIp": "198.51.100.1"},
+			logrus.Fields{"requestIp": "198.51.100.1", "requestGeoCountry": "NL"},
+		},
+		{logrus.Fields{"requestIp": "192.0.2.1"}, logrus.Fields{"requestIp": "192.0.2.1"}},
+		{logrus.Fields{"requestIp": "2001:db8::1"}, logrus.Fields{"requestIp": "2001:db8::1"}},
+		{logrus.Fields{"requestIp": "unknown"}, logrus.Fields{"requestIp": "unknown"}},
+		{logrus.Fields{"requestIp": 42}, logrus.Fields{"requestIp": 42}},
+		{logrus.Fields{}, logrus.Fields{}},
+	} {
+		Process(test.fields)
+		assert.Equal(t, test.want, test.fields, test.want["requestIp"])
+	}
+
+	// Assert that the gRPC peers of the target entries are looked up, into copies of the entries.
+	grpcTarget := logrus.Fields{"targetGrpcMethod": "/pkg.Service/Method", "targetGrpcPeer": "203.0.113.9"}
+	httpTarget := logrus.Fields{"targetUrl": "https://example.com"}
+	fields := logrus.Fields{"target": []logrus.Fields{grpcTarget, httpTarget}}
+	Process(fields)
+	assert.Equal(t, []logrus.Fields{{
+		"targetGrpcMethod":     "/pkg.Service/Method",
+		"targetGrpcPeer":       "203.0.113.9",
+		"targetGeoCountry":     "AU",
+		"targetGeoCity":        "Sydney",
+		"targetAsNumber":       uint(64496),
+		"targetAsOrganization": "Example Networks",
+	}, httpTarget}, fields["target"])
+	assert.NotContains(t, grpcTarget, "targetGeoCountry")
+}
+
+func TestOpen(t *testing.T) {
+	t.Cleanup(func() { _ = Close() })
+
+	// Assert that documents are left untouched until the databases are open.
+	fields := logrus.Fields{"requestIp": "203.0.113.7"}
+	Process(fields)
+	assert.NotContains(t, fields, "requestGeoCountry")
+
+	// Assert that a missing database keeps the previous ones.
+	assert.NoError(t, Open("testdata/city.mmdb"))
+	assert.Error(t, Open("testdata/city.mmdb", "testdata/missing.mmdb"))
+	Process(fields)
+	assert.Equal(t, "AU", fields["requestGeoCountry"])
+	assert.NotContains(t, fields, "requestAsNumber")
+
+	// Assert that Close disables the enrichment.
+	assert.NoError(t, Close())
+	fields = logrus.Fields{"requestIp": "203.0.113.7"}
+	Process(fields)
+	assert.NotContains(t, fields, "requestGeoCountry")
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(func() { _ = Close() })
+
+	// Assert that the databases of the settings are opened by the registered plugin.
+	assert.Contains(t, plugin.Registered(), "geoip")
+	assert.NoError(t, plugin.Configure(plugin.Settings{GeoIPDatabases: databases}))
+	fields := logrus.Fields{"requestIp": "203.0.113.7"}
+	plugin.Apply(fields)
+	assert.Equal(t, "AU", fields["requestGeoCountry"])
+	assert.Equal(t, uint(64496), fields["requestAsNumber"])
+
+	// Assert that the same databases aren't reopened, and no databases keep the open ones.
+	mutex.RLock()
+	opened := readers
+	mutex.RUnlock()
+	assert.NoError(t, plugin.Configure(plugin.Settings{GeoIPDatabases: databases}))
+	assert.NoError(t, plugin.Configure(plugin.Settings{}))
+	mutex.RLock()
+	assert.Equal(t, opened, readers)
+	mutex.RUnlock()
+
+	// Assert that invalid settings report the error and keep the open databases.
+	assert.Error(t, plugin.Configure(plugin.Settings{GeoIPDatabases: []string{"testdata/missing.mmdb"}}))
+	fields = logrus.Fields{"requestIp": "203.0.113.7"}
+	plugin.Apply(fields)
+	assert.Equal(t, "AU", fields["requestGeoCountry"])
+}
@@ -0,0 +1,181 @@
+//go:build ignore
+
+// Generate writes the city.mmdb and asn.mmdb fixtures of the geoip tests, small MaxMind DB
+// (format 2.0) databases of documentation networks, into the directory given as argument:
+//
+//	go run testdata/generate.go testdata
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"sort"
+)
+
+// Data types of the MaxMind DB format, encoded after their Go type.
+type (
+	value any
+	u16   uint16
+	u32   uint32
+	u64   uint64
+)
+
+// empty marks a record of the search tree without data.
+const empty = -1
+
+// network is a CIDR of a database and its data.
+type network struct {
+	cidr string
+	data map[string]value
+}
+
+func main() {
+	dir := os.Args[1]
+	write(dir+"/city.mmdb", "Welog-City-Test", []network{
+		{"203.0.113.0/24", map[string]value{
+			"country": map[string]value{"iso_code": "AU"},
+			"city":    map[string]value{"names": map[string]value{"en": "Sydney"}},
+		}},
+		{"198.51.100.0/24", map[string]value{
+			"country": map[string]value{"iso_code": "NL"},
+		}},
+	})
+	write(dir+"/asn.mmdb", "Welog-ASN-Test", []network{
+		{"203.0.113.0/24", map[string]value{
+			"autonomous_system_number":       u32(64496),
+			"autonomous_system_organization": "Example Networks",
+		}},
+	})
+}
+
+// write writes an IPv4 database of the networks to path, with 24-bit records.
+func write(path, databaseType string, networks []network) {
+	var data bytes.Buffer
+	offsets := make([]int, len(networks))
+	for i, n := range networks {
+		offsets[i] = data.Len()
+		encode(&data, n.data)
+	}
+
+	// A record is the index of a node, empty, or -2 minus the index of a network.
+	nodes := [][2]int{{empty, empty}}
+	for i, n := range networks {
+		ip, ipNet, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			panic(err)
+		}
+		ip = ip.To4()
+		ones, _ := ipNet.Mask.Size()
+
+		node := 0
+		for bit := 0; bit < ones; bit++ {
+			b := ip[bit/8] >> (7 - bit%8) & 1
+			if bit == ones-1 {
+				nodes[node][b] = -2 - i
+				break
+			}
+			if nodes[node][b] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][b] = len(nodes) - 1
+			}
+			node = nodes[node][b]
+		}
+	}
+
+	var out bytes.Buffer
+	count := len(nodes)
+	for _, n := range nodes {
+		for _, r := range n {
+			pointer := count
+			switch {
+			case r >= 0:
+				pointer = r
+			case r < empty:
+				pointer = count + 16 + offsets[-2-r]
+			}
+			out.Write([]byte{byte(pointer >> 16), byte(pointer >> 8), byte(pointer)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+	encode(&out, map[string]value{
+		"binary_format_major_version": u16(2),
+		"binary_format_minor_version": u16(0),
+		"build_epoch":                 u64(1700000000),
+		"database_type":               databaseType,
+		"description":                 map[string]value{"en": "welog geoip test fixture"},
+		"ip_version":                  u16(4),
+		"languages":                   []value{"en"},
+		"node_count":                  u32(count),
+		"record_size":                 u16(24),
+	})
+
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		panic(err)
+	}
+}
+
+// encode writes v to buf, with the keys of maps in sorted order so the output is stable.
+func encode(buf *bytes.Buffer, v value) {
+	switch v := v.(type) {
+	case string:
+		control(buf, 2, len(v))
+		buf.WriteString(v)
+	case u16:
+		writeUint(buf, 5, uint64(v))
+	case u32:
+		writeUint(buf, 6, uint64(v))
+	case u64:
+		writeUint(buf, 9, uint64(v))
+	case map[string]value:
+		control(buf, 7, len(v))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encode(buf, key)
+			encode(buf, v[key])
+		}
+	case []value:
+		control(buf, 11, len(v))
+		for _, element := range v {
+			encode(buf, element)
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// writeUint writes v as an unsigned integer of the type typ, without its leading zero bytes.
+func writeUint(buf *bytes.Buffer, typ int, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	trimmed := bytes.TrimLeft(b[:], "\x00")
+	control(buf, typ, len(trimmed))
+	buf.Write(trimmed)
+}
+
+// control writes the control byte of a field of the type typ and size, followed by the
+// extended type and size bytes if needed. Sizes up to 284 are supported.
+func control(buf *bytes.Buffer, typ, size int) {
+	first := byte(typ << 5)
+	if typ > 7 {
+		first = 0
+	}
+	if size < 29 {
+		buf.WriteByte(first | byte(size))
+	} else {
+		buf.WriteByte(first | 29)
+	}
+	if typ > 7 {
+		buf.WriteByte(byte(typ - 7))
+	}
+	if size >= 29 {
+		buf.WriteByte(byte(size - 29))
+	}
+}
@@ -0,0 +1,110 @@
+// Package plugin provides an init-time registry of request document post-processing
+// plugins. Optional integrations, such as user agent parsing or GeoIP enrichment, live in
+// their own sub-packages and register themselves from an init function, so they are only
+// compiled into an application (together with their dependencies) when it imports them:
+//
+//	import _ "github.com/christiandoxa/welog/pkg/plugin/useragent"
+package plugin
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+)
+
+// Plugin post-processes the fields of a request document before it is logged.
+// Process may add, modify, or remove fields. It is called concurrently from
+// multiple requests and must therefore be safe for concurrent use.
+type Plugin interface {
+	Process(fields logrus.Fields)
+}
+
+// Func is an adapter that allows the use of an ordinary function as a Plugin.
+type Func func(fields logrus.Fields)
+
+// Process calls f(fields).
+func (f Func) Process(fields logrus.Fields) {
+	f(fields)
+}
+
+// Settings holds the plugin settings of the welog configuration, passed by welog.SetConfig to
+// the plugins implementing Configurer.
+type Settings struct {
+	// GeoIPDatabases lists the paths of the MaxMind databases of the geoip plugin.
+	GeoIPDatabases []string
+}
+
+// Configurer is implemented by plugins that read their settings from the welog configuration.
+// Configure is called by Configure, from welog.SetConfig, and must be safe to call while
+// Process runs.
+type Configurer interface {
+	Configure(settings Settings) error
+}
+
+var (
+	mutex   sync.RWMutex          // Protects access to the registered plugins
+	plugins = map[string]Plugin{} // Registered plugins by name
+)
+
+// Register makes a plugin available under the provided name. It is meant to be called
+// from the init function of the plugin's package. If Register is called twice with the
+// same name or if the plugin is nil, it panics.
+func Register(name string, p Plugin) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if p == nil {
+		panic("plugin: Register plugin is nil")
+	}
+	if _, dup := plugins[name]; dup {
+		panic("plugin: Register called twice for plugin " + name)
+	}
+
+	plugins[name] = p
+}
+
+// Registered returns a sorted list of the names of the registered plugins.
+func Registered() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return sortedNames()
+}
+
+// Apply runs all registered plugins on fields, in the order of their names.
+func Apply(fields logrus.Fields) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	for _, name := range sortedNames() {
+		plugins[name].Process(fields)
+	}
+}
+
+// Configure passes settings to the registered plugins implementing Configurer, in the order of
+// their names, and joins their errors.
+func Configure(settings Settings) error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var errs []error
+	for _, name := range sortedNames() {
+		if c, ok := plugins[name].(Configurer); ok {
+			errs = append(errs, c.Configure(settings))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sortedNames returns the names of the registered plugins in sorted order, so plugins
+// run deterministically. The caller must hold mutex.
+func sortedNames() []string {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
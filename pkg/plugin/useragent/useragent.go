@@ -0,0 +1,77 @@
+// Package useragent registers a plugin that derives the browser, operating system, and
+// device type from the requestAgent field of request documents. Import it for its side
+// effect to enable the enrichment:
+//
+//	import _ "github.com/christiandoxa/welog/pkg/plugin/useragent"
+package useragent
+
+import (
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// browsers lists browser tokens in match order. Order matters because most user agents
+// mention several engines, e.g. Edge and Chrome user agents both contain "Safari".
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+	{"PostmanRuntime/", "Postman"},
+	{"Go-http-client/", "Go"},
+}
+
+// systems lists operating system tokens in match order.
+var systems = []struct{ token, name string }{
+	{"Windows", "Windows"},
+	{"Android", "Android"},
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+func init() {
+	plugin.Register("useragent", plugin.Func(Process))
+}
+
+// Process adds the requestAgentBrowser, requestAgentOs, and requestAgentDevice fields
+// derived from the requestAgent field. Documents without a user agent are left untouched.
+func Process(fields logrus.Fields) {
+	agent, _ := fields["requestAgent"].(string)
+	if agent == "" {
+		return
+	}
+
+	fields["requestAgentBrowser"] = match(agent, browsers)
+	fields["requestAgentOs"] = match(agent, systems)
+	fields["requestAgentDevice"] = device(agent)
+}
+
+// match returns the name of the first token contained in agent, or "Other".
+func match(agent string, tokens []struct{ token, name string }) string {
+	for _, t := range tokens {
+		if strings.Contains(agent, t.token) {
+			return t.name
+		}
+	}
+	return "Other"
+}
+
+// device classifies the user agent as a bot, tablet, mobile, or desktop device.
+func device(agent string) string {
+	lower := strings.ToLower(agent)
+	switch {
+	case strings.Contains(lower, "bot"), strings.Contains(lower, "spider"), strings.Contains(lower, "crawl"):
+		return "bot"
+	case strings.Contains(lower, "ipad"), strings.Contains(lower, "tablet"):
+		return "tablet"
+	case strings.Contains(lower, "mobi"), strings.Contains(lower, "iphone"):
+		return "mobile"
+	default:
+		return "desktop"
+	}
+}
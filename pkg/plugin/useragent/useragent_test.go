@@ -0,0 +1,71 @@
+package useragent
+
+import (
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProcess(t *testing.T) {
+	// Assert that the browser, operating system, and device are derived from the user agent.
+	for _, test := range []struct {
+		agent, browser, os, device string
+	}{
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+			"Edge", "Windows", "desktop",
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 OPR/106.0.0.0",
+			"Opera", "Windows", "desktop",
+		},
+		{
+			"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			"Firefox", "Linux", "desktop",
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			"Chrome", "Android", "mobile",
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			"Safari", "iOS", "mobile",
+		},
+		{
+			"Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			"Safari", "iOS", "tablet",
+		},
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			"Safari", "macOS", "desktop",
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			"Other", "Other", "bot",
+		},
+		{"curl/8.5.0", "curl", "Other", "desktop"},
+		{"PostmanRuntime/7.36.0", "Postman", "Other", "desktop"},
+		{"Go-http-client/1.1", "Go", "Other", "desktop"},
+		{"custom-agent", "Other", "Other", "desktop"},
+	} {
+		fields := logrus.Fields{"requestAgent": test.agent}
+		Process(fields)
+		assert.Equal(t, test.browser, fields["requestAgentBrowser"], test.agent)
+		assert.Equal(t, test.os, fields["requestAgentOs"], test.agent)
+		assert.Equal(t, test.device, fields["requestAgentDevice"], test.agent)
+	}
+
+	// Assert that documents without a user agent are left untouched.
+	for _, fields := range []logrus.Fields{{}, {"requestAgent": ""}, {"requestAgent": 42}} {
+		want := logrus.Fields{}
+		for key, value := range fields {
+			want[key] = value
+		}
+		Process(fields)
+		assert.Equal(t, want, fields)
+	}
+
+	// Assert that the plugin is registered.
+	assert.Contains(t, plugin.Registered(), "useragent")
+}
@@ -1,24 +1,29 @@
 package util
 
-import "github.com/valyala/fasthttp"
+import (
+	"net/http"
+	"strings"
+)
 
-// HeaderToMap converts fasthttp headers to map
+// HeaderToMap converts fasthttp or net/http headers to map. The values of net/http headers
+// with several values are joined by commas.
 func HeaderToMap(header interface{}) map[string]interface{} {
 	headersMap := make(map[string]interface{})
 
-	// check if header is *fasthttp.ResponseHeader or *fasthttp.RequestHeader
+	// check if header is a *fasthttp.ResponseHeader or *fasthttp.RequestHeader, which visit
+	// their headers, or http.Header
 
 	switch header.(type) {
 
-	case *fasthttp.ResponseHeader:
-		header.(*fasthttp.ResponseHeader).VisitAll(func(key, value []byte) {
+	case interface{ VisitAll(func(key, value []byte)) }:
+		header.(interface{ VisitAll(func(key, value []byte)) }).VisitAll(func(key, value []byte) {
 			headersMap[string(key)] = string(value)
 		})
 
-	case *fasthttp.RequestHeader:
-		header.(*fasthttp.RequestHeader).VisitAll(func(key, value []byte) {
-			headersMap[string(key)] = string(value)
-		})
+	case http.Header:
+		for key, values := range header.(http.Header) {
+			headersMap[key] = strings.Join(values, ", ")
+		}
 
 	}
 
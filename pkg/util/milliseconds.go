@@ -0,0 +1,9 @@
+package util
+
+import "time"
+
+// Milliseconds returns d as a fractional number of milliseconds, e.g. 12.3 for 12.3ms, for the
+// numeric latency fields that Kibana can aggregate, unlike their string form.
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
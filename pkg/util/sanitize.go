@@ -0,0 +1,139 @@
+package util
+
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Redacted replaces values removed by Sanitize.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are the words whose values are always redacted, compared case-insensitively
+// with the words of a key and their plurals, so "apiKey" or "X-Api-Key" match "apikey" while
+// "hostname" or "cardinality" don't match "name" or "card".
+var sensitiveKeys = map[string]bool{
+	"address": true, "apikey": true, "authorization": true, "card": true, "cookie": true,
+	"email": true, "firstname": true, "fullname": true, "lastname": true, "passphrase": true,
+	"passwd": true, "password": true, "phone": true, "pwd": true, "secret": true, "session": true,
+	"signature": true, "ssn": true, "surname": true, "telephone": true, "token": true, "username": true,
+}
+
+// piiPatterns match personal data inside otherwise harmless string values.
+var piiPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), // Email addresses
+	regexp.MustCompile(`\+?\d[\d -]{7,}\d`),                              // Phone and card numbers
+}
+
+// IsSensitiveKey reports whether values stored under key are considered sensitive, i.e. whether
+// a word of key, or a run of adjacent words, is one of the sensitive keys.
+func IsSensitiveKey(key string) bool {
+	words := keyWords(key)
+	for i := range words {
+		run := ""
+		for _, word := range words[i:] {
+			run += word
+			if sensitiveKeys[run] || sensitiveKeys[strings.TrimSuffix(run, "s")] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyWords splits key into lower-case words at separators and at case changes, so
+// "X-API-Key", "apiKey", "APIKey", and "api_key" all yield "api" and "key".
+func keyWords(key string) []string {
+	runes := []rune(key)
+	var words []string
+	var word []rune
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words = append(words, strings.ToLower(string(word)))
+				word = word[:0]
+			}
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || nextIsLower {
+				words = append(words, strings.ToLower(string(word)))
+				word = word[:0]
+			}
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, strings.ToLower(string(word)))
+	}
+	return words
+}
+
+// Sanitize returns a deep copy of value with personal data removed. Values stored under
+// sensitive keys are replaced by Redacted, and email addresses and long digit sequences in
+// strings are masked. Maps, slices, and headers are copied; the input is never modified.
+func Sanitize(value any) any {
+	switch v := value.(type) {
+	case string:
+		for _, pattern := range piiPatterns {
+			v = pattern.ReplaceAllString(v, Redacted)
+		}
+		return v
+	case map[string]any:
+		return sanitizeMap(v)
+	case logrus.Fields:
+		return sanitizeMap(v)
+	case []any:
+		sanitized := make([]any, len(v))
+		for i, item := range v {
+			sanitized[i] = Sanitize(item)
+		}
+		return sanitized
+	case map[string]string:
+		sanitized := make(map[string]any, len(v))
+		for key, item := range v {
+			sanitized[key] = item
+		}
+		return sanitizeMap(sanitized)
+	case map[string][]string:
+		return sanitizeMultiMap(v)
+	case http.Header:
+		return sanitizeMultiMap(v)
+	default:
+		return v
+	}
+}
+
+// sanitizeMap sanitizes the values of m into a new map.
+func sanitizeMap(m map[string]any) map[string]any {
+	sanitized := make(map[string]any, len(m))
+	for key, item := range m {
+		if IsSensitiveKey(key) {
+			sanitized[key] = Redacted
+		} else {
+			sanitized[key] = Sanitize(item)
+		}
+	}
+	return sanitized
+}
+
+// sanitizeMultiMap sanitizes a map of string slices, such as HTTP headers, into a new map.
+func sanitizeMultiMap(m map[string][]string) map[string]any {
+	sanitized := make(map[string]any, len(m))
+	for key, values := range m {
+		if IsSensitiveKey(key) {
+			sanitized[key] = Redacted
+			continue
+		}
+		items := make([]any, len(values))
+		for i, item := range values {
+			items[i] = Sanitize(item)
+		}
+		sanitized[key] = items
+	}
+	return sanitized
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a thread-safe token bucket rate limiter. A nil *TokenBucket allows everything.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64   // Tokens added per second
+	burst  float64   // Maximum number of tokens
+	tokens float64   // Tokens currently available
+	last   time.Time // Time tokens were last added
+}
+
+// NewTokenBucket creates a full bucket refilled at rate tokens per second and holding at most
+// burst tokens. A non-positive burst defaults to one second worth of tokens. A non-positive
+// rate yields nil, which allows everything.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	capacity := float64(burst)
+	if burst <= 0 {
+		capacity = math.Max(1, math.Ceil(rate))
+	}
+
+	return &TokenBucket{rate: rate, burst: capacity, tokens: capacity}
+}
+
+// Allow takes a token from the bucket, reporting false if none is available.
+func (b *TokenBucket) Allow() bool {
+	return b.AllowAt(time.Now())
+}
+
+// AllowAt is like Allow, but refills the bucket as of now.
+func (b *TokenBucket) AllowAt(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if b.last.IsZero() || now.After(b.last) {
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
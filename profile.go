@@ -0,0 +1,20 @@
+package welog
+
+const (
+	lowResourceQueueSize    = 16      // Entries buffered for ElasticSearch by the low-resource profile
+	lowResourceMemoryBudget = 1 << 20 // Default memory budget of the low-resource profile, in bytes
+)
+
+// LowResourceProfile returns config adjusted for IoT and edge deployments: entries are written
+// to stdout only, bodies are not captured, the ElasticSearch buffer is tiny in case StdoutOnly
+// is turned off again, and the memory budget defaults to 1 MiB. Request documents keep their
+// request ID, so the logs stay correlated.
+func LowResourceProfile(config Config) Config {
+	config.StdoutOnly = true
+	config.DisableBodyCapture = true
+	config.ElasticQueueSize = lowResourceQueueSize
+	if config.MemoryBudget <= 0 {
+		config.MemoryBudget = lowResourceMemoryBudget
+	}
+	return config
+}
@@ -0,0 +1,99 @@
+package welog
+
+import (
+	"context"
+	"errors"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	phaseReceiving  = "receiving"  // The request body is being read
+	phaseProcessing = "processing" // The request body has been read and the handler is running
+)
+
+// progress tracks a running request for its progress documents. It is updated by the
+// request goroutine and read by the progress goroutine.
+type progress struct {
+	received atomic.Int64  // Bytes of the request body received so far
+	done     atomic.Bool   // Set once the request body has been read
+	stop     chan struct{} // Closed when the request completes
+}
+
+// phase returns the phase of the request.
+func (p *progress) phase() string {
+	if p.done.Load() {
+		return phaseProcessing
+	}
+	return phaseReceiving
+}
+
+// countingReader counts the bytes read from a request body into a progress.
+type countingReader struct {
+	io.ReadCloser
+	progress *progress
+}
+
+// Read reads from the body, counting the bytes and marking the body as read at EOF.
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.progress.received.Add(int64(n))
+	if errors.Is(err, io.EOF) {
+		r.progress.done.Store(true)
+	}
+	return n, err
+}
+
+// startProgress emits a progress document for a request every ProgressInterval until stop
+// is called, starting once the request has run for an interval. It returns nil if progress
+// documents are disabled. fields identify the request and are copied into every document.
+func startProgress(ctx context.Context, entry *logrus.Entry, fields logrus.Fields) *progress {
+	config := currentConfig()
+	interval := config.ProgressInterval
+	if interval <= 0 {
+		return nil
+	}
+
+	p := &progress{stop: make(chan struct{})}
+	start := logger.Now()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				elapsed := logger.Since(start)
+				doc := logrus.Fields{
+					"progressBytesReceived": p.received.Load(),
+					"progressElapsed":       elapsed.String(),
+					"progressElapsedMs":     util.Milliseconds(elapsed),
+					"progressPhase":         p.phase(),
+				}
+				for key, value := range fields {
+					doc[key] = value
+				}
+				applyFieldNaming(config, doc)
+				entry.WithContext(logger.WithCategory(ctx, logger.CategoryRequest)).
+					WithFields(doc).
+					Info("request in progress")
+			}
+		}
+	}()
+
+	return p
+}
+
+// finish stops the progress documents of a request. It is a no-op on a nil progress.
+func (p *progress) finish() {
+	if p != nil {
+		close(p.stop)
+	}
+}
@@ -0,0 +1,149 @@
+package welog
+
+import (
+	"context"
+	"fmt"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/goccy/go-json"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// reloadInterval is how often WatchConfig checks the configuration file for changes.
+const reloadInterval = time.Second
+
+// RuntimeConfig holds the settings that can change while the service runs, as read by
+// ReloadConfig from a JSON file such as:
+//
+//	{"level":"debug","sampleRate":0.1,"redactKeys":["token"],"skipPaths":["/healthz"]}
+//
+// Omitted settings keep their current value; an empty list clears the setting.
+type RuntimeConfig struct {
+	// Level is the minimum level of the entries logged through welog, as set by SetLevel.
+	Level string `json:"level"`
+
+	// SampleRate replaces Config.SampleRate.
+	SampleRate *float64 `json:"sampleRate"`
+
+	// SamplePerSecond replaces Config.SamplePerSecond.
+	SamplePerSecond *int `json:"samplePerSecond"`
+
+	// RedactKeys replaces Config.RedactKeys.
+	RedactKeys []string `json:"redactKeys"`
+
+	// SkipPaths replaces Config.SkipPaths.
+	SkipPaths []string `json:"skipPaths"`
+
+	// SkipMethods replaces Config.SkipMethods.
+	SkipMethods []string `json:"skipMethods"`
+}
+
+// ApplyRuntimeConfig applies the settings of runtimeConfig to the running configuration. The
+// middlewares read the configuration on every request, so they pick the settings up without
+// being re-created. Nothing is applied if a setting is invalid.
+func ApplyRuntimeConfig(runtimeConfig RuntimeConfig) error {
+	var level logrus.Level
+	if runtimeConfig.Level != "" {
+		parsed, err := logrus.ParseLevel(runtimeConfig.Level)
+		if err != nil {
+			return fmt.Errorf("level %q is not a level", runtimeConfig.Level)
+		}
+		level = parsed
+	}
+	if rate := runtimeConfig.SampleRate; rate != nil && (*rate < 0 || *rate > 1) {
+		return fmt.Errorf("sampleRate %v is not between 0 and 1", *rate)
+	}
+	if perSecond := runtimeConfig.SamplePerSecond; perSecond != nil && *perSecond < 0 {
+		return fmt.Errorf("samplePerSecond %d is negative", *perSecond)
+	}
+
+	config := currentConfig()
+	if runtimeConfig.SampleRate != nil {
+		config.SampleRate = *runtimeConfig.SampleRate
+	}
+	if runtimeConfig.SamplePerSecond != nil {
+		config.SamplePerSecond = *runtimeConfig.SamplePerSecond
+	}
+	if runtimeConfig.RedactKeys != nil {
+		config.RedactKeys = runtimeConfig.RedactKeys
+	}
+	if runtimeConfig.SkipPaths != nil {
+		config.SkipPaths = runtimeConfig.SkipPaths
+	}
+	if runtimeConfig.SkipMethods != nil {
+		config.SkipMethods = runtimeConfig.SkipMethods
+	}
+	storeConfig(config)
+
+	if runtimeConfig.Level != "" {
+		SetLevel(level)
+	}
+	return nil
+}
+
+// ReloadConfig reads a RuntimeConfig from the JSON file at path and applies it with
+// ApplyRuntimeConfig.
+func ReloadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var runtimeConfig RuntimeConfig
+	if err = json.Unmarshal(data, &runtimeConfig); err != nil {
+		return fmt.Errorf("invalid runtime configuration %s: %w", path, err)
+	}
+	return ApplyRuntimeConfig(runtimeConfig)
+}
+
+// WatchConfig applies the runtime configuration file at path with ReloadConfig, then reloads
+// it whenever the process receives SIGHUP or the file is modified, until ctx is done. Only the
+// first load's error is returned; later failures are logged and keep the previous settings.
+func WatchConfig(ctx context.Context, path string) error {
+	if err := ReloadConfig(path); err != nil {
+		return err
+	}
+	modified := modTime(path)
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+			case <-ticker.C:
+				current := modTime(path)
+				if current.Equal(modified) {
+					continue
+				}
+				modified = current
+			}
+
+			if err := ReloadConfig(path); err != nil {
+				logger.Logger().Error(err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// modTime returns the modification time of the file at path, or the zero time if it can't be read.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
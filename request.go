@@ -0,0 +1,539 @@
+package welog
+
+import (
+	"bytes"
+	"context"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Request is the state of a request logged by a middleware, from its start to its request
+// document. The middlewares of NewHTTP and of the framework integrations, such as
+// github.com/christiandoxa/welog/gin and github.com/christiandoxa/welog/fiber, start one with
+// StartRequest before the handlers, store its Values in the context of the framework, and log
+// it with Log once the handlers returned.
+type Request struct {
+	ID     string        // Request ID, from the X-Request-ID header or new
+	Logger *logrus.Entry // Request-scoped logger, carrying the request ID
+
+	method, path string
+	start        time.Time
+	skip         bool            // Set if the current and the candidate configuration exclude the request
+	force        *atomic.Bool    // Set by ForceLog or a debug token
+	clientLog    *clientLogStore // Target entries, see AddTarget
+	events       *eventStore     // Business events, see LogEvent
+	transaction  *atomic.Pointer[string]
+	user         *requestUser
+	track        *progress        // Progress documents, nil if disabled
+	recovered    *recoveredPanic  // Panic recovered from the handlers, see Recovered
+	capture      *ResponseCapture // Captured response body, see CaptureResponse
+}
+
+// StartRequest starts the logging of a request with method to path. getHeader and getCookie
+// return the request header and cookie of a name, or an empty string. The request ID is taken
+// from the X-Request-ID header, or else generated; requests carrying a debug token, see
+// DebugToken, are forced from the start, so their bodies are captured in full.
+func StartRequest(method, path string, getHeader, getCookie func(name string) string) *Request {
+	requestID := getHeader("X-Request-ID")
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+
+	config := currentConfig()
+	r := &Request{
+		ID:          requestID,
+		Logger:      logger.Logger().WithField(config.FieldNaming.name(generalkey.RequestID), requestID),
+		method:      method,
+		path:        path,
+		skip:        shouldSkip(config, method, path) && currentDarkLaunch().skips(method, path),
+		force:       &atomic.Bool{},
+		clientLog:   &clientLogStore{},
+		events:      &eventStore{},
+		transaction: &atomic.Pointer[string]{},
+		user:        &requestUser{},
+	}
+	r.force.Store(forced(nil, getHeader(DebugHeader), getCookie(DebugCookie)))
+	r.start = logger.Now()
+
+	return r
+}
+
+// Values returns the request-scoped values as key/value pairs of the keys of generalkey, which
+// the middlewares store in the context of the handlers, so LoggerFromContext, AddTarget,
+// SetUser, and the other accessors find them.
+func (r *Request) Values() []any {
+	return []any{
+		generalkey.RequestIDKey, r.ID,
+		generalkey.LoggerKey, r.Logger,
+		generalkey.ClientLogKey, r.clientLog,
+		generalkey.EventKey, r.events,
+		generalkey.ForceLogKey, r.force,
+		generalkey.TransactionNameKey, r.transaction,
+		generalkey.UserKey, r.user,
+	}
+}
+
+// Skipped reports whether the request is excluded by SkipPaths and SkipMethods, for the
+// current configuration and the dark-launched candidate, if any. Log does nothing for skipped
+// requests, so the middlewares can leave out the work of capturing them.
+func (r *Request) Skipped() bool {
+	return r.skip
+}
+
+// StartProgress emits progress documents while the handlers run, see Config.ProgressInterval.
+// url is the URL of the request, redacted in the documents. Skipped requests have none.
+func (r *Request) StartProgress(ctx context.Context, url string) {
+	if r.skip {
+		return
+	}
+	r.track = startProgress(ctx, r.Logger, logrus.Fields{
+		"requestMethod": r.method,
+		"requestUrl":    redactURL(url),
+	})
+}
+
+// BodyRead records the request body of n bytes as received, for frameworks reading it before
+// the handlers.
+func (r *Request) BodyRead(n int64) {
+	if r.track != nil {
+		r.track.received.Store(n)
+		r.track.done.Store(true)
+	}
+}
+
+// CountBody returns body counting the bytes read by the handlers into the progress documents,
+// for frameworks leaving the request body to the handlers. contentLength is the length of the
+// body, zero for requests without a body.
+func (r *Request) CountBody(body io.ReadCloser, contentLength int64) io.ReadCloser {
+	if r.track == nil {
+		return body
+	}
+	if body == nil || contentLength == 0 {
+		r.track.done.Store(true)
+		return body
+	}
+	return &countingReader{ReadCloser: body, progress: r.track}
+}
+
+// Recovered records a panic recovered from the handlers with its stack trace, so the request
+// is logged at error level with the panic and stackTrace fields. The middleware still has to
+// respond, typically with a 500 status.
+func (r *Request) Recovered(value any, stack []byte) {
+	r.recovered = &recoveredPanic{value: value, stack: stack}
+}
+
+// ResponseCapture captures a response body as it is written, for frameworks streaming the
+// responses to the client. The captured bytes are reserved from the memory budget; once it is
+// exhausted, the capture stops and the body is omitted from the document, unless the request
+// is forced. Log returns the bytes to the budget.
+type ResponseCapture struct {
+	body     bytes.Buffer
+	force    *atomic.Bool
+	reserved int64 // Bytes reserved from the memory budget for body
+	omitted  bool  // Set when the body didn't fit into the memory budget
+}
+
+// CaptureResponse returns the capture of the response body of the request, which the response
+// writer of the middleware feeds with Write, or nil if neither the current configuration nor
+// the candidate captures bodies.
+func (r *Request) CaptureResponse() *ResponseCapture {
+	if currentConfig().DisableBodyCapture && !currentDarkLaunch().capturesBodies() {
+		return nil
+	}
+	r.capture = &ResponseCapture{force: r.force}
+	return r.capture
+}
+
+// Write captures b, the bytes written to the response, as long as they fit into the memory
+// budget.
+func (c *ResponseCapture) Write(b []byte) {
+	if c.omitted {
+		return
+	}
+	if reserveBody(int64(len(b)), c.force.Load()) {
+		c.body.Write(b)
+		c.reserved += int64(len(b))
+	} else {
+		c.omitted = true
+	}
+}
+
+// release returns the captured bytes to the memory budget and stops the capture, so the bytes
+// written after the request document, such as the 404 page Gin writes once the middlewares
+// returned, aren't reserved.
+func (c *ResponseCapture) release() {
+	logger.ReleaseMemory(c.reserved)
+	c.reserved = 0
+	c.omitted = true
+}
+
+// Exchange describes a request and its response to Request.Log, as read from the framework
+// once the handlers returned.
+type Exchange struct {
+	Context  context.Context // Context of the handlers, such as the request context
+	URL      string          // URL of the request as received, redacted by Log
+	Query    string          // Raw query string, redacted by Log
+	Host     string
+	Protocol string
+
+	// Route is the route pattern of the request, e.g. "/users/:id", and Handler the name of the
+	// function handling it, if known. NoRoutes is set by frameworks without a router, which leaves
+	// out the requestRoute and requestHandler fields, the anomaly detection, and the examples.
+	Route    string
+	Handler  string
+	NoRoutes bool
+
+	// Params holds the route parameters, redacted by Log, or nil for frameworks without them,
+	// which leaves out the requestParams field.
+	Params map[string]string
+
+	Header     map[string][]string      // Request headers
+	GetHeader  func(name string) string // Returns the first value of a request header
+	RemoteAddr string                   // IP of the peer
+	ClientIP   string                   // Client IP resolved by the framework, see Config.TrustedProxies
+
+	// RequestBody is the request body of frameworks reading it before the handlers. Frameworks
+	// leaving it to the handlers set ReadRequestBody instead, which reads the rest of the body
+	// and puts it back for later readers, and capture the response body with CaptureResponse;
+	// ResponseBody is then ignored. RequestBytes is the size of the request body, -1 if
+	// unknown, in which case the size of the body read for the log is used.
+	RequestContentType string
+	RequestBody        []byte
+	ReadRequestBody    func() ([]byte, error)
+	RequestBytes       int64
+
+	Status                  int
+	ResponseHeader          any // Response headers, as logged in the responseHeader field
+	ResponseContentType     string
+	ResponseContentEncoding string
+	ResponseBody            []byte
+	ResponseBytes           int64
+
+	// Err is the error returned by the handlers, passed to the LevelFunc, and ErrorFields the
+	// fields describing it in the terms of the framework. Failed logs the request at error level
+	// whatever the LevelFunc says, like a panic, e.g. for the errors collected by a framework.
+	Err         error
+	ErrorFields logrus.Fields
+	Failed      bool
+
+	// Fields returns the fields added by the application, such as the ones of the FieldsFunc of
+	// the framework integrations. It runs after the handlers, before the plugins.
+	Fields func() logrus.Fields
+}
+
+// Log writes the request document of the request, unless it is skipped or dropped by
+// sampling, and of the dark-launched candidate, if any. The request ends with it: the progress
+// documents stop and the memory reserved for the captured bodies is returned to the budget.
+func (r *Request) Log(x Exchange) {
+	r.track.finish()
+	if r.capture != nil {
+		defer r.capture.release()
+	}
+	if r.skip {
+		return
+	}
+
+	latency := logger.Since(r.start)
+
+	// The level follows the outcome; requests that panicked or failed are logged at error level.
+	level := requestLevel(x.Status, x.Err)
+	if (r.recovered != nil || x.Failed) && level > logrus.ErrorLevel {
+		level = logrus.ErrorLevel
+	}
+
+	// Count the request for its tenant, before sampling may drop it.
+	tenant := requestTenant(x.GetHeader, level)
+
+	// Requests forced by their handler or a debug token bypass sampling.
+	force := r.force.Load()
+
+	// Successful requests are subject to sampling, errors are always logged. A dark-launched
+	// candidate configuration makes its own decision.
+	config := currentConfig()
+	keep := !shouldSkip(config, r.method, r.path) && (force || sampled(config, &limiter, r.ID, level))
+	dark := currentDarkLaunch()
+	keepDark := dark.sampled(force, r.method, r.path, r.ID, level)
+	if !keep && !keepDark {
+		dark.record(nil, nil)
+		return
+	}
+
+	// Capture the bodies, unless disabled, only if they fit into the memory budget. Forced
+	// requests are captured whatever the skip settings and the budget say.
+	b := r.bodies(config, x, force, keepDark && dark.capturesRequestBody(force, r.path, x.RequestContentType))
+	defer logger.ReleaseMemory(b.reserved)
+
+	request := ParseBody(x.RequestContentType, b.request)
+	response := ParseBody(x.ResponseContentType, b.response)
+
+	// Collect various details of the request and response.
+	fields := logrus.Fields{
+		"requestAgent":            x.GetHeader("User-Agent"),
+		"requestBody":             request,
+		"requestBodyString":       bodyString(x.RequestContentType, b.request),
+		"requestBytes":            b.requestBytes,
+		"requestContentType":      x.RequestContentType,
+		"requestHeader":           x.Header,
+		"requestHostName":         x.Host,
+		"requestId":               r.ID,
+		"requestIp":               clientIP(x.RemoteAddr, x.GetHeader, x.ClientIP),
+		"requestMethod":           r.method,
+		"requestProtocol":         x.Protocol,
+		"requestRemoteAddr":       x.RemoteAddr,
+		"requestQuery":            queryFields(x.Query),
+		"requestTimestamp":        r.start.Format(time.RFC3339Nano),
+		"requestUrl":              redactURL(x.URL),
+		"responseBody":            response,
+		"responseBodyString":      bodyString(x.ResponseContentType, b.response),
+		"responseBytes":           x.ResponseBytes,
+		"responseCharset":         charsetOf(x.ResponseContentType),
+		"responseContentEncoding": x.ResponseContentEncoding,
+		"responseContentType":     x.ResponseContentType,
+		"responseHeader":          x.ResponseHeader,
+		"responseLatency":         latency.String(),
+		"responseLatencyMs":       util.Milliseconds(latency),
+		"responseStatus":          x.Status,
+		"responseStatusClass":     statusClass(x.Status),
+		"responseTimestamp":       r.start.Add(latency).Format(time.RFC3339Nano),
+		"responseUser":            responseUser(),
+		"target":                  r.clientLog.list(),
+	}
+	if !x.NoRoutes {
+		fields["requestRoute"] = x.Route
+		if x.Handler != "" {
+			fields["requestHandler"] = x.Handler
+		}
+	}
+	if x.Params != nil {
+		fields["requestParams"] = paramFields(x.Params)
+	}
+
+	// Attach the value and stack trace of a recovered panic.
+	if r.recovered != nil {
+		for key, value := range r.recovered.fields() {
+			fields[key] = value
+		}
+	}
+
+	// Attach the error returned by the handlers.
+	for key, value := range x.ErrorFields {
+		fields[key] = value
+	}
+
+	// Flag forced requests, which may not be representative of the sampled traffic.
+	if force {
+		fields["forceLogged"] = true
+	}
+
+	// Flag documents whose bodies didn't fit into the memory budget.
+	if b.omitted {
+		fields["bodyOmitted"] = true
+	}
+
+	// Report the target sub-entries that didn't fit into the budget.
+	if dropped := r.clientLog.droppedCount(); dropped > 0 {
+		fields["targetDropped"] = dropped
+	}
+
+	// Capture the preferred languages and the client hints.
+	addClientHints(config, x.Header, fields)
+	addJWTClaims(config, x.Header, fields)
+
+	// Name the operation of SOAP requests.
+	if action := soapAction(x.GetHeader("SOAPAction"), x.RequestContentType); action != "" {
+		fields["soapAction"] = action
+	}
+
+	// Name the operation of GraphQL requests, which all share the same URL.
+	addGraphQL(config, r.method, r.path, b.request, fields)
+
+	// Attach the business events logged by LogEvent.
+	if list := r.events.list(); len(list) > 0 {
+		fields["events"] = list
+	}
+
+	// Name the operation, after the route unless the handler called SetTransactionName.
+	if name := transactionName(r.transaction, r.method, x.Route); name != "" {
+		fields["transactionName"] = name
+	}
+
+	// Identify the user set by SetUser.
+	for key, value := range r.user.fields() {
+		fields[key] = value
+	}
+
+	// Attribute the request to its tenant.
+	if tenant != "" {
+		fields["requestTenant"] = tenant
+	}
+
+	if !x.NoRoutes {
+		// Compare the request with the baseline of its route.
+		detectAnomaly(r.method, x.Route, latency, level, fields)
+
+		// Keep a sanitized example of the route if requested.
+		if keep {
+			collectExample(x.Context, x.Route, x.Status, fields)
+		}
+	}
+
+	// Describe the outcome and the duration of the request for Elastic Observability.
+	addEventFields(fields, level, latency)
+
+	// Merge the fields added by the application.
+	if x.Fields != nil {
+		for key, value := range x.Fields() {
+			fields[key] = value
+		}
+	}
+
+	// Build the candidate's version of the document from the same request.
+	var candidate logrus.Fields
+	if keepDark {
+		candidate = dark.document(force, darkRequest{
+			method:              r.method,
+			path:                r.path,
+			url:                 x.URL,
+			query:               x.Query,
+			params:              x.Params,
+			headers:             x.Header,
+			requestContentType:  x.RequestContentType,
+			requestBody:         b.rawRequest,
+			responseContentType: x.ResponseContentType,
+			responseBody:        b.rawResponse,
+			status:              x.Status,
+		}, fields)
+	}
+
+	// Let the registered plugins post-process the document, name its fields after ECS and
+	// the naming convention, then log it as a request document.
+	plugin.Apply(fields)
+	applyECSFields(config, fields)
+	applyFieldNaming(config, fields)
+	var current logrus.Fields
+	if keep {
+		current = fields
+		r.Logger.WithContext(logger.WithCategory(x.Context, logger.CategoryRequest)).
+			WithFields(fields).
+			Log(level)
+	}
+
+	// Write the candidate's version of the document and compare it with the current one.
+	if keepDark {
+		dark.log(x.Context, r.Logger, level, current, candidate)
+	} else {
+		dark.record(current, nil)
+	}
+}
+
+// requestBodies holds the bodies of a request document.
+type requestBodies struct {
+	request, response       []byte // Bodies captured under the current configuration
+	rawRequest, rawResponse []byte // Bodies available to the candidate configuration
+	requestBytes            int64  // Size of the request body
+	reserved                int64  // Bytes reserved from the memory budget, to release after logging
+	omitted                 bool   // Set when the bodies didn't fit into the memory budget
+}
+
+// bodies captures the bodies of x under config, for the request document. captureDark is set
+// if the candidate configuration captures the request body, so it is read for the candidate
+// even if the current configuration doesn't capture it.
+func (r *Request) bodies(config Config, x Exchange, force, captureDark bool) requestBodies {
+	if x.ReadRequestBody != nil {
+		return r.streamedBodies(config, x, force, captureDark)
+	}
+
+	b := requestBodies{
+		request:      x.RequestBody,
+		response:     x.ResponseBody,
+		rawRequest:   x.RequestBody,
+		rawResponse:  x.ResponseBody,
+		requestBytes: x.RequestBytes,
+	}
+	if config.DisableBodyCapture {
+		b.request, b.response = nil, nil
+	}
+	if !force && !captureRequestBody(config, r.path, x.RequestContentType) {
+		b.request = nil
+	}
+	if !force && !captureResponseBody(config, x.Status, b.response) {
+		b.response = nil
+	}
+
+	captured := int64(len(b.request) + len(b.response))
+	if b.omitted = !reserveBody(captured, force); b.omitted {
+		b.request, b.response = nil, nil
+	} else {
+		b.reserved = captured
+	}
+	return b
+}
+
+// streamedBodies captures the bodies of x for frameworks leaving the request body to the
+// handlers, see Exchange.ReadRequestBody. The request body is read if the current
+// configuration or the candidate captures it, and the response body comes from the capture
+// of CaptureResponse.
+func (r *Request) streamedBodies(config Config, x Exchange, force, captureDark bool) requestBodies {
+	captureRequest := !config.DisableBodyCapture && (force || captureRequestBody(config, r.path, x.RequestContentType))
+
+	var b requestBodies
+	if captureRequest || captureDark {
+		b.rawRequest, b.reserved, b.omitted = readRequestBody(x.ReadRequestBody, x.RequestBytes, force)
+	}
+	if captureRequest {
+		b.request = b.rawRequest
+	}
+
+	if r.capture != nil {
+		b.rawResponse = r.capture.body.Bytes()
+		if r.capture.omitted {
+			b.rawResponse = nil
+			b.omitted = true
+		}
+	}
+	b.response = b.rawResponse
+	if config.DisableBodyCapture || !force && !captureResponseBody(config, x.Status, b.response) {
+		b.response = nil
+	}
+
+	// Size the request body from its length, or else from the body read for the log.
+	b.requestBytes = x.RequestBytes
+	if b.requestBytes < 0 {
+		b.requestBytes = int64(len(b.rawRequest))
+	}
+	return b
+}
+
+// readRequestBody reads a request body of contentLength bytes, -1 if unknown, with read if it
+// fits into the memory budget. The bodies of forced requests are read whatever the budget says.
+// It returns the body, the bytes reserved for it, which the caller must release, and whether
+// the body was omitted because it didn't fit.
+func readRequestBody(read func() ([]byte, error), contentLength int64, force bool) ([]byte, int64, bool) {
+	// Reserve a body of known length before reading it.
+	reserved := max(contentLength, 0)
+	if !reserveBody(reserved, force) {
+		return nil, 0, true
+	}
+
+	body, err := read()
+	if err != nil {
+		logger.Logger().Error(err)
+	}
+
+	// Reserve the rest of a body of unknown or wrong length once it is read.
+	if extra := int64(len(body)) - reserved; extra > 0 {
+		if !reserveBody(extra, force) {
+			return nil, reserved, true
+		}
+		reserved += extra
+	}
+
+	return body, reserved, false
+}
@@ -0,0 +1,29 @@
+// Package welogresty records the calls of Resty clients in the target field of the request
+// documents of welog. It is a module of its own, so the applications without Resty don't depend
+// on it:
+//
+//	import welogresty "github.com/christiandoxa/welog/resty"
+//
+//	resp, err := welogresty.NewClient(c.Request.Context()).R().Get("https://example.com")
+package welogresty
+
+import (
+	"context"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/model"
+	"github.com/go-resty/resty/v2"
+)
+
+// NewClient returns a Resty client sending its requests through welog.NewTransport. The
+// attempts of requests retried by the client are recorded with their number.
+func NewClient(ctx context.Context) *resty.Client {
+	client := resty.New()
+	return client.
+		SetTransport(welog.NewTransport(ctx, client.GetClient().Transport)).
+		OnBeforeRequest(func(c *resty.Client, r *resty.Request) error {
+			if c.RetryCount > 0 {
+				r.SetContext(welog.WithTargetAttempt(r.Context(), model.TargetAttempt{Number: r.Attempt, MaxRetries: c.RetryCount}))
+			}
+			return nil
+		})
+}
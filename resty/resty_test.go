@@ -0,0 +1,60 @@
+package welogresty
+
+import (
+	"context"
+	"fmt"
+	"github.com/christiandoxa/welog"
+	"github.com/go-resty/resty/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestNewClient tests that the calls of the client are recorded, with the number of their
+// attempt when retried.
+func TestNewClient(t *testing.T) {
+	welog.SetConfig(welog.Config{StdoutOnly: true})
+
+	// Serve a downstream service failing the first call and echoing the request ID.
+	var calls atomic.Int32
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"requestId":%q}`, r.Header.Get("X-Request-ID"))
+	}))
+	defer downstream.Close()
+
+	// Call it with a client retrying server errors.
+	ctx := welog.NewJobContext(context.Background(), "client-request")
+	client := NewClient(ctx).
+		SetRetryCount(3).
+		SetRetryWaitTime(time.Millisecond).
+		AddRetryCondition(func(r *resty.Response, _ error) bool { return r.StatusCode() >= 500 })
+	_, err := client.R().Get(downstream.URL)
+	assert.NoError(t, err)
+
+	// Assert that both attempts are numbered and the request ID was forwarded.
+	entries := welog.Targets(ctx)
+	if assert.Len(t, entries, 2) {
+		for i, entry := range entries {
+			assert.Equal(t, i+1, entry["targetRequestAttempt"])
+			assert.Equal(t, 3, entry["targetRequestMaxRetries"])
+		}
+		assert.Equal(t, http.StatusServiceUnavailable, entries[0]["targetResponseStatus"])
+		assert.Equal(t, logrus.Fields{"requestId": "client-request"}, entries[1]["targetResponseBody"])
+	}
+
+	// Assert that clients without retries don't number their calls.
+	_, err = NewClient(ctx).R().Get(downstream.URL)
+	assert.NoError(t, err)
+	if entries = welog.Targets(ctx); assert.Len(t, entries, 3) {
+		assert.NotContains(t, entries[2], "targetRequestAttempt")
+	}
+}
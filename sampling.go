@@ -0,0 +1,70 @@
+package welog
+
+import (
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the number of sampled requests per second using a fixed
+// one-second window.
+type rateLimiter struct {
+	mu     sync.Mutex
+	window int64 // Unix second of the current window
+	count  int   // Requests allowed in the current window
+}
+
+// limiter limits the successful requests logged per second by SamplePerSecond.
+var limiter rateLimiter
+
+// allow reports whether another request fits into the limit of the current second.
+func (l *rateLimiter) allow(limit int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if second := now.Unix(); second != l.window {
+		l.window = second
+		l.count = 0
+	}
+	if l.count >= limit {
+		return false
+	}
+	l.count++
+
+	return true
+}
+
+// sampled reports whether a request logged at level should be written under config.
+// Warnings and errors are always written. Other requests are kept with the probability
+// SampleRate, decided by hashing the request ID when SampleByRequestID is set so every
+// service sharing the request ID makes the same decision, and are then capped by
+// SamplePerSecond using l.
+func sampled(config Config, l *rateLimiter, requestID string, level logrus.Level) bool {
+	if level <= logrus.WarnLevel {
+		return true
+	}
+
+	if rate := config.SampleRate; rate > 0 && rate < 1 {
+		var draw float64
+		if config.SampleByRequestID && requestID != "" {
+			h := fnv.New64a()
+			_, _ = h.Write([]byte(requestID))
+			draw = float64(h.Sum64()) / math.MaxUint64
+		} else {
+			draw = rand.Float64()
+		}
+		if draw >= rate {
+			return false
+		}
+	}
+
+	if config.SamplePerSecond > 0 {
+		return l.allow(config.SamplePerSecond, logger.Now())
+	}
+
+	return true
+}
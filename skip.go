@@ -0,0 +1,34 @@
+package welog
+
+import (
+	"strings"
+)
+
+// shouldSkip reports whether a request is excluded from logging by the SkipPaths or
+// SkipMethods configuration. Paths match exactly, or by prefix when the configured path
+// ends with "*". Methods match case-insensitively.
+func shouldSkip(config Config, method, path string) bool {
+	for _, m := range config.SkipMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+
+	return matchPath(config.SkipPaths, path)
+}
+
+// matchPath reports whether path matches one of patterns, exactly, or by prefix when the
+// pattern ends with "*".
+func matchPath(patterns []string, path string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if p == path {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package welog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+	"net/netip"
+	"path"
+	"time"
+)
+
+// StartupPolicy decides how the error-returning constructors treat ElasticSearch at startup.
+type StartupPolicy int
+
+const (
+	// StartupDegrade only validates the configuration. An unreachable ElasticSearch is tolerated;
+	// entries go to the fallback file until it is reachable. It is the default.
+	StartupDegrade StartupPolicy = iota
+
+	// StartupRequireElastic additionally requires ElasticSearch to be reachable and to accept the
+	// credentials.
+	StartupRequireElastic
+
+	// StartupFailFast checks the same as StartupRequireElastic, and additionally makes SetConfig
+	// panic when the checks fail, for deployments preferring a crash to silently losing logs.
+	StartupFailFast
+)
+
+// startupTimeout bounds the connectivity check of the error-returning constructors and of
+// SetConfig under StartupFailFast.
+const startupTimeout = 10 * time.Second
+
+// CheckStartup validates the configuration set by SetConfig and, depending on its startup
+// policy, the connection to ElasticSearch, like the NewE constructors of the framework
+// integrations do. Call it before serving with NewHTTP to fail early.
+func CheckStartup() error {
+	config := currentConfig()
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+
+	if config.StartupPolicy == StartupDegrade || config.StdoutOnly || config.Development {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), startupTimeout)
+	defer cancel()
+
+	return logger.Ping(ctx)
+}
+
+// validateConfig reports every invalid setting of config.
+func validateConfig(config Config) error {
+	var errs []error
+
+	if config.ElasticURL == "" && len(config.ElasticURLs) == 0 && config.ElasticCloudID == "" &&
+		config.ElasticClient == nil && !config.StdoutOnly && !config.Development {
+		errs = append(errs, errors.New("ElasticURL is not set"))
+	}
+	if (config.ElasticURL != "" || len(config.ElasticURLs) > 0) && config.ElasticCloudID != "" {
+		errs = append(errs, errors.New("ElasticURL and ElasticCloudID are both set"))
+	}
+	if config.ElasticMaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("ElasticMaxRetries %d is negative", config.ElasticMaxRetries))
+	}
+	if config.ElasticFailbackInterval < 0 {
+		errs = append(errs, fmt.Errorf("ElasticFailbackInterval %s is negative", config.ElasticFailbackInterval))
+	}
+	if (config.ElasticClientCertPath == "") != (config.ElasticClientKeyPath == "") {
+		errs = append(errs, errors.New("ElasticClientCertPath and ElasticClientKeyPath must be set together"))
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("SampleRate %v is not between 0 and 1", config.SampleRate))
+	}
+	if config.SamplePerSecond < 0 {
+		errs = append(errs, fmt.Errorf("SamplePerSecond %d is negative", config.SamplePerSecond))
+	}
+	if config.AnomalyThreshold < 0 {
+		errs = append(errs, fmt.Errorf("AnomalyThreshold %v is negative", config.AnomalyThreshold))
+	}
+	if config.ElasticQueueSize < 0 {
+		errs = append(errs, fmt.Errorf("ElasticQueueSize %d is negative", config.ElasticQueueSize))
+	}
+	if config.ElasticQueueBytes < 0 {
+		errs = append(errs, fmt.Errorf("ElasticQueueBytes %d is negative", config.ElasticQueueBytes))
+	}
+	if config.ElasticEntryTTL < 0 {
+		errs = append(errs, fmt.Errorf("ElasticEntryTTL %s is negative", config.ElasticEntryTTL))
+	}
+	if config.ElasticWorkers < 0 {
+		errs = append(errs, fmt.Errorf("ElasticWorkers %d is negative", config.ElasticWorkers))
+	}
+	if config.ElasticBatchSize < 0 {
+		errs = append(errs, fmt.Errorf("ElasticBatchSize %d is negative", config.ElasticBatchSize))
+	}
+	if _, err := logrus.ParseLevel(config.LogLevel); config.LogLevel != "" && err != nil {
+		errs = append(errs, fmt.Errorf("LogLevel %q is not a level", config.LogLevel))
+	}
+	errs = append(errs, validateSinkFilter("OutputFilter", config.OutputFilter)...)
+	errs = append(errs, validateSinkFilter("ElasticFilter", config.ElasticFilter)...)
+	if config.BodyMaxDepth < 0 {
+		errs = append(errs, fmt.Errorf("BodyMaxDepth %d is negative", config.BodyMaxDepth))
+	}
+	if config.BodyMaxKeys < 0 {
+		errs = append(errs, fmt.Errorf("BodyMaxKeys %d is negative", config.BodyMaxKeys))
+	}
+	if config.MemoryBudget < 0 {
+		errs = append(errs, fmt.Errorf("MemoryBudget %d is negative", config.MemoryBudget))
+	}
+	if config.DiagnosticsInterval < 0 {
+		errs = append(errs, fmt.Errorf("DiagnosticsInterval %s is negative", config.DiagnosticsInterval))
+	}
+	if config.FieldNaming < CamelCase || config.FieldNaming > SnakeCase {
+		errs = append(errs, fmt.Errorf("FieldNaming %d is unknown", config.FieldNaming))
+	}
+	for mediaType, mode := range config.BinaryBodies {
+		if mode < BinarySize || mode > BinaryBase64 {
+			errs = append(errs, fmt.Errorf("BinaryBodies has an unknown mode %d for %q", mode, mediaType))
+		}
+	}
+	for method, methodConfig := range config.GRPCMethods {
+		if rate := methodConfig.SampleRate; rate < 0 || rate > 1 {
+			errs = append(errs, fmt.Errorf("GRPCMethods has a SampleRate %v not between 0 and 1 for %q", rate, method))
+		}
+	}
+	for _, proxy := range config.TrustedProxies {
+		_, prefixErr := netip.ParsePrefix(proxy)
+		_, addrErr := netip.ParseAddr(proxy)
+		if prefixErr != nil && addrErr != nil {
+			errs = append(errs, fmt.Errorf("TrustedProxies has an invalid entry %q", proxy))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateSinkFilter reports every invalid setting of the filter of the Config field name.
+func validateSinkFilter(name string, filter logger.SinkFilter) []error {
+	var errs []error
+
+	if _, err := logrus.ParseLevel(filter.Level); filter.Level != "" && err != nil {
+		errs = append(errs, fmt.Errorf("%s has a Level %q that is not a level", name, filter.Level))
+	}
+	for _, patterns := range [][]string{filter.Fields, filter.OmitFields} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("%s has an invalid field pattern %q", name, pattern))
+			}
+		}
+	}
+
+	return errs
+}
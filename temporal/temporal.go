@@ -0,0 +1,119 @@
+// Package temporal logs the workflows and activities run by Temporal workers with welog.
+// Every activity attempt and every workflow run gets a document of its own, with the
+// workflow ID as request ID, so the documents of a workflow and its activities share it:
+//
+//	w := worker.New(c, "queue", worker.Options{
+//		Interceptors: []interceptor.WorkerInterceptor{welogTemporal.NewInterceptor()},
+//	})
+//
+// Activities get a context prepared by welog.NewJobContext, so their logger and the calls
+// logged with welog.AddTarget belong to the attempt. Workflows are deterministic and can't
+// log while they run; their document is only written when they complete, not on replays.
+package temporal
+
+import (
+	"context"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/sirupsen/logrus"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+	"time"
+)
+
+// workerInterceptor logs the activities and workflows of a worker.
+type workerInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+// NewInterceptor returns a worker interceptor logging every activity attempt and workflow run.
+func NewInterceptor() interceptor.WorkerInterceptor {
+	return &workerInterceptor{}
+}
+
+// InterceptActivity implements interceptor.WorkerInterceptor.
+func (*workerInterceptor) InterceptActivity(
+	_ context.Context,
+	next interceptor.ActivityInboundInterceptor,
+) interceptor.ActivityInboundInterceptor {
+	return &activityInterceptor{ActivityInboundInterceptorBase: interceptor.ActivityInboundInterceptorBase{Next: next}}
+}
+
+// InterceptWorkflow implements interceptor.WorkerInterceptor.
+func (*workerInterceptor) InterceptWorkflow(
+	_ workflow.Context,
+	next interceptor.WorkflowInboundInterceptor,
+) interceptor.WorkflowInboundInterceptor {
+	return &workflowInterceptor{WorkflowInboundInterceptorBase: interceptor.WorkflowInboundInterceptorBase{Next: next}}
+}
+
+// activityInterceptor logs every attempt of an activity.
+type activityInterceptor struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+// ExecuteActivity logs the attempt with the activity type, ID, attempt, task queue, workflow,
+// latency, and error.
+func (a *activityInterceptor) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (any, error) {
+	info := activity.GetInfo(ctx)
+	ctx = welog.NewJobContext(ctx, info.WorkflowExecution.ID)
+
+	start := logger.Now()
+	result, err := a.Next.ExecuteActivity(ctx, in)
+	latency := logger.Since(start)
+
+	welog.LogJob(ctx, logrus.Fields{
+		"activityAttempt":   info.Attempt,
+		"activityId":        info.ActivityID,
+		"activityLatency":   latency.String(),
+		"activityLatencyMs": util.Milliseconds(latency),
+		"activityTaskQueue": info.TaskQueue,
+		"activityTimestamp": start.Format(time.RFC3339Nano),
+		"activityType":      info.ActivityType.Name,
+		"workflowId":        info.WorkflowExecution.ID,
+		"workflowRunId":     info.WorkflowExecution.RunID,
+	}, err)
+
+	return result, err
+}
+
+// workflowInterceptor logs the completion of a workflow run.
+type workflowInterceptor struct {
+	interceptor.WorkflowInboundInterceptorBase
+}
+
+// ExecuteWorkflow logs the completed run with the workflow type, ID, run ID, attempt, task
+// queue, latency in workflow time, and error. Runs continued as new aren't failures; they
+// are logged with workflowContinuedAsNew instead of the error.
+func (w *workflowInterceptor) ExecuteWorkflow(ctx workflow.Context, in *interceptor.ExecuteWorkflowInput) (any, error) {
+	start := workflow.Now(ctx)
+	result, err := w.Next.ExecuteWorkflow(ctx, in)
+
+	if workflow.IsReplaying(ctx) {
+		return result, err
+	}
+
+	info := workflow.GetInfo(ctx)
+	latency := workflow.Now(ctx).Sub(start)
+	fields := logrus.Fields{
+		"workflowAttempt":   info.Attempt,
+		"workflowId":        info.WorkflowExecution.ID,
+		"workflowLatency":   latency.String(),
+		"workflowLatencyMs": util.Milliseconds(latency),
+		"workflowRunId":     info.WorkflowExecution.RunID,
+		"workflowTaskQueue": info.TaskQueueName,
+		"workflowTimestamp": start.Format(time.RFC3339Nano),
+		"workflowType":      info.WorkflowType.Name,
+	}
+
+	logErr := err
+	if workflow.IsContinueAsNewError(err) {
+		fields["workflowContinuedAsNew"] = true
+		logErr = nil
+	}
+	welog.LogJob(welog.NewJobContext(context.Background(), info.WorkflowExecution.ID), fields, logErr)
+
+	return result, err
+}
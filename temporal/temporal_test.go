@@ -0,0 +1,58 @@
+package temporal
+
+import (
+	"bytes"
+	"context"
+	"github.com/christiandoxa/welog"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/stretchr/testify/assert"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// greet is the activity of the test workflow.
+func greet(ctx context.Context, name string) (string, error) {
+	welog.LoggerFromContext(ctx).Info("greeting")
+	return "Hello " + name, nil
+}
+
+// greeting is the test workflow.
+func greeting(ctx workflow.Context, name string) (string, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{StartToCloseTimeout: time.Minute})
+	var greeting string
+	err := workflow.ExecuteActivity(ctx, greet, name).Get(ctx, &greeting)
+	return greeting, err
+}
+
+// TestInterceptor tests that workflows and activities are logged with the workflow ID as request ID.
+func TestInterceptor(t *testing.T) {
+	welog.SetConfig(welog.Config{StdoutOnly: true})
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+
+	suite := &testsuite.WorkflowTestSuite{}
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{NewInterceptor()}})
+	env.RegisterActivity(greet)
+	env.ExecuteWorkflow(greeting, "welog")
+	assert.NoError(t, env.GetWorkflowError())
+
+	// Assert that the activity logs and the workflow completion share the request ID.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"message":"greeting"`)
+	assert.Contains(t, lines[1], `"activityType":"greet"`)
+	assert.Contains(t, lines[1], `"activityAttempt":1`)
+	assert.Contains(t, lines[2], `"workflowType":"greeting"`)
+	assert.Contains(t, lines[2], `"log.level":"info"`)
+	for _, line := range lines {
+		assert.Contains(t, line, `"requestId":"default-test-workflow-id"`)
+	}
+}
@@ -0,0 +1,117 @@
+package welog
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	maxTenants  = 10000   // Number of distinct tenants counted before the rest are folded together
+	otherTenant = "other" // Tenant the requests of uncounted tenants are attributed to
+)
+
+// TenantCounter holds the number of requests and errors of a tenant since the process started.
+type TenantCounter struct {
+	Requests uint64
+	Errors   uint64
+}
+
+var (
+	tenantCounters = map[string]*TenantCounter{} // Counters per tenant, bounded by maxTenants
+	tenantMutex    sync.Mutex                    // Protects access to tenantCounters
+)
+
+// countTenant counts a request of tenant logged at level. Requests logged at error level or
+// above are counted as errors as well.
+func countTenant(tenant string, level logrus.Level) {
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+
+	counter, ok := tenantCounters[tenant]
+	if !ok {
+		if len(tenantCounters) >= maxTenants {
+			tenant = otherTenant
+		}
+		if counter, ok = tenantCounters[tenant]; !ok {
+			counter = &TenantCounter{}
+			tenantCounters[tenant] = counter
+		}
+	}
+
+	counter.Requests++
+	if level <= logrus.ErrorLevel {
+		counter.Errors++
+	}
+}
+
+// requestTenant returns the tenant of a request, identified by the TenantHeader header, and
+// counts the request for it. It returns an empty string if tenants aren't tracked or the
+// header is missing.
+func requestTenant(getHeader func(string) string, level logrus.Level) string {
+	name := currentConfig().TenantHeader
+	if name == "" {
+		return ""
+	}
+
+	tenant := getHeader(name)
+	if tenant != "" {
+		countTenant(tenant, level)
+	}
+	return tenant
+}
+
+// TenantCounters returns a snapshot of the request and error counters per tenant, to be used
+// for quota enforcement or billing checks. It is empty unless Config.TenantHeader is set.
+func TenantCounters() map[string]TenantCounter {
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+
+	snapshot := make(map[string]TenantCounter, len(tenantCounters))
+	for tenant, counter := range tenantCounters {
+		snapshot[tenant] = *counter
+	}
+	return snapshot
+}
+
+// TenantMetricsHandler returns an http.Handler serving the tenant counters in the Prometheus
+// text exposition format, as the welog_tenant_requests_total and welog_tenant_errors_total
+// counters labelled by tenant. Use gin.WrapH or Fiber's adaptor package to mount it.
+func TenantMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		counters := TenantCounters()
+
+		tenants := make([]string, 0, len(counters))
+		for tenant := range counters {
+			tenants = append(tenants, tenant)
+		}
+		sort.Strings(tenants)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		_, _ = fmt.Fprintln(w, "# HELP welog_tenant_requests_total Requests logged per tenant.")
+		_, _ = fmt.Fprintln(w, "# TYPE welog_tenant_requests_total counter")
+		for _, tenant := range tenants {
+			_, _ = fmt.Fprintf(w, "welog_tenant_requests_total{tenant=\"%s\"} %d\n",
+				escapeLabel(tenant), counters[tenant].Requests)
+		}
+
+		_, _ = fmt.Fprintln(w, "# HELP welog_tenant_errors_total Requests logged at error level per tenant.")
+		_, _ = fmt.Fprintln(w, "# TYPE welog_tenant_errors_total counter")
+		for _, tenant := range tenants {
+			_, _ = fmt.Fprintf(w, "welog_tenant_errors_total{tenant=\"%s\"} %d\n",
+				escapeLabel(tenant), counters[tenant].Errors)
+		}
+	})
+}
+
+// labelEscaper escapes a Prometheus label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,32 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"sync/atomic"
+)
+
+// SetTransactionName names the operation of the request of ctx, e.g. "CreateOrder", in the
+// transactionName field of its document, in place of the default method and route, such as
+// "POST /api/v2/orders/:id/items". Latency dashboards can then group the requests by business
+// operation. Pass the request context of a Gin handler or the user context of a Fiber handler.
+// It does nothing if ctx doesn't belong to a request handled by the middlewares.
+func SetTransactionName(ctx context.Context, name string) {
+	if stored, ok := ctx.Value(generalkey.TransactionNameKey).(*atomic.Pointer[string]); ok {
+		stored.Store(&name)
+	}
+}
+
+// transactionName returns the name set by SetTransactionName in stored, or else the method
+// followed by the route, or empty without a route. It is safe on a nil stored.
+func transactionName(stored *atomic.Pointer[string], method, route string) string {
+	if stored != nil {
+		if name := stored.Load(); name != nil {
+			return *name
+		}
+	}
+	if route == "" {
+		return ""
+	}
+	return method + " " + route
+}
@@ -0,0 +1,77 @@
+package welog
+
+import (
+	"context"
+	"github.com/christiandoxa/welog/pkg/constant/generalkey"
+	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
+	"github.com/sirupsen/logrus"
+	"os/user"
+	"sync"
+)
+
+// requestUser holds the identity of the user making a request. It is stored behind
+// generalkey.UserKey by the middlewares and filled in by SetUser.
+type requestUser struct {
+	mu    sync.Mutex
+	id    string
+	name  string
+	email string
+}
+
+// SetUser records the user making the request of ctx, typically from an authentication
+// middleware. The request document gets the ECS fields user.id, user.name, and user.email
+// for the non-empty values. Pass the request context of a Gin handler or the user context
+// of a Fiber handler. It does nothing if ctx doesn't belong to a request handled by the
+// middlewares.
+func SetUser(ctx context.Context, id, name, email string) {
+	if u, ok := ctx.Value(generalkey.UserKey).(*requestUser); ok {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+
+		u.id, u.name, u.email = id, name, email
+	}
+}
+
+// fields returns the ECS user fields of the non-empty values. It is safe on a nil user.
+func (u *requestUser) fields() logrus.Fields {
+	fields := logrus.Fields{}
+	if u == nil {
+		return fields
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for key, value := range map[string]string{"user.id": u.id, "user.name": u.name, "user.email": u.email} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+var (
+	processUser     string    // Name of the OS user running the process
+	processUserOnce sync.Once // Ensures the OS user is only looked up once
+)
+
+// responseUser returns the value of the responseUser field: the configured ServiceUser,
+// or else the name of the OS user running the process. The OS user is looked up once
+// and cached, since the lookup does syscalls and may go through CGO/NSS.
+func responseUser() string {
+	if serviceUser := currentConfig().ServiceUser; serviceUser != "" {
+		return serviceUser
+	}
+
+	processUserOnce.Do(func() {
+		currentUser, err := user.Current()
+		if err != nil {
+			logger.Logger().Error(err)
+			processUser = "unknown"
+			return
+		}
+		processUser = currentUser.Username
+	})
+
+	return processUser
+}
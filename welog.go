@@ -1,314 +1,515 @@
+// Package welog provides request and response logging middlewares that ship structured entries
+// to ElasticSearch: NewHTTP for net/http, and the modules github.com/christiandoxa/welog/fiber
+// and github.com/christiandoxa/welog/gin for Fiber and Gin.
 package welog
 
 import (
-	"bytes"
+	"fmt"
 	"github.com/christiandoxa/welog/pkg/constant/envkey"
-	"github.com/christiandoxa/welog/pkg/constant/generalkey"
 	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
-	"github.com/christiandoxa/welog/pkg/util"
-	"github.com/gin-gonic/gin"
-	"github.com/goccy/go-json"
-	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/sirupsen/logrus"
-	"io"
 	"os"
-	"os/user"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+var (
+	activeConfig Config       // Current configuration set by SetConfig
+	configMutex  sync.RWMutex // Protects access to activeConfig
+)
+
+// Config holds the ElasticSearch connection parameters and the behavior of the middlewares.
 type Config struct {
 	ElasticIndex    string
 	ElasticURL      string
 	ElasticUsername string
 	ElasticPassword string
-}
 
-// responseBodyWriter is a custom response writer that captures the response body.
-type responseBodyWriter struct {
-	gin.ResponseWriter
-	body *bytes.Buffer
-}
+	// ElasticURLs lists further nodes of the cluster. Requests are load balanced over them and
+	// ElasticURL, and failed requests are retried on another node.
+	ElasticURLs []string
+
+	// ElasticDiscoverInterval makes the client discover the nodes of the cluster at startup and then
+	// every interval, so nodes added after startup are used. Zero disables discovery.
+	ElasticDiscoverInterval time.Duration
+
+	// ElasticMaxRetries is how many times a failed request is retried. Zero uses the default of 3.
+	ElasticMaxRetries int
+
+	// ElasticRetryOnStatus lists the response statuses that are retried. Nil uses 502, 503, and 504.
+	ElasticRetryOnStatus []int
+
+	// ElasticSecondaryURLs lists the nodes of a secondary cluster, reached with the same credentials
+	// and TLS settings, that entries are shipped to while the primary one is unreachable, before
+	// resorting to the fallback file.
+	ElasticSecondaryURLs []string
+
+	// ElasticFailbackInterval is how often the primary cluster is checked while entries are shipped
+	// to the secondary one, so they go back to it once it recovers. Zero uses 30s.
+	ElasticFailbackInterval time.Duration
+
+	// ElasticAPIKey is the base64-encoded API key authenticating with ElasticSearch, used instead
+	// of ElasticUsername and ElasticPassword when set.
+	ElasticAPIKey string
 
-// Write writes the response body to both the underlying ResponseWriter and the buffer.
-func (w responseBodyWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
-	return w.ResponseWriter.Write(b)
+	// ElasticServiceToken is the service account token authenticating with ElasticSearch, used
+	// instead of ElasticUsername and ElasticPassword when set.
+	ElasticServiceToken string
+
+	// ElasticCloudID identifies an Elastic Cloud deployment to connect to instead of ElasticURL.
+	ElasticCloudID string
+
+	// ElasticCACertPath and ElasticCACertPEM provide PEM-encoded certificate authorities, as a file or
+	// inline, trusted in addition to the system ones to verify the certificate of ElasticSearch.
+	ElasticCACertPath string
+	ElasticCACertPEM  string
+
+	// ElasticClientCertPath and ElasticClientKeyPath locate the PEM-encoded certificate and key
+	// presented to ElasticSearch for mutual TLS.
+	ElasticClientCertPath string
+	ElasticClientKeyPath  string
+
+	// ElasticInsecureSkipVerify disables the verification of the ElasticSearch certificate. Only use it
+	// for testing.
+	ElasticInsecureSkipVerify bool
+
+	// ElasticClient is an existing client used to ship the entries instead of one built from the
+	// connection settings above, e.g. to reuse a custom transport, proxy, or instrumentation.
+	ElasticClient *elasticsearch.Client
+
+	// ElasticIndexDateLayout is the Go time layout of the date appended to ElasticIndex, such as
+	// "2006-01" for monthly indices. Empty uses "2006-01-02".
+	ElasticIndexDateLayout string
+
+	// IndexNameFunc names the index of every entry instead of ElasticIndex followed by the date, e.g.
+	// to route entries by level or tenant. It may call logger.DefaultIndexName to build upon the default.
+	IndexNameFunc func(entry *logrus.Entry) string
+
+	// DocumentIDFunc computes the ElasticSearch document ID of every entry, so retried and replayed
+	// entries overwrite their earlier copy. Nil hashes the timestamp, level, message, and fields, and
+	// an empty ID lets ElasticSearch generate one.
+	DocumentIDFunc func(entry *logrus.Entry) string
+
+	// ElasticPipeline names the ingest pipeline processing the documents server-side, e.g. with the
+	// geoip, user_agent, or fingerprint processors. Empty indexes the documents as they are.
+	ElasticPipeline string
+
+	// ElasticWriteTimeout bounds a single write to ElasticSearch. Zero uses the default of 10 seconds.
+	ElasticWriteTimeout time.Duration
+
+	// ElasticSlowThreshold is the 95th percentile of write latency above which ElasticSearch is
+	// considered slow and bypassed for ElasticBypassDuration. Zero uses the default of 2 seconds,
+	// a negative value disables the detection.
+	ElasticSlowThreshold time.Duration
+
+	// ElasticBypassDuration is how long a slow ElasticSearch is bypassed. Zero uses the default of 1 minute.
+	ElasticBypassDuration time.Duration
+
+	// ElasticQueueSize is the number of entries buffered for ElasticSearch, with a separate buffer of
+	// the same size for warnings and errors, which are written first. Entries logged while their
+	// buffer is full are dropped. Zero uses the default of 1000.
+	ElasticQueueSize int
+
+	// ElasticQueueBytes caps the bytes of the entries buffered for ElasticSearch. Entries logged while
+	// the buffer is full are dropped. Zero means unlimited.
+	ElasticQueueBytes int64
+
+	// ElasticEntryTTL is the age after which a buffered entry is written to the fallback file instead
+	// of ElasticSearch, so fresh entries come first after an outage. Zero keeps entries indefinitely.
+	ElasticEntryTTL time.Duration
+
+	// ElasticSpoolDir is a directory where the buffered entries are also written, so entries that
+	// weren't delivered when the process stopped are shipped by the next one. Empty disables it.
+	ElasticSpoolDir string
+
+	// ElasticWorkers is the number of workers writing the buffered entries concurrently, so one slow
+	// write doesn't stall every entry. Zero uses a single worker.
+	ElasticWorkers int
+
+	// ElasticBatchSize is the maximum number of buffered entries a worker writes with one bulk request.
+	// Zero or 1 writes every entry with its own request.
+	ElasticBatchSize int
+
+	// LogLevel is the minimum level of the entries shipped to ElasticSearch, such as "info", so debug
+	// entries only reach the local output. Empty uses the LOG_LEVEL environment variable, and ships
+	// every entry if it is unset too. The level of the logger, see SetLevel, decides what is logged at all.
+	LogLevel string
+
+	// StdoutOnly writes the entries to stdout only, without connecting to ElasticSearch.
+	StdoutOnly bool
+
+	// Development writes the entries to stdout in a colorized, human-readable format instead of ECS
+	// JSON, without connecting to ElasticSearch, so local runs are readable. Don't use it in production.
+	Development bool
+
+	// Formatter formats the entries, both in the output and in ElasticSearch, instead of ECS JSON, for
+	// teams with their own field schema. Nil uses ECS JSON, or the console format under Development.
+	Formatter logrus.Formatter
+
+	// Clock tells the time of the timestamps and latencies of the documents and of the entries,
+	// e.g. a fixed clock making golden-file tests deterministic. Nil uses the system clock.
+	Clock logger.Clock
+
+	// Processors transform every entry, including the request documents, before it reaches the
+	// output, the subscribers, and ElasticSearch, e.g. for custom redaction, enrichment, or
+	// renaming. A processor returning nil drops the entry.
+	Processors []logger.Processor
+
+	// OutputFilter selects the entries and fields written to the logger's output, and ElasticFilter
+	// those shipped to ElasticSearch, e.g. every entry in the output but only info and above, without
+	// bodies, in ElasticSearch. The zero values pass everything.
+	OutputFilter  logger.SinkFilter
+	ElasticFilter logger.SinkFilter
+
+	// StartupPolicy decides whether CheckStartup, and so the NewE constructors of the middlewares,
+	// require ElasticSearch to be reachable, and whether SetConfig panics on an invalid
+	// configuration or an unreachable ElasticSearch. The default, StartupDegrade, only validates
+	// the configuration.
+	StartupPolicy StartupPolicy
+
+	// FallbackPath is the file receiving entries that can't be written to ElasticSearch.
+	// Empty uses "logs.txt" in the working directory.
+	FallbackPath string
+
+	// DeadLetterPath is the file receiving entries ElasticSearch rejects permanently, e.g. because of a
+	// mapping conflict or their size, along with the error it returned. Empty uses "deadletter.txt".
+	DeadLetterPath string
+
+	// ServiceName, ServiceVersion, and ServiceEnvironment identify the emitting service. They are stamped
+	// onto every entry as the ECS service.name, service.version, and labels.env fields when set.
+	ServiceName        string
+	ServiceVersion     string
+	ServiceEnvironment string
+
+	// DisableHostMetadata stops stamping the host.hostname, kubernetes.pod.name, kubernetes.namespace,
+	// kubernetes.node.name, and container.id fields onto every entry.
+	DisableHostMetadata bool
+
+	// ServiceUser overrides the responseUser field, which otherwise holds the OS user running the process.
+	ServiceUser string
+
+	// LevelFunc maps the status and error of a request to the level of its log entry.
+	// Nil uses DefaultLevelFunc.
+	LevelFunc LevelFunc
+
+	// SampleRate is the fraction of successful requests that are logged, between 0 and 1.
+	// Requests logged at warning level or above are always logged. Zero logs every request.
+	SampleRate float64
+
+	// SampleByRequestID derives the sampling decision from a hash of the request ID instead of
+	// a random draw, so every service and entry sharing a request ID is sampled the same way.
+	SampleByRequestID bool
+
+	// SamplePerSecond caps the number of successful requests logged per second. Zero disables the cap.
+	SamplePerSecond int
+
+	// DebugSecret signs the debug tokens created by DebugToken. Requests carrying a valid token in
+	// the DebugHeader header or the DebugCookie cookie are exempt from sampling until the token
+	// expires. Empty disables debug tokens.
+	DebugSecret string
+
+	// DisableBodyCapture leaves the request and response bodies out of the request documents.
+	DisableBodyCapture bool
+
+	// RequestBodySkipPaths lists the paths, with the same matching as SkipPaths, whose request bodies
+	// aren't captured, such as upload endpoints or webhook receivers with huge payloads. Their
+	// requests are still logged, with the headers, latency, and requestBytes.
+	RequestBodySkipPaths []string
+
+	// RequestBodySkipTypes lists the media types, or "type/*" wildcards, of the request bodies that
+	// aren't captured, such as "multipart/form-data".
+	RequestBodySkipTypes []string
+
+	// ResponseBodyOnError captures the response bodies of failed requests only, with a status of
+	// 400 or more, and of the responses matching ResponseBodyFunc. The other responses are logged
+	// with their status and responseBytes, cutting the volume of the request documents.
+	ResponseBodyOnError bool
+
+	// ResponseBodyFunc selects, under ResponseBodyOnError, the successful responses whose body is
+	// captured anyway, e.g. those reporting an error in a 200 body.
+	ResponseBodyFunc func(status int, body []byte) bool
+
+	// RequestBudget limits the request documents shipped to ElasticSearch.
+	RequestBudget Budget
+
+	// AppLogBudget limits the application log entries shipped to ElasticSearch, i.e. every entry
+	// that isn't a request document.
+	AppLogBudget Budget
+
+	// DedupeWindow aggregates identical application log entries shipped to ElasticSearch, with the
+	// same level, message, and fields. The first entry is shipped and its repeats within the window
+	// are shipped as a single entry carrying their number in repeatCount. Zero disables it.
+	DedupeWindow time.Duration
+
+	// MemoryBudget caps the bytes held by welog's buffers, i.e. the entries queued for ElasticSearch
+	// and the captured request and response bodies. Entries that don't fit are dropped and bodies
+	// that don't fit are omitted, flagged by the bodyOmitted field. Zero means unlimited.
+	MemoryBudget int64
+
+	// DiagnosticsInterval is how often welog reports its own health: entries dropped or diverted to the
+	// fallback and dead-letter files, failed writes, reconnection attempts, and the queue depth. Zero
+	// disables the reports.
+	DiagnosticsInterval time.Duration
+
+	// DiagnosticsFunc receives the reports, e.g. to export them as metrics. Nil writes them to stderr.
+	DiagnosticsFunc func(diagnostics logger.Diagnostics)
+
+	// TargetBudget limits the target sub-entries recorded by AddTarget and the LogClient functions.
+	// Entries over budget are counted in the targetDropped field of the request document.
+	TargetBudget Budget
+
+	// CollectExamples writes one sanitized request/response example per route, method, and status
+	// combination to ExampleIndex, to be used by API documentation and QA.
+	CollectExamples bool
+
+	// ExampleIndex is the index prefix of the collected examples. Empty uses ElasticIndex followed
+	// by "-examples".
+	ExampleIndex string
+
+	// AuditIndex is the index prefix of the entries logged by Audit. Empty uses ElasticIndex followed
+	// by "-audit".
+	AuditIndex string
+
+	// AnomalyDetection tags request documents whose latency or outcome deviates from the
+	// in-process baseline of their route with the anomaly and zscore fields.
+	AnomalyDetection bool
+
+	// AnomalyThreshold is the latency z-score above which a request is anomalous. Zero uses 3.
+	AnomalyThreshold float64
+
+	// ParseXML decodes XML request, response, and target bodies, such as SOAP envelopes, into the
+	// structured body fields. XML bodies are only logged as raw strings otherwise.
+	ParseXML bool
+
+	// BodyMaxDepth is the maximum nesting depth of the objects in the structured body fields, the body
+	// itself being at depth 1. Deeper objects are logged as JSON strings, so deeply nested bodies don't
+	// explode the ElasticSearch mapping. Zero means unlimited.
+	BodyMaxDepth int
+
+	// BodyMaxKeys is the maximum number of keys over all objects of a structured body field. The keys
+	// beyond it, in sorted order, are logged together as a JSON string under "_truncated". Zero means
+	// unlimited.
+	BodyMaxKeys int
+
+	// BinaryBodies selects how binary bodies are logged in the body string fields, keyed by media
+	// type or "type/*" wildcard, e.g. {"image/*": BinaryHash}. Bodies of binary media types, such as
+	// images or protobuf, and bodies that aren't valid UTF-8 are binary. Unlisted types use BinarySize.
+	BinaryBodies map[string]BinaryMode
+
+	// GraphQLPaths lists the paths of GraphQL endpoints, with the same matching as SkipPaths. POST
+	// requests to them are described by the graphqlOperationName, graphqlOperationType, and sanitized
+	// graphqlVariables fields, taken from the request body. Nil uses "/graphql".
+	GraphQLPaths []string
+
+	// DisableClientHints stops capturing the Accept-Language header and the Sec-CH-UA* client hints
+	// as the requestLanguages and requestClientHints fields, for privacy-sensitive deployments.
+	DisableClientHints bool
+
+	// JWTClaims lists the claims of the bearer token of the Authorization header that are logged in
+	// the requestClaims field, such as "sub", "iss", "aud", or a custom tenant claim. The token isn't
+	// verified and is never logged by this option. Empty disables the extraction.
+	JWTClaims []string
+
+	// TenantHeader names the request header identifying the tenant or API key of a request, such as
+	// "X-Tenant-ID". When set, the tenant is logged in the requestTenant field and requests and errors
+	// are counted per tenant, see TenantCounters and TenantMetricsHandler.
+	TenantHeader string
+
+	// ProgressInterval makes requests running longer than the interval, such as large uploads, emit a
+	// "request in progress" document every interval with the bytes received so far and their phase,
+	// so stuck requests are visible before they complete. Zero disables progress documents.
+	ProgressInterval time.Duration
+
+	// TrustedProxies lists the CIDRs or addresses of the load balancers and proxies in front of the
+	// service. Requests received from them have their requestIp resolved from the CF-Connecting-IP,
+	// True-Client-IP, X-Real-IP, or X-Forwarded-For headers. Empty keeps the resolution of the framework.
+	TrustedProxies []string
+
+	// RedactKeys lists the query and route parameters whose values are replaced by "[REDACTED]" in
+	// the requestQuery, requestParams, and requestUrl fields, compared case-insensitively. Nil uses
+	// access_token, api_key, apikey, password, secret, signature, and token.
+	RedactKeys []string
+
+	// ECSFields names the fields of the request documents after the Elastic Common Schema, such as
+	// http.request.method, http.response.status_code, url.full, client.ip, and event.duration instead
+	// of requestMethod, responseStatus, requestUrl, requestIp, and responseLatency, so the Kibana and
+	// APM dashboards work out of the box. Fields without an ECS equivalent keep their name.
+	ECSFields bool
+
+	// FieldNaming is the naming convention of the fields of the request documents, their target
+	// sub-entries, and the progress documents, e.g. SnakeCase for request_method instead of
+	// requestMethod, to match existing index mappings. The default is CamelCase.
+	FieldNaming FieldNaming
+
+	// GRPCMethods tunes the logging of the gRPC calls recorded by github.com/christiandoxa/welog/grpc
+	// per method, keyed by full method name such as "/grpc.health.v1.Health/Check". A trailing "*"
+	// matches every method with the given prefix, e.g. "/internal.Cache/*"; exact names win over
+	// prefixes, and longer prefixes over shorter ones.
+	GRPCMethods map[string]GRPCMethodConfig
+
+	// SkipPaths lists request paths that are not logged, such as "/healthz" or "/metrics".
+	// A trailing "*" matches every path with the given prefix, e.g. "/static/*".
+	SkipPaths []string
+
+	// SkipMethods lists request methods that are not logged, such as "OPTIONS".
+	SkipMethods []string
+
+	// GeoIPDatabases lists the paths of the MaxMind databases, such as GeoLite2-City.mmdb and
+	// GeoLite2-ASN.mmdb, the geoip plugin looks the request and gRPC peer IPs up in. It takes
+	// effect once pkg/plugin/geoip is imported; empty keeps the databases opened by geoip.Open.
+	GeoIPDatabases []string
 }
 
+// SetConfig configures the ElasticSearch connection through environment variables, except
+// for the API key, service token, and TLS material, and stores the middleware options. Call it before installing the middlewares. Under
+// StartupFailFast, it panics if the configuration is invalid or ElasticSearch is unreachable.
 func SetConfig(config Config) {
+	storeConfig(config)
+	applyBudgets(config)
+	logger.SetDedupeWindow(config.DedupeWindow)
+	applyTrustedProxies(config)
+	logger.SetMemoryBudget(config.MemoryBudget)
+	logger.SetDiagnostics(config.DiagnosticsInterval, config.DiagnosticsFunc)
+	applyExampleIndex(config)
+	applyAuditIndex(config)
+	applyMetadata(config)
+	if err := plugin.Configure(plugin.Settings{GeoIPDatabases: config.GeoIPDatabases}); err != nil {
+		logger.Logger().Error(err)
+	}
+
 	if err := os.Setenv(envkey.ElasticIndex, config.ElasticIndex); err != nil {
 		logger.Logger().Error(err)
 	}
+	if err := os.Setenv(envkey.ElasticIndexDateLayout, config.ElasticIndexDateLayout); err != nil {
+		logger.Logger().Error(err)
+	}
 	if err := os.Setenv(envkey.ElasticURL, config.ElasticURL); err != nil {
 		logger.Logger().Error(err)
 	}
+	if err := os.Setenv(envkey.ElasticURLs, strings.Join(config.ElasticURLs, ",")); err != nil {
+		logger.Logger().Error(err)
+	}
+	if err := os.Setenv(envkey.ElasticDiscoverInterval, config.ElasticDiscoverInterval.String()); err != nil {
+		logger.Logger().Error(err)
+	}
+	if err := os.Setenv(envkey.ElasticMaxRetries, strconv.Itoa(config.ElasticMaxRetries)); err != nil {
+		logger.Logger().Error(err)
+	}
+	if err := os.Setenv(envkey.ElasticRetryOnStatus, joinInts(config.ElasticRetryOnStatus)); err != nil {
+		logger.Logger().Error(err)
+	}
+	if err := os.Setenv(envkey.ElasticSecondaryURLs, strings.Join(config.ElasticSecondaryURLs, ",")); err != nil {
+		logger.Logger().Error(err)
+	}
+	if err := os.Setenv(envkey.ElasticFailbackInterval, config.ElasticFailbackInterval.String()); err != nil {
+		logger.Logger().Error(err)
+	}
 	if err := os.Setenv(envkey.ElasticUsername, config.ElasticUsername); err != nil {
 		logger.Logger().Error(err)
 	}
 	if err := os.Setenv(envkey.ElasticPassword, config.ElasticPassword); err != nil {
 		logger.Logger().Error(err)
 	}
-}
-
-// NewFiber creates a new Fiber middleware that logs requests and responses.
-func NewFiber(fiberConfig fiber.Config) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		// Generate or retrieve the request ID.
-		requestID := c.Get("X-Request-ID")
-		if requestID == "" {
-			requestID = uuid.NewString()
-		}
-
-		// Set the request ID to the context.
-		c.Set("X-Request-ID", requestID)
-
-		// Set request-related values to the context.
-		c.Locals(generalkey.RequestID, requestID)
-		c.Locals(generalkey.Logger, logger.Logger().WithField(generalkey.RequestID, requestID))
-		c.Locals(generalkey.ClientLog, []logrus.Fields{})
-
-		reqTime := time.Now()
-
-		// Proceed to the next middleware and handle any errors.
-		if err := c.Next(); err != nil {
-			errorHandler := fiber.DefaultErrorHandler
-			if fiberConfig.ErrorHandler != nil {
-				errorHandler = fiberConfig.ErrorHandler
-			}
-			if err = errorHandler(c, err); err != nil {
-				logFiber(c, reqTime)
-				return err
-			}
-		}
-
-		// Log the request and response details.
-		logFiber(c, reqTime)
-
-		return nil
+	if err := os.Setenv(envkey.ElasticCloudID, config.ElasticCloudID); err != nil {
+		logger.Logger().Error(err)
 	}
-}
-
-// logFiber logs the details of the Fiber request and response.
-func logFiber(c *fiber.Ctx, requestTime time.Time) {
-	latency := time.Since(requestTime)
-
-	// Get the current user; if not available, set as "unknown".
-	currentUser, err := user.Current()
-	if err != nil {
-		c.Locals(generalkey.Logger).(*logrus.Entry).Error(err)
-		currentUser = &user.User{Username: "unknown"}
+	if err := os.Setenv(envkey.ElasticInsecureSkipVerify, strconv.FormatBool(config.ElasticInsecureSkipVerify)); err != nil {
+		logger.Logger().Error(err)
 	}
-
-	var request, response logrus.Fields
-	if err = json.Unmarshal(c.Body(), &request); err != nil {
+	if err := os.Setenv(envkey.ElasticPipeline, config.ElasticPipeline); err != nil {
 		logger.Logger().Error(err)
 	}
-	if err = json.Unmarshal(c.Response().Body(), &response); err != nil {
+	if err := os.Setenv(envkey.ElasticWriteTimeout, config.ElasticWriteTimeout.String()); err != nil {
 		logger.Logger().Error(err)
 	}
-
-	clientLog := c.Locals(generalkey.ClientLog).([]logrus.Fields)
-
-	// Log various details of the request and response.
-	c.Locals(generalkey.Logger).(*logrus.Entry).WithFields(logrus.Fields{
-		"requestAgent":       c.Get("User-Agent"),
-		"requestBody":        request,
-		"requestBodyString":  string(c.Body()),
-		"requestContentType": c.Get("Content-Type"),
-		"requestHeader":      c.GetReqHeaders(),
-		"requestHostName":    c.Hostname(),
-		"requestId":          c.Locals(generalkey.RequestID),
-		"requestIp":          c.IP(),
-		"requestMethod":      c.Method(),
-		"requestProtocol":    c.Protocol(),
-		"requestTimestamp":   requestTime.Format(time.RFC3339Nano),
-		"requestUrl":         c.BaseURL() + c.OriginalURL(),
-		"responseBody":       response,
-		"responseBodyString": string(c.Response().Body()),
-		"responseHeader":     util.HeaderToMap(&c.Response().Header),
-		"responseLatency":    latency.String(),
-		"responseStatus":     c.Response().StatusCode(),
-		"responseTimestamp":  requestTime.Add(latency).Format(time.RFC3339Nano),
-		"responseUser":       currentUser.Username,
-		"target":             clientLog,
-	}).Info()
-}
-
-// LogFiberClient logs a custom client request and response for Fiber.
-func LogFiberClient(
-	c *fiber.Ctx,
-	requestURL string,
-	requestMethod string,
-	requestContentType string,
-	requestHeader map[string]interface{},
-	requestBody []byte,
-	responseHeader map[string]interface{},
-	responseBody []byte,
-	responseStatus int,
-	requestTime time.Time,
-	responseLatency time.Duration,
-) {
-	var requestField, responseField logrus.Fields
-
-	if err := json.Unmarshal(requestBody, &requestField); err != nil {
+	if err := os.Setenv(envkey.ElasticSlowThreshold, config.ElasticSlowThreshold.String()); err != nil {
 		logger.Logger().Error(err)
 	}
-	if err := json.Unmarshal(responseBody, &responseField); err != nil {
+	if err := os.Setenv(envkey.ElasticBypassDuration, config.ElasticBypassDuration.String()); err != nil {
 		logger.Logger().Error(err)
 	}
-
-	logData := logrus.Fields{
-		"targetRequestBody":        requestField,
-		"targetRequestBodyString":  string(requestBody),
-		"targetRequestContentType": requestContentType,
-		"targetRequestHeader":      requestHeader,
-		"targetRequestMethod":      requestMethod,
-		"targetRequestTimestamp":   requestTime.Format(time.RFC3339Nano),
-		"targetRequestURL":         requestURL,
-		"targetResponseBody":       responseField,
-		"targetResponseBodyString": string(responseBody),
-		"targetResponseHeader":     responseHeader,
-		"targetResponseLatency":    responseLatency.String(),
-		"targetResponseStatus":     responseStatus,
-		"targetResponseTimestamp":  requestTime.Add(responseLatency).Format(time.RFC3339Nano),
+	if err := os.Setenv(envkey.FallbackPath, config.FallbackPath); err != nil {
+		logger.Logger().Error(err)
 	}
-
-	clientLog := c.Locals(generalkey.ClientLog).([]logrus.Fields)
-	c.Locals(generalkey.ClientLog, append(clientLog, logData))
-}
-
-// NewGin creates a new Gin middleware that logs requests and responses.
-func NewGin() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Generate or retrieve the request ID.
-		requestID := c.GetHeader("X-Request-ID")
-		if requestID == "" {
-			requestID = uuid.NewString()
-		}
-
-		// Set the request ID in the context.
-		c.Header("X-Request-ID", requestID)
-
-		// Set request-related values to the context.
-		c.Set(generalkey.RequestID, requestID)
-		c.Set(generalkey.Logger, logger.Logger().WithField(generalkey.RequestID, requestID))
-		c.Set(generalkey.ClientLog, []logrus.Fields{})
-
-		// Create a response writer that captures the response body.
-		bodyBuf := &bytes.Buffer{}
-		writer := responseBodyWriter{body: bodyBuf, ResponseWriter: c.Writer}
-		c.Writer = writer
-
-		requestTime := time.Now()
-
-		// Proceed to the next middleware.
-		c.Next()
-
-		// Log the request and response details.
-		logGin(c, bodyBuf, requestTime)
+	if err := os.Setenv(envkey.DeadLetterPath, config.DeadLetterPath); err != nil {
+		logger.Logger().Error(err)
 	}
-}
-
-// logGin logs the details of the Gin request and response.
-func logGin(c *gin.Context, buf *bytes.Buffer, requestTime time.Time) {
-	latency := time.Since(requestTime)
-
-	currentUser, err := user.Current()
-	if err != nil {
+	if err := os.Setenv(envkey.ElasticQueueSize, strconv.Itoa(config.ElasticQueueSize)); err != nil {
 		logger.Logger().Error(err)
 	}
-
-	var request, response logrus.Fields
-	bodyBytes, err := io.ReadAll(c.Request.Body)
-	if err != nil {
+	if err := os.Setenv(envkey.ElasticQueueBytes, strconv.FormatInt(config.ElasticQueueBytes, 10)); err != nil {
 		logger.Logger().Error(err)
 	}
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-	if err = json.Unmarshal(bodyBytes, &request); err != nil {
+	if err := os.Setenv(envkey.ElasticEntryTTL, config.ElasticEntryTTL.String()); err != nil {
 		logger.Logger().Error(err)
 	}
-
-	responseBody := buf.Bytes()
-	if err = json.Unmarshal(responseBody, &response); err != nil {
+	if err := os.Setenv(envkey.ElasticSpoolDir, config.ElasticSpoolDir); err != nil {
 		logger.Logger().Error(err)
 	}
-
-	clientLog, _ := c.Get(generalkey.ClientLog)
-	clientLogFields := clientLog.([]logrus.Fields)
-
-	log, _ := c.Get(generalkey.Logger)
-	entry := log.(*logrus.Entry)
-
-	// Log various details of the request and response.
-	entry.WithFields(logrus.Fields{
-		"requestAgent":       c.GetHeader("User-Agent"),
-		"requestBody":        request,
-		"requestBodyString":  string(bodyBytes),
-		"requestContentType": c.GetHeader("Content-Type"),
-		"requestHeader":      c.Request.Header,
-		"requestHostName":    c.Request.Host,
-		"requestId":          c.GetString(generalkey.RequestID),
-		"requestIp":          c.ClientIP(),
-		"requestMethod":      c.Request.Method,
-		"requestProtocol":    c.Request.Proto,
-		"requestTimestamp":   requestTime.Format(time.RFC3339Nano),
-		"requestUrl":         c.Request.RequestURI,
-		"responseBody":       response,
-		"responseBodyString": string(responseBody),
-		"responseHeader":     c.Writer.Header(),
-		"responseLatency":    latency.String(),
-		"responseStatus":     c.Writer.Status(),
-		"responseTimestamp":  requestTime.Add(latency).Format(time.RFC3339Nano),
-		"responseUser":       currentUser.Username,
-		"target":             clientLogFields,
-	}).Info()
-}
-
-// LogGinClient logs a custom client request and response for Gin.
-func LogGinClient(
-	c *gin.Context,
-	requestURL string,
-	requestMethod string,
-	requestContentType string,
-	requestHeader map[string]interface{},
-	requestBody []byte,
-	responseHeader map[string]interface{},
-	responseBody []byte,
-	responseStatus int,
-	requestTime time.Time,
-	responseLatency time.Duration,
-) {
-	var requestField, responseField logrus.Fields
-
-	if err := json.Unmarshal(requestBody, &requestField); err != nil {
+	if err := os.Setenv(envkey.ElasticWorkers, strconv.Itoa(config.ElasticWorkers)); err != nil {
 		logger.Logger().Error(err)
 	}
-	if err := json.Unmarshal(responseBody, &responseField); err != nil {
+	if err := os.Setenv(envkey.ElasticBatchSize, strconv.Itoa(config.ElasticBatchSize)); err != nil {
 		logger.Logger().Error(err)
 	}
-
-	logData := logrus.Fields{
-		"targetRequestBody":        requestField,
-		"targetRequestBodyString":  string(requestBody),
-		"targetRequestContentType": requestContentType,
-		"targetRequestHeader":      requestHeader,
-		"targetRequestMethod":      requestMethod,
-		"targetRequestTimestamp":   requestTime.Format(time.RFC3339Nano),
-		"targetRequestURL":         requestURL,
-		"targetResponseBody":       responseField,
-		"targetResponseBodyString": string(responseBody),
-		"targetResponseHeader":     responseHeader,
-		"targetResponseLatency":    responseLatency.String(),
-		"targetResponseStatus":     responseStatus,
-		"targetResponseTimestamp":  requestTime.Add(responseLatency).Format(time.RFC3339Nano),
+	if err := os.Setenv(envkey.StdoutOnly, strconv.FormatBool(config.StdoutOnly)); err != nil {
+		logger.Logger().Error(err)
+	}
+	if err := os.Setenv(envkey.Development, strconv.FormatBool(config.Development)); err != nil {
+		logger.Logger().Error(err)
 	}
 
-	clientLog, exists := c.Get(generalkey.ClientLog)
-	if !exists {
-		clientLog = []logrus.Fields{}
+	// The pipeline reads the environment written above when it reconnects or reformats. The
+	// secrets are stored instead, so child processes don't inherit them.
+	logger.SetCredentials(logger.Credentials{
+		APIKey:         config.ElasticAPIKey,
+		ServiceToken:   config.ElasticServiceToken,
+		CACertPath:     config.ElasticCACertPath,
+		CACertPEM:      config.ElasticCACertPEM,
+		ClientCertPath: config.ElasticClientCertPath,
+		ClientKeyPath:  config.ElasticClientKeyPath,
+	})
+	logger.SetIndexNameFunc(config.IndexNameFunc)
+	logger.SetDocumentIDFunc(config.DocumentIDFunc)
+	logger.SetClock(config.Clock)
+	logger.SetProcessors(config.Processors...)
+	logger.SetLogLevel(config.LogLevel)
+	logger.SetFormatter(config.Formatter)
+	logger.SetSinkFilters(config.OutputFilter, config.ElasticFilter)
+	logger.SetClient(config.ElasticClient)
+
+	if config.StartupPolicy == StartupFailFast {
+		if err := CheckStartup(); err != nil {
+			panic(fmt.Errorf("welog: %w", err))
+		}
 	}
+}
+
+// storeConfig keeps the configuration for the middlewares, which read it on every request.
+func storeConfig(c Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	activeConfig = c
+}
 
-	clientLog = append(clientLog.([]logrus.Fields), logData)
-	c.Set(generalkey.ClientLog, clientLog)
+// currentConfig returns the configuration set by SetConfig.
+func currentConfig() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return activeConfig
+}
+
+// joinInts formats numbers as a comma-separated list.
+func joinInts(numbers []int) string {
+	formatted := make([]string, len(numbers))
+	for i, n := range numbers {
+		formatted[i] = strconv.Itoa(n)
+	}
+	return strings.Join(formatted, ",")
 }
@@ -2,17 +2,31 @@ package welog
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"github.com/christiandoxa/welog/pkg/constant/envkey"
 	"github.com/christiandoxa/welog/pkg/constant/generalkey"
 	"github.com/christiandoxa/welog/pkg/infrastructure/logger"
-	"github.com/gin-gonic/gin"
-	"github.com/gofiber/fiber/v2"
+	"github.com/christiandoxa/welog/pkg/model"
+	"github.com/christiandoxa/welog/pkg/plugin"
+	"github.com/christiandoxa/welog/pkg/util"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
-	"github.com/valyala/fasthttp"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -45,194 +59,1829 @@ func TestSetConfig(t *testing.T) {
 	assert.Equal(t, welogConfig.ElasticPassword, elasticPassword, "ElasticPassword should be set correctly")
 }
 
-// TestNewFiber tests the NewFiber middleware to ensure it sets up the Fiber application correctly.
-func TestNewFiber(t *testing.T) {
+// TestPluginApply tests that registered plugins post-process the request document.
+func TestPluginApply(t *testing.T) {
 	// Call the SetConfig function
 	SetConfig(welogConfig)
+	buf := captureOutput(t)
 
-	// Create a new Fiber app and apply the middleware.
-	app := fiber.New()
-	app.Use(NewFiber(fiber.Config{}))
+	// Register a plugin that tags every request document.
+	plugin.Register("welog-test", plugin.Func(func(fields logrus.Fields) {
+		fields["pluginTag"] = "tagged"
+	}))
+	assert.Contains(t, plugin.Registered(), "welog-test")
+	assert.Panics(t, func() { plugin.Register("welog-test", plugin.Func(func(logrus.Fields) {})) })
 
-	// Create a new HTTP GET request.
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("User-Agent", "Test-Agent")
+	// Serve a request.
+	NewHTTP(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
 
-	// Perform the request and capture the response.
-	resp, err := app.Test(req, 5000) //nolint:bodyclose
+	// Assert that the plugin field is part of the log output.
+	assert.Contains(t, buf.String(), `"pluginTag":"tagged"`)
+}
 
-	// Assert that there are no errors and the status is 404 Not Found.
-	assert.NoError(t, err)
-	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+// settingsPlugin records the settings passed by SetConfig, and fails on GeoIP databases.
+type settingsPlugin struct {
+	settings *atomic.Pointer[plugin.Settings]
+}
+
+func (settingsPlugin) Process(logrus.Fields) {}
+
+func (p settingsPlugin) Configure(settings plugin.Settings) error {
+	p.settings.Store(&settings)
+	if len(settings.GeoIPDatabases) > 0 {
+		return errors.New("configure failed")
+	}
+	return nil
+}
+
+// TestPluginConfigure tests that SetConfig passes the plugin settings to the plugins.
+func TestPluginConfigure(t *testing.T) {
+	p := settingsPlugin{settings: &atomic.Pointer[plugin.Settings]{}}
+	plugin.Register("welog-test-settings", p)
+	buf := captureOutput(t)
+
+	// Assert that the settings reach the plugin and its error is logged.
+	config := welogConfig
+	config.GeoIPDatabases = []string{"GeoLite2-City.mmdb", "GeoLite2-ASN.mmdb"}
+	SetConfig(config)
+	assert.Equal(t, &plugin.Settings{GeoIPDatabases: config.GeoIPDatabases}, p.settings.Load())
+	assert.Contains(t, buf.String(), "configure failed")
+
+	SetConfig(welogConfig)
+	assert.Equal(t, &plugin.Settings{}, p.settings.Load())
 }
 
-// TestLogFiber tests the logFiber function within the Fiber middleware.
-func TestLogFiber(t *testing.T) {
+// captureOutput redirects the output of the global logger into a buffer for the duration of the test.
+func captureOutput(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+	return buf
+}
+
+// TestNewHTTP tests that NewHTTP logs net/http requests and stores the request values in the
+// context of the handler.
+func TestNewHTTP(t *testing.T) {
 	// Call the SetConfig function
 	SetConfig(welogConfig)
+	buf := captureOutput(t)
 
-	// Create a new Fiber app.
-	app := fiber.New()
+	// Create a mux with an endpoint asserting the values stored by the middleware.
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-request-id", RequestIDFromContext(r.Context()))
+		assert.Equal(t, "test-request-id", LoggerFromContext(r.Context()).Data[generalkey.RequestID])
+		AddTarget(r.Context(), logrus.Fields{"targetRequestURL": "http://downstream/"})
 
-	// Create a POST request with a JSON body.
-	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBuffer([]byte(`{"key": "value"}`)))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	})
+
+	// Serve a request with a custom Request ID.
+	req := httptest.NewRequest(http.MethodPost, "/items?page=2", strings.NewReader(`{"key":"value"}`))
 	req.Header.Set("Content-Type", "application/json")
-	resp := httptest.NewRecorder()
+	req.Header.Set("X-Request-ID", "test-request-id")
+	w := httptest.NewRecorder()
+	NewHTTP(mux).ServeHTTP(w, req)
 
-	// Define a middleware that logs the request using logFiber.
-	app.Use(func(c *fiber.Ctx) error {
-		c.Locals(generalkey.Logger, logger.Logger().WithField(generalkey.RequestID, c.Locals("requestid")))
-		c.Locals(generalkey.ClientLog, []logrus.Fields{})
-		logFiber(c, time.Now())
-		return c.SendStatus(fiber.StatusOK)
-	})
+	// Assert that the response is untouched and the document carries the usual fields.
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"ok":true}`, w.Body.String())
+	assert.Equal(t, "test-request-id", w.Header().Get("X-Request-ID"))
+	assert.Contains(t, buf.String(), `"requestMethod":"POST"`)
+	assert.Contains(t, buf.String(), `"requestUrl":"/items?page=2"`)
+	assert.Contains(t, buf.String(), `"requestRoute":"/items"`)
+	assert.Contains(t, buf.String(), `"requestBody":{"key":"value"}`)
+	assert.Contains(t, buf.String(), `"responseBody":{"ok":true}`)
+	assert.Contains(t, buf.String(), `"responseStatus":200`)
+	assert.Contains(t, buf.String(), `"targetRequestURL":"http://downstream/"`)
+}
 
-	// Perform the request and capture the response.
-	_, err := app.Test(req, -1) //nolint:bodyclose
-	assert.NoError(t, err)
+// TestNewHTTPRecover tests that NewHTTP recovers from panics and still logs the request.
+func TestNewHTTPRecover(t *testing.T) {
+	// Call the SetConfig function
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Wrap a panicking handler.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	// Serve the request and capture the response.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
 
-	// Assert that the status code is 200 OK.
-	assert.Equal(t, fiber.StatusOK, resp.Code)
+	// Assert that the panic was converted and logged at error level with a stack trace.
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, buf.String(), `"log.level":"error"`)
+	assert.Contains(t, buf.String(), `"panic":"boom"`)
+	assert.Contains(t, buf.String(), `"stackTrace":"goroutine`)
+	assert.Contains(t, buf.String(), `"requestMethod":"GET"`)
 }
 
-// TestLogFiberClient tests the LogFiberClient function to ensure it logs client requests and responses correctly.
-func TestLogFiberClient(t *testing.T) {
+// TestNewHTTPStreaming tests that the handlers wrapped by NewHTTP can still flush their response
+// and hijack the connection.
+func TestNewHTTPStreaming(t *testing.T) {
 	// Call the SetConfig function
 	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Wrap a handler streaming server-sent events.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: 1\n\n"))
+		flusher, ok := w.(http.Flusher)
+		if assert.True(t, ok) {
+			flusher.Flush()
+		}
 
-	// Create a new Fiber app.
-	app := fiber.New()
+		// Assert that recorders, which can't be hijacked, report it.
+		_, _, err := w.(http.Hijacker).Hijack()
+		assert.ErrorIs(t, err, http.ErrNotSupported)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	assert.True(t, w.Flushed)
+	assert.Contains(t, buf.String(), `"responseBodyString":"data: 1\n\n"`)
 
-	// Acquire a new context from the Fiber app for testing.
-	fastCtx := &fasthttp.RequestCtx{}
-	fiberCtx := app.AcquireCtx(fastCtx)
-	defer app.ReleaseCtx(fiberCtx)
+	// Serve a handler taking over the connection to answer by itself.
+	server := httptest.NewServer(NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hijacker, ok := w.(http.Hijacker)
+		if !assert.True(t, ok) {
+			return
+		}
+		conn, rw, err := hijacker.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		_ = rw.Flush()
+	})))
+	defer server.Close()
 
-	// Set initial client log fields.
-	fiberCtx.Locals(generalkey.ClientLog, []logrus.Fields{})
+	// Assert that the client got the answer of the handler.
+	res, err := http.Get(server.URL + "/ws")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		assert.Equal(t, "hijacked", string(body))
+	}
+}
 
-	// Define test input values.
-	url := "https://example.com"
-	method := "GET"
-	contentType := "application/json"
-	header := map[string]interface{}{"Content-Type": "application/json"}
-	responseHeader := map[string]interface{}{"Content-Type": "application/json"}
-	body := []byte(`{"test": "data"}`)
-	response := []byte(`{"response": "ok"}`)
-	status := http.StatusOK
-	start := time.Now()
-	elapsed := 100 * time.Millisecond
+// TestDefaultLevelFunc tests the default mapping of statuses to log levels.
+func TestDefaultLevelFunc(t *testing.T) {
+	assert.Equal(t, logrus.InfoLevel, DefaultLevelFunc(http.StatusOK, nil))
+	assert.Equal(t, logrus.InfoLevel, DefaultLevelFunc(http.StatusFound, nil))
+	assert.Equal(t, logrus.WarnLevel, DefaultLevelFunc(http.StatusNotFound, nil))
+	assert.Equal(t, logrus.ErrorLevel, DefaultLevelFunc(http.StatusBadGateway, nil))
+}
 
-	// Log the client request and response.
-	LogFiberClient(fiberCtx, url, method, contentType, header, body, responseHeader, response, status, start, elapsed)
+// TestLevelFunc tests that the request log level follows the configured LevelFunc.
+func TestLevelFunc(t *testing.T) {
+	// Log 404 responses at error level.
+	config := welogConfig
+	var gotStatus int
+	config.LevelFunc = func(status int, err error) logrus.Level {
+		gotStatus = status
+		if status == http.StatusNotFound {
+			return logrus.ErrorLevel
+		}
+		return DefaultLevelFunc(status, err)
+	}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
 
-	// Retrieve the client log and assert that it contains the correct values.
-	clientLog := fiberCtx.Locals(generalkey.ClientLog).([]logrus.Fields)
-	assert.Len(t, clientLog, 1)
-	assert.Equal(t, status, clientLog[0]["targetResponseStatus"])
+	// Serve a request with a mux without routes so it is answered with 404.
+	w := httptest.NewRecorder()
+	NewHTTP(http.NewServeMux()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Assert that the hook received the status and its level was used.
+	assert.Equal(t, http.StatusNotFound, gotStatus)
+	assert.Contains(t, buf.String(), `"log.level":"error"`)
 }
 
-// TestNewGin tests the NewGin middleware to ensure it sets up the Gin application correctly.
-func TestNewGin(t *testing.T) {
-	// Call the SetConfig function
+// TestLevelHandler tests changing the level at runtime through LevelHandler.
+func TestLevelHandler(t *testing.T) {
 	SetConfig(welogConfig)
+	t.Cleanup(func() {
+		SetConfig(welogConfig)
+		SetLevel(logrus.InfoLevel)
+	})
+	handler := LevelHandler()
 
-	// Create a new Gin router and apply the middleware.
-	r := gin.New()
-	r.Use(NewGin())
+	// Assert that the level is reported and changed.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.JSONEq(t, `{"level":"info","outputLevel":"","elasticLevel":""}`, rec.Body.String())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level":"debug"}`)))
+	assert.JSONEq(t, `{"level":"debug","outputLevel":"","elasticLevel":""}`, rec.Body.String())
+	assert.Equal(t, logrus.DebugLevel, Level())
+
+	// Assert that the levels of the sinks are changed on their own.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"elasticLevel":"warn"}`)))
+	assert.JSONEq(t, `{"level":"debug","outputLevel":"","elasticLevel":"warn"}`, rec.Body.String())
+	output, elastic := logger.SinkFilters()
+	assert.Equal(t, "", output.Level)
+	assert.Equal(t, "warn", elastic.Level)
+
+	// Assert that invalid levels and methods are refused, leaving every level as is.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level":"loud"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level":"info","outputLevel":"loud"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, logrus.DebugLevel, Level())
+	outputLevel, elasticLevel := SinkLevels()
+	assert.Equal(t, "", outputLevel)
+	assert.Equal(t, "warn", elasticLevel)
+}
 
-	// Define a simple GET endpoint.
-	r.GET("/", func(c *gin.Context) {
-		c.String(http.StatusOK, "ok")
+// TestReloadConfig tests that the runtime configuration file is applied, partially, and that
+// invalid files keep the previous settings.
+func TestReloadConfig(t *testing.T) {
+	SetConfig(welogConfig)
+	t.Cleanup(func() {
+		SetConfig(welogConfig)
+		SetLevel(logrus.InfoLevel)
 	})
+	path := t.TempDir() + "/welog.json"
 
-	// Create a GET request with a custom Request ID.
-	req, _ := http.NewRequest(http.MethodGet, "/", bytes.NewBuffer([]byte(`{"key": "value"}`)))
-	req.Header.Set("X-Request-ID", "test-request-id")
-	w := httptest.NewRecorder()
+	// Assert that the settings of the file are applied.
+	assert.NoError(t, os.WriteFile(path, []byte(`{"level":"debug","sampleRate":0.5,"skipPaths":["/healthz"]}`), 0o600))
+	assert.NoError(t, ReloadConfig(path))
+	assert.Equal(t, logrus.DebugLevel, Level())
+	assert.Equal(t, 0.5, currentConfig().SampleRate)
+	assert.True(t, shouldSkip(currentConfig(), http.MethodGet, "/healthz"))
 
-	// Serve the request and capture the response.
-	r.ServeHTTP(w, req)
+	// Assert that omitted settings are kept.
+	assert.NoError(t, os.WriteFile(path, []byte(`{"redactKeys":["secret"]}`), 0o600))
+	assert.NoError(t, ReloadConfig(path))
+	assert.Equal(t, logrus.DebugLevel, Level())
+	assert.Equal(t, 0.5, currentConfig().SampleRate)
+	assert.Equal(t, []string{"secret"}, currentConfig().RedactKeys)
+
+	// Assert that invalid settings are refused as a whole.
+	assert.NoError(t, os.WriteFile(path, []byte(`{"level":"warn","sampleRate":2}`), 0o600))
+	assert.Error(t, ReloadConfig(path))
+	assert.Equal(t, logrus.DebugLevel, Level())
+	assert.Equal(t, 0.5, currentConfig().SampleRate)
 
-	// Assert that the response status and body are correct.
+	// Assert that a watched file is reloaded when it changes.
+	assert.NoError(t, os.WriteFile(path, []byte(`{"level":"info"}`), 0o600))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	assert.NoError(t, WatchConfig(ctx, path))
+	assert.Equal(t, logrus.InfoLevel, Level())
+	assert.NoError(t, os.WriteFile(path, []byte(`{"level":"error"}`), 0o600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	assert.Eventually(t, func() bool { return Level() == logrus.ErrorLevel }, 5*time.Second, 50*time.Millisecond)
+}
+
+// TestShouldSkip tests the matching of the SkipPaths and SkipMethods configuration.
+func TestShouldSkip(t *testing.T) {
+	config := Config{SkipPaths: []string{"/healthz", "/static/*"}, SkipMethods: []string{"options"}}
+
+	assert.True(t, shouldSkip(config, http.MethodGet, "/healthz"))
+	assert.True(t, shouldSkip(config, http.MethodGet, "/static/app.js"))
+	assert.True(t, shouldSkip(config, http.MethodOptions, "/api"))
+	assert.False(t, shouldSkip(config, http.MethodGet, "/healthz/deep"))
+	assert.False(t, shouldSkip(config, http.MethodGet, "/api"))
+}
+
+// TestSkipPaths tests that excluded requests are served but not logged by both middlewares.
+func TestSkipPaths(t *testing.T) {
+	config := welogConfig
+	config.SkipPaths = []string{"/healthz"}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a mux with a health check endpoint.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, RequestIDFromContext(r.Context()))
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	// Serve the request.
+	w := httptest.NewRecorder()
+	NewHTTP(mux).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "ok", w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+
+	// Assert that no request log was written.
+	assert.NotContains(t, buf.String(), `requestMethod`)
+}
+
+// TestSampled tests the sampling decisions for successful and failed requests.
+func TestSampled(t *testing.T) {
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Errors are always logged, even when no successful request is.
+	config := welogConfig
+	config.SampleRate = 0.000001
+	SetConfig(config)
+	assert.True(t, sampled(config, &limiter, "id", logrus.ErrorLevel))
+	assert.True(t, sampled(config, &limiter, "id", logrus.WarnLevel))
+
+	// Hashing the request ID yields the same decision every time.
+	config.SampleRate = 0.5
+	config.SampleByRequestID = true
+	SetConfig(config)
+	kept, dropped := 0, 0
+	for i := 0; i < 100; i++ {
+		requestID := uuid.NewString()
+		decision := sampled(config, &limiter, requestID, logrus.InfoLevel)
+		assert.Equal(t, decision, sampled(config, &limiter, requestID, logrus.InfoLevel))
+		if decision {
+			kept++
+		} else {
+			dropped++
+		}
+	}
+	assert.NotZero(t, kept)
+	assert.NotZero(t, dropped)
+
+	// The per-second cap allows only the configured number of requests in a window.
+	limit := rateLimiter{}
+	now := time.Unix(1000, 0)
+	assert.True(t, limit.allow(2, now))
+	assert.True(t, limit.allow(2, now))
+	assert.False(t, limit.allow(2, now))
+	assert.True(t, limit.allow(2, now.Add(time.Second)))
+}
+
+// TestTargetBudget tests that target sub-entries over budget are dropped and counted.
+func TestTargetBudget(t *testing.T) {
+	config := welogConfig
+	config.TargetBudget = Budget{PerSecond: 0.001, Burst: 1}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Log three client calls while the budget only allows one.
+	ctx := NewJobContext(context.Background(), "budget")
+	for i := 0; i < 3; i++ {
+		LogTarget(ctx, model.TargetRequest{URL: "https://example.com", Method: "GET", Timestamp: time.Now()},
+			model.TargetResponse{Status: http.StatusOK, Latency: time.Millisecond})
+	}
+
+	// Assert that only one entry was kept and the others were counted.
+	store := ctx.Value(generalkey.ClientLogKey).(*clientLogStore)
+	assert.Len(t, store.list(), 1)
+	assert.Equal(t, 2, store.droppedCount())
+}
+
+// TestCollectExample tests that one sanitized example is written per route and status.
+func TestCollectExample(t *testing.T) {
+	config := welogConfig
+	config.CollectExamples = true
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a mux with an endpoint echoing personal data.
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"id":%q,"email":"jane@example.com","plan":"pro"}`, r.PathValue("id"))
+	})
+	handler := NewHTTP(mux)
+
+	// Serve two requests for the same route.
+	for _, id := range []string{"1", "2"} {
+		req := httptest.NewRequest(http.MethodPost, "/users/"+id, bytes.NewBufferString(`{"password":"hunter2"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert that a single example was written and personal data was removed from it.
+	output := buf.String()
+	assert.Equal(t, 1, strings.Count(output, `"exampleRoute":"/users/{id}"`))
+	var example map[string]interface{}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, `"exampleRoute"`) {
+			assert.NoError(t, json.Unmarshal([]byte(line), &example))
+		}
+	}
+	assert.Equal(t, util.Redacted, example["requestBody"].(map[string]interface{})["password"])
+	assert.Equal(t, util.Redacted, example["requestHeader"].(map[string]interface{})["Authorization"])
+	assert.Equal(t, util.Redacted, example["responseBody"].(map[string]interface{})["email"])
+	assert.Equal(t, "pro", example["responseBody"].(map[string]interface{})["plan"])
+
+	// Assert that keys are matched by whole words rather than fragments.
+	for _, key := range []string{"X-API-Key", "apiKey", "refresh_token", "firstName", "Set-Cookie"} {
+		assert.True(t, util.IsSensitiveKey(key), key)
+	}
+	for _, key := range []string{"hostname", "fileName", "cardinality", "name"} {
+		assert.False(t, util.IsSensitiveKey(key), key)
+	}
+}
+
+// TestDetectAnomaly tests that requests deviating from their route baseline are tagged.
+func TestDetectAnomaly(t *testing.T) {
+	config := welogConfig
+	config.AnomalyDetection = true
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Build a baseline of requests taking 10ms to 12ms.
+	for i := 0; i < 50; i++ {
+		fields := logrus.Fields{}
+		detectAnomaly(http.MethodGet, "/anomaly", time.Duration(10+i%3)*time.Millisecond, logrus.InfoLevel, fields)
+		if i >= anomalyWarmup {
+			assert.Equal(t, false, fields["anomaly"])
+		}
+	}
+
+	// Assert that a slow request and a failing request are anomalous.
+	slow := logrus.Fields{}
+	detectAnomaly(http.MethodGet, "/anomaly", time.Second, logrus.InfoLevel, slow)
+	assert.Equal(t, true, slow["anomaly"])
+	assert.Greater(t, slow["zscore"], 3.0)
+
+	failed := logrus.Fields{}
+	detectAnomaly(http.MethodGet, "/anomaly", 11*time.Millisecond, logrus.ErrorLevel, failed)
+	assert.Equal(t, true, failed["anomaly"])
+}
+
+// TestResponseUser tests that the responseUser field is cached and can be overridden.
+func TestResponseUser(t *testing.T) {
+	SetConfig(welogConfig)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Assert that the OS user is resolved once and reused.
+	first := responseUser()
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, responseUser())
+
+	// Assert that a configured service user takes precedence.
+	config := welogConfig
+	config.ServiceUser = "billing-service"
+	SetConfig(config)
+	assert.Equal(t, "billing-service", responseUser())
+}
+
+func TestClientHints(t *testing.T) {
+	headers := map[string][]string{
+		"Accept-Language":    {"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5"},
+		"Sec-Ch-Ua-Platform": {`"Windows"`},
+		"Sec-Ch-Ua-Mobile":   {"?0"},
+		"User-Agent":         {"Mozilla/5.0"},
+	}
+
+	// Assert that the languages are ordered by preference and the hints are unquoted.
+	config := welogConfig
+	fields := logrus.Fields{}
+	addClientHints(config, headers, fields)
+	assert.Equal(t, []string{"fr-CH", "fr", "en", "de"}, fields["requestLanguages"])
+	assert.Equal(t, map[string]string{
+		"sec-ch-ua-platform": "Windows",
+		"sec-ch-ua-mobile":   "?0",
+	}, fields["requestClientHints"])
+
+	// Assert that nothing is captured when disabled.
+	config.DisableClientHints = true
+	fields = logrus.Fields{}
+	addClientHints(config, headers, fields)
+	assert.Empty(t, fields)
+}
+
+func TestParseBody(t *testing.T) {
+	// Assert that JSON bodies are decoded, whether declared or sniffed.
+	assert.Equal(t, logrus.Fields{"a": "b"}, ParseBody("application/json; charset=utf-8", []byte(`{"a":"b"}`)))
+	assert.Equal(t, logrus.Fields{"a": "b"}, ParseBody("application/problem+json", []byte(`{"a":"b"}`)))
+	assert.Equal(t, logrus.Fields{"a": "b"}, ParseBody("", []byte(` {"a":"b"}`)))
+
+	// Assert that other bodies yield nil.
+	assert.Nil(t, ParseBody("text/html", []byte(`<html></html>`)))
+	assert.Nil(t, ParseBody("text/plain", []byte(`{"a":"b"}`)))
+	assert.Nil(t, ParseBody("", nil))
+	assert.Nil(t, ParseBody("application/json", []byte(`not json`)))
+
+	// Assert that URL-encoded forms are decoded, repeated keys into slices.
+	assert.Equal(t,
+		logrus.Fields{"name": "gopher", "tag": []string{"a", "b"}},
+		ParseBody("application/x-www-form-urlencoded", []byte("name=gopher&tag=a&tag=b")),
+	)
+
+	// Assert that multipart forms record values and file metadata, but not file contents.
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	assert.NoError(t, writer.WriteField("name", "gopher"))
+	file, err := writer.CreateFormFile("avatar", "gopher.png")
+	assert.NoError(t, err)
+	_, err = file.Write([]byte("0123456789"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	fields := ParseBody(writer.FormDataContentType(), body.Bytes())
+	assert.Equal(t, "gopher", fields["name"])
+	assert.Equal(t, logrus.Fields{
+		"contentType": "application/octet-stream",
+		"fileName":    "gopher.png",
+		"size":        int64(10),
+	}, fields["avatar"])
+}
+
+// TestBodyLimits tests that BodyMaxDepth and BodyMaxKeys limit the structured body fields.
+func TestBodyLimits(t *testing.T) {
+	body := []byte(`{"a":{"b":{"c":1}},"d":[{"e":{"f":2}}],"x":1,"y":2}`)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Assert that objects deeper than the limit become strings, also within arrays.
+	config := welogConfig
+	config.BodyMaxDepth = 2
+	SetConfig(config)
+	assert.Equal(t, logrus.Fields{
+		"a": logrus.Fields{"b": `{"c":1}`},
+		"d": []any{logrus.Fields{"e": `{"f":2}`}},
+		"x": float64(1),
+		"y": float64(2),
+	}, ParseBody("application/json", body))
+
+	// Assert that the keys beyond the limit are grouped into a single string.
+	config = welogConfig
+	config.BodyMaxKeys = 3
+	SetConfig(config)
+	assert.Equal(t, logrus.Fields{
+		"a":          logrus.Fields{"b": logrus.Fields{"c": float64(1)}},
+		truncatedKey: `{"d":[{"e":{"f":2}}],"x":1,"y":2}`,
+	}, ParseBody("application/json", body))
 }
 
-// TestLogGin tests the logGin function within the Gin middleware.
-func TestLogGin(t *testing.T) {
+func TestNonJSONBody(t *testing.T) {
 	// Call the SetConfig function
 	SetConfig(welogConfig)
+	buf := captureOutput(t)
 
-	// Create a buffer and logger to capture log output.
-	buf := &bytes.Buffer{}
-	log := logrus.New()
-	log.Out = buf
+	// Wrap a handler returning HTML.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("hello world"))
+	}))
 
-	// Create a POST request with a JSON body.
-	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer([]byte(`{"key": "value"}`)))
-	req.Header.Set("Content-Type", "application/json")
+	// Serve a plain-text request.
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert that no unmarshal errors are logged while the raw bodies are kept.
+	assert.NotContains(t, buf.String(), `"log.level":"error"`)
+	assert.Contains(t, buf.String(), `"requestBodyString":"hello"`)
+	assert.Contains(t, buf.String(), `"responseBodyString":"hello world"`)
+}
+func TestResponseContentFields(t *testing.T) {
+	// Call the SetConfig function
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Wrap a handler returning compressed text.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=ISO-8859-1")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	// Serve the request.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that the content type, encoding, and charset are logged as dedicated fields.
+	assert.Contains(t, buf.String(), `"responseContentType":"text/plain; charset=ISO-8859-1"`)
+	assert.Contains(t, buf.String(), `"responseContentEncoding":"gzip"`)
+	assert.Contains(t, buf.String(), `"responseCharset":"iso-8859-1"`)
+}
+func TestTenantCounters(t *testing.T) {
+	config := welogConfig
+	config.TenantHeader = "X-Tenant-ID"
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a mux with a succeeding and a failing endpoint.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	handler := NewHTTP(mux)
+
+	// Serve two requests of the same tenant.
+	for _, path := range []string{"/ok", "/fail"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Tenant-ID", "acme\"corp")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert that the tenant is logged and counted.
+	assert.Contains(t, buf.String(), `"requestTenant":"acme\"corp"`)
+	assert.Equal(t, TenantCounter{Requests: 2, Errors: 1}, TenantCounters()["acme\"corp"])
+
+	// Assert that the counters are exposed in the Prometheus text format.
 	w := httptest.NewRecorder()
+	TenantMetricsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `welog_tenant_requests_total{tenant="acme\"corp"} 2`)
+	assert.Contains(t, w.Body.String(), `welog_tenant_errors_total{tenant="acme\"corp"} 1`)
+}
+func TestParseXML(t *testing.T) {
+	envelope := []byte(`<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap:Body>
+    <GetPrice currency="EUR">
+      <Item>Apple</Item>
+      <Item>Pear</Item>
+    </GetPrice>
+  </soap:Body>
+</soap:Envelope>`)
+
+	// Assert that XML is only decoded when enabled.
+	SetConfig(welogConfig)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	assert.Nil(t, ParseBody("text/xml", envelope))
+
+	config := welogConfig
+	config.ParseXML = true
+	SetConfig(config)
+	assert.Equal(t, logrus.Fields{
+		"Envelope": logrus.Fields{
+			"Body": logrus.Fields{
+				"GetPrice": logrus.Fields{
+					"@currency": "EUR",
+					"Item":      []any{"Apple", "Pear"},
+				},
+			},
+		},
+	}, ParseBody("text/xml; charset=utf-8", envelope))
+	assert.Nil(t, ParseBody("application/soap+xml", []byte("<broken")))
+
+	// Assert that documents nested beyond the depth limit aren't decoded.
+	deep := strings.Repeat("<a>", maxXMLDepth+1) + "x" + strings.Repeat("</a>", maxXMLDepth+1)
+	assert.Nil(t, ParseBody("text/xml", []byte(deep)))
+	assert.NotNil(t, ParseBody("text/xml", []byte(deep[len("<a>"):len(deep)-len("</a>")])))
+
+	// Assert that the SOAP action is taken from the header or the SOAP 1.2 content type.
+	assert.Equal(t, "urn:GetPrice", soapAction(`"urn:GetPrice"`, "text/xml"))
+	assert.Equal(t, "urn:GetPrice", soapAction("", `application/soap+xml; action="urn:GetPrice"`))
+	assert.Empty(t, soapAction("", "application/json"))
+}
+
+func TestBodyString(t *testing.T) {
+	config := welogConfig
+	config.BinaryBodies = map[string]BinaryMode{
+		"image/*":                BinaryHash,
+		"application/x-protobuf": BinaryBase64,
+	}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Assert that text bodies are logged as they are.
+	assert.Equal(t, "hello", bodyString("text/plain", []byte("hello")))
+	assert.Equal(t, "hello", bodyString("", []byte("hello")))
+
+	// Assert that binary bodies are logged as configured, by size by default.
+	assert.Equal(t,
+		"sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		bodyString("image/png", []byte("hello")),
+	)
+	assert.Equal(t, "base64:aGVsbG8=", bodyString("application/x-protobuf", []byte("hello")))
+	assert.Equal(t, "[binary application/pdf, 5 bytes]", bodyString("application/pdf", []byte("hello")))
+	assert.Equal(t, "[binary unknown, 2 bytes]", bodyString("", []byte{0xff, 0xfe}))
+}
+
+func TestForceLog(t *testing.T) {
+	config := welogConfig
+	config.SampleRate = 0.000001
+	config.DebugSecret = "secret"
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a mux with an endpoint forcing its log and a regular one.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /forced", func(w http.ResponseWriter, r *http.Request) {
+		ForceLog(r.Context())
+	})
+	mux.HandleFunc("GET /regular", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /echo", func(w http.ResponseWriter, r *http.Request) {
+		ForceLog(r.Context())
+		_, _ = w.Write([]byte("pong"))
+	})
+	handler := NewHTTP(mux)
+
+	// Assert that only the forced request is logged.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/forced", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/regular", nil))
+	assert.Contains(t, buf.String(), `"requestUrl":"/forced"`)
+	assert.NotContains(t, buf.String(), `/regular`)
+	assert.Contains(t, buf.String(), `"forceLogged":true`)
+
+	// Assert that a valid debug token forces the log, while expired or forged tokens don't.
+	buf.Reset()
+	for _, token := range []string{DebugToken("secret", -time.Minute), DebugToken("forged", time.Minute)} {
+		req := httptest.NewRequest(http.MethodGet, "/regular", nil)
+		req.Header.Set(DebugHeader, token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Empty(t, buf.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/regular", nil)
+	req.AddCookie(&http.Cookie{Name: DebugCookie, Value: DebugToken("secret", time.Minute)})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buf.String(), `"forceLogged":true`)
+
+	// Assert that the bodies of forced requests are captured whatever the capture limits say.
+	config.RequestBodySkipTypes = []string{"text/plain"}
+	config.ResponseBodyOnError = true
+	config.MemoryBudget = 1
+	SetConfig(config)
+	buf.Reset()
+	req = httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("ping"))
+	req.Header.Set("Content-Type", "text/plain")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buf.String(), `"requestBodyString":"ping"`)
+	assert.Contains(t, buf.String(), `"responseBodyString":"pong"`)
+	assert.NotContains(t, buf.String(), `"bodyOmitted"`)
+	assert.Zero(t, logger.MemoryInUse())
+}
+func TestDarkLaunch(t *testing.T) {
+	SetConfig(welogConfig)
+	candidate := welogConfig
+	candidate.DisableClientHints = true
+	candidate.SampleRate = 0.000001
+	StartDarkLaunch(candidate, "welog-staging")
+	t.Cleanup(func() { StopDarkLaunch() })
+	buf := captureOutput(t)
+
+	// Create a mux with a succeeding and a failing endpoint.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("GET /echo", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+	handler := NewHTTP(mux)
+
+	// Serve the requests with an Accept-Language header.
+	for _, path := range []string{"/ok", "/fail"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", "en")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert that the candidate dropped the successful request and left out the client hints.
+	report := StopDarkLaunch()
+	assert.Equal(t, uint64(2), report.Requests)
+	assert.Equal(t, uint64(2), report.Current)
+	assert.Equal(t, uint64(1), report.Candidate)
+	assert.Equal(t, map[string]uint64{"requestLanguages": 1}, report.FieldDiffs)
+	assert.Equal(t, 3, strings.Count(buf.String(), `"requestMethod":"GET"`))
 
-	// Create a Gin context for testing.
-	c, _ := gin.CreateTestContext(w)
-	c.Request = req
+	// Assert that the report is empty once stopped.
+	assert.Equal(t, DarkLaunchReport{}, DarkLaunchStatus())
 
-	// Set the logger and client log fields.
-	c.Set(generalkey.Logger, log.WithField(generalkey.RequestID, "test-request-id"))
-	c.Set(generalkey.ClientLog, []logrus.Fields{})
+	// Dark-launch a candidate with its own redaction, body capture, and skip settings.
+	config := welogConfig
+	config.SkipPaths = []string{"/ok"}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	candidate = config
+	candidate.SkipPaths = nil
+	candidate.RedactKeys = []string{"code"}
+	candidate.ResponseBodyOnError = true
+	StartDarkLaunch(candidate, "welog-staging")
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/echo?code=secret", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
 
-	// Capture the response body using a custom response writer.
-	bodyBuf := &bytes.Buffer{}
-	c.Writer = &responseBodyWriter{body: bodyBuf, ResponseWriter: c.Writer}
+	// Assert that the candidate's document follows the candidate's settings.
+	report = StopDarkLaunch()
+	assert.Equal(t, uint64(2), report.Requests)
+	assert.Equal(t, uint64(1), report.Current)
+	assert.Equal(t, uint64(2), report.Candidate)
+	assert.Equal(t, map[string]uint64{"requestQuery": 1, "requestUrl": 1, "responseBodyString": 1}, report.FieldDiffs)
+	assert.Contains(t, buf.String(), `"requestUrl":"/echo?code=secret"`)
+	assert.Contains(t, buf.String(), `"requestUrl":"/echo?code=%5BREDACTED%5D"`)
+	assert.Contains(t, buf.String(), `"requestUrl":"/ok"`)
+}
+func TestRouteFields(t *testing.T) {
+	// Call the SetConfig function
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a mux with parameterized endpoints.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/files/{path...}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewHTTP(mux)
+
+	// Serve a request on each.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/files/a/b.txt", nil))
+
+	// Assert that the route templates without their method, and the parameters, are logged.
+	assert.Contains(t, buf.String(), `"requestRoute":"/users/{id}"`)
+	assert.Contains(t, buf.String(), `"requestParams":{"id":"42"}`)
+	assert.Contains(t, buf.String(), `"requestRoute":"/files/{path...}"`)
+	assert.Contains(t, buf.String(), `"requestParams":{"path":"a/b.txt"}`)
+	assert.NotContains(t, buf.String(), `"requestHandler"`)
+}
+func TestProgress(t *testing.T) {
+	config := welogConfig
+	config.ProgressInterval = 20 * time.Millisecond
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := &syncBuffer{}
+	logger.Logger().SetOutput(buf)
+	t.Cleanup(func() { logger.Logger().SetOutput(os.Stderr) })
+
+	// Wrap a handler reading the body slowly.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(io.LimitReader(r.Body, 3))
+		time.Sleep(70 * time.Millisecond)
+		_, _ = io.ReadAll(r.Body)
+		time.Sleep(70 * time.Millisecond)
+	}))
+
+	// Serve the request.
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("hello"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
 
-	// Log the request and response.
-	requestTime := time.Now()
-	logGin(c, bodyBuf, requestTime)
+	// Assert that progress documents were emitted in both phases before the request document.
+	output := buf.String()
+	assert.Contains(t, output, `"message":"request in progress"`)
+	assert.Contains(t, output, `"progressBytesReceived":3,"progressElapsed"`)
+	assert.Contains(t, output, `"progressPhase":"receiving"`)
+	assert.Contains(t, output, `"progressBytesReceived":5,"progressElapsed"`)
+	assert.Contains(t, output, `"progressPhase":"processing"`)
+	assert.Contains(t, output, `"requestUrl":"/upload"`)
+}
+
+// syncBuffer is a bytes.Buffer that can be written from multiple goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
 
-	// Retrieve and assert the log output.
-	logOutput := buf.String()
-	assert.Contains(t, logOutput, `requestMethod=POST`)
-	assert.Contains(t, logOutput, `responseStatus=200`)
+// Write appends b to the buffer.
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
 }
 
-// TestLogGinClient tests the LogGinClient function to ensure it logs client requests and responses correctly.
-func TestLogGinClient(t *testing.T) {
+// String returns the contents of the buffer.
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMemoryBudget(t *testing.T) {
+	config := welogConfig
+	config.MemoryBudget = 16
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Wrap a handler answering with a long body.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("a response that doesn't fit"))
+	}))
+
+	// Serve a request whose body fits and whose response doesn't.
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("small"))
+	req.Header.Set("Content-Type", "text/plain")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert that the bodies were omitted and all the memory was returned.
+	assert.Contains(t, buf.String(), `"bodyOmitted":true`)
+	assert.Contains(t, buf.String(), `"requestBodyString":"small"`)
+	assert.Contains(t, buf.String(), `"responseBodyString":""`)
+	assert.Zero(t, logger.MemoryInUse())
+}
+func TestQueryAndParams(t *testing.T) {
 	// Call the SetConfig function
 	SetConfig(welogConfig)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
 
-	// Create a POST request with a JSON body.
-	req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer([]byte(`{"key": "value"}`)))
-	req.Header.Set("Content-Type", "application/json")
+	// Create a mux with a parameterized endpoint.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /reset/{token}/{page}", func(w http.ResponseWriter, r *http.Request) {})
+
+	// Serve a request with sensitive and repeated query parameters.
+	NewHTTP(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reset/abc/2?Token=xyz&tag=a&tag=b", nil))
+
+	// Assert that the parameters are structured and the sensitive ones redacted, in the URL as well.
+	assert.Contains(t, buf.String(), `"requestParams":{"page":"2","token":"[REDACTED]"}`)
+	assert.Contains(t, buf.String(), `"requestQuery":{"Token":"[REDACTED]","tag":["a","b"]}`)
+	assert.Contains(t, buf.String(), `"requestUrl":"/reset/abc/2?Token=%5BREDACTED%5D\u0026tag=a\u0026tag=b"`)
+
+	// Assert that the redaction list is configurable.
+	config := welogConfig
+	config.RedactKeys = []string{"tag"}
+	SetConfig(config)
+	assert.Equal(t, map[string]any{"token": "xyz", "tag": util.Redacted}, queryFields("token=xyz&tag=a"))
+}
+func TestLowResourceProfile(t *testing.T) {
+	config := LowResourceProfile(welogConfig)
+
+	// Assert that the profile trims the footprint but keeps explicit budgets.
+	assert.True(t, config.StdoutOnly)
+	assert.True(t, config.DisableBodyCapture)
+	assert.Equal(t, 16, config.ElasticQueueSize)
+	assert.Equal(t, int64(1<<20), config.MemoryBudget)
+	budgeted := welogConfig
+	budgeted.MemoryBudget = 512
+	assert.Equal(t, int64(512), LowResourceProfile(budgeted).MemoryBudget)
+
+	// Assert that bodies are not captured while the request ID is still logged.
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("response"))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("request"))
+	req.Header.Set("X-Request-ID", "edge-request")
 	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "response", w.Body.String())
+	assert.Contains(t, buf.String(), `"requestId":"edge-request"`)
+	assert.Contains(t, buf.String(), `"requestBodyString":""`)
+	assert.Contains(t, buf.String(), `"responseBodyString":""`)
+}
+func TestClientIP(t *testing.T) {
+	config := welogConfig
+	config.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1"}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	headers := func(values map[string]string) func(string) string {
+		return func(name string) string { return values[name] }
+	}
+
+	// Assert that headers of untrusted peers are ignored.
+	assert.Equal(t, "203.0.113.9", clientIP("203.0.113.9", headers(map[string]string{"X-Real-IP": "1.2.3.4"}), ""))
+
+	// Assert that trusted proxies are resolved through the headers in order of precedence.
+	assert.Equal(t, "1.2.3.4", clientIP("10.1.1.1", headers(map[string]string{
+		"True-Client-IP":  "1.2.3.4",
+		"X-Forwarded-For": "5.6.7.8",
+	}), ""))
+	assert.Equal(t, "5.6.7.8", clientIP("192.0.2.1", headers(map[string]string{
+		"X-Forwarded-For": "6.6.6.6, 5.6.7.8, 10.2.2.2",
+	}), ""))
+	assert.Equal(t, "10.1.1.1", clientIP("10.1.1.1", headers(nil), ""))
+
+	// Assert that the framework's resolution is kept without trusted proxies.
+	SetConfig(welogConfig)
+	assert.Equal(t, "fallback", clientIP("10.1.1.1", headers(map[string]string{"X-Real-IP": "1.2.3.4"}), "fallback"))
+
+	// Assert that both addresses are logged.
+	SetConfig(config)
+	buf := captureOutput(t)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	NewHTTP(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Contains(t, buf.String(), `"requestIp":"198.51.100.7"`)
+	assert.Contains(t, buf.String(), `"requestRemoteAddr":"10.0.0.5"`)
+}
+func TestCheckStartup(t *testing.T) {
+	t.Setenv(envkey.LogLevel, "")
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Assert that a valid configuration passes.
+	SetConfig(welogConfig)
+	assert.NoError(t, CheckStartup())
+
+	// Assert that every invalid setting is reported.
+	config := welogConfig
+	config.ElasticURL = ""
+	config.SampleRate = 2
+	config.TrustedProxies = []string{"not-an-ip"}
+	config.LogLevel = "loud"
+	SetConfig(config)
+	err := CheckStartup()
+	assert.ErrorContains(t, err, `LogLevel "loud" is not a level`)
+	assert.ErrorContains(t, err, "ElasticURL is not set")
+	assert.ErrorContains(t, err, "SampleRate 2 is not between 0 and 1")
+	assert.ErrorContains(t, err, `TrustedProxies has an invalid entry "not-an-ip"`)
+	assert.Empty(t, os.Getenv(envkey.LogLevel)) // The level of the operator is left alone
+	config = welogConfig
+	config.ElasticCloudID = "deployment:ZXhhbXBsZS5jb20kYWJjJGRlZg=="
+	SetConfig(config)
+	err = CheckStartup()
+	assert.ErrorContains(t, err, "ElasticURL and ElasticCloudID are both set")
+
+	// Assert that an unreachable ElasticSearch fails the startup only when required.
+	config = welogConfig
+	config.ElasticURL = "http://127.0.0.1:1"
+	SetConfig(config)
+	assert.NoError(t, CheckStartup())
+	config.StartupPolicy = StartupRequireElastic
+	SetConfig(config)
+	err = CheckStartup()
+	assert.ErrorContains(t, err, "elasticsearch is unreachable")
+
+	// Assert that SetConfig itself fails under StartupFailFast.
+	config.StartupPolicy = StartupFailFast
+	assert.Panics(t, func() { SetConfig(config) })
+	config.StdoutOnly = true
+	assert.NotPanics(t, func() { SetConfig(config) })
+}
+func TestSetUser(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a mux whose handlers identify the user.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /alice", func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), "42", "alice", "")
+	})
+	mux.HandleFunc("GET /bob", func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), "43", "bob", "bob@example.com")
+	})
+	handler := NewHTTP(mux)
+
+	// Serve a request on each.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/alice", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bob", nil))
+
+	// Assert that the user fields are logged, leaving out the empty ones.
+	assert.Contains(t, buf.String(), `"user.id":"42"`)
+	assert.Contains(t, buf.String(), `"user.name":"alice"`)
+	assert.Contains(t, buf.String(), `"user.id":"43"`)
+	assert.Contains(t, buf.String(), `"user.email":"bob@example.com"`)
+	assert.Equal(t, 1, strings.Count(buf.String(), `"user.email"`))
+
+	// Assert that a context outside of the middlewares is ignored.
+	assert.NotPanics(t, func() { SetUser(context.Background(), "1", "", "") })
+}
+
+// TestSetTransactionName tests that requests are named after their route unless the handler
+// names them.
+func TestSetTransactionName(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Create a mux with an endpoint naming its operation and one keeping the route.
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders/{id}/items", func(w http.ResponseWriter, r *http.Request) {
+		SetTransactionName(r.Context(), "CreateOrderItem")
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /orders/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewHTTP(mux)
+
+	// Serve a request on each.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders/1/items", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+
+	// Assert that both names are logged.
+	assert.Contains(t, buf.String(), `"transactionName":"CreateOrderItem"`)
+	assert.Contains(t, buf.String(), `"transactionName":"GET /orders/{id}"`)
+
+	// Assert that a context outside of the middlewares is ignored.
+	assert.NotPanics(t, func() { SetTransactionName(context.Background(), "Ignored") })
+}
+
+// TestHandlePanic tests that a panic is logged and flushed before being raised again.
+func TestHandlePanic(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+	var drained bool
+	flushPipeline = func(context.Context) error {
+		drained = true
+		return nil
+	}
+	t.Cleanup(func() { flushPipeline = logger.Flush })
+
+	// Assert that the panic goes on with its value once logged and flushed.
+	assert.PanicsWithValue(t, "boom", func() {
+		defer HandlePanic()
+		panic("boom")
+	})
+	assert.True(t, drained)
+	assert.Contains(t, buf.String(), `"panic":"boom"`)
+	assert.Contains(t, buf.String(), `"stackTrace":`)
+
+	// Assert that the panics of logrus aren't logged twice.
+	buf.Reset()
+	assert.Panics(t, func() {
+		defer HandlePanic()
+		logger.Logger().Panic("logged")
+	})
+	assert.Equal(t, 1, strings.Count(buf.String(), "logged"))
+
+	// Assert that nothing happens without a panic.
+	drained = false
+	assert.NotPanics(t, func() {
+		defer HandlePanic()
+	})
+	assert.False(t, drained)
+}
+
+// TestDoctor tests that the report starts with the configuration and catches an unreachable cluster.
+func TestDoctor(t *testing.T) {
+	config := welogConfig
+	config.FallbackPath = t.TempDir() + "/logs.txt"
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	report := Doctor(context.Background())
+	if assert.GreaterOrEqual(t, len(report.Checks), 2) {
+		assert.Equal(t, logger.Check{Name: "configuration", Status: logger.CheckOK}, report.Checks[0])
+		assert.Equal(t, "connectivity", report.Checks[1].Name)
+		assert.Equal(t, logger.CheckFailed, report.Checks[1].Status)
+	}
+	assert.False(t, report.OK())
+}
+
+// fixedClock is a logger.Clock standing still at a fixed time.
+type fixedClock struct {
+	now time.Time
+}
+
+// Now returns the fixed time.
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// TestClock tests that the timestamps and latencies of the request documents come from the configured clock.
+func TestClock(t *testing.T) {
+	config := welogConfig
+	config.Clock = fixedClock{now: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	NewHTTP(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that the document is deterministic.
+	assert.Contains(t, buf.String(), `"@timestamp":"2025-01-02T03:04:05.000Z"`)
+	assert.Contains(t, buf.String(), `"requestTimestamp":"2025-01-02T03:04:05Z"`)
+	assert.Contains(t, buf.String(), `"responseTimestamp":"2025-01-02T03:04:05Z"`)
+	assert.Contains(t, buf.String(), `"responseLatency":"0s"`)
+
+	// Assert that the per-second cap of sampling counts requests in the seconds of the clock.
+	limit := rateLimiter{}
+	config.SamplePerSecond = 1
+	assert.True(t, sampled(config, &limit, "", logrus.InfoLevel))
+	assert.Equal(t, config.Clock.Now().Unix(), limit.window)
+
+	// Assert that debug tokens expire in the time of the clock.
+	config.DebugSecret = "secret"
+	SetConfig(config)
+	token := DebugToken("secret", time.Minute)
+	assert.True(t, strings.HasPrefix(token, strconv.FormatInt(config.Clock.Now().Add(time.Minute).Unix(), 10)+"."))
+	assert.True(t, validDebugToken(token))
+}
+
+// TestProcessors tests that processors transform and drop entries before they are written.
+func TestProcessors(t *testing.T) {
+	config := welogConfig
+	config.Processors = []logger.Processor{
+		func(entry *logrus.Entry) *logrus.Entry {
+			if entry.Message == "noise" {
+				return nil
+			}
+			return entry
+		},
+		func(entry *logrus.Entry) *logrus.Entry {
+			return entry.WithField("team", "billing")
+		},
+		func(entry *logrus.Entry) *logrus.Entry {
+			delete(entry.Data, "secret")
+			return entry
+		},
+	}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	var delivered []string
+	unsubscribe := logger.Subscribe(func(doc logger.Document) {
+		delivered = append(delivered, doc.Message)
+	})
+	defer unsubscribe()
+
+	logger.Logger().Info("noise")
+	logger.Logger().WithField("secret", "hunter2").Info("invoice issued")
+
+	// Assert that the dropped entry reached no sink and the other one was transformed.
+	assert.NotContains(t, buf.String(), "noise")
+	assert.Contains(t, buf.String(), `"message":"invoice issued"`)
+	assert.Contains(t, buf.String(), `"team":"billing"`)
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.Equal(t, []string{"invoice issued"}, delivered)
+}
+
+// TestComponent tests that component loggers carry the component and the request ID.
+func TestComponent(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	Component(NewJobContext(context.Background(), "component-id"), "billing").Info("invoice issued")
+	assert.Contains(t, buf.String(), `"component":"billing"`)
+	assert.Contains(t, buf.String(), `"requestId":"component-id"`)
+
+	buf.Reset()
+	Component(context.Background(), "billing").Info("invoice issued")
+	assert.Contains(t, buf.String(), `"component":"billing"`)
+	assert.NotContains(t, buf.String(), `"requestId"`)
+}
+
+// TestLogEvent tests that business events are attached to the request document, or logged on their own.
+func TestLogEvent(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Serve a request whose handler records an event.
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders", func(w http.ResponseWriter, r *http.Request) {
+		LogEvent(r.Context(), "order_created", logrus.Fields{"orderId": "o-1"})
+		w.WriteHeader(http.StatusCreated)
+	})
+	NewHTTP(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	// Assert that the event is part of the request document.
+	output := strings.TrimSpace(buf.String())
+	assert.NotContains(t, output, "\n")
+	assert.Contains(t, output, `"events":[{"eventName":"order_created","eventTimestamp":"`)
+	assert.Contains(t, output, `"orderId":"o-1"`)
+
+	// Assert that events outside of requests are logged on their own.
+	buf.Reset()
+	LogEvent(context.Background(), "payment_failed", logrus.Fields{"reason": "declined"})
+	assert.Contains(t, buf.String(), `"eventName":"payment_failed"`)
+	assert.Contains(t, buf.String(), `"message":"payment_failed"`)
+	assert.Contains(t, buf.String(), `"reason":"declined"`)
+}
+
+// TestAudit tests that audit entries are tagged, identify the user, and are routed to the audit index.
+func TestAudit(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Record a data export of an identified user.
+	ctx := NewJobContext(context.Background(), "audit-id")
+	SetUser(ctx, "42", "alice", "")
+	Audit(ctx, "data_export", "customers", "success", logrus.Fields{"rows": 120})
+
+	// Assert that the entry carries the event, the request ID, and the user.
+	assert.Contains(t, buf.String(), `"event.kind":"audit"`)
+	assert.Contains(t, buf.String(), `"event.action":"data_export"`)
+	assert.Contains(t, buf.String(), `"event.outcome":"success"`)
+	assert.Contains(t, buf.String(), `"auditResource":"customers"`)
+	assert.Contains(t, buf.String(), `"auditDetails":{"rows":120}`)
+	assert.Contains(t, buf.String(), `"requestId":"audit-id"`)
+	assert.Contains(t, buf.String(), `"user.id":"42"`)
+
+	// Assert that the entries are routed to their own index.
+	SetConfig(Config{ElasticIndex: "welog"})
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	entry := logrus.NewEntry(logger.Logger()).WithContext(logger.WithCategory(ctx, logger.CategoryAudit))
+	assert.True(t, strings.HasPrefix(logger.DefaultIndexName(entry), "welog-audit-"))
+}
+
+func TestJWTClaims(t *testing.T) {
+	config := welogConfig
+	config.JWTClaims = []string{"sub", "aud"}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Create a handler and a token with an extra claim.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-42","aud":["api"],"secret":"hidden"}`))
+	token := "eyJhbGciOiJIUzI1NiJ9." + payload + ".c2lnbmF0dXJl"
+
+	// Serve a request with the token and one with a malformed token.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert that only the allowed claims are logged, once.
+	assert.Contains(t, buf.String(), `"requestClaims":{"aud":["api"],"sub":"user-42"}`)
+	assert.Equal(t, 1, strings.Count(buf.String(), `"requestClaims"`))
+	assert.NotContains(t, buf.String(), `"secret"`)
+}
+func TestServiceMetadata(t *testing.T) {
+	config := welogConfig
+	config.ServiceName = "checkout"
+	config.ServiceVersion = "1.4.2"
+	config.ServiceEnvironment = "staging"
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Assert that application logs carry the service fields.
+	logger.Logger().Info("started")
+	assert.Contains(t, buf.String(), `"service.name":"checkout"`)
+	assert.Contains(t, buf.String(), `"service.version":"1.4.2"`)
+	assert.Contains(t, buf.String(), `"labels.env":"staging"`)
+
+	// Assert that fields set on an entry take precedence and that an empty configuration stamps nothing.
+	buf.Reset()
+	logger.Logger().WithField("service.name", "worker").Info("started")
+	assert.Contains(t, buf.String(), `"service.name":"worker"`)
+	SetConfig(welogConfig)
+	buf.Reset()
+	logger.Logger().Info("started")
+	assert.NotContains(t, buf.String(), `service.`)
+}
+
+func TestHostMetadata(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Assert that entries carry the hostname unless disabled.
+	hostname, err := os.Hostname()
+	assert.NoError(t, err)
+	logger.Logger().Info("started")
+	assert.Contains(t, buf.String(), `"host.hostname":"`+hostname+`"`)
+
+	config := welogConfig
+	config.DisableHostMetadata = true
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf.Reset()
+	logger.Logger().Info("started")
+	assert.NotContains(t, buf.String(), `"host.hostname"`)
+}
+
+func TestECSFields(t *testing.T) {
+	config := welogConfig
+	config.ECSFields = true
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Serve a request with a mux.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	NewHTTP(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items?page=2", nil))
+
+	// Assert that the fields are named after ECS and the latency is numeric.
+	assert.Contains(t, buf.String(), `"http.request.method":"GET"`)
+	assert.Contains(t, buf.String(), `"http.response.status_code":204`)
+	assert.Contains(t, buf.String(), `"url.full":"/items?page=2"`)
+	assert.Contains(t, buf.String(), `"http.version":"1.1"`)
+	assert.Contains(t, buf.String(), `"event.duration":`)
+	assert.NotContains(t, buf.String(), `"requestMethod"`)
+	assert.NotContains(t, buf.String(), `"responseLatency"`)
+	assert.Contains(t, buf.String(), `"requestRoute":"/items"`)
+}
+
+// TestEventFields tests that the request documents carry the ECS event fields, without ECSFields.
+func TestEventFields(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Serve a successful and a failed request with a mux.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	handler := NewHTTP(mux)
+	for _, path := range []string{"/ok", "/fail"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Assert that both outcomes are logged with the category and a numeric duration.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 2) {
+		var success, failure map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &success))
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &failure))
+		assert.Equal(t, "success", success["event.outcome"])
+		assert.Equal(t, "failure", failure["event.outcome"])
+		assert.Equal(t, "web", success["event.category"])
+		assert.IsType(t, float64(0), success["event.duration"])
+	}
+}
+
+// TestLatencyMilliseconds tests that the latencies are also logged as numbers of milliseconds.
+func TestLatencyMilliseconds(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Serve a request whose handler records a target.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LogTarget(r.Context(), model.TargetRequest{Method: http.MethodGet, Timestamp: time.Now()},
+			model.TargetResponse{Status: http.StatusOK, Latency: 12300 * time.Microsecond})
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that both forms are logged.
+	var document map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &document))
+	assert.IsType(t, "", document["responseLatency"])
+	assert.IsType(t, float64(0), document["responseLatencyMs"])
+	assert.Contains(t, buf.String(), `"targetResponseLatency":"12.3ms"`)
+	assert.Contains(t, buf.String(), `"targetResponseLatencyMs":12.3`)
+}
+
+// TestStatusClass tests that the statuses are logged as integers along with their class.
+func TestStatusClass(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Serve a request whose handler records a target.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LogTarget(r.Context(), model.TargetRequest{Method: http.MethodGet, Timestamp: time.Now()},
+			model.TargetResponse{Status: http.StatusServiceUnavailable})
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Contains(t, buf.String(), `"responseStatus":404`)
+	assert.Contains(t, buf.String(), `"responseStatusClass":"4xx"`)
+	assert.Contains(t, buf.String(), `"targetResponseStatus":503`)
+	assert.Contains(t, buf.String(), `"targetResponseStatusClass":"5xx"`)
+
+	// Assert that invalid statuses, such as those of calls failing without a response, have no class.
+	assert.Equal(t, "", statusClass(0))
+	assert.Equal(t, "2xx", statusClass(http.StatusNoContent))
+}
+
+// TestBodyBytes tests that the sizes of the bodies are logged as integers, also without body capture.
+func TestBodyBytes(t *testing.T) {
+	config := welogConfig
+	config.DisableBodyCapture = true
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Serve a request with a handler answering with a body.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello, world"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"key":"value"}`)))
+
+	assert.Contains(t, buf.String(), `"requestBytes":15`)
+	assert.Contains(t, buf.String(), `"responseBytes":12`)
+}
+
+// TestResponseBodyOnError tests that only the response bodies of failed or selected requests are captured.
+func TestResponseBodyOnError(t *testing.T) {
+	config := welogConfig
+	config.ResponseBodyOnError = true
+	config.ResponseBodyFunc = func(status int, body []byte) bool {
+		return bytes.Contains(body, []byte(`"success":false`))
+	}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("fine"))
+	})
+	mux.HandleFunc("GET /failed", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("invalid page"))
+	})
+	mux.HandleFunc("GET /reported", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"success":false}`))
+	})
+	handler := NewHTTP(mux)
+
+	// Assert that successful responses are logged with their size only.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	assert.Contains(t, buf.String(), `"responseBodyString":""`)
+	assert.Contains(t, buf.String(), `"responseBytes":4`)
+
+	// Assert that failed responses and those matching ResponseBodyFunc keep their body.
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/failed", nil))
+	assert.Contains(t, buf.String(), `"responseBodyString":"invalid page"`)
+
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/reported", nil))
+	assert.Contains(t, buf.String(), `"responseBody":{"success":false}`)
+}
+
+// TestRequestBodySkip tests that the request bodies of the configured paths and content types aren't captured.
+func TestRequestBodySkip(t *testing.T) {
+	config := welogConfig
+	config.RequestBodySkipPaths = []string{"/webhooks/*"}
+	config.RequestBodySkipTypes = []string{"multipart/*"}
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	post := func(path, contentType, body string) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Assert that skipped bodies are left out, while the request is still logged with its size.
+	post("/webhooks/github", "application/json", `{"key":"value"}`)
+	assert.Contains(t, buf.String(), `"requestBodyString":""`)
+	assert.Contains(t, buf.String(), `"requestBytes":15`)
+
+	buf.Reset()
+	post("/upload", "multipart/form-data; boundary=x", "--x--")
+	assert.Contains(t, buf.String(), `"requestBodyString":""`)
+
+	// Assert that other bodies are still captured.
+	buf.Reset()
+	post("/items", "application/json", `{"key":"value"}`)
+	assert.Contains(t, buf.String(), `"requestBody":{"key":"value"}`)
+}
+func TestFieldNaming(t *testing.T) {
+	// Assert that camel case keys are converted, keeping acronyms together.
+	assert.Equal(t, "requestMethod", CamelCase.name("requestMethod"))
+	assert.Equal(t, "request_method", SnakeCase.name("requestMethod"))
+	assert.Equal(t, "target_request_url", SnakeCase.name("targetRequestURL"))
+	assert.Equal(t, "http.response.status_code", SnakeCase.name("http.response.status_code"))
+
+	config := welogConfig
+	config.FieldNaming = SnakeCase
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	buf := captureOutput(t)
+
+	// Serve a request whose handler logs a client request.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LogTarget(r.Context(), model.TargetRequest{URL: "http://upstream", Method: http.MethodGet, Timestamp: time.Now()},
+			model.TargetResponse{Status: http.StatusOK})
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Assert that the document and its target sub-entries are in snake case.
+	assert.Contains(t, buf.String(), `"request_method":"GET"`)
+	assert.Contains(t, buf.String(), `"response_status":200`)
+	assert.Contains(t, buf.String(), `"target_request_url":"http://upstream"`)
+	assert.Contains(t, buf.String(), `"request_id":"`)
+	assert.NotContains(t, buf.String(), `"requestMethod"`)
+	assert.NotContains(t, buf.String(), `"requestId"`)
+}
+func TestGraphQL(t *testing.T) {
+	// Assert that the executed operation is found, named or not.
+	for _, test := range []struct {
+		document, operationName, wantType, wantName string
+	}{
+		{`{ user(id: 1) { name } }`, "", "query", ""},
+		{`query GetUser($id: ID!) { user(id: $id) { name } }`, "", "query", "GetUser"},
+		{`# comment "query"
+fragment F on User { name }
+mutation { a }
+mutation Rename($names: [String]) @log { b(s: "{") }`, "Rename", "mutation", "Rename"},
+		{`subscription OnEvent { event }`, "", "subscription", "OnEvent"},
+		{`query A { a }`, "B", "", ""},
+	} {
+		gotType, gotName := graphqlOperation(test.document, test.operationName)
+		assert.Equal(t, test.wantType, gotType, test.document)
+		assert.Equal(t, test.wantName, gotName, test.document)
+	}
+
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Serve a GraphQL request.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	body := `{"query":"mutation Login($user: String, $password: String) { login }","variables":{"user":"gopher","password":"secret"}}`
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert that the operation is described and the variables are sanitized.
+	assert.Contains(t, buf.String(), `"graphqlOperationType":"mutation"`)
+	assert.Contains(t, buf.String(), `"graphqlOperationName":"Login"`)
+	assert.Contains(t, buf.String(), `"graphqlVariables":{"password":"[REDACTED]","user":"gopher"}`)
+}
+
+// TestHTTPClient tests that the calls of the client constructors are recorded with the request ID propagated.
+func TestHTTPClient(t *testing.T) {
+	SetConfig(welogConfig)
+	buf := captureOutput(t)
+
+	// Serve a downstream service echoing the request ID.
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"requestId":%q}`, r.Header.Get("X-Request-ID"))
+	}))
+	defer downstream.Close()
+
+	// Call it from a handler.
+	handler := NewHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := NewHTTPClient(r.Context()).Post(downstream.URL, "application/json", strings.NewReader(`{"a":1}`))
+		if assert.NoError(t, err) {
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, `{"requestId":"client-request"}`, string(body))
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "client-request")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert that the call is a target of the request document.
+	assert.Contains(t, buf.String(), `"targetRequestBody":{"a":1}`)
+	assert.Contains(t, buf.String(), `"targetRequestMethod":"POST"`)
+	assert.Contains(t, buf.String(), `"targetResponseBody":{"requestId":"client-request"}`)
+}
+
+// TestTargetFromHTTP tests that calls made with net/http are described without consuming their bodies.
+func TestTargetFromHTTP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/users", strings.NewReader(`{"name":"gopher"}`))
+	req.Header.Set("Content-Type", "application/json")
+	request := model.TargetRequestFromHTTP(req)
+
+	res := &http.Response{
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id":1}`)),
+	}
+	response := model.TargetResponseFromHTTP(res, time.Second)
+
+	// Assert that the bodies can still be read.
+	requestBody, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `{"name":"gopher"}`, string(requestBody))
+	responseBody, _ := io.ReadAll(res.Body)
+	assert.Equal(t, `{"id":1}`, string(responseBody))
+
+	// Assert that the target entry describes the call.
+	fields := BuildTargetLogFields(request, response)
+	assert.Equal(t, "https://example.com/users", fields["targetRequestURL"])
+	assert.Equal(t, http.MethodPost, fields["targetRequestMethod"])
+	assert.Equal(t, logrus.Fields{"name": "gopher"}, fields["targetRequestBody"])
+	assert.Equal(t, logrus.Fields{"id": float64(1)}, fields["targetResponseBody"])
+	assert.Equal(t, http.StatusCreated, fields["targetResponseStatus"])
+	assert.Equal(t, "1s", fields["targetResponseLatency"])
+}
+
+// TestTargetError tests that calls failing without a response are recorded with their error.
+func TestTargetError(t *testing.T) {
+	// Assert that transport errors are classified.
+	assert.Equal(t, "timeout", errorType(fmt.Errorf("call: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "canceled", errorType(context.Canceled))
+	assert.Equal(t, "dns", errorType(&net.DNSError{Err: "no such host", Name: "example.invalid"}))
+	assert.Equal(t, "connection_refused", errorType(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	assert.Equal(t, "other", errorType(errors.New("boom")))
+
+	// Assert that an explicit type wins over the derived one.
+	fields := BuildTargetLogFields(model.TargetRequest{}, model.TargetResponse{Error: errors.New("boom"), ErrorType: "circuit_open"})
+	assert.Equal(t, "boom", fields["targetResponseError"])
+	assert.Equal(t, "circuit_open", fields["targetResponseErrorType"])
+
+	// Call a closed port through the transport.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	_ = listener.Close()
+
+	ctx := NewJobContext(context.Background(), "")
+	_, err = NewHTTPClient(ctx).Get("http://" + listener.Addr().String())
+	assert.Error(t, err)
+
+	// Assert that the call is recorded with a zero status.
+	store := ctx.Value(generalkey.ClientLogKey).(*clientLogStore)
+	entries := store.list()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, 0, entries[0]["targetResponseStatus"])
+		assert.Equal(t, "connection_refused", entries[0]["targetResponseErrorType"])
+		assert.Contains(t, entries[0]["targetResponseError"], "connection refused")
+	}
+}
+
+// TestTargetAttempt tests that the attempts of retried calls are recorded.
+func TestTargetAttempt(t *testing.T) {
+	// Serve a downstream service failing the first call.
+	var calls atomic.Int32
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer downstream.Close()
+
+	// Call it twice, numbering the attempts like a caller retrying server errors.
+	ctx := NewJobContext(context.Background(), "")
+	client := NewHTTPClient(ctx)
+	for number := 1; number <= 2; number++ {
+		req, err := http.NewRequestWithContext(WithTargetAttempt(ctx, model.TargetAttempt{Number: number, MaxRetries: 3}),
+			http.MethodGet, downstream.URL, nil)
+		assert.NoError(t, err)
+		res, err := client.Do(req)
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+	}
+
+	// Assert that both attempts are numbered.
+	entries := ctx.Value(generalkey.ClientLogKey).(*clientLogStore).list()
+	if assert.Len(t, entries, 2) {
+		for i, entry := range entries {
+			assert.Equal(t, i+1, entry["targetRequestAttempt"])
+			assert.Equal(t, 3, entry["targetRequestMaxRetries"])
+		}
+		assert.Equal(t, http.StatusServiceUnavailable, entries[0]["targetResponseStatus"])
+	}
+
+	// Assert that calls without attempts don't get the fields.
+	fields := BuildTargetLogFields(model.TargetRequest{}, model.TargetResponse{})
+	assert.NotContains(t, fields, "targetRequestAttempt")
+	fields = BuildTargetLogFields(model.TargetRequest{Attempt: model.TargetAttempt{Number: 2, MaxRetries: 5, Backoff: time.Second}}, model.TargetResponse{})
+	assert.Equal(t, "1s", fields["targetRequestBackoff"])
+}
+
+// TestTargetTiming tests that the phases of calls are traced.
+func TestTargetTiming(t *testing.T) {
+	downstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer downstream.Close()
+
+	// Call the downstream service twice through the transport.
+	ctx := NewJobContext(context.Background(), "")
+	client := &http.Client{Transport: NewTransport(ctx, downstream.Client().Transport)}
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(downstream.URL)
+		if assert.NoError(t, err) {
+			_ = res.Body.Close()
+		}
+	}
+
+	// Assert that the first call connected and the second reused the connection.
+	entries := ctx.Value(generalkey.ClientLogKey).(*clientLogStore).list()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, false, entries[0]["targetConnectionReused"])
+		assert.NotEqual(t, "0s", entries[0]["targetTimingConnect"])
+		assert.NotEqual(t, "0s", entries[0]["targetTimingTlsHandshake"])
+		assert.NotEqual(t, "0s", entries[0]["targetTimingFirstByte"])
+		assert.Equal(t, true, entries[1]["targetConnectionReused"])
+		assert.Equal(t, "0s", entries[1]["targetTimingTlsHandshake"])
+	}
+}
+
+// TestGRPCMethods tests that gRPC calls are recorded under the configuration of their method.
+func TestGRPCMethods(t *testing.T) {
+	methods := map[string]GRPCMethodConfig{
+		"/grpc.health.v1.Health/*":    {Skip: true},
+		"/users.UserService/*":        {DisablePayloads: true},
+		"/users.UserService/GetUser":  {SampleRate: 0.000001},
+		"/users.UserService/Internal": {SampleRate: 2},
+	}
+	config := welogConfig
+	config.GRPCMethods = methods
+	assert.ErrorContains(t, validateConfig(config), `SampleRate 2 not between 0 and 1 for "/users.UserService/Internal"`)
+	delete(methods, "/users.UserService/Internal")
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+
+	// Assert that exact names win over prefixes.
+	assert.Equal(t, GRPCMethodConfig{SampleRate: 0.000001}, GRPCMethod("/users.UserService/GetUser"))
+	assert.Equal(t, GRPCMethodConfig{DisablePayloads: true}, GRPCMethod("/users.UserService/ListUsers"))
+	assert.Equal(t, GRPCMethodConfig{}, GRPCMethod("/orders.OrderService/GetOrder"))
+
+	// Assert that skipped and unsampled calls aren't recorded, unlike failed ones.
+	assert.False(t, GRPCMethod("/grpc.health.v1.Health/Check").Record(false))
+	assert.False(t, GRPCMethod("/grpc.health.v1.Health/Check").Record(true))
+	assert.False(t, GRPCMethod("/users.UserService/GetUser").Record(false))
+	assert.True(t, GRPCMethod("/users.UserService/GetUser").Record(true))
+	assert.True(t, GRPCMethod("/users.UserService/ListUsers").Record(false))
+}
+
+// TestGRPCMethodFields tests that full method names are split into service and method.
+func TestGRPCMethodFields(t *testing.T) {
+	SetConfig(welogConfig)
+	assert.Equal(t, logrus.Fields{"targetGrpcService": "users.v1.UserService", "targetGrpcMethodName": "GetUser"},
+		GRPCMethodFields("/users.v1.UserService/GetUser"))
+	assert.Empty(t, GRPCMethodFields("GetUser"))
 
-	// Create a Gin context for testing.
-	c, _ := gin.CreateTestContext(w)
-	c.Request = req
-
-	// Set initial client log fields.
-	c.Set(generalkey.ClientLog, []logrus.Fields{})
-
-	// Define test input values.
-	url := "https://example.com"
-	method := "POST"
-	contentType := "application/json"
-	header := map[string]interface{}{"Content-Type": "application/json"}
-	responseHeader := map[string]interface{}{"Content-Type": "application/json"}
-	body := []byte(`{"test": "data"}`)
-	response := []byte(`{"response": "ok"}`)
-	status := http.StatusOK
-	start := time.Now()
-	elapsed := 100 * time.Millisecond
-
-	// Log the client request and response.
-	LogGinClient(c, url, method, contentType, header, body, responseHeader, response, status, start, elapsed)
-
-	// Retrieve the client log and assert that it contains the correct values.
-	clientLog, exists := c.Get(generalkey.ClientLog)
-	assert.True(t, exists)
-	logFields := clientLog.([]logrus.Fields)
-	assert.Len(t, logFields, 1)
-	assert.Equal(t, status, logFields[0]["targetResponseStatus"])
-	assert.Equal(t, "POST", logFields[0]["targetRequestMethod"])
+	// Assert that ECS names the fields after rpc.*.
+	config := welogConfig
+	config.ECSFields = true
+	SetConfig(config)
+	t.Cleanup(func() { SetConfig(welogConfig) })
+	assert.Equal(t, logrus.Fields{"rpc.system": "grpc", "rpc.service": "users.v1.UserService", "rpc.method": "GetUser"},
+		GRPCMethodFields("/users.v1.UserService/GetUser"))
 }